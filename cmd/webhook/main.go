@@ -19,6 +19,7 @@ import (
 	ctlkubevirt "github.com/harvester/harvester-load-balancer/pkg/generated/controllers/kubevirt.io"
 	ctllb "github.com/harvester/harvester-load-balancer/pkg/generated/controllers/loadbalancer.harvesterhci.io"
 	"github.com/harvester/harvester-load-balancer/pkg/utils"
+	"github.com/harvester/harvester-load-balancer/pkg/webhook/ipclaim"
 	"github.com/harvester/harvester-load-balancer/pkg/webhook/ippool"
 	"github.com/harvester/harvester-load-balancer/pkg/webhook/loadbalancer"
 )
@@ -107,6 +108,9 @@ func run(ctx context.Context, cfg *rest.Config, options *config.Options) error {
 
 	// must declare before start the nad factory
 	poolCache := lbFactory.Loadbalancer().V1beta1().IPPool().Cache()
+	allocationCache := lbFactory.Loadbalancer().V1beta1().IPAllocation().Cache()
+	lbCache := lbFactory.Loadbalancer().V1beta1().LoadBalancer().Cache()
+	claimCache := lbFactory.Loadbalancer().V1beta1().IPClaim().Cache()
 	vmCache := kubevirtFactory.Kubevirt().V1().VirtualMachine().Cache()
 	vmiCache := kubevirtFactory.Kubevirt().V1().VirtualMachineInstance().Cache()
 	nadCache := cniFactory.K8s().V1().NetworkAttachmentDefinition().Cache()
@@ -119,9 +123,10 @@ func run(ctx context.Context, cfg *rest.Config, options *config.Options) error {
 
 	webhookServer := server.NewWebhookServer(ctx, cfg, name, options)
 
-	if err := webhookServer.RegisterValidators(ippool.NewIPPoolValidator(poolCache),
-		loadbalancer.NewValidator(vmCache, vmiCache)); err != nil {
-		return fmt.Errorf("failed to register ip pool and loadbalancer validator: %w", err)
+	if err := webhookServer.RegisterValidators(ippool.NewIPPoolValidator(poolCache, allocationCache, claimCache, namespaceCache),
+		loadbalancer.NewValidator(vmCache, vmiCache, poolCache, allocationCache, claimCache, lbCache, namespaceCache),
+		ipclaim.NewIPClaimValidator(poolCache, lbCache)); err != nil {
+		return fmt.Errorf("failed to register ip pool, loadbalancer and ip claim validator: %w", err)
 	}
 
 	if err := webhookServer.RegisterMutators(ippool.NewIPPoolMutator(nadCache),
@@ -129,7 +134,7 @@ func run(ctx context.Context, cfg *rest.Config, options *config.Options) error {
 		return fmt.Errorf("failed to register ip pool and loadbalancer mutator: %w", err)
 	}
 
-	if err := webhookServer.RegisterConverters(loadbalancer.NewConverter(vmiCache, poolCache)); err != nil {
+	if err := webhookServer.RegisterConverters(loadbalancer.NewConverter(vmiCache, poolCache, allocationCache)); err != nil {
 		return fmt.Errorf("failed to register load balancer converter: %w", err)
 	}
 
@@ -17,6 +17,7 @@ import (
 	"k8s.io/client-go/rest"
 
 	"github.com/harvester/harvester-load-balancer/pkg/config"
+	"github.com/harvester/harvester-load-balancer/pkg/controller/ipclaim"
 	"github.com/harvester/harvester-load-balancer/pkg/controller/ippool"
 	"github.com/harvester/harvester-load-balancer/pkg/controller/loadbalancer"
 	"github.com/harvester/harvester-load-balancer/pkg/controller/vm"
@@ -84,6 +85,9 @@ func run(ctx context.Context, cfg *rest.Config) {
 		if err := loadbalancer.Register(ctx, management); err != nil {
 			logrus.Fatalf("error register loadBalancer controller: %s", err.Error())
 		}
+		if err := ipclaim.Register(ctx, management); err != nil {
+			logrus.Fatalf("error register ip claim controller: %s", err.Error())
+		}
 		if err := vmi.Register(ctx, management); err != nil {
 			logrus.Fatalf("error register vmi controller: %s", err.Error())
 		}
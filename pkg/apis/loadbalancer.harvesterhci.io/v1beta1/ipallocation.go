@@ -0,0 +1,30 @@
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +genclient
+// +genclient:nonNamespaced
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:resource:shortName=ipa;ipas,scope=Cluster
+// +kubebuilder:printcolumn:name="IPPOOL",type=string,JSONPath=`.spec.ipPool`
+// +kubebuilder:printcolumn:name="ADDRESS",type=string,JSONPath=`.spec.address`
+// +kubebuilder:printcolumn:name="APPLICANT",type=string,JSONPath=`.spec.applicant`
+// +kubebuilder:printcolumn:name="AGE",type="date",JSONPath=`.metadata.creationTimestamp`
+
+// IPAllocation records an IP allocated from an IP pool.
+// The name is derived from the IP, so an IP can't be allocated twice as the ranges of the pools don't overlap.
+type IPAllocation struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              IPAllocationSpec `json:"spec"`
+}
+
+type IPAllocationSpec struct {
+	IPPool string `json:"ipPool"`
+
+	Address string `json:"address"`
+	// Applicant is the namespace/name of the load balancer which the IP is allocated to
+	Applicant string `json:"applicant"`
+}
@@ -0,0 +1,50 @@
+package v1beta1
+
+import (
+	"github.com/rancher/wrangler/v3/pkg/condition"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:resource:shortName=ipc;ipcs,scope=Namespaced
+// +kubebuilder:printcolumn:name="IPPOOL",type=string,JSONPath=`.status.allocatedAddress.ipPool`
+// +kubebuilder:printcolumn:name="ADDRESS",type=string,JSONPath=`.status.allocatedAddress.ip`
+// +kubebuilder:printcolumn:name="AGE",type="date",JSONPath=`.metadata.creationTimestamp`
+
+// IPClaim reserves an address from an IP pool before the load balancer using it exists.
+// The address is booked under the applicant ID of the claim, a load balancer referring to the claim adopts it, and
+// it is released when the claim is deleted.
+type IPClaim struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              IPClaimSpec   `json:"spec"`
+	Status            IPClaimStatus `json:"status,omitempty"`
+}
+
+type IPClaimSpec struct {
+	// IPPool is the pool which the address is claimed from, the pool is selected by the network and the namespace of
+	// the claim if it is not specified
+	// +optional
+	IPPool string `json:"ipPool,omitempty"`
+	// Network is the namespace/name of the network to select the pool by
+	// +optional
+	Network string `json:"network,omitempty"`
+	// IPFamily is the IP family of the address, defaults to IPv4
+	// +optional
+	IPFamily corev1.IPFamily `json:"ipFamily,omitempty"`
+	// RequestedIP is the address requested from the pool, the pool is selected by it if IPPool is not specified
+	// +optional
+	RequestedIP string `json:"requestedIP,omitempty"`
+}
+
+type IPClaimStatus struct {
+	// +optional
+	AllocatedAddress AllocatedAddress `json:"allocatedAddress,omitempty"`
+	// +optional
+	Conditions []Condition `json:"conditions,omitempty"`
+}
+
+// IPClaimReady is true if the address is allocated to the claim
+var IPClaimReady condition.Cond = "Ready"
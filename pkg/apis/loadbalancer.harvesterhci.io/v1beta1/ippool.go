@@ -12,6 +12,8 @@ import (
 // +kubebuilder:printcolumn:name="DESCRIPTION",type=string,JSONPath=`.spec.description`
 // +kubebuilder:printcolumn:name="RANGES",type=string,JSONPath=`.spec.ranges`
 // +kubebuilder:printcolumn:name="Priority",type=string,JSONPath=`.spec.selector.priority`
+// +kubebuilder:printcolumn:name="PARENT",type=string,JSONPath=`.spec.parent`
+// +kubebuilder:printcolumn:name="CORDONED",type=boolean,JSONPath=`.spec.cordoned`
 // +kubebuilder:printcolumn:name="AGE",type="date",JSONPath=`.metadata.creationTimestamp`
 
 type IPPool struct {
@@ -26,6 +28,51 @@ type IPPoolSpec struct {
 	Description string `json:"description,omitempty"`
 
 	Ranges []Range `json:"ranges"`
+	// Exclude lists the IPs, CIDRs or IP ranges in the form of start-end in the ranges which are never allocated
+	// +optional
+	Exclude []string `json:"exclude,omitempty"`
+	// Reservations maps the IPs to the namespace/name of the load balancers which they are statically reserved for
+	// +optional
+	Reservations map[string]string `json:"reservations,omitempty"`
+	// AllocationStrategy decides how to pick a free IP from the ranges, defaults to roundrobin
+	// +optional
+	AllocationStrategy AllocationStrategy `json:"allocationStrategy,omitempty"`
+	// QuarantineSeconds is how long a released IP is kept from being allocated to other load balancers
+	// +optional
+	QuarantineSeconds uint32 `json:"quarantineSeconds,omitempty"`
+	// ConflictDetection probes whether an IP is already in use on the network before it is allocated,
+	// the IPs found in use are skipped, no probe is sent if it is not set
+	// +optional
+	ConflictDetection ConflictDetection `json:"conflictDetection,omitempty"`
+	// HistoryRetention bounds the allocated history by age and by entry count
+	// +optional
+	HistoryRetention HistoryRetention `json:"historyRetention,omitempty"`
+	// OrphanCollection configures the release of the IPs allocated to load balancers which no longer exist,
+	// the orphaned IPs are kept unless it is set and enabled
+	// +optional
+	OrphanCollection *OrphanCollection `json:"orphanCollection,omitempty"`
+	// Overflow allows the load balancers which select the pool automatically to be allocated from the next matching
+	// pool in priority order, and then from the global pool, when the pool is exhausted
+	// +optional
+	Overflow bool `json:"overflow,omitempty"`
+	// Cordoned stops the new allocations from the pool while the allocated IPs keep working,
+	// the load balancers can still reclaim the IPs in the allocated history which were allocated to them
+	// +optional
+	Cordoned bool `json:"cordoned,omitempty"`
+	// ReAddress accepts the changes of the ranges and exclusions which exclude the allocated IPs,
+	// the load balancers of the excluded IPs are moved to new addresses in the ranges
+	// +optional
+	ReAddress bool `json:"reAddress,omitempty"`
+	// Parent is the pool which the ranges are delegated from, the ranges must be inside the ranges of the parent and
+	// are never allocated by the parent
+	// +optional
+	Parent string `json:"parent,omitempty"`
+	// Expansion appends new ranges carved from a reserved supernet when the pool runs low on addresses
+	// +optional
+	Expansion *Expansion `json:"expansion,omitempty"`
+	// Quotas cap the addresses which the namespaces, Rancher projects or guest clusters may hold from the pool
+	// +optional
+	Quotas []Quota `json:"quotas,omitempty"`
 	// +optional
 	Selector Selector `json:"selector"`
 }
@@ -38,6 +85,61 @@ type Range struct {
 	Gateway    string `json:"gateway,omitempty"`
 }
 
+type HistoryRetention struct {
+	// MaxAgeSeconds is how long an allocated history entry is kept, zero means no limit
+	// +optional
+	MaxAgeSeconds uint32 `json:"maxAgeSeconds,omitempty"`
+	// MaxEntries is the max number of allocated history entries, the oldest ones are removed first, zero means no limit
+	// +optional
+	MaxEntries uint32 `json:"maxEntries,omitempty"`
+}
+
+type OrphanCollection struct {
+	// Enabled turns on the orphan collection of the pool
+	// +optional
+	Enabled bool `json:"enabled,omitempty"`
+	// GracePeriodSeconds is how long an IP stays allocated after its load balancer is found missing, zero means the
+	// default grace period of 5 minutes
+	// +optional
+	GracePeriodSeconds uint32 `json:"gracePeriodSeconds,omitempty"`
+	// DryRun only reports the orphaned IPs by events and the status without releasing them
+	// +optional
+	DryRun bool `json:"dryRun,omitempty"`
+}
+
+type Expansion struct {
+	// Supernet is the CIDR reserved for the pool inside the subnet of one of its ranges, the appended ranges are
+	// carved from it in ascending order and share the subnet and gateway of that range
+	Supernet string `json:"supernet"`
+	// ChunkSize is the number of IPs of each appended range, the ranges are aligned to the chunk size in the supernet
+	ChunkSize uint32 `json:"chunkSize"`
+	// MaxSize is the max number of IPs in the ranges of the pool including the delegated ones, zero means no limit
+	// +optional
+	MaxSize uint32 `json:"maxSize,omitempty"`
+	// Threshold is the number of available IPs below which the pool is expanded, zero means the pool is expanded
+	// when no IP is available
+	// +optional
+	Threshold uint32 `json:"threshold,omitempty"`
+}
+
+type Quota struct {
+	// The tenant is matched with the project, namespace and guest cluster of the load balancers as same as the scope
+	// of the selector, * matches any value
+	Tuple `json:",inline"`
+	// Max is the max number of addresses the tenant may hold from the pool, zero means no limit
+	// +optional
+	Max uint32 `json:"max,omitempty"`
+	// Min is the number of addresses guaranteed to the tenant, they can't be allocated to other tenants
+	// +optional
+	Min uint32 `json:"min,omitempty"`
+}
+
+type QuotaUsage struct {
+	Tuple `json:",inline"`
+	// Used is the number of addresses held by the tenant
+	Used int64 `json:"used"`
+}
+
 type Selector struct {
 	// +optional
 	Priority uint32 `json:"priority,omitempty"`
@@ -47,10 +149,16 @@ type Selector struct {
 	Scope []Tuple `json:"scope,omitempty"`
 }
 
+// Tuple is matched with the project, namespace and guest cluster of the load balancers.
+// The fields are glob patterns, * matches any sequence of characters and ? matches any single character.
 type Tuple struct {
-	Project      string `json:"project,omitempty"`
-	Namespace    string `json:"namespace,omitempty"`
-	GuestCluster string `json:"guestCluster,omitempty"`
+	Project   string `json:"project,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	// NamespaceSelector selects the Harvester namespaces by their labels, the Namespace is ignored if it is set.
+	// The namespaces of the guest clusters have no labels to select.
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	GuestCluster      string                `json:"guestCluster,omitempty"`
 }
 
 type IPPoolStatus struct {
@@ -59,14 +167,77 @@ type IPPoolStatus struct {
 	Available int64 `json:"available"`
 
 	LastAllocated string `json:"lastAllocated"`
+	// Allocated is deprecated, the allocated IPs are recorded by the IPAllocation objects.
+	// The records left by previous versions are migrated to IPAllocation objects by the controller.
 	// +optional
 	Allocated map[string]string `json:"allocated,omitempty"`
 	// +optional
 	AllocatedHistory map[string]string `json:"allocatedHistory,omitempty"`
+	// AllocatedHistoryTimestamps maps the IPs in the allocated history to their creation time in RFC3339 format
+	// +optional
+	AllocatedHistoryTimestamps map[string]string `json:"allocatedHistoryTimestamps,omitempty"`
+	// Quarantined maps the released IPs in quarantine to their release time in RFC3339 format
+	// +optional
+	Quarantined map[string]string `json:"quarantined,omitempty"`
+	// Conflicted maps the IPs found in use on the network by the conflict detection to the time they were found in
+	// RFC3339 format, they are skipped until they are probed again
+	// +optional
+	Conflicted map[string]string `json:"conflicted,omitempty"`
+	// Orphaned maps the IPs allocated to load balancers which no longer exist to the time they were found in
+	// RFC3339 format
+	// +optional
+	Orphaned map[string]string `json:"orphaned,omitempty"`
+	// ReAddressing maps the allocated IPs excluded from the ranges to the load balancers being moved to new addresses
+	// in the re-address mode
+	// +optional
+	ReAddressing map[string]string `json:"reAddressing,omitempty"`
+	// Delegated is the number of IPs delegated to the child pools, they are not counted in the total
+	// +optional
+	Delegated int64 `json:"delegated,omitempty"`
+	// DelegatedUsed is the number of IPs allocated by the child pools and their descendants
+	// +optional
+	DelegatedUsed int64 `json:"delegatedUsed,omitempty"`
+	// Quotas lists the usage of the quotas in the same order as the spec
+	// +optional
+	Quotas []QuotaUsage `json:"quotas,omitempty"`
 	// +optional
 	Conditions []Condition `json:"conditions,omitempty"`
 }
 
 var (
 	IPPoolReady condition.Cond = "Ready"
+	// IPPoolCordoned is true if the pool refuses new allocations
+	IPPoolCordoned condition.Cond = "Cordoned"
+	// IPPoolAddressConflict is true if any IP of the pool is claimed by more than one load balancer or is claimed by
+	// a load balancer other than the one it is allocated to
+	IPPoolAddressConflict condition.Cond = "AddressConflict"
+	// IPPoolReAddressing is true if any load balancer is being moved to a new address in the re-address mode,
+	// the message tells the progress
+	IPPoolReAddressing condition.Cond = "ReAddressing"
+	// IPPoolExpansionBlocked is true if the pool runs low on addresses but can't be expanded, the message tells why
+	IPPoolExpansionBlocked condition.Cond = "ExpansionBlocked"
+)
+
+// +kubebuilder:validation:Enum=roundrobin;lowestfree;random;hash
+type AllocationStrategy string
+
+const (
+	// RoundRobin allocates the next free IP after the last allocated one
+	RoundRobin AllocationStrategy = "roundrobin"
+	// LowestFree allocates the lowest free IP
+	LowestFree AllocationStrategy = "lowestfree"
+	// Random allocates a free IP randomly
+	Random AllocationStrategy = "random"
+	// Hash allocates the first free IP from the position decided by the hash of the applicant namespace/name
+	Hash AllocationStrategy = "hash"
+)
+
+// +kubebuilder:validation:Enum=arp;icmp
+type ConflictDetection string
+
+const (
+	// ARP probes the IPv4 address by an ARP request on the interface in its subnet
+	ARP ConflictDetection = "arp"
+	// ICMP probes the address by an ICMP echo request, the hosts dropping ICMP are not detected
+	ICMP ConflictDetection = "icmp"
 )
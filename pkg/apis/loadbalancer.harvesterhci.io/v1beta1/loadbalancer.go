@@ -31,12 +31,33 @@ type LoadBalancerSpec struct {
 	// +optional
 	IPAM IPAM `json:"ipam,omitempty"`
 	// +optional
-	IPPool    string     `json:"ipPool,omitempty"`
+	IPPool string `json:"ipPool,omitempty"`
+	// IPFamilyPolicy decides which IP families the load balancer address is allocated from, defaults to ipv4
+	// +optional
+	IPFamilyPolicy IPFamilyPolicy `json:"ipFamilyPolicy,omitempty"`
+	// RequestedIPs are the addresses requested from the IP pool, at most one per IP family
+	// The IP pool is selected by the requested IPs if IPPool is not specified
+	// +optional
+	RequestedIPs []string `json:"requestedIPs,omitempty"`
+	// IPClaim is the name of the IP claim in the same namespace whose address the load balancer adopts,
+	// the address stays with the claim when the load balancer is deleted
+	// +optional
+	IPClaim   string     `json:"ipClaim,omitempty"`
 	Listeners []Listener `json:"listeners,omitempty"`
 	// +optional
 	BackendServerSelector map[string][]string `json:"backendServerSelector,omitempty"`
+	// HealthCheck probes the backend servers for all the listeners, it works together with the health checks of the
+	// listeners per the HealthCheckPolicy
 	// +optional
 	HealthCheck *HealthCheck `json:"healthCheck,omitempty"`
+	// HealthCheckPolicy decides how the results of the health checks are combined into the readiness of the backend
+	// servers, defaults to all
+	// +optional
+	HealthCheckPolicy HealthCheckPolicy `json:"healthCheckPolicy,omitempty"`
+	// PoolMigrationOverlapSeconds is how long both the previous and the new addresses are exposed when the IP pool
+	// changes, zero means the default overlap window of 60 seconds
+	// +optional
+	PoolMigrationOverlapSeconds uint32 `json:"poolMigrationOverlapSeconds,omitempty"`
 }
 
 type LoadBalancerStatus struct {
@@ -44,12 +65,31 @@ type LoadBalancerStatus struct {
 	BackendServers []string `json:"backendServers,omitempty"`
 	// +optional
 	AllocatedAddress AllocatedAddress `json:"allocatedAddress,omitempty"`
+	// SecondaryAllocatedAddress is the IPv6 address allocated to a dual-stack load balancer
+	// +optional
+	SecondaryAllocatedAddress AllocatedAddress `json:"secondaryAllocatedAddress,omitempty"`
 	// +optional
 	Address string `json:"address,omitempty"`
+	// Addresses contains all the addresses of the load balancer service, one per IP family
+	// +optional
+	Addresses []string `json:"addresses,omitempty"`
+	// PoolMigration is the progress of the migration to another IP pool or to new addresses of the same pool in the
+	// re-address mode, nil if there is no migration
+	// +optional
+	PoolMigration *PoolMigration `json:"poolMigration,omitempty"`
 	// +optional
 	Conditions []Condition `json:"conditions,omitempty"`
 }
 
+type PoolMigration struct {
+	// IPPool is the pool which the previous addresses are allocated from
+	IPPool string `json:"ipPool"`
+	// Addresses are the previous addresses exposed together with the new ones during the overlap window
+	Addresses []string `json:"addresses,omitempty"`
+	// ReleaseTime is when the previous addresses are released in RFC3339 format
+	ReleaseTime string `json:"releaseTime,omitempty"`
+}
+
 type AllocatedAddress struct {
 	IPPool  string `json:"ipPool,omitempty"`
 	IP      string `json:"ip,omitempty"`
@@ -63,10 +103,20 @@ type Listener struct {
 	Port        int32           `json:"port"`
 	Protocol    corev1.Protocol `json:"protocol"`
 	BackendPort int32           `json:"backendPort"`
+	// HealthCheck probes the backend servers for this listener, the port defaults to the backend port
+	// +optional
+	HealthCheck *HealthCheck `json:"healthCheck,omitempty"`
 }
 
 type HealthCheck struct {
-	Port uint `json:"port,omitempty"`
+	// Mode decides where the health of the backend servers comes from, defaults to probe
+	// +optional
+	Mode HealthCheckMode `json:"mode,omitempty"`
+	// VMIConditions are the conditions of the VMI of the backend server which must be true in the vmi and
+	// probeandvmi modes, defaults to ready
+	// +optional
+	VMIConditions []VMICondition `json:"vmiConditions,omitempty"`
+	Port          uint           `json:"port,omitempty"`
 	// +optional
 	SuccessThreshold uint `json:"successThreshold,omitempty"`
 	// +optional
@@ -75,6 +125,46 @@ type HealthCheck struct {
 	PeriodSeconds uint `json:"periodSeconds,omitempty"`
 	// +optional
 	TimeoutSeconds uint `json:"timeoutSeconds,omitempty"`
+	// Protocol is how the backend servers are probed, defaults to tcp which only checks the connection
+	// +optional
+	Protocol HealthCheckProtocol `json:"protocol,omitempty"`
+	// Path is the request path of the http and https probes, defaults to /
+	// +optional
+	Path string `json:"path,omitempty"`
+	// Host is the Host header of the http and https probes or the authority of the grpc probe, it is also the server
+	// name to verify the certificate, defaults to the address of the backend server
+	// +optional
+	Host string `json:"host,omitempty"`
+	// ExpectedStatuses lists the status codes or the ranges of them in the form of start-end which are taken as
+	// healthy by the http and https probes, defaults to 200-399
+	// +optional
+	ExpectedStatuses []string `json:"expectedStatuses,omitempty"`
+	// BodyMatch is a regular expression which the response body of the http and https probes must match
+	// +optional
+	BodyMatch string `json:"bodyMatch,omitempty"`
+	// InsecureSkipVerify skips verifying the certificate of the backend servers in the https probe and the grpc probe over TLS
+	// +optional
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+	// Service is the service name sent in the grpc.health.v1.Health/Check request of the grpc probe,
+	// the empty one asks for the health of the whole server
+	// +optional
+	Service string `json:"service,omitempty"`
+	// TLS makes the grpc probe connect to the backend servers over TLS
+	// +optional
+	TLS bool `json:"tls,omitempty"`
+	// Payload is sent in a datagram by the udp probe
+	// +optional
+	Payload string `json:"payload,omitempty"`
+	// ResponseMatch is a regular expression which the response of the udp probe must match, if it is empty,
+	// the backend server is taken as healthy unless the port is unreachable
+	// +optional
+	ResponseMatch string `json:"responseMatch,omitempty"`
+	// UDPPreset replaces the payload and the response match of the udp probe with a well-known request
+	// +optional
+	UDPPreset UDPHealthCheckPreset `json:"udpPreset,omitempty"`
+	// DNSQuery is the domain name queried by the dns preset, defaults to the root zone
+	// +optional
+	DNSQuery string `json:"dnsQuery,omitempty"`
 }
 
 type Condition struct {
@@ -92,7 +182,18 @@ type Condition struct {
 	Message string `json:"message,omitempty"`
 }
 
-const LoadBalancerReady condition.Cond = "Ready"
+const (
+	LoadBalancerReady condition.Cond = "Ready"
+	// LoadBalancerAddressConflict is true if the allocated address is claimed by other load balancers or is
+	// allocated to others by the IP pool
+	LoadBalancerAddressConflict condition.Cond = "AddressConflict"
+	// LoadBalancerPoolOverflowed is true if the address is allocated from a fallback pool as the selected pools are
+	// exhausted, the message tells which pool is used and why
+	LoadBalancerPoolOverflowed condition.Cond = "PoolOverflowed"
+	// LoadBalancerPoolMigrating is true during the overlap window of the migration to another IP pool or to new
+	// addresses of the same pool
+	LoadBalancerPoolMigrating condition.Cond = "PoolMigrating"
+)
 
 // +kubebuilder:validation:Enum=vm;cluster
 type WorkloadType string
@@ -109,3 +210,72 @@ var (
 	Pool IPAM = "pool"
 	DHCP IPAM = "dhcp"
 )
+
+// +kubebuilder:validation:Enum=probe;vmi;probeandvmi
+type HealthCheckMode string
+
+const (
+	// ProbeMode probes the backend servers from the controller
+	ProbeMode HealthCheckMode = "probe"
+	// VMIMode takes the conditions of the VMIs as the health of the backend servers, it needs no network path from
+	// the controller to the VMs
+	VMIMode HealthCheckMode = "vmi"
+	// ProbeAndVMIMode takes the backend server as healthy if both the probe and the conditions of the VMI pass
+	ProbeAndVMIMode HealthCheckMode = "probeandvmi"
+)
+
+// +kubebuilder:validation:Enum=ready;agentconnected
+type VMICondition string
+
+const (
+	// VMIReady is the Ready condition of the VMI which is decided by the readinessProbe of the VMI if it is defined
+	VMIReady VMICondition = "ready"
+	// VMIAgentConnected is the AgentConnected condition of the VMI which is true if the qemu guest agent is connected
+	VMIAgentConnected VMICondition = "agentconnected"
+)
+
+// +kubebuilder:validation:Enum=all;any;perport
+type HealthCheckPolicy string
+
+const (
+	// AllHealthy takes the backend server as ready if all the health checks pass
+	AllHealthy HealthCheckPolicy = "all"
+	// AnyHealthy takes the backend server as ready if any of the health checks passes
+	AnyHealthy HealthCheckPolicy = "any"
+	// PerPortHealth decides the readiness of the backend server per listener, a listener with its own health check
+	// only depends on it, the other listeners depend on the health check of the load balancer
+	PerPortHealth HealthCheckPolicy = "perport"
+)
+
+// +kubebuilder:validation:Enum=tcp;http;https;grpc;udp
+type HealthCheckProtocol string
+
+const (
+	// TCPHealthCheck takes the backend server as healthy if the connection is established
+	TCPHealthCheck HealthCheckProtocol = "tcp"
+	// HTTPHealthCheck sends a GET request and checks the status code and the body of the response
+	HTTPHealthCheck HealthCheckProtocol = "http"
+	// HTTPSHealthCheck is as same as HTTPHealthCheck over TLS
+	HTTPSHealthCheck HealthCheckProtocol = "https"
+	// GRPCHealthCheck calls grpc.health.v1.Health/Check and takes the backend server as healthy if it is SERVING
+	GRPCHealthCheck HealthCheckProtocol = "grpc"
+	// UDPHealthCheck sends a datagram and optionally checks the response
+	UDPHealthCheck HealthCheckProtocol = "udp"
+)
+
+// +kubebuilder:validation:Enum=dns
+type UDPHealthCheckPreset string
+
+const (
+	// DNSPreset sends a DNS query and takes the backend server as healthy if it answers with NOERROR or NXDOMAIN
+	DNSPreset UDPHealthCheckPreset = "dns"
+)
+
+// +kubebuilder:validation:Enum=ipv4;ipv6;dualstack
+type IPFamilyPolicy string
+
+const (
+	IPv4      IPFamilyPolicy = "ipv4"
+	IPv6      IPFamilyPolicy = "ipv6"
+	DualStack IPFamilyPolicy = "dualstack"
+)
@@ -57,3 +57,37 @@ func NewIPPool(namespace, name string, obj IPPool) *IPPool {
 	obj.Namespace = namespace
 	return &obj
 }
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// IPAllocationList is a list of IPAllocation resources
+type IPAllocationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []IPAllocation `json:"items"`
+}
+
+func NewIPAllocation(namespace, name string, obj IPAllocation) *IPAllocation {
+	obj.APIVersion, obj.Kind = SchemeGroupVersion.WithKind("IPAllocation").ToAPIVersionAndKind()
+	obj.Name = name
+	obj.Namespace = namespace
+	return &obj
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// IPClaimList is a list of IPClaim resources
+type IPClaimList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []IPClaim `json:"items"`
+}
+
+func NewIPClaim(namespace, name string, obj IPClaim) *IPClaim {
+	obj.APIVersion, obj.Kind = SchemeGroupVersion.WithKind("IPClaim").ToAPIVersionAndKind()
+	obj.Name = name
+	obj.Namespace = namespace
+	return &obj
+}
@@ -28,6 +28,8 @@ import (
 )
 
 var (
+	IPAllocationResourceName = "ipallocations"
+	IPClaimResourceName      = "ipclaims"
 	IPPoolResourceName       = "ippools"
 	LoadBalancerResourceName = "loadbalancers"
 )
@@ -53,6 +55,10 @@ var (
 // Adds the list of known types to Scheme.
 func addKnownTypes(scheme *runtime.Scheme) error {
 	scheme.AddKnownTypes(SchemeGroupVersion,
+		&IPAllocation{},
+		&IPAllocationList{},
+		&IPClaim{},
+		&IPClaimList{},
 		&IPPool{},
 		&IPPoolList{},
 		&LoadBalancer{},
@@ -27,6 +27,8 @@ func main() {
 				Types: []interface{}{
 					lbv1.LoadBalancer{},
 					lbv1.IPPool{},
+					lbv1.IPAllocation{},
+					lbv1.IPClaim{},
 					lbv1alpha1.LoadBalancer{},
 				},
 				GenerateTypes:   true,
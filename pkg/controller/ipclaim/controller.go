@@ -0,0 +1,169 @@
+package ipclaim
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+
+	ctlcorev1 "github.com/rancher/wrangler/v3/pkg/generated/controllers/core/v1"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+
+	lbv1 "github.com/harvester/harvester-load-balancer/pkg/apis/loadbalancer.harvesterhci.io/v1beta1"
+	"github.com/harvester/harvester-load-balancer/pkg/config"
+	ctllbv1 "github.com/harvester/harvester-load-balancer/pkg/generated/controllers/loadbalancer.harvesterhci.io/v1beta1"
+	"github.com/harvester/harvester-load-balancer/pkg/ipam"
+)
+
+const controllerName = "harvester-ipclaim-controller"
+
+type Handler struct {
+	claimClient    ctllbv1.IPClaimClient
+	ipPoolCache    ctllbv1.IPPoolCache
+	namespaceCache ctlcorev1.NamespaceCache
+
+	allocatorMap *ipam.SafeAllocatorMap
+}
+
+func Register(ctx context.Context, management *config.Management) error {
+	claims := management.LbFactory.Loadbalancer().V1beta1().IPClaim()
+
+	handler := &Handler{
+		claimClient:    claims,
+		ipPoolCache:    management.LbFactory.Loadbalancer().V1beta1().IPPool().Cache(),
+		namespaceCache: management.CoreFactory.Core().V1().Namespace().Cache(),
+		allocatorMap:   management.AllocatorMap,
+	}
+
+	claims.OnChange(ctx, controllerName, handler.OnChange)
+	claims.OnRemove(ctx, controllerName, handler.OnRemove)
+
+	return nil
+}
+
+// OnChange is called when an IPClaim is created or updated
+// Allocate the address of the claim once, it is kept until the claim is deleted
+func (h *Handler) OnChange(_ string, claim *lbv1.IPClaim) (*lbv1.IPClaim, error) {
+	if claim == nil || claim.DeletionTimestamp != nil || claim.Status.AllocatedAddress.IP != "" {
+		return claim, nil
+	}
+
+	claimCopy := claim.DeepCopy()
+	address, err := h.allocate(claim)
+	if err != nil {
+		lbv1.IPClaimReady.False(claimCopy)
+		lbv1.IPClaimReady.Message(claimCopy, err.Error())
+	} else {
+		claimCopy.Status.AllocatedAddress = *address
+		lbv1.IPClaimReady.True(claimCopy)
+		lbv1.IPClaimReady.Message(claimCopy, "")
+		logrus.Infof("ip claim %s/%s allocate ip %s from pool %s", claim.Namespace, claim.Name, address.IP, address.IPPool)
+	}
+
+	if reflect.DeepEqual(claim.Status, claimCopy.Status) {
+		return claim, err
+	}
+	updated, updateErr := h.claimClient.Update(claimCopy)
+	if updateErr != nil {
+		return nil, fmt.Errorf("fail to update status of ip claim %s/%s, error: %w", claim.Namespace, claim.Name, updateErr)
+	}
+
+	return updated, err
+}
+
+// OnRemove is called when an IPClaim is deleted
+// Release the address of the claim
+func (h *Handler) OnRemove(_ string, claim *lbv1.IPClaim) (*lbv1.IPClaim, error) {
+	if claim == nil || claim.Status.AllocatedAddress.IPPool == "" {
+		return claim, nil
+	}
+
+	pool := claim.Status.AllocatedAddress.IPPool
+	// the address is removed with the pool
+	if _, err := h.ipPoolCache.Get(pool); apierrors.IsNotFound(err) {
+		return claim, nil
+	}
+	a := h.allocatorMap.Get(pool)
+	if a == nil {
+		return nil, fmt.Errorf("fail to get allocator %s", pool)
+	}
+	if err := a.Release(ipam.ClaimApplicant(claim.Namespace, claim.Name), ""); err != nil {
+		return nil, fmt.Errorf("fail to release ip %s to pool %s, error: %w", claim.Status.AllocatedAddress.IP, pool, err)
+	}
+	logrus.Infof("ip claim %s/%s is deleted, release ip %s to pool %s", claim.Namespace, claim.Name,
+		claim.Status.AllocatedAddress.IP, pool)
+
+	return claim, nil
+}
+
+func (h *Handler) allocate(claim *lbv1.IPClaim) (*lbv1.AllocatedAddress, error) {
+	pool, err := h.getIPPool(claim)
+	if err != nil {
+		return nil, err
+	}
+	a := h.allocatorMap.Get(pool.Name)
+	if a == nil {
+		return nil, fmt.Errorf("fail to get allocator %s", pool.Name)
+	}
+
+	family := claim.Spec.IPFamily
+	if family == "" {
+		family = corev1.IPv4Protocol
+	}
+	if !a.HasIPFamily(family) {
+		return nil, fmt.Errorf("pool %s has no %s range", pool.Name, family)
+	}
+
+	id := ipam.ClaimApplicant(claim.Namespace, claim.Name)
+
+	// the address may be allocated without being recorded into the status, take it again
+	requestedIP := net.ParseIP(claim.Spec.RequestedIP)
+	if requestedIP == nil {
+		requestedIP = a.GetAllocatedIP(id, family)
+	}
+	ipConfig, err := a.Get(id, family, requestedIP)
+	if err != nil {
+		return nil, fmt.Errorf("fail to get %s ip from pool %s, error: %w", family, pool.Name, err)
+	}
+
+	return &lbv1.AllocatedAddress{
+		IPPool:  pool.Name,
+		IP:      ipConfig.Address.IP.String(),
+		Mask:    net.IP(ipConfig.Address.Mask).String(),
+		Gateway: ipConfig.Gateway.String(),
+	}, nil
+}
+
+// getIPPool returns the pool specified by the claim, the pool containing the requested IP, or the pool selected by
+// the network and the namespace of the claim
+func (h *Handler) getIPPool(claim *lbv1.IPClaim) (*lbv1.IPPool, error) {
+	if claim.Spec.IPPool != "" {
+		pool, err := h.ipPoolCache.Get(claim.Spec.IPPool)
+		if err != nil {
+			return nil, fmt.Errorf("fail to get pool %s, error: %w", claim.Spec.IPPool, err)
+		}
+		return pool, nil
+	}
+
+	r, err := ipam.NewClaimRequirement(claim, h.namespaceCache)
+	if err != nil {
+		return nil, err
+	}
+	var pool *lbv1.IPPool
+	selector := ipam.NewSelector(h.ipPoolCache)
+	if ip := net.ParseIP(claim.Spec.RequestedIP); ip != nil {
+		pool, err = selector.SelectByIP(ip, r, false)
+	} else {
+		pool, err = selector.Select(r, false)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fail to select pool, error: %w", err)
+	}
+	if pool == nil {
+		return nil, fmt.Errorf("no matched IPPool")
+	}
+
+	return pool, nil
+}
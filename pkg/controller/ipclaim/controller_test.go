@@ -0,0 +1,107 @@
+package ipclaim
+
+import (
+	"context"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+
+	lbv1 "github.com/harvester/harvester-load-balancer/pkg/apis/loadbalancer.harvesterhci.io/v1beta1"
+	"github.com/harvester/harvester-load-balancer/pkg/generated/clientset/versioned/fake"
+	"github.com/harvester/harvester-load-balancer/pkg/ipam"
+	"github.com/harvester/harvester-load-balancer/pkg/ipam/store"
+	"github.com/harvester/harvester-load-balancer/pkg/utils/fakeclients"
+)
+
+func TestHandler_OnChangeAndOnRemove(t *testing.T) {
+	pool := &lbv1.IPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "pool1"},
+		Spec: lbv1.IPPoolSpec{
+			Ranges: []lbv1.Range{{Subnet: "192.168.100.0/24", RangeStart: "192.168.100.10", RangeEnd: "192.168.100.20"}},
+			Selector: lbv1.Selector{
+				Scope: []lbv1.Tuple{{Namespace: "default"}},
+			},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		spec    lbv1.IPClaimSpec
+		wantIP  string
+		wantErr bool
+	}{
+		{
+			name:   "claim from the specified pool",
+			spec:   lbv1.IPClaimSpec{IPPool: pool.Name},
+			wantIP: "192.168.100.10",
+		},
+		{
+			name:   "claim the requested IP",
+			spec:   lbv1.IPClaimSpec{RequestedIP: "192.168.100.15"},
+			wantIP: "192.168.100.15",
+		},
+		{
+			name:   "claim from the selected pool",
+			wantIP: "192.168.100.10",
+		},
+		{
+			name:    "claim the IP out of any pool",
+			spec:    lbv1.IPClaimSpec{RequestedIP: "192.168.200.15"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claim := &lbv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "claim1"},
+				Spec:       tt.spec,
+			}
+			clientset := fake.NewSimpleClientset(pool, claim)
+			allocationCache := fakeclients.IPAllocationCache(clientset.LoadbalancerV1beta1().IPAllocations)
+			h := &Handler{
+				claimClient:    fakeclients.IPClaimClient(clientset.LoadbalancerV1beta1().IPClaims),
+				ipPoolCache:    fakeclients.IPPoolCache(clientset.LoadbalancerV1beta1().IPPools),
+				namespaceCache: fakeclients.NamespaceCache(k8sfake.NewSimpleClientset().CoreV1().Namespaces),
+				allocatorMap:   ipam.NewSafeAllocatorMap(),
+			}
+			a, err := ipam.NewAllocator(pool.Name, &pool.Spec, h.ipPoolCache,
+				fakeclients.IPPoolClient(clientset.LoadbalancerV1beta1().IPPools), allocationCache,
+				fakeclients.IPAllocationClient(clientset.LoadbalancerV1beta1().IPAllocations))
+			if err != nil {
+				t.Fatalf("failed to create allocator, error: %s", err.Error())
+			}
+			h.allocatorMap.AddOrUpdate(pool.Name, a)
+
+			if _, err := h.OnChange("", claim); (err != nil) != tt.wantErr {
+				t.Fatalf("OnChange() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			updated, err := clientset.LoadbalancerV1beta1().IPClaims(claim.Namespace).Get(context.TODO(), claim.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("failed to get claim, error: %s", err.Error())
+			}
+			if tt.wantErr {
+				if !lbv1.IPClaimReady.IsFalse(updated) {
+					t.Errorf("expect the claim isn't ready, got %+v", updated.Status)
+				}
+				return
+			}
+			if updated.Status.AllocatedAddress.IPPool != pool.Name || updated.Status.AllocatedAddress.IP != tt.wantIP ||
+				!lbv1.IPClaimReady.IsTrue(updated) {
+				t.Fatalf("unexpected status %+v, want ip %s", updated.Status, tt.wantIP)
+			}
+			allocated, _ := store.GetAllocated(allocationCache, pool)
+			if allocated[tt.wantIP] != ipam.ClaimApplicant(claim.Namespace, claim.Name) {
+				t.Errorf("expect ip %s is allocated to the claim, got %v", tt.wantIP, allocated)
+			}
+
+			if _, err := h.OnRemove("", updated); err != nil {
+				t.Fatalf("OnRemove() error = %v", err)
+			}
+			if allocated, _ := store.GetAllocated(allocationCache, pool); len(allocated) != 0 {
+				t.Errorf("expect the claimed ip is released, got %v", allocated)
+			}
+		})
+	}
+}
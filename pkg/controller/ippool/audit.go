@@ -0,0 +1,232 @@
+package ippool
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rancher/wrangler/v3/pkg/condition"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	lbv1 "github.com/harvester/harvester-load-balancer/pkg/apis/loadbalancer.harvesterhci.io/v1beta1"
+	"github.com/harvester/harvester-load-balancer/pkg/ipam/store"
+	"github.com/harvester/harvester-load-balancer/pkg/utils"
+)
+
+// auditInterval is how often the allocated addresses of the load balancers are compared with the pool records
+const auditInterval = 10 * time.Minute
+
+// OnChangeToAudit compares the allocated addresses of the load balancers with the records of the IPPool.
+// The missing records are re-booked, and the double-bookings are reported by the AddressConflict condition of both
+// the IPPool and the load balancers.
+// The IPPool changes often, e.g. its status is written after allocations, so it is audited at most once per audit
+// interval, the next audit has been scheduled by the last one.
+func (h *Handler) OnChangeToAudit(_ string, ipPool *lbv1.IPPool) (*lbv1.IPPool, error) {
+	if ipPool == nil || ipPool.DeletionTimestamp != nil {
+		return ipPool, nil
+	}
+
+	now := time.Now()
+	if !h.isAuditDue(ipPool.Name, now) {
+		return ipPool, nil
+	}
+
+	updated, err := h.audit(ipPool)
+	if err != nil {
+		return ipPool, err
+	}
+	h.markAudited(ipPool.Name, now)
+	h.ipPoolController.EnqueueAfter(ipPool.Name, auditInterval)
+
+	return updated, nil
+}
+
+// isAuditDue checks whether the audit interval has passed since the pool was audited last time
+func (h *Handler) isAuditDue(name string, now time.Time) bool {
+	h.auditMutex.Lock()
+	defer h.auditMutex.Unlock()
+
+	last, ok := h.auditTimes[name]
+
+	return !ok || !now.Before(last.Add(auditInterval))
+}
+
+func (h *Handler) markAudited(name string, now time.Time) {
+	h.auditMutex.Lock()
+	defer h.auditMutex.Unlock()
+
+	if h.auditTimes == nil {
+		h.auditTimes = make(map[string]time.Time)
+	}
+	h.auditTimes[name] = now
+}
+
+func (h *Handler) forgetAudit(name string) {
+	h.auditMutex.Lock()
+	defer h.auditMutex.Unlock()
+
+	delete(h.auditTimes, name)
+}
+
+func (h *Handler) audit(pool *lbv1.IPPool) (*lbv1.IPPool, error) {
+	lbs, err := h.lbCache.List(metav1.NamespaceAll, labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	// claims maps the IPs of the pool to the load balancers whose status names them
+	claims := make(map[string][]string)
+	claimants := make(map[string]*lbv1.LoadBalancer)
+	for _, lb := range lbs {
+		if !isAuditable(lb) {
+			continue
+		}
+		key := lb.Namespace + "/" + lb.Name
+		for _, address := range []lbv1.AllocatedAddress{lb.Status.AllocatedAddress, lb.Status.SecondaryAllocatedAddress} {
+			ip := net.ParseIP(address.IP)
+			if address.IPPool != pool.Name || ip == nil {
+				continue
+			}
+			claims[ip.String()] = append(claims[ip.String()], key)
+			claimants[key] = lb
+		}
+	}
+
+	allocated, err := store.GetAllocated(h.allocationCache, pool)
+	if err != nil {
+		return nil, err
+	}
+
+	ips := make([]string, 0, len(claims))
+	for ip := range claims {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+
+	conflicts := make(map[string][]string)
+	var poolConflicts []string
+	for _, ip := range ips {
+		keys := claims[ip]
+		slices.Sort(keys)
+		owner, ok := allocated[ip]
+
+		var message string
+		switch {
+		case len(keys) > 1 && ok:
+			message = fmt.Sprintf("IP %s allocated to %s is claimed by %s", ip, owner, strings.Join(keys, ", "))
+		case len(keys) > 1:
+			message = fmt.Sprintf("IP %s is claimed by %s", ip, strings.Join(keys, ", "))
+		case ok && owner != keys[0]:
+			message = fmt.Sprintf("IP %s allocated to %s is claimed by %s", ip, owner, keys[0])
+		case !ok:
+			if err := h.rebook(pool, ip, keys[0]); err != nil {
+				message = fmt.Sprintf("IP %s claimed by %s can't be re-booked, error: %s", ip, keys[0], err.Error())
+			}
+		}
+		if message == "" {
+			continue
+		}
+
+		logrus.Warnf("IP Pool %s has a double-booking, %s", pool.Name, message)
+		poolConflicts = append(poolConflicts, message)
+		for _, key := range keys {
+			conflicts[key] = append(conflicts[key], message)
+		}
+	}
+
+	for key, lb := range claimants {
+		if err := h.updateAddressConflict(lb, conflicts[key]); err != nil {
+			return nil, err
+		}
+	}
+
+	poolCopy := pool.DeepCopy()
+	setAddressConflict(poolCopy, lbv1.IPPoolAddressConflict, poolConflicts)
+	if reflect.DeepEqual(pool.Status, poolCopy.Status) {
+		return pool, nil
+	}
+	updated, err := h.ipPoolClient.Update(poolCopy)
+	if err != nil {
+		return nil, fmt.Errorf("update address conflict of pool %s failed, %w", pool.Name, err)
+	}
+
+	return updated, nil
+}
+
+// isAuditable returns false if the load balancer doesn't allocate IPs from a pool, it adopts the address of an IP
+// claim, or it is releasing its allocated address, in which case the address may have been released while the status
+// is not updated yet
+func isAuditable(lb *lbv1.LoadBalancer) bool {
+	if lb.DeletionTimestamp != nil || lb.Spec.IPAM == lbv1.DHCP || lb.Status.AllocatedAddress.IPPool == "" ||
+		lb.Spec.IPClaim != "" {
+		return false
+	}
+	if lb.Spec.IPPool != "" && lb.Spec.IPPool != lb.Status.AllocatedAddress.IPPool {
+		return false
+	}
+
+	for _, address := range []lbv1.AllocatedAddress{lb.Status.AllocatedAddress, lb.Status.SecondaryAllocatedAddress} {
+		ip := net.ParseIP(address.IP)
+		if ip == nil {
+			continue
+		}
+		for _, requested := range lb.Spec.RequestedIPs {
+			if requestedIP := net.ParseIP(requested); requestedIP != nil &&
+				utils.GetIPFamily(requestedIP) == utils.GetIPFamily(ip) && !requestedIP.Equal(ip) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// rebook records the IP claimed by the load balancer into the pool
+func (h *Handler) rebook(pool *lbv1.IPPool, ipStr, applicant string) error {
+	a := h.allocatorMap.Get(pool.Name)
+	if a == nil {
+		return fmt.Errorf("fail to get allocator %s", pool.Name)
+	}
+
+	ip := net.ParseIP(ipStr)
+	if _, err := a.Get(applicant, utils.GetIPFamily(ip), ip); err != nil {
+		return err
+	}
+	logrus.Infof("IP Pool %s re-books the IP %s claimed by %s", pool.Name, ipStr, applicant)
+
+	return nil
+}
+
+func (h *Handler) updateAddressConflict(lb *lbv1.LoadBalancer, conflicts []string) error {
+	lbCopy := lb.DeepCopy()
+	setAddressConflict(lbCopy, lbv1.LoadBalancerAddressConflict, conflicts)
+	if reflect.DeepEqual(lb.Status, lbCopy.Status) {
+		return nil
+	}
+	if _, err := h.lbClient.Update(lbCopy); err != nil {
+		return fmt.Errorf("update address conflict of lb %s/%s failed, %w", lb.Namespace, lb.Name, err)
+	}
+
+	return nil
+}
+
+// setAddressConflict sets the condition true with the conflicts as the message, or false if there is no conflict.
+// The condition isn't added to the objects which never have a conflict.
+func setAddressConflict(obj interface{}, cond condition.Cond, conflicts []string) {
+	if len(conflicts) > 0 {
+		cond.True(obj)
+		cond.Message(obj, strings.Join(conflicts, "; "))
+		return
+	}
+	if cond.GetStatus(obj) == "" {
+		return
+	}
+	cond.False(obj)
+	cond.Message(obj, "")
+}
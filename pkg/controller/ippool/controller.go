@@ -5,40 +5,68 @@ import (
 	"fmt"
 	"net"
 	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
 	ctlcorev1 "github.com/rancher/wrangler/v3/pkg/generated/controllers/core/v1"
 	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/cache"
 
 	lbv1 "github.com/harvester/harvester-load-balancer/pkg/apis/loadbalancer.harvesterhci.io/v1beta1"
 	"github.com/harvester/harvester-load-balancer/pkg/config"
 	"github.com/harvester/harvester-load-balancer/pkg/controller/ippool/kubevip"
 	ctllbv1 "github.com/harvester/harvester-load-balancer/pkg/generated/controllers/loadbalancer.harvesterhci.io/v1beta1"
 	"github.com/harvester/harvester-load-balancer/pkg/ipam"
+	"github.com/harvester/harvester-load-balancer/pkg/ipam/store"
 	"github.com/harvester/harvester-load-balancer/pkg/utils"
 )
 
 const controllerName = "harvester-ipam-controller"
 
+const eventReasonIPInUse = "IPInUse"
+
 type Handler struct {
-	ipPoolCache  ctllbv1.IPPoolCache
-	ipPoolClient ctllbv1.IPPoolClient
-	cmClient     ctlcorev1.ConfigMapClient
-	lbCache      ctllbv1.LoadBalancerCache
+	ipPoolCache      ctllbv1.IPPoolCache
+	ipPoolClient     ctllbv1.IPPoolClient
+	ipPoolController ctllbv1.IPPoolController
+	allocationCache  ctllbv1.IPAllocationCache
+	allocationClient ctllbv1.IPAllocationClient
+	cmClient         ctlcorev1.ConfigMapClient
+	eventClient      ctlcorev1.EventClient
+	lbCache          ctllbv1.LoadBalancerCache
+	lbClient         ctllbv1.LoadBalancerClient
+	claimCache       ctllbv1.IPClaimCache
+	namespaceCache   ctlcorev1.NamespaceCache
 
 	allocatorMap           *ipam.SafeAllocatorMap
 	kubevipIPPoolConverter *kubevip.IPPoolConverter
+
+	// auditMutex protects auditTimes, which records when each pool was audited last time
+	auditMutex sync.Mutex
+	auditTimes map[string]time.Time
 }
 
 func Register(ctx context.Context, management *config.Management) error {
 	ipPools := management.LbFactory.Loadbalancer().V1beta1().IPPool()
+	ipAllocations := management.LbFactory.Loadbalancer().V1beta1().IPAllocation()
 	configmaps := management.CoreFactory.Core().V1().ConfigMap()
-	lbCache := management.LbFactory.Loadbalancer().V1beta1().LoadBalancer().Cache()
+	lbs := management.LbFactory.Loadbalancer().V1beta1().LoadBalancer()
 
 	handler := &Handler{
 		ipPoolCache:            ipPools.Cache(),
 		ipPoolClient:           ipPools,
-		lbCache:                lbCache,
+		ipPoolController:       ipPools,
+		allocationCache:        ipAllocations.Cache(),
+		allocationClient:       ipAllocations,
+		eventClient:            management.CoreFactory.Core().V1().Event(),
+		lbCache:                lbs.Cache(),
+		lbClient:               lbs,
+		claimCache:             management.LbFactory.Loadbalancer().V1beta1().IPClaim().Cache(),
+		namespaceCache:         management.CoreFactory.Core().V1().Namespace().Cache(),
 		allocatorMap:           management.AllocatorMap,
 		kubevipIPPoolConverter: kubevip.NewIPPoolConverter(configmaps),
 	}
@@ -49,13 +77,26 @@ func Register(ctx context.Context, management *config.Management) error {
 
 	ipPools.OnChange(ctx, controllerName, handler.OnChange)
 	ipPools.OnChange(ctx, controllerName, handler.OnChangeToReleaseAnIP)
+	ipPools.OnChange(ctx, controllerName, handler.OnChangeToPruneHistory)
+	ipPools.OnChange(ctx, controllerName, handler.OnChangeToCollectOrphans)
+	ipPools.OnChange(ctx, controllerName, handler.OnChangeToAudit)
 	ipPools.OnRemove(ctx, controllerName, handler.OnRemove)
+	ipAllocations.OnChange(ctx, controllerName, handler.OnIPAllocationChange)
+	// the deleted allocation is only passed to the informer, an OnRemove handler would hold the deletion by a
+	// finalizer and block the IP from being allocated again
+	if _, err := ipAllocations.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		DeleteFunc: handler.OnIPAllocationDelete,
+	}); err != nil {
+		return fmt.Errorf("add the delete handler of IP allocations failed, %w", err)
+	}
 
 	return nil
 }
 
 // OnChange is called when a IPPool is created or updated
-// Create a new ipam allocator if the IPPool is new or the ranges are changed
+// Migrate the allocated IPs recorded in the status by previous versions to IPAllocation objects
+// Create a new ipam allocator if the IPPool is new, or rebuild it on the same store if the ranges, exclusions or
+// reservations are changed, the ranges delegated to the child pools are excluded
 func (h *Handler) OnChange(_ string, ipPool *lbv1.IPPool) (*lbv1.IPPool, error) {
 	if ipPool == nil || ipPool.DeletionTimestamp != nil {
 		return nil, nil
@@ -63,23 +104,121 @@ func (h *Handler) OnChange(_ string, ipPool *lbv1.IPPool) (*lbv1.IPPool, error)
 
 	logrus.Debugf("IP Pool %s has been changed", ipPool.Name)
 
-	previousAllocator := h.allocatorMap.Get(ipPool.Name)
-	if previousAllocator == nil || previousAllocator.CheckSum() != ipam.CalculateCheckSum(ipPool.Spec.Ranges) {
-		a, err := ipam.NewAllocator(ipPool.Name, ipPool.Spec.Ranges, h.ipPoolCache, h.ipPoolClient)
+	ipPool, err := h.migrateAllocated(ipPool)
+	if err != nil {
+		return nil, err
+	}
+
+	// the parent counts the IPs delegated to its children
+	if ipPool.Spec.Parent != "" {
+		h.ipPoolController.Enqueue(ipPool.Spec.Parent)
+	}
+
+	children, err := ipam.GetChildren(h.ipPoolCache, ipPool.Name)
+	if err != nil {
+		return nil, err
+	}
+	spec, err := ipam.ExcludeDelegated(&ipPool.Spec, children)
+	if err != nil {
+		return nil, err
+	}
+
+	a := h.allocatorMap.Get(ipPool.Name)
+	if a == nil || a.CheckSum() != ipam.CalculateCheckSum(spec) {
+		if a == nil {
+			a, err = ipam.NewAllocator(ipPool.Name, spec, h.ipPoolCache, h.ipPoolClient, h.allocationCache, h.allocationClient)
+		} else {
+			a, err = a.Rebuild(spec)
+		}
 		if err != nil {
 			return nil, err
 		}
-
-		// update status
+		// the IPs found in use by the conflict detection are skipped, tell which host address conflicts
+		a.SetConflictHandler(func(ip net.IP) {
+			h.recordEvent(ipPool, corev1.EventTypeWarning, eventReasonIPInUse,
+				fmt.Sprintf("IP %s is in use on the network, it is skipped by the allocation", ip))
+		})
+		a.SetTenantGetter(ipam.NewTenantGetter(h.lbCache, h.claimCache, h.namespaceCache))
 		h.allocatorMap.AddOrUpdate(ipPool.Name, a)
-		if err := h.updateStatus(ipPool, a.Total()); err != nil {
-			return nil, err
-		}
+	}
+
+	// the counters are updated whenever the pool or its allocations change
+	updated, err := h.updateStatus(ipPool, spec, a.Total(), children)
+	if err != nil {
+		return nil, err
+	}
+
+	// the expansion relies on the counters of the current ranges
+	if _, err := h.expand(updated); err != nil {
+		return nil, err
 	}
 
 	return ipPool, nil
 }
 
+// OnIPAllocationChange is called when an IPAllocation is created or updated
+// Enqueue the IPPool of the allocation to update its counters
+func (h *Handler) OnIPAllocationChange(_ string, allocation *lbv1.IPAllocation) (*lbv1.IPAllocation, error) {
+	// the deleted allocation is handled by OnIPAllocationDelete
+	if allocation == nil {
+		return nil, nil
+	}
+	h.ipPoolController.Enqueue(allocation.Spec.IPPool)
+
+	return allocation, nil
+}
+
+// OnIPAllocationDelete is called by the informer when an IPAllocation is deleted
+// Enqueue the IPPool which the allocation belongs to, the object may be wrapped in a tombstone if the deletion was
+// missed by the watch
+func (h *Handler) OnIPAllocationDelete(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	allocation, ok := obj.(*lbv1.IPAllocation)
+	if !ok {
+		return
+	}
+
+	pool := allocation.Labels[utils.LabelKeyIPPool]
+	if pool == "" {
+		pool = allocation.Spec.IPPool
+	}
+	if pool != "" {
+		h.ipPoolController.Enqueue(pool)
+	}
+}
+
+// migrateAllocated creates an IPAllocation object for each allocated IP recorded in the status of the pool by
+// previous versions and removes them from the status
+func (h *Handler) migrateAllocated(pool *lbv1.IPPool) (*lbv1.IPPool, error) {
+	if len(pool.Status.Allocated) == 0 {
+		return pool, nil
+	}
+
+	logrus.Infof("Migrate %d allocated IPs of IP Pool %s to IPAllocation objects", len(pool.Status.Allocated), pool.Name)
+	for ipStr, applicant := range pool.Status.Allocated {
+		ip := net.ParseIP(ipStr)
+		if ip == nil {
+			logrus.Warnf("IP Pool %s has an invalid allocated IP %s, skip", pool.Name, ipStr)
+			continue
+		}
+		allocation := store.NewAllocation(pool, ip.String(), applicant)
+		if _, err := h.allocationClient.Create(allocation); err != nil && !apierrors.IsAlreadyExists(err) {
+			return nil, fmt.Errorf("migrate allocated IP %s of pool %s failed, %w", ipStr, pool.Name, err)
+		}
+	}
+
+	poolCopy := pool.DeepCopy()
+	poolCopy.Status.Allocated = nil
+	updated, err := h.ipPoolClient.Update(poolCopy)
+	if err != nil {
+		return nil, fmt.Errorf("remove migrated allocated IPs from pool %s failed, %w", pool.Name, err)
+	}
+
+	return updated, nil
+}
+
 // OnRemove is called when a IPPool is deleted
 // Delete the ipam allocator
 func (h *Handler) OnRemove(_ string, ipPool *lbv1.IPPool) (*lbv1.IPPool, error) {
@@ -88,6 +227,11 @@ func (h *Handler) OnRemove(_ string, ipPool *lbv1.IPPool) (*lbv1.IPPool, error)
 	}
 	logrus.Infof("IP Pool %s is deleted", ipPool.Name)
 	h.allocatorMap.Delete(ipPool.Name)
+	h.forgetAudit(ipPool.Name)
+	// the parent takes back the delegated ranges
+	if ipPool.Spec.Parent != "" {
+		h.ipPoolController.Enqueue(ipPool.Spec.Parent)
+	}
 	return ipPool, nil
 }
 
@@ -109,36 +253,125 @@ func (h *Handler) initializeFromKubevipConfigMap() error {
 	return h.kubevipIPPoolConverter.AfterConversion()
 }
 
-func (h *Handler) updateStatus(pool *lbv1.IPPool, total int64) error {
-	poolCopy := pool.DeepCopy()
-	if pool.Status.Allocated == nil {
-		poolCopy.Status.Available = total
-	} else {
-		poolCopy.Status.Available = total - int64(len(pool.Status.Allocated))
+// updateStatus updates the counters and conditions of the pool, the spec is the one which the allocator is built from.
+// It returns the updated pool.
+func (h *Handler) updateStatus(pool *lbv1.IPPool, spec *lbv1.IPPoolSpec, total int64, children []*lbv1.IPPool) (*lbv1.IPPool, error) {
+	allocated, err := store.GetAllocated(h.allocationCache, pool)
+	if err != nil {
+		return nil, err
+	}
+
+	rs, err := ipam.LBPoolSpecToAllocatorRangeSet(spec)
+	if err != nil {
+		return nil, err
+	}
+	// the IPs out of the ranges, e.g. allocated before the ranges shrink, don't take the available IPs.
+	// In the re-address mode, they are marked for the load balancers to move to new addresses.
+	var used int64
+	var reAddressing map[string]string
+	for ipStr, applicant := range allocated {
+		ip := net.ParseIP(ipStr)
+		if ip == nil {
+			continue
+		}
+		if rs.Contains(ip) {
+			used++
+			continue
+		}
+		if pool.Spec.ReAddress {
+			if reAddressing == nil {
+				reAddressing = make(map[string]string)
+			}
+			reAddressing[ipStr] = applicant
+		}
 	}
+
+	poolCopy := pool.DeepCopy()
+	poolCopy.Status.Available = total - used
 	poolCopy.Status.Total = total
+	if pool.Status.Total != poolCopy.Status.Total || pool.Status.Available != poolCopy.Status.Available {
+		logrus.Debugf("IP Pool %s recomputes the counters, total %d -> %d, available %d -> %d", pool.Name,
+			pool.Status.Total, poolCopy.Status.Total, pool.Status.Available, poolCopy.Status.Available)
+	}
 
-	var err error
-	poolCopy.Status.AllocatedHistory, err = correctAllocatedHistory(pool)
+	poolCopy.Status.Delegated, poolCopy.Status.DelegatedUsed = countDelegated(children)
+
+	poolCopy.Status.AllocatedHistory, err = correctAllocatedHistory(pool, spec)
 	if err != nil {
-		return fmt.Errorf("correct allocated history for %s failed, %w", pool.Name, err)
+		return nil, fmt.Errorf("correct allocated history for %s failed, %w", pool.Name, err)
 	}
 
 	lbv1.IPPoolReady.True(poolCopy)
 	lbv1.IPPoolReady.Message(poolCopy, "")
+	if pool.Spec.Cordoned {
+		lbv1.IPPoolCordoned.True(poolCopy)
+		lbv1.IPPoolCordoned.Message(poolCopy, "the pool refuses new allocations")
+	} else if lbv1.IPPoolCordoned.GetStatus(poolCopy) != "" {
+		lbv1.IPPoolCordoned.False(poolCopy)
+		lbv1.IPPoolCordoned.Message(poolCopy, "")
+	}
+
+	poolCopy.Status.Quotas, err = h.getQuotaUsage(pool, allocated)
+	if err != nil {
+		return nil, fmt.Errorf("count quota usage of %s failed, %w", pool.Name, err)
+	}
+
+	for ipStr, applicant := range reAddressing {
+		if _, ok := pool.Status.ReAddressing[ipStr]; !ok {
+			logrus.Infof("IP Pool %s marks IP %s allocated to %s to be re-addressed", pool.Name, ipStr, applicant)
+		}
+	}
+	poolCopy.Status.ReAddressing = reAddressing
+	if len(reAddressing) > 0 {
+		lbv1.IPPoolReAddressing.True(poolCopy)
+		lbv1.IPPoolReAddressing.Message(poolCopy, fmt.Sprintf("%d allocated IPs excluded from the ranges are being re-addressed", len(reAddressing)))
+	} else if lbv1.IPPoolReAddressing.GetStatus(poolCopy) != "" {
+		lbv1.IPPoolReAddressing.False(poolCopy)
+		lbv1.IPPoolReAddressing.Message(poolCopy, "")
+	}
 
 	if reflect.DeepEqual(pool.Status, poolCopy.Status) {
-		return nil
+		return pool, nil
 	}
-	if _, err := h.ipPoolClient.Update(poolCopy); err != nil {
-		return fmt.Errorf("update IP pool %s status failed, %w", pool.Name, err)
+	updated, err := h.ipPoolClient.Update(poolCopy)
+	if err != nil {
+		return nil, fmt.Errorf("update IP pool %s status failed, %w", pool.Name, err)
 	}
 
-	return nil
+	return updated, nil
+}
+
+// getQuotaUsage returns the usage of the quotas of the pool, nil if the pool has no quota
+func (h *Handler) getQuotaUsage(pool *lbv1.IPPool, allocated map[string]string) ([]lbv1.QuotaUsage, error) {
+	if len(pool.Spec.Quotas) == 0 {
+		return nil, nil
+	}
+
+	used, err := ipam.GetQuotaUsage(pool, allocated, ipam.NewTenantGetter(h.lbCache, h.claimCache, h.namespaceCache), "")
+	if err != nil {
+		return nil, err
+	}
+	usage := make([]lbv1.QuotaUsage, 0, len(used))
+	for i := range pool.Spec.Quotas {
+		usage = append(usage, lbv1.QuotaUsage{Tuple: pool.Spec.Quotas[i].Tuple, Used: used[i]})
+	}
+
+	return usage, nil
+}
+
+// countDelegated returns the number of IPs delegated to the child pools and the number of them allocated, the IPs
+// delegated further by the children are included
+func countDelegated(children []*lbv1.IPPool) (delegated, used int64) {
+	for _, child := range children {
+		delegated += child.Status.Total + child.Status.Delegated
+		used += child.Status.Total - child.Status.Available + child.Status.DelegatedUsed
+	}
+
+	return delegated, used
 }
 
-func correctAllocatedHistory(pool *lbv1.IPPool) (map[string]string, error) {
-	rs, err := ipam.LBRangesToAllocatorRangeSet(pool.Spec.Ranges)
+func correctAllocatedHistory(pool *lbv1.IPPool, spec *lbv1.IPPoolSpec) (map[string]string, error) {
+	rs, err := ipam.LBPoolSpecToAllocatorRangeSet(spec)
 	if err != nil {
 		return nil, err
 	}
@@ -158,6 +391,93 @@ func correctAllocatedHistory(pool *lbv1.IPPool) (map[string]string, error) {
 	return allocatedHistory, nil
 }
 
+// OnChangeToPruneHistory removes the allocated history entries beyond the retention policy of the IPPool
+// and requeues the IPPool when the next entry expires
+func (h *Handler) OnChangeToPruneHistory(_ string, ipPool *lbv1.IPPool) (*lbv1.IPPool, error) {
+	if ipPool == nil || ipPool.DeletionTimestamp != nil {
+		return ipPool, nil
+	}
+
+	poolCopy := ipPool.DeepCopy()
+	next := pruneAllocatedHistory(poolCopy, time.Now())
+	if !reflect.DeepEqual(ipPool.Status, poolCopy.Status) {
+		logrus.Debugf("IP Pool %s has pruned the allocated history", ipPool.Name)
+		updated, err := h.ipPoolClient.Update(poolCopy)
+		if err != nil {
+			return ipPool, fmt.Errorf("prune allocated history for %s failed, %w", ipPool.Name, err)
+		}
+		ipPool = updated
+	}
+
+	if next > 0 {
+		h.ipPoolController.EnqueueAfter(ipPool.Name, next)
+	}
+
+	return ipPool, nil
+}
+
+// pruneAllocatedHistory stamps the allocated history entries without a creation time, which are recorded by
+// previous versions, and removes the entries older than the max age or beyond the max entries.
+// It returns the duration until the next entry expires, or zero if no entry expires.
+func pruneAllocatedHistory(pool *lbv1.IPPool, now time.Time) time.Duration {
+	history, timestamps := pool.Status.AllocatedHistory, pool.Status.AllocatedHistoryTimestamps
+	if len(history) == 0 {
+		pool.Status.AllocatedHistoryTimestamps = nil
+		return 0
+	}
+
+	createdTime := make(map[string]time.Time, len(history))
+	for ip := range history {
+		t, err := time.Parse(time.RFC3339, timestamps[ip])
+		if err != nil {
+			t = now
+		}
+		createdTime[ip] = t
+	}
+
+	retention := pool.Spec.HistoryRetention
+	var next time.Duration
+	if retention.MaxAgeSeconds > 0 {
+		maxAge := time.Duration(retention.MaxAgeSeconds) * time.Second
+		for ip, t := range createdTime {
+			left := t.Add(maxAge).Sub(now)
+			if left <= 0 {
+				delete(createdTime, ip)
+				continue
+			}
+			if next == 0 || left < next {
+				next = left
+			}
+		}
+	}
+
+	if retention.MaxEntries > 0 && len(createdTime) > int(retention.MaxEntries) {
+		ips := make([]string, 0, len(createdTime))
+		for ip := range createdTime {
+			ips = append(ips, ip)
+		}
+		// remove the oldest entries first
+		slices.SortFunc(ips, func(a, b string) int {
+			if c := createdTime[a].Compare(createdTime[b]); c != 0 {
+				return c
+			}
+			return strings.Compare(a, b)
+		})
+		for _, ip := range ips[:len(ips)-int(retention.MaxEntries)] {
+			delete(createdTime, ip)
+		}
+	}
+
+	pool.Status.AllocatedHistory = make(map[string]string, len(createdTime))
+	pool.Status.AllocatedHistoryTimestamps = make(map[string]string, len(createdTime))
+	for ip, t := range createdTime {
+		pool.Status.AllocatedHistory[ip] = history[ip]
+		pool.Status.AllocatedHistoryTimestamps[ip] = t.UTC().Format(time.RFC3339)
+	}
+
+	return next
+}
+
 // Due to previous version bug, the LB may be removed but the related IP still exists on pool allocation record
 // This feature gives a way to remove the IP allocation record manually
 func (h *Handler) OnChangeToReleaseAnIP(_ string, ipPool *lbv1.IPPool) (*lbv1.IPPool, error) {
@@ -179,7 +499,11 @@ func (h *Handler) OnChangeToReleaseAnIP(_ string, ipPool *lbv1.IPPool) (*lbv1.IP
 	}
 
 	// check it is a real allocation record
-	lbStr := ipPool.Status.Allocated[ip]
+	allocated, err := store.GetAllocated(h.allocationCache, ipPool)
+	if err != nil {
+		return ipPool, err
+	}
+	lbStr := allocated[ip]
 	if lbStr == "" || lbStr != fmt.Sprintf("%s/%s", namespace, name) {
 		logrus.Infof("IP Pool %s has a manual IP release request %s, it has been released, skip", ipPool.Name, ipStr)
 		return h.removeAnnotationKeyManuallyReleaseIP(ipPool)
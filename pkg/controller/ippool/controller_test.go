@@ -0,0 +1,627 @@
+package ippool
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+
+	lbv1 "github.com/harvester/harvester-load-balancer/pkg/apis/loadbalancer.harvesterhci.io/v1beta1"
+	"github.com/harvester/harvester-load-balancer/pkg/generated/clientset/versioned/fake"
+	ctllbv1 "github.com/harvester/harvester-load-balancer/pkg/generated/controllers/loadbalancer.harvesterhci.io/v1beta1"
+	"github.com/harvester/harvester-load-balancer/pkg/ipam"
+	"github.com/harvester/harvester-load-balancer/pkg/ipam/store"
+	"github.com/harvester/harvester-load-balancer/pkg/utils/fakeclients"
+)
+
+// fakeIPPoolController only records the enqueued pools
+type fakeIPPoolController struct {
+	ctllbv1.IPPoolController
+	enqueued []string
+	after    []string
+}
+
+func (c *fakeIPPoolController) Enqueue(name string) {
+	c.enqueued = append(c.enqueued, name)
+}
+
+func (c *fakeIPPoolController) EnqueueAfter(name string, _ time.Duration) {
+	c.after = append(c.after, name)
+}
+
+func TestPruneAllocatedHistory(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	stamp := func(d time.Duration) string {
+		return now.Add(-d).Format(time.RFC3339)
+	}
+
+	tests := []struct {
+		name           string
+		retention      lbv1.HistoryRetention
+		history        map[string]string
+		timestamps     map[string]string
+		wantHistory    map[string]string
+		wantTimestamps map[string]string
+		wantNext       time.Duration
+	}{
+		{
+			name:      "no history",
+			retention: lbv1.HistoryRetention{MaxAgeSeconds: 60, MaxEntries: 1},
+			timestamps: map[string]string{
+				"192.168.100.10": stamp(time.Second),
+			},
+		},
+		{
+			name: "stamp the entries without creation time",
+			history: map[string]string{
+				"192.168.100.10": "default/lb1",
+				"192.168.100.11": "default/lb2",
+			},
+			timestamps: map[string]string{
+				"192.168.100.11": stamp(time.Hour),
+				"192.168.100.12": stamp(time.Hour),
+			},
+			wantHistory: map[string]string{
+				"192.168.100.10": "default/lb1",
+				"192.168.100.11": "default/lb2",
+			},
+			wantTimestamps: map[string]string{
+				"192.168.100.10": stamp(0),
+				"192.168.100.11": stamp(time.Hour),
+			},
+		},
+		{
+			name:      "remove the expired entries",
+			retention: lbv1.HistoryRetention{MaxAgeSeconds: 3600},
+			history: map[string]string{
+				"192.168.100.10": "default/lb1",
+				"192.168.100.11": "default/lb2",
+				"192.168.100.12": "default/lb3",
+			},
+			timestamps: map[string]string{
+				"192.168.100.10": stamp(2 * time.Hour),
+				"192.168.100.11": stamp(time.Hour),
+				"192.168.100.12": stamp(20 * time.Minute),
+			},
+			wantHistory: map[string]string{
+				"192.168.100.12": "default/lb3",
+			},
+			wantTimestamps: map[string]string{
+				"192.168.100.12": stamp(20 * time.Minute),
+			},
+			wantNext: 40 * time.Minute,
+		},
+		{
+			name:      "remove the oldest entries beyond the max entries",
+			retention: lbv1.HistoryRetention{MaxEntries: 2},
+			history: map[string]string{
+				"192.168.100.10": "default/lb1",
+				"192.168.100.11": "default/lb2",
+				"192.168.100.12": "default/lb3",
+				"192.168.100.13": "default/lb4",
+			},
+			timestamps: map[string]string{
+				"192.168.100.10": stamp(time.Minute),
+				"192.168.100.11": stamp(time.Hour),
+				"192.168.100.12": stamp(time.Hour),
+				"192.168.100.13": stamp(time.Second),
+			},
+			wantHistory: map[string]string{
+				"192.168.100.10": "default/lb1",
+				"192.168.100.13": "default/lb4",
+			},
+			wantTimestamps: map[string]string{
+				"192.168.100.10": stamp(time.Minute),
+				"192.168.100.13": stamp(time.Second),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := &lbv1.IPPool{
+				Spec: lbv1.IPPoolSpec{HistoryRetention: tt.retention},
+				Status: lbv1.IPPoolStatus{
+					AllocatedHistory:           tt.history,
+					AllocatedHistoryTimestamps: tt.timestamps,
+				},
+			}
+
+			next := pruneAllocatedHistory(pool, now)
+			if next != tt.wantNext {
+				t.Errorf("pruneAllocatedHistory() = %v, want %v", next, tt.wantNext)
+			}
+			if len(pool.Status.AllocatedHistory) != 0 || len(tt.wantHistory) != 0 {
+				if !reflect.DeepEqual(pool.Status.AllocatedHistory, tt.wantHistory) {
+					t.Errorf("allocated history = %v, want %v", pool.Status.AllocatedHistory, tt.wantHistory)
+				}
+			}
+			if len(pool.Status.AllocatedHistoryTimestamps) != 0 || len(tt.wantTimestamps) != 0 {
+				if !reflect.DeepEqual(pool.Status.AllocatedHistoryTimestamps, tt.wantTimestamps) {
+					t.Errorf("allocated history timestamps = %v, want %v", pool.Status.AllocatedHistoryTimestamps, tt.wantTimestamps)
+				}
+			}
+		})
+	}
+}
+
+func TestHandler_MigrateAllocated(t *testing.T) {
+	pool := &lbv1.IPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "pool1"},
+		Status: lbv1.IPPoolStatus{
+			Allocated: map[string]string{
+				"192.168.100.10": "default/lb1",
+				"fd00:100::a":    "default/lb1",
+			},
+		},
+	}
+	clientset := fake.NewSimpleClientset(pool)
+	h := &Handler{
+		ipPoolClient:     fakeclients.IPPoolClient(clientset.LoadbalancerV1beta1().IPPools),
+		allocationCache:  fakeclients.IPAllocationCache(clientset.LoadbalancerV1beta1().IPAllocations),
+		allocationClient: fakeclients.IPAllocationClient(clientset.LoadbalancerV1beta1().IPAllocations),
+	}
+
+	migrated, err := h.migrateAllocated(pool)
+	if err != nil {
+		t.Fatalf("migrateAllocated() error = %v", err)
+	}
+	if len(migrated.Status.Allocated) != 0 {
+		t.Errorf("expect the allocated IPs are removed from the status, got %v", migrated.Status.Allocated)
+	}
+
+	allocated, err := store.GetAllocated(h.allocationCache, migrated)
+	if err != nil {
+		t.Fatalf("failed to get allocated IPs, error: %s", err.Error())
+	}
+	if !reflect.DeepEqual(allocated, pool.Status.Allocated) {
+		t.Errorf("allocated IPs = %v, want %v", allocated, pool.Status.Allocated)
+	}
+}
+
+func TestHandler_OnIPAllocationDelete(t *testing.T) {
+	pool := &lbv1.IPPool{ObjectMeta: metav1.ObjectMeta{Name: "pool1"}}
+	allocation := store.NewAllocation(pool, "192.168.100.10", "default/lb1")
+
+	tests := []struct {
+		name string
+		obj  interface{}
+		want []string
+	}{
+		{
+			name: "the deleted allocation",
+			obj:  allocation,
+			want: []string{"pool1"},
+		},
+		{
+			name: "the deleted allocation in a tombstone",
+			obj:  cache.DeletedFinalStateUnknown{Key: allocation.Name, Obj: allocation},
+			want: []string{"pool1"},
+		},
+		{
+			name: "the tombstone of another object",
+			obj:  cache.DeletedFinalStateUnknown{Key: "pool1", Obj: pool},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller := &fakeIPPoolController{}
+			h := &Handler{ipPoolController: controller}
+			h.OnIPAllocationDelete(tt.obj)
+			if !reflect.DeepEqual(controller.enqueued, tt.want) {
+				t.Errorf("enqueued pools = %v, want %v", controller.enqueued, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandler_CollectOrphans(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	stamp := func(d time.Duration) string {
+		return now.Add(-d).UTC().Format(time.RFC3339)
+	}
+
+	tests := []struct {
+		name         string
+		collection   *lbv1.OrphanCollection
+		orphaned     map[string]string
+		wantOrphaned map[string]string
+		wantReleased []string
+		wantNext     time.Duration
+	}{
+		{
+			name:       "record the newly found orphans",
+			collection: &lbv1.OrphanCollection{Enabled: true},
+			wantOrphaned: map[string]string{
+				"192.168.100.11": stamp(0),
+				"192.168.100.12": stamp(0),
+			},
+			wantNext: defaultOrphanGracePeriod,
+		},
+		{
+			name:       "release the orphans beyond the grace period",
+			collection: &lbv1.OrphanCollection{Enabled: true, GracePeriodSeconds: 180},
+			orphaned: map[string]string{
+				"192.168.100.10": stamp(time.Hour),
+				"192.168.100.11": stamp(time.Hour),
+				"192.168.100.12": stamp(time.Minute),
+			},
+			wantOrphaned: map[string]string{
+				"192.168.100.12": stamp(time.Minute),
+			},
+			wantReleased: []string{"192.168.100.11"},
+			wantNext:     2 * time.Minute,
+		},
+		{
+			name:       "only report the orphans in dry-run mode",
+			collection: &lbv1.OrphanCollection{Enabled: true, GracePeriodSeconds: 600, DryRun: true},
+			orphaned: map[string]string{
+				"192.168.100.11": stamp(time.Hour),
+			},
+			wantOrphaned: map[string]string{
+				"192.168.100.11": stamp(time.Hour),
+				"192.168.100.12": stamp(0),
+			},
+			wantNext: orphanCheckInterval,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := &lbv1.IPPool{
+				ObjectMeta: metav1.ObjectMeta{Name: "pool1"},
+				Spec: lbv1.IPPoolSpec{
+					Ranges:           []lbv1.Range{{Subnet: "192.168.100.0/24"}},
+					OrphanCollection: tt.collection,
+				},
+				Status: lbv1.IPPoolStatus{Orphaned: tt.orphaned},
+			}
+			clientset := fake.NewSimpleClientset(pool,
+				&lbv1.LoadBalancer{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "lb1"}},
+				store.NewAllocation(pool, "192.168.100.10", "default/lb1"),
+				store.NewAllocation(pool, "192.168.100.11", "default/lb2"),
+				store.NewAllocation(pool, "192.168.100.12", "default/lb3"),
+			)
+			h := &Handler{
+				ipPoolCache:      fakeclients.IPPoolCache(clientset.LoadbalancerV1beta1().IPPools),
+				ipPoolClient:     fakeclients.IPPoolClient(clientset.LoadbalancerV1beta1().IPPools),
+				allocationCache:  fakeclients.IPAllocationCache(clientset.LoadbalancerV1beta1().IPAllocations),
+				allocationClient: fakeclients.IPAllocationClient(clientset.LoadbalancerV1beta1().IPAllocations),
+				eventClient:      fakeclients.EventClient(k8sfake.NewSimpleClientset().CoreV1().Events),
+				lbCache:          fakeclients.LoadBalancerCache(clientset.LoadbalancerV1beta1().LoadBalancers),
+				allocatorMap:     ipam.NewSafeAllocatorMap(),
+			}
+			a, err := ipam.NewAllocator(pool.Name, &pool.Spec, h.ipPoolCache, h.ipPoolClient, h.allocationCache, h.allocationClient)
+			if err != nil {
+				t.Fatalf("failed to create allocator, error: %s", err.Error())
+			}
+			h.allocatorMap.AddOrUpdate(pool.Name, a)
+
+			updated, next, err := h.collectOrphans(pool, now)
+			if err != nil {
+				t.Fatalf("collectOrphans() error = %v", err)
+			}
+			if next != tt.wantNext {
+				t.Errorf("collectOrphans() next = %v, want %v", next, tt.wantNext)
+			}
+			if !reflect.DeepEqual(updated.Status.Orphaned, tt.wantOrphaned) {
+				t.Errorf("orphaned IPs = %v, want %v", updated.Status.Orphaned, tt.wantOrphaned)
+			}
+
+			allocated, err := store.GetAllocated(h.allocationCache, updated)
+			if err != nil {
+				t.Fatalf("failed to get allocated IPs, error: %s", err.Error())
+			}
+			for _, ip := range tt.wantReleased {
+				if _, ok := allocated[ip]; ok {
+					t.Errorf("expect %s is released", ip)
+				}
+			}
+			if len(allocated) != 3-len(tt.wantReleased) {
+				t.Errorf("unexpected allocated IPs %v", allocated)
+			}
+		})
+	}
+}
+
+func TestHandler_OnChangeToCollectOrphans(t *testing.T) {
+	tests := []struct {
+		name         string
+		collection   *lbv1.OrphanCollection
+		wantOrphaned bool
+		wantRequeued bool
+	}{
+		{
+			name: "the collection is not set",
+		},
+		{
+			name:       "the collection is not enabled",
+			collection: &lbv1.OrphanCollection{GracePeriodSeconds: 1},
+		},
+		{
+			name:         "the collection is enabled",
+			collection:   &lbv1.OrphanCollection{Enabled: true, GracePeriodSeconds: 7200},
+			wantOrphaned: true,
+			wantRequeued: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := &lbv1.IPPool{
+				ObjectMeta: metav1.ObjectMeta{Name: "pool1"},
+				Spec: lbv1.IPPoolSpec{
+					Ranges:           []lbv1.Range{{Subnet: "192.168.100.0/24"}},
+					OrphanCollection: tt.collection,
+				},
+				// the orphan found long ago would be released at once if the stale record were kept
+				Status: lbv1.IPPoolStatus{Orphaned: map[string]string{
+					"192.168.100.11": time.Now().Add(-time.Hour).UTC().Format(time.RFC3339),
+				}},
+			}
+			clientset := fake.NewSimpleClientset(pool, store.NewAllocation(pool, "192.168.100.11", "default/lb2"))
+			controller := &fakeIPPoolController{}
+			h := &Handler{
+				ipPoolController: controller,
+				ipPoolCache:      fakeclients.IPPoolCache(clientset.LoadbalancerV1beta1().IPPools),
+				ipPoolClient:     fakeclients.IPPoolClient(clientset.LoadbalancerV1beta1().IPPools),
+				allocationCache:  fakeclients.IPAllocationCache(clientset.LoadbalancerV1beta1().IPAllocations),
+				eventClient:      fakeclients.EventClient(k8sfake.NewSimpleClientset().CoreV1().Events),
+				lbCache:          fakeclients.LoadBalancerCache(clientset.LoadbalancerV1beta1().LoadBalancers),
+				allocatorMap:     ipam.NewSafeAllocatorMap(),
+			}
+
+			updated, err := h.OnChangeToCollectOrphans(pool.Name, pool)
+			if err != nil {
+				t.Fatalf("OnChangeToCollectOrphans() error = %v", err)
+			}
+			if got := len(updated.Status.Orphaned) > 0; got != tt.wantOrphaned {
+				t.Errorf("orphaned IPs = %v, want recorded %v", updated.Status.Orphaned, tt.wantOrphaned)
+			}
+			if got := len(controller.after) > 0; got != tt.wantRequeued {
+				t.Errorf("requeued = %v, want %v", got, tt.wantRequeued)
+			}
+			allocated, err := store.GetAllocated(h.allocationCache, pool)
+			if err != nil {
+				t.Fatalf("failed to get allocated IPs, error: %s", err.Error())
+			}
+			if len(allocated) != 1 {
+				t.Errorf("expect the orphaned IP is kept in the grace period, got %v", allocated)
+			}
+		})
+	}
+}
+
+func TestHandler_Audit(t *testing.T) {
+	pool := &lbv1.IPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "pool1"},
+		Spec: lbv1.IPPoolSpec{
+			Ranges: []lbv1.Range{{Subnet: "192.168.100.0/24"}},
+		},
+	}
+	newLB := func(name, ip string) *lbv1.LoadBalancer {
+		return &lbv1.LoadBalancer{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+			Spec:       lbv1.LoadBalancerSpec{IPAM: lbv1.Pool},
+			Status: lbv1.LoadBalancerStatus{
+				AllocatedAddress: lbv1.AllocatedAddress{IPPool: pool.Name, IP: ip},
+			},
+		}
+	}
+	clientset := fake.NewSimpleClientset(pool,
+		newLB("lb1", "192.168.100.10"),
+		newLB("lb2", "192.168.100.11"),
+		newLB("lb3", "192.168.100.12"),
+		newLB("lb4", "192.168.100.12"),
+		newLB("lb5", "192.168.100.13"),
+		store.NewAllocation(pool, "192.168.100.10", "default/lb1"),
+		store.NewAllocation(pool, "192.168.100.12", "default/lb3"),
+		store.NewAllocation(pool, "192.168.100.13", "default/lb6"),
+	)
+	h := &Handler{
+		ipPoolCache:      fakeclients.IPPoolCache(clientset.LoadbalancerV1beta1().IPPools),
+		ipPoolClient:     fakeclients.IPPoolClient(clientset.LoadbalancerV1beta1().IPPools),
+		allocationCache:  fakeclients.IPAllocationCache(clientset.LoadbalancerV1beta1().IPAllocations),
+		allocationClient: fakeclients.IPAllocationClient(clientset.LoadbalancerV1beta1().IPAllocations),
+		lbCache:          fakeclients.LoadBalancerCache(clientset.LoadbalancerV1beta1().LoadBalancers),
+		lbClient:         fakeclients.LoadBalancerClient(clientset.LoadbalancerV1beta1().LoadBalancers),
+		allocatorMap:     ipam.NewSafeAllocatorMap(),
+	}
+	a, err := ipam.NewAllocator(pool.Name, &pool.Spec, h.ipPoolCache, h.ipPoolClient, h.allocationCache, h.allocationClient)
+	if err != nil {
+		t.Fatalf("failed to create allocator, error: %s", err.Error())
+	}
+	h.allocatorMap.AddOrUpdate(pool.Name, a)
+
+	updated, err := h.audit(pool)
+	if err != nil {
+		t.Fatalf("audit() error = %v", err)
+	}
+	if !lbv1.IPPoolAddressConflict.IsTrue(updated) {
+		t.Errorf("expect the pool has address conflicts")
+	}
+
+	allocated, err := store.GetAllocated(h.allocationCache, updated)
+	if err != nil {
+		t.Fatalf("failed to get allocated IPs, error: %s", err.Error())
+	}
+	if allocated["192.168.100.11"] != "default/lb2" {
+		t.Errorf("expect the IP claimed by default/lb2 is re-booked, got %v", allocated)
+	}
+
+	wantConflict := map[string]bool{"lb1": false, "lb2": false, "lb3": true, "lb4": true, "lb5": true}
+	for name, want := range wantConflict {
+		lb, err := h.lbCache.Get("default", name)
+		if err != nil {
+			t.Fatalf("failed to get lb %s, error: %s", name, err.Error())
+		}
+		if got := lbv1.LoadBalancerAddressConflict.IsTrue(lb); got != want {
+			t.Errorf("lb %s has address conflict %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestHandler_OnChangeToAudit(t *testing.T) {
+	pool := &lbv1.IPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "pool1"},
+		Spec: lbv1.IPPoolSpec{
+			Ranges: []lbv1.Range{{Subnet: "192.168.100.0/24"}},
+		},
+	}
+	clientset := fake.NewSimpleClientset(pool)
+	controller := &fakeIPPoolController{}
+	h := &Handler{
+		ipPoolController: controller,
+		ipPoolCache:      fakeclients.IPPoolCache(clientset.LoadbalancerV1beta1().IPPools),
+		ipPoolClient:     fakeclients.IPPoolClient(clientset.LoadbalancerV1beta1().IPPools),
+		allocationCache:  fakeclients.IPAllocationCache(clientset.LoadbalancerV1beta1().IPAllocations),
+		lbCache:          fakeclients.LoadBalancerCache(clientset.LoadbalancerV1beta1().LoadBalancers),
+	}
+	countLBLists := func() int {
+		count := 0
+		for _, action := range clientset.Actions() {
+			if action.GetVerb() == "list" && action.GetResource().Resource == "loadbalancers" {
+				count++
+			}
+		}
+		return count
+	}
+
+	// the first change audits the pool and schedules the next audit
+	if _, err := h.OnChangeToAudit(pool.Name, pool); err != nil {
+		t.Fatalf("OnChangeToAudit() error = %v", err)
+	}
+	if countLBLists() != 1 || len(controller.after) != 1 {
+		t.Fatalf("expect the pool is audited and requeued once, got %d audits and %d requeues", countLBLists(), len(controller.after))
+	}
+
+	// the changes within the audit interval are skipped
+	if _, err := h.OnChangeToAudit(pool.Name, pool); err != nil {
+		t.Fatalf("OnChangeToAudit() error = %v", err)
+	}
+	if countLBLists() != 1 || len(controller.after) != 1 {
+		t.Errorf("expect the change within the audit interval is skipped, got %d audits and %d requeues", countLBLists(), len(controller.after))
+	}
+
+	// the scheduled audit runs after the interval
+	h.markAudited(pool.Name, time.Now().Add(-auditInterval))
+	if _, err := h.OnChangeToAudit(pool.Name, pool); err != nil {
+		t.Fatalf("OnChangeToAudit() error = %v", err)
+	}
+	if countLBLists() != 2 || len(controller.after) != 2 {
+		t.Errorf("expect the pool is audited again after the interval, got %d audits and %d requeues", countLBLists(), len(controller.after))
+	}
+}
+
+func TestHandler_UpdateStatusReAddressing(t *testing.T) {
+	tests := []struct {
+		name      string
+		reAddress bool
+		want      map[string]string
+		wantCond  string
+	}{
+		{
+			name: "excluded IPs are kept without the re-address mode",
+		},
+		{
+			name:      "excluded IPs are marked in the re-address mode",
+			reAddress: true,
+			want:      map[string]string{"192.168.100.10": "default/lb1"},
+			wantCond:  "True",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := &lbv1.IPPool{
+				ObjectMeta: metav1.ObjectMeta{Name: "pool1"},
+				Spec: lbv1.IPPoolSpec{
+					Ranges:    []lbv1.Range{{Subnet: "192.168.100.0/24", RangeStart: "192.168.100.20", RangeEnd: "192.168.100.29"}},
+					ReAddress: tt.reAddress,
+				},
+			}
+			clientset := fake.NewSimpleClientset(pool,
+				store.NewAllocation(pool, "192.168.100.10", "default/lb1"),
+				store.NewAllocation(pool, "192.168.100.20", "default/lb2"))
+			h := &Handler{
+				ipPoolClient:    fakeclients.IPPoolClient(clientset.LoadbalancerV1beta1().IPPools),
+				allocationCache: fakeclients.IPAllocationCache(clientset.LoadbalancerV1beta1().IPAllocations),
+			}
+
+			if _, err := h.updateStatus(pool, &pool.Spec, 10, nil); err != nil {
+				t.Fatalf("updateStatus() error = %v", err)
+			}
+			updated, err := clientset.LoadbalancerV1beta1().IPPools().Get(context.TODO(), pool.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("failed to get pool, error: %s", err.Error())
+			}
+			if updated.Status.Available != 9 {
+				t.Errorf("available = %d, want 9", updated.Status.Available)
+			}
+			if !reflect.DeepEqual(updated.Status.ReAddressing, tt.want) {
+				t.Errorf("reAddressing = %v, want %v", updated.Status.ReAddressing, tt.want)
+			}
+			if got := lbv1.IPPoolReAddressing.GetStatus(updated); got != tt.wantCond {
+				t.Errorf("condition %s = %q, want %q", lbv1.IPPoolReAddressing, got, tt.wantCond)
+			}
+		})
+	}
+}
+
+func TestHandler_UpdateStatusDelegated(t *testing.T) {
+	pool := &lbv1.IPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "parent"},
+		Spec: lbv1.IPPoolSpec{
+			Ranges: []lbv1.Range{{Subnet: "192.168.100.0/24", RangeStart: "192.168.100.10", RangeEnd: "192.168.100.29"}},
+		},
+		Status: lbv1.IPPoolStatus{
+			AllocatedHistory: map[string]string{
+				"192.168.100.11": "default/lb1",
+				"192.168.100.21": "default/lb2",
+			},
+		},
+	}
+	children := []*lbv1.IPPool{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "child"},
+			Spec: lbv1.IPPoolSpec{
+				Ranges: []lbv1.Range{{Subnet: "192.168.100.0/24", RangeStart: "192.168.100.20", RangeEnd: "192.168.100.29"}},
+				Parent: "parent",
+			},
+			Status: lbv1.IPPoolStatus{Total: 6, Available: 5, Delegated: 4, DelegatedUsed: 2},
+		},
+	}
+	spec, err := ipam.ExcludeDelegated(&pool.Spec, children)
+	if err != nil {
+		t.Fatalf("ExcludeDelegated() error = %v", err)
+	}
+	clientset := fake.NewSimpleClientset(pool, children[0], store.NewAllocation(pool, "192.168.100.10", "default/lb3"))
+	h := &Handler{
+		ipPoolClient:    fakeclients.IPPoolClient(clientset.LoadbalancerV1beta1().IPPools),
+		allocationCache: fakeclients.IPAllocationCache(clientset.LoadbalancerV1beta1().IPAllocations),
+	}
+
+	if _, err := h.updateStatus(pool, spec, 10, children); err != nil {
+		t.Fatalf("updateStatus() error = %v", err)
+	}
+	updated, err := clientset.LoadbalancerV1beta1().IPPools().Get(context.TODO(), pool.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get pool, error: %s", err.Error())
+	}
+	if updated.Status.Available != 9 {
+		t.Errorf("available = %d, want 9", updated.Status.Available)
+	}
+	if updated.Status.Delegated != 10 || updated.Status.DelegatedUsed != 3 {
+		t.Errorf("delegated = %d, delegatedUsed = %d, want 10 and 3", updated.Status.Delegated, updated.Status.DelegatedUsed)
+	}
+	// the history of the delegated IPs is removed from the parent
+	want := map[string]string{"192.168.100.11": "default/lb1"}
+	if !reflect.DeepEqual(updated.Status.AllocatedHistory, want) {
+		t.Errorf("allocatedHistory = %v, want %v", updated.Status.AllocatedHistory, want)
+	}
+}
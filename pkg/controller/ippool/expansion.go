@@ -0,0 +1,94 @@
+package ippool
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	lbv1 "github.com/harvester/harvester-load-balancer/pkg/apis/loadbalancer.harvesterhci.io/v1beta1"
+	"github.com/harvester/harvester-load-balancer/pkg/ipam"
+)
+
+const eventReasonExpanded = "Expanded"
+
+// expand appends the next chunk of the supernet to the ranges of the pool if its available IPs fall below the
+// threshold of the expansion policy. The allocator is rebuilt with the new ranges as the checksum changes.
+// The counters in the status must be computed from the current ranges.
+func (h *Handler) expand(pool *lbv1.IPPool) (*lbv1.IPPool, error) {
+	var blocked string
+	if e := pool.Spec.Expansion; e != nil && pool.Status.Available < int64(max(e.Threshold, 1)) {
+		size := pool.Status.Total + pool.Status.Delegated
+		if e.MaxSize > 0 && size+int64(e.ChunkSize) > int64(e.MaxSize) {
+			blocked = fmt.Sprintf("the pool has %d IPs, another chunk of %d IPs exceeds the max size %d", size, e.ChunkSize, e.MaxSize)
+		} else {
+			r, err := h.nextChunk(pool)
+			if err != nil {
+				return nil, fmt.Errorf("find the next chunk of pool %s failed, %w", pool.Name, err)
+			}
+			if r != nil {
+				return h.appendChunk(pool, r)
+			}
+			blocked = fmt.Sprintf("supernet %s is exhausted", e.Supernet)
+		}
+	}
+
+	poolCopy := pool.DeepCopy()
+	if blocked != "" {
+		lbv1.IPPoolExpansionBlocked.True(poolCopy)
+		lbv1.IPPoolExpansionBlocked.Message(poolCopy, blocked)
+	} else if lbv1.IPPoolExpansionBlocked.GetStatus(poolCopy) != "" {
+		lbv1.IPPoolExpansionBlocked.False(poolCopy)
+		lbv1.IPPoolExpansionBlocked.Message(poolCopy, "")
+	}
+	if reflect.DeepEqual(pool.Status, poolCopy.Status) {
+		return pool, nil
+	}
+	if blocked != "" {
+		logrus.Warnf("IP Pool %s runs low on addresses but can't be expanded, %s", pool.Name, blocked)
+	}
+	updated, err := h.ipPoolClient.Update(poolCopy)
+	if err != nil {
+		return nil, fmt.Errorf("update expansion condition of pool %s failed, %w", pool.Name, err)
+	}
+
+	return updated, nil
+}
+
+func (h *Handler) nextChunk(pool *lbv1.IPPool) (*lbv1.Range, error) {
+	pools, err := h.ipPoolCache.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	others := make([]*lbv1.IPPool, 0, len(pools))
+	for _, p := range pools {
+		if p.Name != pool.Name {
+			others = append(others, p)
+		}
+	}
+
+	return ipam.NextChunk(pool, others)
+}
+
+func (h *Handler) appendChunk(pool *lbv1.IPPool, r *lbv1.Range) (*lbv1.IPPool, error) {
+	poolCopy := pool.DeepCopy()
+	poolCopy.Spec.Ranges = append(poolCopy.Spec.Ranges, *r)
+	if lbv1.IPPoolExpansionBlocked.GetStatus(poolCopy) != "" {
+		lbv1.IPPoolExpansionBlocked.False(poolCopy)
+		lbv1.IPPoolExpansionBlocked.Message(poolCopy, "")
+	}
+	updated, err := h.ipPoolClient.Update(poolCopy)
+	if err != nil {
+		return nil, fmt.Errorf("expand pool %s failed, %w", pool.Name, err)
+	}
+
+	message := fmt.Sprintf("range %s-%s is appended as %d of %d IPs are available", r.RangeStart, r.RangeEnd,
+		pool.Status.Available, pool.Status.Total)
+	logrus.Infof("IP Pool %s is expanded, %s", pool.Name, message)
+	h.recordEvent(pool, corev1.EventTypeNormal, eventReasonExpanded, message)
+
+	return updated, nil
+}
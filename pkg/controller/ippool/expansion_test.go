@@ -0,0 +1,89 @@
+package ippool
+
+import (
+	"context"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+
+	lbv1 "github.com/harvester/harvester-load-balancer/pkg/apis/loadbalancer.harvesterhci.io/v1beta1"
+	"github.com/harvester/harvester-load-balancer/pkg/generated/clientset/versioned/fake"
+	"github.com/harvester/harvester-load-balancer/pkg/utils/fakeclients"
+)
+
+func TestHandler_Expand(t *testing.T) {
+	tests := []struct {
+		name        string
+		available   int64
+		threshold   uint32
+		maxSize     uint32
+		wantRanges  int
+		wantBlocked string
+	}{
+		{
+			name:       "pool with available IPs isn't expanded",
+			available:  2,
+			wantRanges: 1,
+		},
+		{
+			name:       "pool is expanded when no IP is available",
+			wantRanges: 2,
+		},
+		{
+			name:       "pool is expanded below the threshold",
+			available:  2,
+			threshold:  3,
+			wantRanges: 2,
+		},
+		{
+			name:        "pool reaching the max size isn't expanded",
+			maxSize:     16,
+			wantRanges:  1,
+			wantBlocked: "True",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := &lbv1.IPPool{
+				ObjectMeta: metav1.ObjectMeta{Name: "pool1"},
+				Spec: lbv1.IPPoolSpec{
+					Ranges: []lbv1.Range{{Subnet: "192.168.100.0/24", RangeStart: "192.168.100.2", RangeEnd: "192.168.100.11"}},
+					Expansion: &lbv1.Expansion{
+						Supernet:  "192.168.100.128/25",
+						ChunkSize: 16,
+						MaxSize:   tt.maxSize,
+						Threshold: tt.threshold,
+					},
+				},
+				Status: lbv1.IPPoolStatus{Total: 10, Available: tt.available},
+			}
+			clientset := fake.NewSimpleClientset(pool)
+			h := &Handler{
+				ipPoolCache:  fakeclients.IPPoolCache(clientset.LoadbalancerV1beta1().IPPools),
+				ipPoolClient: fakeclients.IPPoolClient(clientset.LoadbalancerV1beta1().IPPools),
+				eventClient:  fakeclients.EventClient(k8sfake.NewSimpleClientset().CoreV1().Events),
+			}
+
+			if _, err := h.expand(pool); err != nil {
+				t.Fatalf("expand() error = %v", err)
+			}
+			updated, err := clientset.LoadbalancerV1beta1().IPPools().Get(context.TODO(), pool.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("failed to get pool, error: %s", err.Error())
+			}
+			if len(updated.Spec.Ranges) != tt.wantRanges {
+				t.Fatalf("ranges = %+v, want %d ranges", updated.Spec.Ranges, tt.wantRanges)
+			}
+			if tt.wantRanges > 1 {
+				if r := updated.Spec.Ranges[1]; r.RangeStart != "192.168.100.128" || r.RangeEnd != "192.168.100.143" {
+					t.Errorf("appended range = %+v, want 192.168.100.128-192.168.100.143", r)
+				}
+			}
+			if got := lbv1.IPPoolExpansionBlocked.GetStatus(updated); got != tt.wantBlocked {
+				t.Errorf("condition %s = %q, want %q", lbv1.IPPoolExpansionBlocked, got, tt.wantBlocked)
+			}
+		})
+	}
+}
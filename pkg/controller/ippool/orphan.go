@@ -0,0 +1,197 @@
+package ippool
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	lbv1 "github.com/harvester/harvester-load-balancer/pkg/apis/loadbalancer.harvesterhci.io/v1beta1"
+	"github.com/harvester/harvester-load-balancer/pkg/ipam"
+	"github.com/harvester/harvester-load-balancer/pkg/ipam/store"
+)
+
+const (
+	// defaultOrphanGracePeriod is how long an orphaned IP stays allocated if the pool doesn't specify it
+	defaultOrphanGracePeriod = 5 * time.Minute
+	// orphanCheckInterval is how often the allocations of a pool are checked, the removal of a load balancer
+	// doesn't trigger its pool
+	orphanCheckInterval = 5 * time.Minute
+
+	eventReasonOrphanedIP         = "OrphanedIP"
+	eventReasonOrphanedIPReleased = "OrphanedIPReleased"
+	// the events of the cluster scoped IP pools are recorded in the default namespace
+	eventNamespace = "default"
+)
+
+// OnChangeToCollectOrphans releases the IPs allocated to the load balancers which no longer exist after the grace
+// period of the IPPool, and requeues the IPPool to check its allocations periodically.
+// Nothing is collected unless the orphan collection of the IPPool is enabled.
+func (h *Handler) OnChangeToCollectOrphans(_ string, ipPool *lbv1.IPPool) (*lbv1.IPPool, error) {
+	if ipPool == nil || ipPool.DeletionTimestamp != nil {
+		return ipPool, nil
+	}
+	if !isOrphanCollectionEnabled(ipPool) {
+		return h.clearOrphans(ipPool)
+	}
+
+	updated, next, err := h.collectOrphans(ipPool, time.Now())
+	if err != nil {
+		return ipPool, err
+	}
+	h.ipPoolController.EnqueueAfter(ipPool.Name, next)
+
+	return updated, nil
+}
+
+// collectOrphans records the orphaned IPs into the status of the pool with the time they were found, and releases
+// the ones beyond the grace period unless the pool is in dry-run mode.
+// It returns the duration until the next check.
+func (h *Handler) collectOrphans(pool *lbv1.IPPool, now time.Time) (*lbv1.IPPool, time.Duration, error) {
+	allocated, err := store.GetAllocated(h.allocationCache, pool)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	gracePeriod := defaultOrphanGracePeriod
+	if pool.Spec.OrphanCollection != nil && pool.Spec.OrphanCollection.GracePeriodSeconds > 0 {
+		gracePeriod = time.Duration(pool.Spec.OrphanCollection.GracePeriodSeconds) * time.Second
+	}
+	dryRun := pool.Spec.OrphanCollection != nil && pool.Spec.OrphanCollection.DryRun
+
+	next := orphanCheckInterval
+	orphaned := make(map[string]string)
+	expired := make(map[string]string)
+	for ip, applicant := range allocated {
+		ok, err := h.isOrphaned(applicant)
+		if err != nil {
+			return nil, 0, err
+		}
+		if !ok {
+			continue
+		}
+
+		foundTime, err := time.Parse(time.RFC3339, pool.Status.Orphaned[ip])
+		if err != nil {
+			foundTime = now
+			logrus.Infof("IP Pool %s has an orphaned IP %s allocated to %s", pool.Name, ip, applicant)
+			h.recordEvent(pool, corev1.EventTypeWarning, eventReasonOrphanedIP,
+				fmt.Sprintf("IP %s is allocated to %s which no longer exists", ip, applicant))
+		}
+
+		left := foundTime.Add(gracePeriod).Sub(now)
+		if left <= 0 && !dryRun {
+			expired[ip] = applicant
+			continue
+		}
+		orphaned[ip] = foundTime.UTC().Format(time.RFC3339)
+		if left > 0 && left < next {
+			next = left
+		}
+	}
+
+	if len(orphaned) == 0 {
+		orphaned = nil
+	}
+	if !reflect.DeepEqual(pool.Status.Orphaned, orphaned) {
+		poolCopy := pool.DeepCopy()
+		poolCopy.Status.Orphaned = orphaned
+		if pool, err = h.ipPoolClient.Update(poolCopy); err != nil {
+			return nil, 0, fmt.Errorf("update orphaned IPs of pool %s failed, %w", poolCopy.Name, err)
+		}
+	}
+
+	if len(expired) == 0 {
+		return pool, next, nil
+	}
+
+	a := h.allocatorMap.Get(pool.Name)
+	if a == nil {
+		return nil, 0, fmt.Errorf("release orphaned IPs of pool %s failed, fail to get allocator", pool.Name)
+	}
+	for ip, applicant := range expired {
+		// the other IPs of a dual-stack applicant are released together
+		if err := a.Release(applicant, ""); err != nil {
+			return nil, 0, fmt.Errorf("release orphaned IP %s of pool %s failed, error: %w", ip, pool.Name, err)
+		}
+		logrus.Infof("IP Pool %s has released the orphaned IP %s allocated to %s", pool.Name, ip, applicant)
+		h.recordEvent(pool, corev1.EventTypeNormal, eventReasonOrphanedIPReleased,
+			fmt.Sprintf("IP %s allocated to %s which no longer exists is released", ip, applicant))
+	}
+
+	return pool, next, nil
+}
+
+func isOrphanCollectionEnabled(pool *lbv1.IPPool) bool {
+	return pool.Spec.OrphanCollection != nil && pool.Spec.OrphanCollection.Enabled
+}
+
+// clearOrphans drops the orphaned IPs recorded before the orphan collection was disabled, otherwise they would be
+// released at once with the stale found time when the collection is enabled again
+func (h *Handler) clearOrphans(pool *lbv1.IPPool) (*lbv1.IPPool, error) {
+	if len(pool.Status.Orphaned) == 0 {
+		return pool, nil
+	}
+	poolCopy := pool.DeepCopy()
+	poolCopy.Status.Orphaned = nil
+	updated, err := h.ipPoolClient.Update(poolCopy)
+	if err != nil {
+		return pool, fmt.Errorf("clear orphaned IPs of pool %s failed, %w", pool.Name, err)
+	}
+
+	return updated, nil
+}
+
+// isOrphaned checks whether the applicant namespace/name refers to a load balancer which no longer exists, or the
+// applicant of an IP claim refers to a claim which no longer exists
+func (h *Handler) isOrphaned(applicant string) (bool, error) {
+	var err error
+	if namespace, name, ok := ipam.ParseClaimApplicant(applicant); ok {
+		_, err = h.claimCache.Get(namespace, name)
+	} else if namespace, name, ok := strings.Cut(applicant, "/"); ok {
+		_, err = h.lbCache.Get(namespace, name)
+	} else {
+		return false, nil
+	}
+	if err == nil {
+		return false, nil
+	}
+	if apierrors.IsNotFound(err) {
+		return true, nil
+	}
+
+	return false, err
+}
+
+func (h *Handler) recordEvent(pool *lbv1.IPPool, eventType, reason, message string) {
+	now := metav1.Now()
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s.%x", pool.Name, now.UnixNano()),
+			Namespace: eventNamespace,
+		},
+		InvolvedObject: corev1.ObjectReference{
+			APIVersion:      lbv1.SchemeGroupVersion.String(),
+			Kind:            "IPPool",
+			Name:            pool.Name,
+			UID:             pool.UID,
+			ResourceVersion: pool.ResourceVersion,
+		},
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		Source:         corev1.EventSource{Component: controllerName},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+
+	if _, err := h.eventClient.Create(event); err != nil {
+		logrus.Warnf("record event %s of IP pool %s failed, error: %s", reason, pool.Name, err.Error())
+	}
+}
@@ -0,0 +1,94 @@
+package loadbalancer
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+
+	lbv1 "github.com/harvester/harvester-load-balancer/pkg/apis/loadbalancer.harvesterhci.io/v1beta1"
+	"github.com/harvester/harvester-load-balancer/pkg/ipam"
+	"github.com/harvester/harvester-load-balancer/pkg/ipam/store"
+	"github.com/harvester/harvester-load-balancer/pkg/utils"
+)
+
+// adoptClaimedAddress takes the address of the IP claim which the lb refers to, the addresses allocated to the lb
+// itself before are released
+func (h *Handler) adoptClaimedAddress(lbCopy, lb *lbv1.LoadBalancer) (*lbv1.LoadBalancer, error) {
+	claim, err := h.claimCache.Get(lb.Namespace, lb.Spec.IPClaim)
+	if apierrors.IsNotFound(err) {
+		return lb, fmt.Errorf("%w, ip claim %s is not found", errNoAvailableIP, lb.Spec.IPClaim)
+	}
+	if err != nil {
+		return lb, fmt.Errorf("fail to get ip claim %s, error: %w", lb.Spec.IPClaim, err)
+	}
+	address := claim.Status.AllocatedAddress
+	if address.IP == "" {
+		return lb, fmt.Errorf("%w, ip claim %s has no address yet", errNoAvailableIP, claim.Name)
+	}
+	// the claim books a single address, don't give up the own addresses for a claim serving part of the IP families
+	if !utils.IsIPFamilyCovered(claim.Spec.IPFamily, lb.Spec.IPFamilyPolicy) {
+		return lb, fmt.Errorf("ip claim %s of ip family %s doesn't cover ip family policy %s", claim.Name,
+			claim.Spec.IPFamily, lb.Spec.IPFamilyPolicy)
+	}
+	if lb.Status.AllocatedAddress == address && lb.Status.SecondaryAllocatedAddress.IP == "" && lb.Status.PoolMigration == nil {
+		return lb, nil
+	}
+
+	if lb.Status.AllocatedAddress.IPPool != "" {
+		if err := h.releaseIP(lb); err != nil {
+			return lb, fmt.Errorf("fail to release ip %s to pool %s, error: %w", lb.Status.AllocatedAddress.IP,
+				lb.Status.AllocatedAddress.IPPool, err)
+		}
+	}
+	if lb.Status.PoolMigration != nil {
+		if err := h.releasePreviousAddresses(lb); err != nil {
+			return lb, err
+		}
+		lbCopy.Status.PoolMigration = nil
+		lbv1.LoadBalancerPoolMigrating.False(lbCopy)
+		lbv1.LoadBalancerPoolMigrating.Message(lbCopy, "")
+	}
+
+	lbCopy.Status.AllocatedAddress = address
+	lbCopy.Status.SecondaryAllocatedAddress = lbv1.AllocatedAddress{}
+	logrus.Infof("lb %s/%s adopts ip %s of ip claim %s from pool %s", lb.Namespace, lb.Name, address.IP, claim.Name, address.IPPool)
+
+	return lb, nil
+}
+
+// isClaimedAddress checks whether the allocated address of the lb is booked by an IP claim
+func (h *Handler) isClaimedAddress(lb *lbv1.LoadBalancer) bool {
+	ip := net.ParseIP(lb.Status.AllocatedAddress.IP)
+	if ip == nil {
+		return false
+	}
+	allocation, err := h.allocationCache.Get(store.AllocationName(ip))
+	if err != nil || allocation.Spec.IPPool != lb.Status.AllocatedAddress.IPPool {
+		return false
+	}
+	_, _, ok := ipam.ParseClaimApplicant(allocation.Spec.Applicant)
+
+	return ok
+}
+
+// OnIPClaimChange enqueues the load balancers which refer to the IP claim to adopt its address
+func (h *Handler) OnIPClaimChange(_ string, claim *lbv1.IPClaim) (*lbv1.IPClaim, error) {
+	if claim == nil || claim.DeletionTimestamp != nil || claim.Status.AllocatedAddress.IP == "" {
+		return claim, nil
+	}
+
+	lbs, err := h.lbCache.List(claim.Namespace, labels.Everything())
+	if err != nil {
+		return claim, err
+	}
+	for _, lb := range lbs {
+		if lb.Spec.IPClaim == claim.Name && lb.Status.AllocatedAddress.IP != claim.Status.AllocatedAddress.IP {
+			h.lbController.Enqueue(lb.Namespace, lb.Name)
+		}
+	}
+
+	return claim, nil
+}
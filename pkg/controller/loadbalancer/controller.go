@@ -9,8 +9,10 @@ import (
 	"strings"
 	"time"
 
+	current "github.com/containernetworking/cni/pkg/types/100"
 	ctlcorev1 "github.com/rancher/wrangler/v3/pkg/generated/controllers/core/v1"
 	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
 
 	lbv1 "github.com/harvester/harvester-load-balancer/pkg/apis/loadbalancer.harvesterhci.io/v1beta1"
 	"github.com/harvester/harvester-load-balancer/pkg/config"
@@ -43,13 +45,17 @@ var (
 
 type Handler struct {
 	lbController        ctllbv1.LoadBalancerController
+	lbCache             ctllbv1.LoadBalancerCache
 	ipPoolCache         ctllbv1.IPPoolCache
+	allocationCache     ctllbv1.IPAllocationCache
+	claimCache          ctllbv1.IPClaimCache
 	nadCache            ctlcniv1.NetworkAttachmentDefinitionCache
 	serviceClient       ctlcorev1.ServiceClient
 	serviceCache        ctlcorev1.ServiceCache
 	endpointSliceClient ctldiscoveryv1.EndpointSliceClient
 	endpointSliceCache  ctldiscoveryv1.EndpointSliceCache
 	vmiCache            ctlkubevirtv1.VirtualMachineInstanceCache
+	namespaceCache      ctlcorev1.NamespaceCache
 
 	allocatorMap *ipam.SafeAllocatorMap
 
@@ -59,6 +65,7 @@ type Handler struct {
 func Register(ctx context.Context, management *config.Management) error {
 	lbc := management.LbFactory.Loadbalancer().V1beta1().LoadBalancer()
 	pools := management.LbFactory.Loadbalancer().V1beta1().IPPool()
+	claims := management.LbFactory.Loadbalancer().V1beta1().IPClaim()
 	nads := management.CniFactory.K8s().V1().NetworkAttachmentDefinition()
 	services := management.CoreFactory.Core().V1().Service()
 	endpointSlices := management.DiscoveryFactory.Discovery().V1().EndpointSlice()
@@ -66,13 +73,17 @@ func Register(ctx context.Context, management *config.Management) error {
 
 	handler := &Handler{
 		lbController:        lbc,
+		lbCache:             lbc.Cache(),
 		ipPoolCache:         pools.Cache(),
+		allocationCache:     management.LbFactory.Loadbalancer().V1beta1().IPAllocation().Cache(),
+		claimCache:          claims.Cache(),
 		nadCache:            nads.Cache(),
 		serviceClient:       services,
 		serviceCache:        services.Cache(),
 		endpointSliceClient: endpointSlices,
 		endpointSliceCache:  endpointSlices.Cache(),
 		vmiCache:            vmis.Cache(),
+		namespaceCache:      management.CoreFactory.Core().V1().Namespace().Cache(),
 
 		allocatorMap: management.AllocatorMap,
 
@@ -87,6 +98,8 @@ func Register(ctx context.Context, management *config.Management) error {
 
 	lbc.OnChange(ctx, controllerName, handler.OnChange)
 	lbc.OnRemove(ctx, controllerName, handler.OnRemove)
+	pools.OnChange(ctx, controllerName, handler.OnIPPoolChange)
+	claims.OnChange(ctx, controllerName, handler.OnIPClaimChange)
 
 	return nil
 }
@@ -129,6 +142,12 @@ func (h *Handler) OnRemove(_ string, lb *lbv1.LoadBalancer) (*lbv1.LoadBalancer,
 		}
 		logrus.Debugf("lb %s/%s release ip %s", lb.Namespace, lb.Name, lb.Status.AllocatedAddress.IP)
 	}
+	if lb.Spec.IPAM == lbv1.Pool && lb.Status.PoolMigration != nil {
+		if err := h.releasePreviousAddresses(lb); err != nil {
+			logrus.Infof("lb %s/%s fail to release the previous addresses %v, error: %s", lb.Namespace, lb.Name, lb.Status.PoolMigration.Addresses, err.Error())
+			return nil, err
+		}
+	}
 
 	if lb.Spec.WorkloadType == lbv1.VM || lb.Spec.WorkloadType == "" {
 		if err := h.lbManager.DeleteLoadBalancer(lb); err != nil {
@@ -165,18 +184,20 @@ func (h *Handler) ensureVMLoadBalancer(lbCopy, lb *lbv1.LoadBalancer) (*lbv1.Loa
 		return lb, err
 	}
 
-	ip, err := h.lbManager.EnsureLoadBalancerServiceIP(lb)
+	ips, err := h.lbManager.EnsureLoadBalancerServiceIP(lb)
 	if err != nil {
 		lbCopy.Status.Address = ""
+		lbCopy.Status.Addresses = nil
 		return lb, err
 	}
 
-	lbCopy.Status.Address = ip
+	lbCopy.Status.Address = ips[0]
+	lbCopy.Status.Addresses = ips
 	servers, err := h.lbManager.EnsureBackendServers(lb)
 	if err != nil {
 		return lb, err
 	}
-	lbCopy.Status.BackendServers = getServerAddress(servers.GetBackendServers())
+	lbCopy.Status.BackendServers = getServerAddress(servers.GetBackendServers(), utils.GetIPFamilies(lb.Spec.IPFamilyPolicy))
 	if len(lbCopy.Status.BackendServers) == 0 {
 		if servers.GetMatchedBackendServerCount() == 0 {
 			return lb, errNoRunningBackendServer
@@ -185,7 +206,7 @@ func (h *Handler) ensureVMLoadBalancer(lbCopy, lb *lbv1.LoadBalancer) (*lbv1.Loa
 		return lb, errAllBackendServersNoIP
 	}
 
-	if lb.Spec.HealthCheck != nil && lb.Spec.HealthCheck.Port != 0 {
+	if lbpkg.IsHealthCheckEnabled(lb) {
 		count, err := h.lbManager.GetProbeReadyBackendServerCount(lb)
 		if err != nil {
 			return lb, err
@@ -200,14 +221,17 @@ func (h *Handler) ensureVMLoadBalancer(lbCopy, lb *lbv1.LoadBalancer) (*lbv1.Loa
 	return lb, nil
 }
 
-func getServerAddress(servers []lbpkg.BackendServer) []string {
+// getServerAddress lists the addresses of the servers in all the IP families of the lb
+func getServerAddress(servers []lbpkg.BackendServer, families []corev1.IPFamily) []string {
 	if len(servers) == 0 {
 		return nil
 	}
 	address := make([]string, 0, len(servers))
 	for _, server := range servers {
-		if addr, ok := server.GetAddress(); ok {
-			address = append(address, addr)
+		for _, family := range families {
+			if addr, ok := server.GetAddressByFamily(family); ok {
+				address = append(address, addr)
+			}
 		}
 	}
 	return address
@@ -255,6 +279,16 @@ func (h *Handler) updateStatusNotReturnError(lbCopy, lb *lbv1.LoadBalancer, err
 }
 
 func (h *Handler) ensureAllocatedAddressDHCP(lbCopy, lb *lbv1.LoadBalancer) (*lbv1.LoadBalancer, error) {
+	// the addresses which the lb migrates from are not used by DHCP, release them to the pool at once
+	if lb.Status.PoolMigration != nil {
+		if err := h.releasePreviousAddresses(lb); err != nil {
+			return lb, err
+		}
+		logrus.Infof("lb %s/%s turns to DHCP, release the previous addresses %v to pool %s", lb.Namespace, lb.Name,
+			lb.Status.PoolMigration.Addresses, lb.Status.PoolMigration.IPPool)
+		clearPoolMigration(lbCopy)
+	}
+
 	if lb.Status.AllocatedAddress.IP != utils.Address4AskDHCP {
 		lbCopy.Status.AllocatedAddress = lbv1.AllocatedAddress{
 			IP: utils.Address4AskDHCP,
@@ -266,21 +300,54 @@ func (h *Handler) ensureAllocatedAddressDHCP(lbCopy, lb *lbv1.LoadBalancer) (*lb
 }
 
 func (h *Handler) ensureAllocatedAddressPool(lbCopy, lb *lbv1.LoadBalancer) (*lbv1.LoadBalancer, error) {
-	// lb's ip pool changes, release the previous allocated IP
+	// lb refers to an IP claim, take the address booked by the claim
+	if lb.Spec.IPClaim != "" {
+		return h.adoptClaimedAddress(lbCopy, lb)
+	}
+
+	// lb no longer refers to the IP claim whose address it took, the address stays with the claim
+	if lb.Status.AllocatedAddress.IPPool != "" && h.isClaimedAddress(lb) {
+		logrus.Infof("lb %s/%s gives up ip %s of ip claim", lb.Namespace, lb.Name, lb.Status.AllocatedAddress.IP)
+		lbCopy.Status.AllocatedAddress = lbv1.AllocatedAddress{}
+		lbCopy.Status.SecondaryAllocatedAddress = lbv1.AllocatedAddress{}
+		return lb, nil
+	}
+
+	// lb's ip pool changes, allocate the new IP while keeping the previous one during the overlap window
 	if lb.Spec.IPPool != "" && lb.Status.AllocatedAddress.IPPool != "" && lb.Status.AllocatedAddress.IPPool != lb.Spec.IPPool {
-		logrus.Infof("lb %s/%s release ip %s to pool %s", lb.Namespace, lb.Name, lb.Status.AllocatedAddress.IP, lb.Status.AllocatedAddress.IPPool)
+		return h.startPoolMigration(lbCopy, lb, time.Now())
+	}
+
+	// release the previous IP after the overlap window passes
+	if lb.Status.PoolMigration != nil {
+		if lb, err := h.finishPoolMigration(lbCopy, lb, time.Now()); err != nil {
+			return lb, err
+		}
+	}
+
+	// the allocated IPs are excluded from the ranges of the pool in the re-address mode, move to new addresses
+	if lb.Status.PoolMigration == nil && lb.Status.AllocatedAddress.IPPool != "" {
+		if excluded := h.getReAddressed(lb); len(excluded) > 0 {
+			return h.startReAddress(lbCopy, lb, excluded, time.Now())
+		}
+	}
+
+	// lb's requested IPs change, release the previous allocated IPs and re-allocate
+	if lb.Status.AllocatedAddress.IPPool != "" && isRequestedIPChanged(lb) {
+		logrus.Infof("lb %s/%s requests ips %v, release ip %s to pool %s", lb.Namespace, lb.Name, lb.Spec.RequestedIPs,
+			lb.Status.AllocatedAddress.IP, lb.Status.AllocatedAddress.IPPool)
 		if err := h.releaseIP(lb); err != nil {
-			logrus.Warnf("lb %s/%s fail to release ip %s to pool %s, error: %s", lb.Namespace, lb.Name, lb.Status.AllocatedAddress.IP, lb.Spec.IPPool, err.Error())
-			return lb, fmt.Errorf("fail to release ip %s to pool %s, error: %w", lb.Status.AllocatedAddress.IP, lb.Spec.IPPool, err)
+			return lb, fmt.Errorf("fail to release ip %s to pool %s, error: %w", lb.Status.AllocatedAddress.IP, lb.Status.AllocatedAddress.IPPool, err)
 		}
 
 		lbCopy.Status.AllocatedAddress = lbv1.AllocatedAddress{}
+		lbCopy.Status.SecondaryAllocatedAddress = lbv1.AllocatedAddress{}
 		return lb, nil
 	}
 
 	// allocate or re-allocate IP
 	if lb.Status.AllocatedAddress.IPPool == "" {
-		ip, err := h.allocateIPFromPool(lb)
+		ips, err := h.allocateIPFromPool(lbCopy, lb)
 		if err != nil {
 			logrus.Debugf("lb %s/%s fail to allocate from pool %s", lb.Namespace, lb.Name, err.Error())
 			// if unlucky the DuplicateAllocationKeyWord is reported, try to release IP, do not overwrite original error
@@ -295,37 +362,101 @@ func (h *Handler) ensureAllocatedAddressPool(lbCopy, lb *lbv1.LoadBalancer) (*lb
 			return lb, err
 		}
 
-		lbCopy.Status.AllocatedAddress = *ip
-		logrus.Infof("lb %s/%s allocate ip %s from pool %s", lb.Namespace, lb.Name, ip.IP, ip.IPPool)
+		// the first IP is in the primary IP family, a dual-stack lb has a secondary IP
+		lbCopy.Status.AllocatedAddress = ips[0]
+		if len(ips) > 1 {
+			lbCopy.Status.SecondaryAllocatedAddress = ips[1]
+		}
+		for _, ip := range ips {
+			logrus.Infof("lb %s/%s allocate ip %s from pool %s", lb.Namespace, lb.Name, ip.IP, ip.IPPool)
+		}
 		return lb, nil
 	}
 
 	return lb, nil
 }
 
-func (h *Handler) allocateIPFromPool(lb *lbv1.LoadBalancer) (*lbv1.AllocatedAddress, error) {
-	pool := lb.Spec.IPPool
-	if pool == "" {
-		// match an IP pool automatically if not specified
-		pool, err := h.selectIPPool(lb)
+func (h *Handler) allocateIPFromPool(lbCopy, lb *lbv1.LoadBalancer) ([]lbv1.AllocatedAddress, error) {
+	// the pool specified by the lb or selected by the requested IPs doesn't overflow
+	if lb.Spec.IPPool != "" || len(lb.Spec.RequestedIPs) > 0 {
+		pool, err := h.getIPPoolName(lb)
 		if err != nil {
 			return nil, err
 		}
 		return h.requestIP(lb, pool)
 	}
 
-	return h.requestIP(lb, pool)
+	pools, err := h.selectIPPools(lb)
+	if err != nil {
+		return nil, err
+	}
+
+	// try the next matching pool and then the global pool if the pool is exhausted and allows to overflow
+	var exhausted []string
+	for _, pool := range pools {
+		addresses, err := h.requestIP(lb, pool.Name)
+		if err == nil {
+			setPoolOverflowed(lbCopy, pool.Name, exhausted)
+			return addresses, nil
+		}
+		if !errors.Is(err, ipam.ErrPoolExhausted) || !pool.Spec.Overflow {
+			return nil, err
+		}
+		logrus.Infof("lb %s/%s overflows from the exhausted pool %s", lb.Namespace, lb.Name, pool.Name)
+		exhausted = append(exhausted, pool.Name)
+	}
+
+	return nil, fmt.Errorf("%w, pools %s are exhausted", errNoAvailableIP, strings.Join(exhausted, ", "))
 }
 
-func (h *Handler) tryReleaseDuplicatedIPToPool(lb *lbv1.LoadBalancer) (string, error) {
-	pool := lb.Spec.IPPool
-	var err error
-	if pool == "" {
-		// match an IP pool automatically if not specified
-		pool, err = h.selectIPPool(lb)
+// setPoolOverflowed records which pool the address is allocated from and the exhausted pools before it
+func setPoolOverflowed(lb *lbv1.LoadBalancer, pool string, exhausted []string) {
+	if len(exhausted) > 0 {
+		lbv1.LoadBalancerPoolOverflowed.True(lb)
+		lbv1.LoadBalancerPoolOverflowed.Message(lb, fmt.Sprintf("allocated from pool %s as pool %s exhausted", pool, strings.Join(exhausted, ", ")))
+		return
+	}
+	if lbv1.LoadBalancerPoolOverflowed.GetStatus(lb) != "" {
+		lbv1.LoadBalancerPoolOverflowed.False(lb)
+		lbv1.LoadBalancerPoolOverflowed.Message(lb, "")
+	}
+}
+
+// getIPPoolName returns the pool specified by the lb
+// if not specified, the pool containing the requested IP or the pool matched automatically is returned
+func (h *Handler) getIPPoolName(lb *lbv1.LoadBalancer) (string, error) {
+	if lb.Spec.IPPool != "" {
+		return lb.Spec.IPPool, nil
+	}
+
+	if len(lb.Spec.RequestedIPs) > 0 {
+		ip := net.ParseIP(lb.Spec.RequestedIPs[0])
+		if ip == nil {
+			return "", fmt.Errorf("invalid requested ip %s", lb.Spec.RequestedIPs[0])
+		}
+		r, err := ipam.NewRequirement(lb, h.namespaceCache)
+		if err != nil {
+			return "", err
+		}
+		// the Cluster type lb matches the pool in loose mode as same as the pool is selected automatically
+		pool, err := ipam.NewSelector(h.ipPoolCache).SelectByIP(ip, r, lb.Spec.WorkloadType == lbv1.Cluster)
 		if err != nil {
-			return pool, err
+			return "", fmt.Errorf("%w with requested ip %s, error: %w", errNoMatchedIPPool, ip, err)
 		}
+		if pool == nil {
+			return "", fmt.Errorf("%w with requested ip %s", errNoMatchedIPPool, ip)
+		}
+		return pool.Name, nil
+	}
+
+	// match an IP pool automatically if not specified
+	return h.selectIPPool(lb)
+}
+
+func (h *Handler) tryReleaseDuplicatedIPToPool(lb *lbv1.LoadBalancer) (string, error) {
+	pool, err := h.getIPPoolName(lb)
+	if err != nil {
+		return pool, err
 	}
 
 	// if pool is not ready, just fail and wait
@@ -336,57 +467,116 @@ func (h *Handler) tryReleaseDuplicatedIPToPool(lb *lbv1.LoadBalancer) (string, e
 	return pool, a.Release(fmt.Sprintf("%s/%s", lb.Namespace, lb.Name), "")
 }
 
-func (h *Handler) requestIP(lb *lbv1.LoadBalancer, pool string) (*lbv1.AllocatedAddress, error) {
+// requestIP allocates one IP per IP family required by the lb's IP family policy
+func (h *Handler) requestIP(lb *lbv1.LoadBalancer, pool string) ([]lbv1.AllocatedAddress, error) {
 	allocator := h.allocatorMap.Get(pool)
 	if allocator == nil {
 		return nil, fmt.Errorf("fail to get allocator %s", pool)
 	}
-	// the ip is booked on pool when successfully Get()
-	ipConfig, err := allocator.Get(fmt.Sprintf("%s/%s", lb.Namespace, lb.Name))
-	if err != nil {
-		// if failed, log the pool name
-		return nil, fmt.Errorf("fail to get ip from pool %s, error: %w", pool, err)
+
+	id := fmt.Sprintf("%s/%s", lb.Namespace, lb.Name)
+	families := utils.GetIPFamilies(lb.Spec.IPFamilyPolicy)
+	for _, family := range families {
+		if !allocator.HasIPFamily(family) {
+			return nil, fmt.Errorf("%w, pool %s has no %s range", errNoAvailableIP, pool, family)
+		}
 	}
 
-	return &lbv1.AllocatedAddress{
+	addresses := make([]lbv1.AllocatedAddress, 0, len(families))
+	for _, family := range families {
+		// the ip is booked on pool when successfully Get()
+		ipConfig, err := allocator.Get(id, family, getRequestedIP(lb, family))
+		if err != nil {
+			// release the IPs allocated in other IP families to avoid leaking them
+			if len(addresses) > 0 {
+				if releaseErr := allocator.Release(id, ""); releaseErr != nil {
+					logrus.Warnf("lb %s fail to release ip to pool %s, error: %s", id, pool, releaseErr.Error())
+				}
+			}
+			// if failed, log the pool name
+			if errors.Is(err, ipam.ErrPoolExhausted) || errors.Is(err, ipam.ErrQuotaExceeded) {
+				return nil, fmt.Errorf("%w, fail to get %s ip from pool %s, error: %w", errNoAvailableIP, family, pool, err)
+			}
+			return nil, fmt.Errorf("fail to get %s ip from pool %s, error: %w", family, pool, err)
+		}
+
+		addresses = append(addresses, toAllocatedAddress(pool, ipConfig))
+	}
+
+	return addresses, nil
+}
+
+func toAllocatedAddress(pool string, ipConfig *current.IPConfig) lbv1.AllocatedAddress {
+	return lbv1.AllocatedAddress{
 		IPPool:  pool,
 		IP:      ipConfig.Address.IP.String(),
 		Mask:    net.IP(ipConfig.Address.Mask).String(),
 		Gateway: ipConfig.Gateway.String(),
-	}, nil
+	}
+}
+
+// getRequestedIP returns the requested IP of the IP family, nil if not requested
+func getRequestedIP(lb *lbv1.LoadBalancer, family corev1.IPFamily) net.IP {
+	for _, ipStr := range lb.Spec.RequestedIPs {
+		if ip := net.ParseIP(ipStr); ip != nil && utils.GetIPFamily(ip) == family {
+			return ip
+		}
+	}
+
+	return nil
+}
+
+// isRequestedIPChanged returns true if any allocated IP is different from the requested IP of the same IP family
+func isRequestedIPChanged(lb *lbv1.LoadBalancer) bool {
+	for _, address := range []lbv1.AllocatedAddress{lb.Status.AllocatedAddress, lb.Status.SecondaryAllocatedAddress} {
+		ip := net.ParseIP(address.IP)
+		if ip == nil {
+			continue
+		}
+		if requestedIP := getRequestedIP(lb, utils.GetIPFamily(ip)); requestedIP != nil && !requestedIP.Equal(ip) {
+			return true
+		}
+	}
+
+	return false
 }
 
 func (h *Handler) selectIPPool(lb *lbv1.LoadBalancer) (string, error) {
-	r := &ipam.Requirement{
-		Network:   lb.Annotations[utils.AnnotationKeyNetwork],
-		Project:   lb.Annotations[utils.AnnotationKeyProject],
-		Namespace: lb.Annotations[utils.AnnotationKeyNamespace],
-		Cluster:   lb.Annotations[utils.AnnotationKeyCluster],
+	pools, err := h.selectIPPools(lb)
+	if err != nil {
+		return "", err
 	}
-	if r.Namespace == "" {
-		r.Namespace = lb.Namespace
+
+	return pools[0].Name, nil
+}
+
+// selectIPPools returns the pools matching the lb in priority order, followed by the global pool
+func (h *Handler) selectIPPools(lb *lbv1.LoadBalancer) ([]*lbv1.IPPool, error) {
+	r, err := ipam.NewRequirement(lb, h.namespaceCache)
+	if err != nil {
+		return nil, err
 	}
-	pool, err := ipam.NewSelector(h.ipPoolCache).Select(r, false)
+	pools, err := ipam.NewSelector(h.ipPoolCache).SelectAll(r, false)
 	if err != nil {
-		return "", fmt.Errorf("%w with selector, error: %w", errNoMatchedIPPool, err)
+		return nil, fmt.Errorf("%w with selector, error: %w", errNoMatchedIPPool, err)
 	}
-	if pool != nil {
-		return pool.Name, nil
+	if len(pools) > 0 {
+		return pools, nil
 	}
 
 	// for Cluster type LB, re-try in loose moe
 	if lb.Spec.WorkloadType == lbv1.Cluster {
-		pool, err := ipam.NewSelector(h.ipPoolCache).Select(r, true)
+		pools, err := ipam.NewSelector(h.ipPoolCache).SelectAll(r, true)
 		if err != nil {
-			return "", fmt.Errorf("%w with selector, error: %w", errNoMatchedIPPool, err)
+			return nil, fmt.Errorf("%w with selector, error: %w", errNoMatchedIPPool, err)
 		}
-		if pool != nil {
-			return pool.Name, nil
+		if len(pools) > 0 {
+			return pools, nil
 		}
 		// pool is still not found
 	}
 
-	return "", fmt.Errorf("%w with requirement %+v", errNoMatchedIPPool, r)
+	return nil, fmt.Errorf("%w with requirement %+v", errNoMatchedIPPool, r)
 }
 
 func (h *Handler) releaseIP(lb *lbv1.LoadBalancer) error {
@@ -398,6 +588,21 @@ func (h *Handler) releaseIP(lb *lbv1.LoadBalancer) error {
 	return a.Release(fmt.Sprintf("%s/%s", lb.Namespace, lb.Name), "")
 }
 
+// OnIPPoolChange enqueues the load balancers whose addresses are marked to be re-addressed by the IP pool
+func (h *Handler) OnIPPoolChange(_ string, pool *lbv1.IPPool) (*lbv1.IPPool, error) {
+	if pool == nil || pool.DeletionTimestamp != nil {
+		return pool, nil
+	}
+
+	for _, applicant := range pool.Status.ReAddressing {
+		if namespace, name, ok := strings.Cut(applicant, "/"); ok {
+			h.lbController.Enqueue(namespace, name)
+		}
+	}
+
+	return pool, nil
+}
+
 // lb manager health check notify that the health of some VMs changed
 func (h *Handler) HealthCheckNotify(namespace, name string) error {
 	h.lbController.Enqueue(namespace, name)
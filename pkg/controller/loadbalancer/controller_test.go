@@ -0,0 +1,369 @@
+package loadbalancer
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	lbv1 "github.com/harvester/harvester-load-balancer/pkg/apis/loadbalancer.harvesterhci.io/v1beta1"
+	"github.com/harvester/harvester-load-balancer/pkg/generated/clientset/versioned/fake"
+	ctllbv1 "github.com/harvester/harvester-load-balancer/pkg/generated/controllers/loadbalancer.harvesterhci.io/v1beta1"
+	"github.com/harvester/harvester-load-balancer/pkg/ipam"
+	"github.com/harvester/harvester-load-balancer/pkg/ipam/store"
+	"github.com/harvester/harvester-load-balancer/pkg/utils"
+	"github.com/harvester/harvester-load-balancer/pkg/utils/fakeclients"
+)
+
+func newPool(name, ip string, priority uint32, overflow bool) *lbv1.IPPool {
+	return &lbv1.IPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: lbv1.IPPoolSpec{
+			Ranges:   []lbv1.Range{{Subnet: "192.168.100.0/24", RangeStart: ip, RangeEnd: ip}},
+			Overflow: overflow,
+			Selector: lbv1.Selector{
+				Priority: priority,
+				Scope:    []lbv1.Tuple{{Namespace: "default"}},
+			},
+		},
+	}
+}
+
+// fakeLBController only records the requeue of the lb
+type fakeLBController struct {
+	ctllbv1.LoadBalancerController
+	after time.Duration
+}
+
+func (c *fakeLBController) EnqueueAfter(_, _ string, after time.Duration) {
+	c.after = after
+}
+
+func TestHandler_AllocateIPFromPoolWithOverflow(t *testing.T) {
+	tests := []struct {
+		name           string
+		overflow       bool
+		wantPool       string
+		wantOverflowed bool
+		wantErr        error
+	}{
+		{
+			name:           "overflow to the next matching pool",
+			overflow:       true,
+			wantPool:       "pool2",
+			wantOverflowed: true,
+		},
+		{
+			name:    "the exhausted pool doesn't overflow",
+			wantErr: errNoAvailableIP,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool1 := newPool("pool1", "192.168.100.10", 2, tt.overflow)
+			pool2 := newPool("pool2", "192.168.100.20", 1, false)
+			clientset := fake.NewSimpleClientset(pool1, pool2, store.NewAllocation(pool1, "192.168.100.10", "default/others"))
+			h := &Handler{
+				ipPoolCache:  fakeclients.IPPoolCache(clientset.LoadbalancerV1beta1().IPPools),
+				allocatorMap: ipam.NewSafeAllocatorMap(),
+			}
+			for _, pool := range []*lbv1.IPPool{pool1, pool2} {
+				a, err := ipam.NewAllocator(pool.Name, &pool.Spec, h.ipPoolCache,
+					fakeclients.IPPoolClient(clientset.LoadbalancerV1beta1().IPPools),
+					fakeclients.IPAllocationCache(clientset.LoadbalancerV1beta1().IPAllocations),
+					fakeclients.IPAllocationClient(clientset.LoadbalancerV1beta1().IPAllocations))
+				if err != nil {
+					t.Fatalf("failed to create allocator, error: %s", err.Error())
+				}
+				h.allocatorMap.AddOrUpdate(pool.Name, a)
+			}
+
+			lb := &lbv1.LoadBalancer{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "lb1"}}
+			lbCopy := lb.DeepCopy()
+			addresses, err := h.allocateIPFromPool(lbCopy, lb)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("allocateIPFromPool() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("allocateIPFromPool() error = %v", err)
+			}
+			if len(addresses) != 1 || addresses[0].IPPool != tt.wantPool {
+				t.Errorf("allocateIPFromPool() = %+v, want an address from %s", addresses, tt.wantPool)
+			}
+			if got := lbv1.LoadBalancerPoolOverflowed.IsTrue(lbCopy); got != tt.wantOverflowed {
+				t.Errorf("pool overflowed = %v, want %v", got, tt.wantOverflowed)
+			}
+		})
+	}
+}
+
+func TestHandler_PoolMigration(t *testing.T) {
+	pool1 := newPool("pool1", "192.168.100.10", 0, false)
+	pool2 := newPool("pool2", "192.168.100.20", 0, false)
+	clientset := fake.NewSimpleClientset(pool1, pool2, store.NewAllocation(pool1, "192.168.100.10", "default/lb1"))
+	allocationCache := fakeclients.IPAllocationCache(clientset.LoadbalancerV1beta1().IPAllocations)
+	controller := &fakeLBController{}
+	h := &Handler{
+		lbController:    controller,
+		ipPoolCache:     fakeclients.IPPoolCache(clientset.LoadbalancerV1beta1().IPPools),
+		allocationCache: allocationCache,
+		allocatorMap:    ipam.NewSafeAllocatorMap(),
+	}
+	for _, pool := range []*lbv1.IPPool{pool1, pool2} {
+		a, err := ipam.NewAllocator(pool.Name, &pool.Spec, h.ipPoolCache,
+			fakeclients.IPPoolClient(clientset.LoadbalancerV1beta1().IPPools), allocationCache,
+			fakeclients.IPAllocationClient(clientset.LoadbalancerV1beta1().IPAllocations))
+		if err != nil {
+			t.Fatalf("failed to create allocator, error: %s", err.Error())
+		}
+		h.allocatorMap.AddOrUpdate(pool.Name, a)
+	}
+
+	lb := &lbv1.LoadBalancer{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "lb1"},
+		Spec:       lbv1.LoadBalancerSpec{IPAM: lbv1.Pool, IPPool: pool2.Name},
+		Status: lbv1.LoadBalancerStatus{
+			AllocatedAddress: lbv1.AllocatedAddress{IPPool: pool1.Name, IP: "192.168.100.10"},
+		},
+	}
+	now := time.Now().Truncate(time.Second)
+
+	// the new address is allocated while the previous one is kept
+	lbCopy := lb.DeepCopy()
+	if _, err := h.startPoolMigration(lbCopy, lb, now); err != nil {
+		t.Fatalf("startPoolMigration() error = %v", err)
+	}
+	if lbCopy.Status.AllocatedAddress.IPPool != pool2.Name || lbCopy.Status.AllocatedAddress.IP != "192.168.100.20" {
+		t.Errorf("unexpected allocated address %+v", lbCopy.Status.AllocatedAddress)
+	}
+	migration := lbCopy.Status.PoolMigration
+	if migration == nil || migration.IPPool != pool1.Name || len(migration.Addresses) != 1 || migration.Addresses[0] != "192.168.100.10" {
+		t.Fatalf("unexpected pool migration %+v", migration)
+	}
+	if !lbv1.LoadBalancerPoolMigrating.IsTrue(lbCopy) || controller.after != defaultPoolMigrationOverlap {
+		t.Errorf("expect the lb is migrating and requeued after %v, got %v", defaultPoolMigrationOverlap, controller.after)
+	}
+
+	// the previous address is kept during the overlap window
+	lb = lbCopy
+	lbCopy = lb.DeepCopy()
+	if _, err := h.finishPoolMigration(lbCopy, lb, now.Add(time.Second)); err != nil {
+		t.Fatalf("finishPoolMigration() error = %v", err)
+	}
+	if lbCopy.Status.PoolMigration == nil {
+		t.Errorf("expect the migration is in the overlap window")
+	}
+	if allocated, _ := store.GetAllocated(allocationCache, pool1); allocated["192.168.100.10"] != "default/lb1" {
+		t.Errorf("expect the previous address is kept, got %v", allocated)
+	}
+
+	// the previous address is released after the overlap window
+	if _, err := h.finishPoolMigration(lbCopy, lb, now.Add(defaultPoolMigrationOverlap)); err != nil {
+		t.Fatalf("finishPoolMigration() error = %v", err)
+	}
+	if lbCopy.Status.PoolMigration != nil || lbv1.LoadBalancerPoolMigrating.IsTrue(lbCopy) {
+		t.Errorf("expect the migration is finished, got %+v", lbCopy.Status.PoolMigration)
+	}
+	if allocated, _ := store.GetAllocated(allocationCache, pool1); len(allocated) != 0 {
+		t.Errorf("expect the previous address is released, got %v", allocated)
+	}
+}
+
+func TestHandler_EnsureAllocatedAddressDHCP(t *testing.T) {
+	pool1 := newPool("pool1", "192.168.100.10", 0, false)
+	clientset := fake.NewSimpleClientset(pool1, store.NewAllocation(pool1, "192.168.100.10", "default/lb1"))
+	allocationCache := fakeclients.IPAllocationCache(clientset.LoadbalancerV1beta1().IPAllocations)
+	h := &Handler{
+		ipPoolCache:     fakeclients.IPPoolCache(clientset.LoadbalancerV1beta1().IPPools),
+		allocationCache: allocationCache,
+		allocatorMap:    ipam.NewSafeAllocatorMap(),
+	}
+	a, err := ipam.NewAllocator(pool1.Name, &pool1.Spec, h.ipPoolCache,
+		fakeclients.IPPoolClient(clientset.LoadbalancerV1beta1().IPPools), allocationCache,
+		fakeclients.IPAllocationClient(clientset.LoadbalancerV1beta1().IPAllocations))
+	if err != nil {
+		t.Fatalf("failed to create allocator, error: %s", err.Error())
+	}
+	h.allocatorMap.AddOrUpdate(pool1.Name, a)
+
+	lb := &lbv1.LoadBalancer{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "lb1"},
+		Spec:       lbv1.LoadBalancerSpec{IPAM: lbv1.DHCP},
+		Status: lbv1.LoadBalancerStatus{
+			PoolMigration: &lbv1.PoolMigration{
+				IPPool:      pool1.Name,
+				Addresses:   []string{"192.168.100.10"},
+				ReleaseTime: time.Now().Add(time.Hour).Format(time.RFC3339),
+			},
+		},
+	}
+	lbv1.LoadBalancerPoolMigrating.True(lb)
+	lbCopy := lb.DeepCopy()
+	if _, err := h.ensureAllocatedAddressDHCP(lbCopy, lb); err != nil {
+		t.Fatalf("ensureAllocatedAddressDHCP() error = %v", err)
+	}
+	if lbCopy.Status.PoolMigration != nil || lbv1.LoadBalancerPoolMigrating.IsTrue(lbCopy) {
+		t.Errorf("expect the migration is dropped, got %+v", lbCopy.Status.PoolMigration)
+	}
+	if allocated, _ := store.GetAllocated(allocationCache, pool1); len(allocated) != 0 {
+		t.Errorf("expect the previous address is released, got %v", allocated)
+	}
+	if lbCopy.Status.AllocatedAddress.IP != utils.Address4AskDHCP {
+		t.Errorf("unexpected allocated address %+v", lbCopy.Status.AllocatedAddress)
+	}
+}
+
+func TestHandler_ReAddress(t *testing.T) {
+	// the range shrinks to exclude the allocated IP 192.168.100.10
+	pool := newPool("pool1", "192.168.100.20", 0, false)
+	pool.Spec.ReAddress = true
+	pool.Status.ReAddressing = map[string]string{"192.168.100.10": "default/lb1"}
+	clientset := fake.NewSimpleClientset(pool, store.NewAllocation(pool, "192.168.100.10", "default/lb1"))
+	allocationCache := fakeclients.IPAllocationCache(clientset.LoadbalancerV1beta1().IPAllocations)
+	controller := &fakeLBController{}
+	h := &Handler{
+		lbController:    controller,
+		ipPoolCache:     fakeclients.IPPoolCache(clientset.LoadbalancerV1beta1().IPPools),
+		allocationCache: allocationCache,
+		allocatorMap:    ipam.NewSafeAllocatorMap(),
+	}
+	a, err := ipam.NewAllocator(pool.Name, &pool.Spec, h.ipPoolCache,
+		fakeclients.IPPoolClient(clientset.LoadbalancerV1beta1().IPPools), allocationCache,
+		fakeclients.IPAllocationClient(clientset.LoadbalancerV1beta1().IPAllocations))
+	if err != nil {
+		t.Fatalf("failed to create allocator, error: %s", err.Error())
+	}
+	h.allocatorMap.AddOrUpdate(pool.Name, a)
+
+	lb := &lbv1.LoadBalancer{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "lb1"},
+		Spec:       lbv1.LoadBalancerSpec{IPAM: lbv1.Pool, IPPool: pool.Name},
+		Status: lbv1.LoadBalancerStatus{
+			AllocatedAddress: lbv1.AllocatedAddress{IPPool: pool.Name, IP: "192.168.100.10"},
+		},
+	}
+
+	// the new address in the ranges is allocated while the excluded one is kept
+	lbCopy := lb.DeepCopy()
+	if _, err := h.ensureAllocatedAddressPool(lbCopy, lb); err != nil {
+		t.Fatalf("ensureAllocatedAddressPool() error = %v", err)
+	}
+	if lbCopy.Status.AllocatedAddress.IPPool != pool.Name || lbCopy.Status.AllocatedAddress.IP != "192.168.100.20" {
+		t.Errorf("unexpected allocated address %+v", lbCopy.Status.AllocatedAddress)
+	}
+	migration := lbCopy.Status.PoolMigration
+	if migration == nil || migration.IPPool != pool.Name || len(migration.Addresses) != 1 || migration.Addresses[0] != "192.168.100.10" {
+		t.Fatalf("unexpected pool migration %+v", migration)
+	}
+	if !lbv1.LoadBalancerPoolMigrating.IsTrue(lbCopy) || controller.after != defaultPoolMigrationOverlap {
+		t.Errorf("expect the lb is re-addressing and requeued after %v, got %v", defaultPoolMigrationOverlap, controller.after)
+	}
+
+	// only the excluded address is released after the overlap window
+	lb = lbCopy
+	lbCopy = lb.DeepCopy()
+	releaseTime, _ := time.Parse(time.RFC3339, migration.ReleaseTime)
+	if _, err := h.finishPoolMigration(lbCopy, lb, releaseTime); err != nil {
+		t.Fatalf("finishPoolMigration() error = %v", err)
+	}
+	if lbCopy.Status.PoolMigration != nil {
+		t.Errorf("expect the re-addressing is finished, got %+v", lbCopy.Status.PoolMigration)
+	}
+	allocated, _ := store.GetAllocated(allocationCache, pool)
+	if len(allocated) != 1 || allocated["192.168.100.20"] != "default/lb1" {
+		t.Errorf("expect only the new address is allocated, got %v", allocated)
+	}
+}
+
+func TestHandler_AdoptClaimedAddress(t *testing.T) {
+	pool := newPool("pool1", "192.168.100.10", 0, false)
+	pool.Spec.Ranges[0].RangeEnd = "192.168.100.20"
+	claim := &lbv1.IPClaim{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "claim1"},
+		Status: lbv1.IPClaimStatus{
+			AllocatedAddress: lbv1.AllocatedAddress{IPPool: pool.Name, IP: "192.168.100.11", Mask: "255.255.255.0"},
+		},
+	}
+	clientset := fake.NewSimpleClientset(pool, claim, store.NewAllocation(pool, "192.168.100.10", "default/lb1"),
+		store.NewAllocation(pool, "192.168.100.11", ipam.ClaimApplicant("default", "claim1")))
+	allocationCache := fakeclients.IPAllocationCache(clientset.LoadbalancerV1beta1().IPAllocations)
+	h := &Handler{
+		ipPoolCache:     fakeclients.IPPoolCache(clientset.LoadbalancerV1beta1().IPPools),
+		allocationCache: allocationCache,
+		claimCache:      fakeclients.IPClaimCache(clientset.LoadbalancerV1beta1().IPClaims),
+		allocatorMap:    ipam.NewSafeAllocatorMap(),
+	}
+	a, err := ipam.NewAllocator(pool.Name, &pool.Spec, h.ipPoolCache,
+		fakeclients.IPPoolClient(clientset.LoadbalancerV1beta1().IPPools), allocationCache,
+		fakeclients.IPAllocationClient(clientset.LoadbalancerV1beta1().IPAllocations))
+	if err != nil {
+		t.Fatalf("failed to create allocator, error: %s", err.Error())
+	}
+	h.allocatorMap.AddOrUpdate(pool.Name, a)
+
+	// the lb refers to the claim after allocating its own address
+	lb := &lbv1.LoadBalancer{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "lb1"},
+		Spec:       lbv1.LoadBalancerSpec{IPAM: lbv1.Pool, IPClaim: "claim1"},
+		Status: lbv1.LoadBalancerStatus{
+			AllocatedAddress: lbv1.AllocatedAddress{IPPool: pool.Name, IP: "192.168.100.10"},
+		},
+	}
+	lbCopy := lb.DeepCopy()
+	if _, err := h.ensureAllocatedAddressPool(lbCopy, lb); err != nil {
+		t.Fatalf("ensureAllocatedAddressPool() error = %v", err)
+	}
+	if lbCopy.Status.AllocatedAddress != claim.Status.AllocatedAddress {
+		t.Errorf("expect the lb adopts the claimed address, got %+v", lbCopy.Status.AllocatedAddress)
+	}
+	allocated, _ := store.GetAllocated(allocationCache, pool)
+	if _, ok := allocated["192.168.100.10"]; ok || len(allocated) != 1 {
+		t.Errorf("expect the own address of the lb is released, got %v", allocated)
+	}
+
+	// the claimed address stays with the claim after the lb stops referring to it
+	lb = lbCopy
+	lb.Spec.IPClaim = ""
+	lbCopy = lb.DeepCopy()
+	if _, err := h.ensureAllocatedAddressPool(lbCopy, lb); err != nil {
+		t.Fatalf("ensureAllocatedAddressPool() error = %v", err)
+	}
+	if lbCopy.Status.AllocatedAddress.IP != "" {
+		t.Errorf("expect the claimed address is given up, got %+v", lbCopy.Status.AllocatedAddress)
+	}
+	allocated, _ = store.GetAllocated(allocationCache, pool)
+	if allocated["192.168.100.11"] != ipam.ClaimApplicant("default", "claim1") {
+		t.Errorf("expect the claimed address is kept by the claim, got %v", allocated)
+	}
+
+	// the lb waits for the claim which isn't bound
+	lb = &lbv1.LoadBalancer{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "lb2"},
+		Spec:       lbv1.LoadBalancerSpec{IPAM: lbv1.Pool, IPClaim: "claim2"},
+	}
+	if _, err := h.ensureAllocatedAddressPool(lb.DeepCopy(), lb); !errors.Is(err, errNoAvailableIP) {
+		t.Errorf("expect error %v, got %v", errNoAvailableIP, err)
+	}
+
+	// the lb keeps its own addresses when the claim doesn't cover its IP families
+	lb = &lbv1.LoadBalancer{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "lb3"},
+		Spec:       lbv1.LoadBalancerSpec{IPAM: lbv1.Pool, IPClaim: "claim1", IPFamilyPolicy: lbv1.DualStack},
+		Status: lbv1.LoadBalancerStatus{
+			AllocatedAddress:          lbv1.AllocatedAddress{IPPool: pool.Name, IP: "192.168.100.12"},
+			SecondaryAllocatedAddress: lbv1.AllocatedAddress{IPPool: pool.Name, IP: "fd00::12"},
+		},
+	}
+	lbCopy = lb.DeepCopy()
+	if _, err := h.ensureAllocatedAddressPool(lbCopy, lb); err == nil {
+		t.Errorf("expect the lb fails to adopt the claimed address")
+	}
+	if lbCopy.Status.AllocatedAddress != lb.Status.AllocatedAddress || lbCopy.Status.SecondaryAllocatedAddress != lb.Status.SecondaryAllocatedAddress {
+		t.Errorf("expect the own addresses are kept, got %+v, %+v", lbCopy.Status.AllocatedAddress, lbCopy.Status.SecondaryAllocatedAddress)
+	}
+}
@@ -0,0 +1,208 @@
+package loadbalancer
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+
+	lbv1 "github.com/harvester/harvester-load-balancer/pkg/apis/loadbalancer.harvesterhci.io/v1beta1"
+	"github.com/harvester/harvester-load-balancer/pkg/ipam"
+	"github.com/harvester/harvester-load-balancer/pkg/utils"
+)
+
+// defaultPoolMigrationOverlap is how long both the previous and the new addresses are exposed if the lb doesn't
+// specify it
+const defaultPoolMigrationOverlap = 60 * time.Second
+
+// startPoolMigration allocates the new addresses from the pool specified by the lb while keeping the previous ones,
+// both of them are exposed on the service until the overlap window passes
+func (h *Handler) startPoolMigration(lbCopy, lb *lbv1.LoadBalancer, now time.Time) (*lbv1.LoadBalancer, error) {
+	// a new migration finishes the ongoing one
+	if lb.Status.PoolMigration != nil {
+		if err := h.releasePreviousAddresses(lb); err != nil {
+			return lb, err
+		}
+	}
+
+	ips, err := h.requestIP(lb, lb.Spec.IPPool)
+	if err != nil {
+		// the new addresses may be allocated without being recorded into the status, release and retry
+		if strings.Contains(err.Error(), utils.DuplicateAllocationKeyWord) {
+			if a := h.allocatorMap.Get(lb.Spec.IPPool); a != nil {
+				if releaseErr := a.Release(fmt.Sprintf("%s/%s", lb.Namespace, lb.Name), ""); releaseErr != nil {
+					logrus.Infof("lb %s/%s error: %s, try to release ip to pool %s, error: %s", lb.Namespace, lb.Name, err.Error(), lb.Spec.IPPool, releaseErr.Error())
+				}
+			}
+		}
+		return lb, fmt.Errorf("fail to migrate to pool %s, error: %w", lb.Spec.IPPool, err)
+	}
+
+	previous := make([]string, 0, 2)
+	for _, address := range []lbv1.AllocatedAddress{lb.Status.AllocatedAddress, lb.Status.SecondaryAllocatedAddress} {
+		if address.IP != "" {
+			previous = append(previous, address.IP)
+		}
+	}
+	overlap := getPoolMigrationOverlap(lb)
+	releaseTime := now.Add(overlap).UTC().Format(time.RFC3339)
+
+	lbCopy.Status.PoolMigration = &lbv1.PoolMigration{
+		IPPool:      lb.Status.AllocatedAddress.IPPool,
+		Addresses:   previous,
+		ReleaseTime: releaseTime,
+	}
+	lbCopy.Status.AllocatedAddress = ips[0]
+	lbCopy.Status.SecondaryAllocatedAddress = lbv1.AllocatedAddress{}
+	if len(ips) > 1 {
+		lbCopy.Status.SecondaryAllocatedAddress = ips[1]
+	}
+	lbv1.LoadBalancerPoolMigrating.True(lbCopy)
+	lbv1.LoadBalancerPoolMigrating.Message(lbCopy, fmt.Sprintf("migrating from pool %s to pool %s, the previous addresses %s are released at %s",
+		lb.Status.AllocatedAddress.IPPool, lb.Spec.IPPool, strings.Join(previous, ","), releaseTime))
+
+	for _, ip := range ips {
+		logrus.Infof("lb %s/%s migrates to ip %s from pool %s, the previous addresses %v are released at %s",
+			lb.Namespace, lb.Name, ip.IP, ip.IPPool, previous, releaseTime)
+	}
+	h.lbController.EnqueueAfter(lb.Namespace, lb.Name, overlap)
+
+	return lb, nil
+}
+
+func getPoolMigrationOverlap(lb *lbv1.LoadBalancer) time.Duration {
+	if lb.Spec.PoolMigrationOverlapSeconds > 0 {
+		return time.Duration(lb.Spec.PoolMigrationOverlapSeconds) * time.Second
+	}
+
+	return defaultPoolMigrationOverlap
+}
+
+// finishPoolMigration releases the previous addresses after the overlap window passes
+func (h *Handler) finishPoolMigration(lbCopy, lb *lbv1.LoadBalancer, now time.Time) (*lbv1.LoadBalancer, error) {
+	releaseTime, err := time.Parse(time.RFC3339, lb.Status.PoolMigration.ReleaseTime)
+	if err == nil && now.Before(releaseTime) {
+		h.lbController.EnqueueAfter(lb.Namespace, lb.Name, releaseTime.Sub(now))
+		return lb, nil
+	}
+
+	if err := h.releasePreviousAddresses(lb); err != nil {
+		return lb, err
+	}
+	logrus.Infof("lb %s/%s has migrated from pool %s, release the previous addresses %v", lb.Namespace, lb.Name,
+		lb.Status.PoolMigration.IPPool, lb.Status.PoolMigration.Addresses)
+
+	clearPoolMigration(lbCopy)
+
+	return lb, nil
+}
+
+// clearPoolMigration drops the migration record once the previous addresses are released
+func clearPoolMigration(lbCopy *lbv1.LoadBalancer) {
+	lbCopy.Status.PoolMigration = nil
+	lbv1.LoadBalancerPoolMigrating.False(lbCopy)
+	lbv1.LoadBalancerPoolMigrating.Message(lbCopy, "")
+}
+
+// releasePreviousAddresses releases the addresses which the lb migrates from
+func (h *Handler) releasePreviousAddresses(lb *lbv1.LoadBalancer) error {
+	pool := lb.Status.PoolMigration.IPPool
+	if pool == "" {
+		return nil
+	}
+	// the addresses are removed with the pool
+	if _, err := h.ipPoolCache.Get(pool); apierrors.IsNotFound(err) {
+		return nil
+	}
+	// if pool is not ready, just fail and wait
+	a := h.allocatorMap.Get(pool)
+	if a == nil {
+		return fmt.Errorf("fail to get allocator %s", pool)
+	}
+	// only the previous addresses are released, the new ones may be allocated from the same pool when re-addressed
+	id := fmt.Sprintf("%s/%s", lb.Namespace, lb.Name)
+	for _, address := range lb.Status.PoolMigration.Addresses {
+		ip := net.ParseIP(address)
+		if ip == nil {
+			continue
+		}
+		if err := a.ReleaseIP(id, ip); err != nil {
+			return fmt.Errorf("fail to release the previous address %s to pool %s, error: %w", address, pool, err)
+		}
+	}
+
+	return nil
+}
+
+// getReAddressed returns the allocated addresses which the pool in the re-address mode marks to be moved
+func (h *Handler) getReAddressed(lb *lbv1.LoadBalancer) []lbv1.AllocatedAddress {
+	pool, err := h.ipPoolCache.Get(lb.Status.AllocatedAddress.IPPool)
+	if err != nil || !pool.Spec.ReAddress {
+		return nil
+	}
+
+	id := fmt.Sprintf("%s/%s", lb.Namespace, lb.Name)
+	var addresses []lbv1.AllocatedAddress
+	for _, address := range []lbv1.AllocatedAddress{lb.Status.AllocatedAddress, lb.Status.SecondaryAllocatedAddress} {
+		if address.IP != "" && pool.Status.ReAddressing[address.IP] == id {
+			addresses = append(addresses, address)
+		}
+	}
+
+	return addresses
+}
+
+// startReAddress allocates new addresses in the ranges of the pool to replace the excluded ones, both of them are
+// exposed on the service until the overlap window passes as same as the migration to another pool
+func (h *Handler) startReAddress(lbCopy, lb *lbv1.LoadBalancer, excluded []lbv1.AllocatedAddress, now time.Time) (*lbv1.LoadBalancer, error) {
+	pool := lb.Status.AllocatedAddress.IPPool
+	a := h.allocatorMap.Get(pool)
+	if a == nil {
+		return lb, fmt.Errorf("fail to get allocator %s", pool)
+	}
+
+	id := fmt.Sprintf("%s/%s", lb.Namespace, lb.Name)
+	previous := make([]string, 0, len(excluded))
+	for _, address := range excluded {
+		family := utils.GetIPFamily(net.ParseIP(address.IP))
+		if requestedIP := getRequestedIP(lb, family); requestedIP != nil {
+			return lb, fmt.Errorf("fail to re-address, the requested ip %s is excluded from pool %s", requestedIP, pool)
+		}
+		// the new address may be allocated without being recorded into the status, take it again
+		ipConfig, err := a.Get(id, family, a.GetAllocatedIP(id, family))
+		if err != nil {
+			if errors.Is(err, ipam.ErrPoolExhausted) {
+				return lb, fmt.Errorf("%w, fail to re-address ip %s in pool %s, error: %w", errNoAvailableIP, address.IP, pool, err)
+			}
+			return lb, fmt.Errorf("fail to re-address ip %s in pool %s, error: %w", address.IP, pool, err)
+		}
+
+		newAddress := toAllocatedAddress(pool, ipConfig)
+		if lb.Status.AllocatedAddress.IP == address.IP {
+			lbCopy.Status.AllocatedAddress = newAddress
+		} else {
+			lbCopy.Status.SecondaryAllocatedAddress = newAddress
+		}
+		previous = append(previous, address.IP)
+		logrus.Infof("lb %s/%s re-addresses ip %s to %s in pool %s", lb.Namespace, lb.Name, address.IP, newAddress.IP, pool)
+	}
+
+	overlap := getPoolMigrationOverlap(lb)
+	releaseTime := now.Add(overlap).UTC().Format(time.RFC3339)
+
+	lbCopy.Status.PoolMigration = &lbv1.PoolMigration{
+		IPPool:      pool,
+		Addresses:   previous,
+		ReleaseTime: releaseTime,
+	}
+	lbv1.LoadBalancerPoolMigrating.True(lbCopy)
+	lbv1.LoadBalancerPoolMigrating.Message(lbCopy, fmt.Sprintf("re-addressing in pool %s, the excluded addresses %s are released at %s",
+		pool, strings.Join(previous, ","), releaseTime))
+	h.lbController.EnqueueAfter(lb.Namespace, lb.Name, overlap)
+
+	return lb, nil
+}
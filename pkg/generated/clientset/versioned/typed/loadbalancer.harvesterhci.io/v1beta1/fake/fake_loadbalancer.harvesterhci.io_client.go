@@ -28,6 +28,14 @@ type FakeLoadbalancerV1beta1 struct {
 	*testing.Fake
 }
 
+func (c *FakeLoadbalancerV1beta1) IPAllocations() v1beta1.IPAllocationInterface {
+	return &FakeIPAllocations{c}
+}
+
+func (c *FakeLoadbalancerV1beta1) IPClaims(namespace string) v1beta1.IPClaimInterface {
+	return &FakeIPClaims{c, namespace}
+}
+
 func (c *FakeLoadbalancerV1beta1) IPPools() v1beta1.IPPoolInterface {
 	return &FakeIPPools{c}
 }
@@ -18,6 +18,10 @@ limitations under the License.
 
 package v1beta1
 
+type IPAllocationExpansion interface{}
+
+type IPClaimExpansion interface{}
+
 type IPPoolExpansion interface{}
 
 type LoadBalancerExpansion interface{}
@@ -28,6 +28,8 @@ import (
 
 type LoadbalancerV1beta1Interface interface {
 	RESTClient() rest.Interface
+	IPAllocationsGetter
+	IPClaimsGetter
 	IPPoolsGetter
 	LoadBalancersGetter
 }
@@ -37,6 +39,14 @@ type LoadbalancerV1beta1Client struct {
 	restClient rest.Interface
 }
 
+func (c *LoadbalancerV1beta1Client) IPAllocations() IPAllocationInterface {
+	return newIPAllocations(c)
+}
+
+func (c *LoadbalancerV1beta1Client) IPClaims(namespace string) IPClaimInterface {
+	return newIPClaims(c, namespace)
+}
+
 func (c *LoadbalancerV1beta1Client) IPPools() IPPoolInterface {
 	return newIPPools(c)
 }
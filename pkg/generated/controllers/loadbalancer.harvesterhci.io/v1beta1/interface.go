@@ -31,6 +31,8 @@ func init() {
 }
 
 type Interface interface {
+	IPAllocation() IPAllocationController
+	IPClaim() IPClaimController
 	IPPool() IPPoolController
 	LoadBalancer() LoadBalancerController
 }
@@ -45,6 +47,14 @@ type version struct {
 	controllerFactory controller.SharedControllerFactory
 }
 
+func (v *version) IPAllocation() IPAllocationController {
+	return generic.NewNonNamespacedController[*v1beta1.IPAllocation, *v1beta1.IPAllocationList](schema.GroupVersionKind{Group: "loadbalancer.harvesterhci.io", Version: "v1beta1", Kind: "IPAllocation"}, "ipallocations", v.controllerFactory)
+}
+
+func (v *version) IPClaim() IPClaimController {
+	return generic.NewController[*v1beta1.IPClaim, *v1beta1.IPClaimList](schema.GroupVersionKind{Group: "loadbalancer.harvesterhci.io", Version: "v1beta1", Kind: "IPClaim"}, "ipclaims", true, v.controllerFactory)
+}
+
 func (v *version) IPPool() IPPoolController {
 	return generic.NewNonNamespacedController[*v1beta1.IPPool, *v1beta1.IPPoolList](schema.GroupVersionKind{Group: "loadbalancer.harvesterhci.io", Version: "v1beta1", Kind: "IPPool"}, "ippools", v.controllerFactory)
 }
@@ -2,30 +2,66 @@ package ipam
 
 import (
 	"crypto/sha256"
+	"errors"
 	"fmt"
+	"math"
 	"math/big"
 	"net"
 	"net/netip"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/containernetworking/cni/pkg/types"
 	current "github.com/containernetworking/cni/pkg/types/100"
 	cnip "github.com/containernetworking/plugins/pkg/ip"
+	"github.com/containernetworking/plugins/plugins/ipam/host-local/backend"
 	"github.com/containernetworking/plugins/plugins/ipam/host-local/backend/allocator"
+	corev1 "k8s.io/api/core/v1"
 
 	lbv1 "github.com/harvester/harvester-load-balancer/pkg/apis/loadbalancer.harvesterhci.io/v1beta1"
 	ctllbv1 "github.com/harvester/harvester-load-balancer/pkg/generated/controllers/loadbalancer.harvesterhci.io/v1beta1"
 	"github.com/harvester/harvester-load-balancer/pkg/ipam/store"
+	"github.com/harvester/harvester-load-balancer/pkg/utils"
 )
 
-const p2pMaskStr = "ffffffff"
+// ErrPoolExhausted is returned by Get if there is no free IP of the IP family in the pool
+var ErrPoolExhausted = errors.New("pool is exhausted")
 
+// ErrPoolCordoned is returned by Get if the pool is cordoned and the applicant doesn't reclaim its allocated history
+var ErrPoolCordoned = errors.New("pool is cordoned")
+
+// hostLocalExhaustedKeyWord is in the error returned by the host-local IPAllocator if there is no free IP
+const hostLocalExhaustedKeyWord = "no IP addresses available"
+
+// maxProbes is how many candidates are probed by the conflict detection in one allocation
+const maxProbes = 16
+
+// Allocator allocates IPs from the ranges of an IP pool.
+// The host-local IPAllocator requires all the ranges of a range set are in the same IP family,
+// so one IPAllocator is created for each IP family of the pool.
 type Allocator struct {
-	*allocator.IPAllocator
-	name     string
-	checkSum string
-	total    int64
-	cache    ctllbv1.IPPoolCache
+	ipv4      *allocator.IPAllocator
+	ipv6      *allocator.IPAllocator
+	ipv4Space *ipSpace
+	ipv6Space *ipSpace
+	name      string
+	checkSum  string
+	total     int64
+	strategy  lbv1.AllocationStrategy
+	store     ipPoolStore
+}
+
+// ipPoolStore is a backend store which records the allocated IPs in an IP pool
+type ipPoolStore interface {
+	backend.Store
+	GetIPPool() (*lbv1.IPPool, error)
+	GetAllocated() (map[string]string, error)
+	Release(ip net.IP) error
+	GetUnavailable(pool *lbv1.IPPool, applicantID string, now time.Time) map[string]bool
+	SetConflictHandler(handler func(ip net.IP))
+	Probe(ip net.IP)
+	SetQuotaChecker(checker func(applicantID string, allocated map[string]string) error)
 }
 
 type SafeAllocatorMap struct {
@@ -33,33 +69,70 @@ type SafeAllocatorMap struct {
 	mutex      sync.RWMutex
 }
 
-func NewAllocator(name string, ranges []lbv1.Range, cache ctllbv1.IPPoolCache, client ctllbv1.IPPoolClient) (*Allocator, error) {
-	if len(ranges) == 0 {
-		return nil, fmt.Errorf("range can't be empty")
+func NewAllocator(name string, spec *lbv1.IPPoolSpec, cache ctllbv1.IPPoolCache, client ctllbv1.IPPoolClient,
+	allocationCache ctllbv1.IPAllocationCache, allocationClient ctllbv1.IPAllocationClient) (*Allocator, error) {
+	s, err := store.New(name, cache, client, allocationCache, allocationClient)
+	if err != nil {
+		return nil, fmt.Errorf("create store of pool %s failed, error: %w", name, err)
 	}
 
-	rangeSlice := make([]allocator.Range, 0)
-	var total int64
+	return newAllocator(name, spec, s)
+}
 
-	for i := range ranges {
-		element, err := MakeRange(&ranges[i])
-		if err != nil {
-			return nil, err
-		}
-		rangeSlice = append(rangeSlice, *element)
-		total += countIP(element)
+// Rebuild creates an allocator from the changed spec of the pool on top of the store of the allocator.
+// The store keeps the in-memory records of the pool, e.g. the IPs released or found in use whose status may not be
+// written yet, and its status writer, so the changes of the ranges don't lose them. The store is only created again
+// with a new allocator when the pool is added, e.g. after the leadership is gained.
+func (a *Allocator) Rebuild(spec *lbv1.IPPoolSpec) (*Allocator, error) {
+	return newAllocator(a.name, spec, a.store)
+}
+
+func newAllocator(name string, spec *lbv1.IPPoolSpec, s ipPoolStore) (*Allocator, error) {
+	if len(spec.Ranges) == 0 {
+		return nil, fmt.Errorf("range can't be empty")
 	}
 
-	rangeSet := allocator.RangeSet(rangeSlice)
+	// the excluded IPs are removed from the range set, so they are neither counted nor allocated
+	rs, err := LBPoolSpecToAllocatorRangeSet(spec)
+	if err != nil {
+		return nil, err
+	}
+	ipv4Ranges, ipv6Ranges, total := splitRangesByFamily(rs)
+
 	return &Allocator{
-		name:        name,
-		IPAllocator: allocator.NewIPAllocator(&rangeSet, store.New(name, cache, client), 0),
-		checkSum:    CalculateCheckSum(ranges),
-		total:       total,
-		cache:       cache,
+		name:      name,
+		ipv4:      newIPAllocator(ipv4Ranges, s),
+		ipv6:      newIPAllocator(ipv6Ranges, s),
+		ipv4Space: newIPSpace(ipv4Ranges),
+		ipv6Space: newIPSpace(ipv6Ranges),
+		checkSum:  CalculateCheckSum(spec),
+		total:     total,
+		strategy:  spec.AllocationStrategy,
+		store:     s,
 	}, nil
 }
 
+// splitRangesByFamily groups the ranges by IP family, it also returns the count of IPs
+func splitRangesByFamily(rs allocator.RangeSet) (ipv4Ranges, ipv6Ranges allocator.RangeSet, total int64) {
+	for i := range rs {
+		if rs[i].RangeStart.To4() != nil {
+			ipv4Ranges = append(ipv4Ranges, rs[i])
+		} else {
+			ipv6Ranges = append(ipv6Ranges, rs[i])
+		}
+		total = addCount(total, countIP(&rs[i]))
+	}
+
+	return ipv4Ranges, ipv6Ranges, total
+}
+
+func newIPAllocator(rangeSet allocator.RangeSet, s ipPoolStore) *allocator.IPAllocator {
+	if len(rangeSet) == 0 {
+		return nil
+	}
+	return allocator.NewIPAllocator(&rangeSet, s, 0)
+}
+
 func MakeRange(r *lbv1.Range) (*allocator.Range, error) {
 	ip, ipNet, err := net.ParseCIDR(r.Subnet)
 	if err != nil {
@@ -68,9 +141,8 @@ func MakeRange(r *lbv1.Range) (*allocator.Range, error) {
 	}
 
 	var defaultStart, defaultEnd, defaultGateway, start, end, gateway net.IP
-	mask := ipNet.Mask.String()
-	// If the subnet is a point to point IP
-	if mask == p2pMaskStr {
+	// If the subnet is a point to point IP, /32 for IPv4 or /128 for IPv6
+	if ones, bits := ipNet.Mask.Size(); ones == bits {
 		defaultStart = ip.To16()
 		defaultEnd = ip.To16()
 		defaultGateway = nil
@@ -133,7 +205,8 @@ func parseIP(ipStr string, ipNet *net.IPNet, defaultIP net.IP) (net.IP, error) {
 	if ip.Equal(networkIP(*ipNet)) {
 		return nil, fmt.Errorf("IP %s is the network address", ipStr)
 	}
-	if ip.Equal(broadcastIP(*ipNet)) {
+	// IPv6 has no broadcast address
+	if ip.To4() != nil && ip.Equal(broadcastIP(*ipNet)) {
 		return nil, fmt.Errorf("IP %s is the broadcast address", ipStr)
 	}
 
@@ -153,14 +226,25 @@ func lastIP(subnet net.IPNet) net.IP {
 	return end
 }
 
+// countIP returns the count of IPs in the range, an IPv6 range may be too large for int64, the count is capped at math.MaxInt64
 func countIP(r *allocator.Range) int64 {
-	count := big.NewInt(0).Add(big.NewInt(0).Sub(ipToInt(r.RangeEnd), ipToInt(r.RangeStart)), big.NewInt(1)).Int64()
+	c := big.NewInt(0).Add(big.NewInt(0).Sub(ipToInt(r.RangeEnd), ipToInt(r.RangeStart)), big.NewInt(1))
 
 	if r.Gateway != nil && r.Contains(r.Gateway) {
-		count--
+		c.Sub(c, big.NewInt(1))
 	}
+	if !c.IsInt64() {
+		return math.MaxInt64
+	}
+
+	return c.Int64()
+}
 
-	return count
+func addCount(a, b int64) int64 {
+	if a > math.MaxInt64-b {
+		return math.MaxInt64
+	}
+	return a + b
 }
 
 func networkIP(n net.IPNet) net.IP {
@@ -190,27 +274,257 @@ func (a *Allocator) Total() int64 {
 	return a.total
 }
 
-func (a *Allocator) Get(id string) (*current.IPConfig, error) {
-	pool, err := a.cache.Get(a.name)
+// SetConflictHandler sets the handler called when an IP is found in use on the network by the conflict detection
+func (a *Allocator) SetConflictHandler(handler func(ip net.IP)) {
+	a.store.SetConflictHandler(handler)
+}
+
+// SetTenantGetter enforces the quotas of the pool on the allocations, the tenants of the applicants are returned by
+// the getter
+func (a *Allocator) SetTenantGetter(getTenant TenantGetter) {
+	a.store.SetQuotaChecker(func(applicantID string, allocated map[string]string) error {
+		return a.checkQuota(getTenant, applicantID, allocated)
+	})
+}
+
+// checkQuota checks whether the applicant can take one more IP from the pool. It is called by the store with the lock
+// held, so the allocated IPs are the in-memory records which the concurrent allocations of the pool can't change.
+// The IPs out of the ranges, e.g. being re-addressed, neither count into the usage nor take the available IPs.
+func (a *Allocator) checkQuota(getTenant TenantGetter, applicantID string, allocated map[string]string) error {
+	pool, err := a.store.GetIPPool()
+	if err != nil {
+		return err
+	}
+	if len(pool.Spec.Quotas) == 0 {
+		return nil
+	}
+	r, err := getTenant(applicantID)
+	if err != nil {
+		return fmt.Errorf("get tenant of %s failed, error: %w", applicantID, err)
+	}
+	if r == nil {
+		return nil
+	}
+
+	inRanges := make(map[string]string, len(allocated))
+	for ipStr, applicant := range allocated {
+		ip := net.ParseIP(ipStr)
+		if ip == nil {
+			continue
+		}
+		if space := a.getIPSpace(utils.GetIPFamily(ip)); space != nil && space.ranges.Contains(ip) {
+			inRanges[ipStr] = applicant
+		}
+	}
+	used, err := GetQuotaUsage(pool, inRanges, getTenant, "")
+	if err != nil {
+		return err
+	}
+
+	return CheckQuota(pool, r, 1, used, a.total-int64(len(inRanges)))
+}
+
+// HasIPFamily returns true if the pool has at least one range of the IP family
+func (a *Allocator) HasIPFamily(family corev1.IPFamily) bool {
+	return a.getIPAllocator(family) != nil
+}
+
+func (a *Allocator) getIPSpace(family corev1.IPFamily) *ipSpace {
+	switch family {
+	case corev1.IPv4Protocol:
+		return a.ipv4Space
+	case corev1.IPv6Protocol:
+		return a.ipv6Space
+	default:
+		return nil
+	}
+}
+
+func (a *Allocator) getIPAllocator(family corev1.IPFamily) *allocator.IPAllocator {
+	switch family {
+	case corev1.IPv4Protocol:
+		return a.ipv4
+	case corev1.IPv6Protocol:
+		return a.ipv6
+	default:
+		return nil
+	}
+}
+
+// Get allocates an IP of the IP family to the applicant
+// The requestedIP is allocated if it is not nil, otherwise the IP reserved for the applicant
+// or the IP allocated to the applicant before is applied in priority
+func (a *Allocator) Get(id string, family corev1.IPFamily, requestedIP net.IP) (*current.IPConfig, error) {
+	ipAllocator := a.getIPAllocator(family)
+	if ipAllocator == nil {
+		return nil, fmt.Errorf("pool %s has no %s range", a.name, family)
+	}
+
+	if requestedIP != nil && utils.GetIPFamily(requestedIP) != family {
+		return nil, fmt.Errorf("requested IP %s is not in IP family %s", requestedIP, family)
+	}
+
+	pool, err := a.store.GetIPPool()
 	if err != nil {
 		return nil, err
 	}
 
+	// a cordoned pool only allows the applicant to keep its IP, take the IP reserved for it or reclaim the IP
+	// allocated to it before
+	if pool.Spec.Cordoned {
+		ip := requestedIP
+		if ip == nil {
+			ip = a.GetAllocatedIP(id, family)
+		}
+		if ip == nil {
+			ip = getReservedIP(pool, id, family)
+		}
+		if ip == nil {
+			ip = a.getHistoryIP(pool, id, family)
+		}
+		if ip == nil || !a.isOwnIP(pool, id, ip) {
+			return nil, fmt.Errorf("%w, pool %s refuses new allocations", ErrPoolCordoned, a.name)
+		}
+		return a.allocate(ipAllocator, id, ip)
+	}
+
+	if requestedIP != nil {
+		return a.allocate(ipAllocator, id, requestedIP)
+	}
+
+	if ip := getReservedIP(pool, id, family); ip != nil {
+		return a.allocate(ipAllocator, id, ip)
+	}
+
 	// apply the IP allocated before in priority
-	if pool.Status.AllocatedHistory != nil {
-		for k, v := range pool.Status.AllocatedHistory {
-			if id == v {
-				return a.IPAllocator.Get(id, "", net.ParseIP(k))
-			}
+	if ip := a.getHistoryIP(pool, id, family); ip != nil {
+		return a.allocate(ipAllocator, id, ip)
+	}
+
+	// the host-local IPAllocator allocates IPs in round-robin
+	if a.strategy == "" || a.strategy == lbv1.RoundRobin {
+		ipConfig, err := a.allocate(ipAllocator, id, nil)
+		if err != nil && strings.Contains(err.Error(), hostLocalExhaustedKeyWord) {
+			return nil, fmt.Errorf("%w, %w", ErrPoolExhausted, err)
 		}
+		return ipConfig, err
+	}
+
+	// the picked IP may be allocated to others concurrently before it is reserved, pick again in that case
+	for i := 0; ; i++ {
+		ip, err := a.pickIP(id, family, pool)
+		if err != nil {
+			return nil, err
+		}
+		ipConfig, err := a.allocate(ipAllocator, id, ip)
+		if err == nil || i >= maxPickRetries {
+			return ipConfig, err
+		}
+	}
+}
+
+// allocate reserves the IP, or the next free one if the IP is nil, by the host-local IPAllocator.
+// The candidate which has to be probed by the conflict detection is probed after the IPAllocator releases the lock
+// of the store, then the IPAllocator reserves again with the result of the probe.
+func (a *Allocator) allocate(ipAllocator *allocator.IPAllocator, id string, ip net.IP) (*current.IPConfig, error) {
+	for i := 0; ; i++ {
+		ipConfig, err := ipAllocator.Get(id, "", ip)
+		var probeErr *store.ProbeRequiredError
+		if !errors.As(err, &probeErr) {
+			return ipConfig, err
+		}
+		if i >= maxProbes {
+			return nil, fmt.Errorf("fail to allocate from pool %s after probing %d addresses, error: %w", a.name, i, err)
+		}
+		a.store.Probe(probeErr.IP)
+	}
+}
+
+// getReservedIP returns the IP of the IP family statically reserved for the applicant, nil if there is no such IP
+func getReservedIP(pool *lbv1.IPPool, id string, family corev1.IPFamily) net.IP {
+	for k, v := range pool.Spec.Reservations {
+		if ip := net.ParseIP(k); id == v && utils.GetIPFamily(ip) == family {
+			return ip
+		}
+	}
+
+	return nil
+}
+
+// isOwnIP checks whether the IP is allocated to the applicant, reserved for it or in its allocated history
+func (a *Allocator) isOwnIP(pool *lbv1.IPPool, id string, ip net.IP) bool {
+	ipStr := ip.String()
+	if pool.Status.AllocatedHistory[ipStr] == id {
+		return true
+	}
+	if owner, ok := store.GetReservation(&pool.Spec, ip); ok && owner == id {
+		return true
+	}
+	allocated, err := a.store.GetAllocated()
+
+	return err == nil && allocated[ipStr] == id
+}
+
+// getHistoryIP returns the IP of the IP family allocated to the applicant before, nil if there is no such IP.
+// The IPs out of the ranges, e.g. released after being re-addressed, are skipped.
+func (a *Allocator) getHistoryIP(pool *lbv1.IPPool, id string, family corev1.IPFamily) net.IP {
+	space := a.getIPSpace(family)
+	if space == nil {
+		return nil
+	}
+	for k, v := range pool.Status.AllocatedHistory {
+		if ip := net.ParseIP(k); id == v && ip != nil && utils.GetIPFamily(ip) == family && space.ranges.Contains(ip) {
+			return ip
+		}
+	}
+
+	return nil
+}
+
+// Release releases all the IPs allocated to the applicant, no matter which IP family they belong to
+func (a *Allocator) Release(id, ifname string) error {
+	if a.ipv4 != nil {
+		return a.ipv4.Release(id, ifname)
+	}
+	if a.ipv6 != nil {
+		return a.ipv6.Release(id, ifname)
+	}
+	return nil
+}
+
+// GetAllocatedIP returns the IP of the IP family in the ranges allocated to the applicant, nil if there is no such IP
+func (a *Allocator) GetAllocatedIP(id string, family corev1.IPFamily) net.IP {
+	space := a.getIPSpace(family)
+	if space == nil {
+		return nil
+	}
+	for _, ip := range a.store.GetByID(id, "") {
+		if utils.GetIPFamily(ip) == family && space.ranges.Contains(ip) {
+			return ip
+		}
+	}
+
+	return nil
+}
+
+// ReleaseIP releases the IP if it is allocated to the applicant, the other IPs of the applicant are kept
+func (a *Allocator) ReleaseIP(id string, ip net.IP) error {
+	allocated, err := a.store.GetAllocated()
+	if err != nil {
+		return err
+	}
+	if allocated[ip.String()] != id {
+		return nil
 	}
 
-	return a.IPAllocator.Get(id, "", nil)
+	return a.store.Release(ip)
 }
 
-func CalculateCheckSum(ranges []lbv1.Range) string {
+// CalculateCheckSum calculates the checksum of the pool spec fields which the allocator is built from
+func CalculateCheckSum(spec *lbv1.IPPoolSpec) string {
 	h := sha256.New()
-	fmt.Fprintf(h, "%v", ranges)
+	// the map is printed in key-sorted order
+	fmt.Fprintf(h, "%v%v%v%v", spec.Ranges, spec.Exclude, spec.Reservations, spec.AllocationStrategy)
 
 	return fmt.Sprintf("%x", h.Sum(nil))
 }
@@ -1,15 +1,30 @@
 package ipam
 
 import (
+	"errors"
 	"fmt"
 	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/containernetworking/cni/pkg/types"
 	"github.com/containernetworking/plugins/plugins/ipam/host-local/backend/allocator"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/util/retry"
 
 	lbv1 "github.com/harvester/harvester-load-balancer/pkg/apis/loadbalancer.harvesterhci.io/v1beta1"
+	"github.com/harvester/harvester-load-balancer/pkg/generated/clientset/versioned/fake"
+	ctllbv1 "github.com/harvester/harvester-load-balancer/pkg/generated/controllers/loadbalancer.harvesterhci.io/v1beta1"
 	"github.com/harvester/harvester-load-balancer/pkg/ipam/store"
+	"github.com/harvester/harvester-load-balancer/pkg/utils"
+	"github.com/harvester/harvester-load-balancer/pkg/utils/fakeclients"
 )
 
 var (
@@ -23,36 +38,25 @@ var (
 	p2pIP               = net.IP{192, 168, 100, 10}
 	p2pMask             = net.IPv4Mask(255, 255, 255, 255)
 
+	ipv6Subnet        = "fd00:100::/120"
+	ipv6SubnetIP      = net.ParseIP("fd00:100::")
+	ipv6SubnetMask    = net.CIDRMask(120, 128)
+	ipv6SubnetIPStart = net.ParseIP("fd00:100::1")
+	ipv6SubnetIPEnd   = net.ParseIP("fd00:100::ff")
+	ipv6P2PIPStr      = "fd00:100::10/128"
+	ipv6P2PIP         = net.ParseIP("fd00:100::10")
+
 	cClassErrorSubnet1 = "192.168.100.0"
 	cClassErrorSubnet2 = "192.168.100.0/100"
 	cClassErrorSubnet3 = "192.168.300.0/24"
 )
 
 func newFakeAllocator(name string, ranges []lbv1.Range) (*Allocator, error) {
-	if len(ranges) == 0 {
-		return nil, fmt.Errorf("range could not be empty")
-	}
-
-	rangeSlice := make([]allocator.Range, 0)
-	var total int64
-
-	for i := range ranges {
-		element, err := MakeRange(&ranges[i])
-		if err != nil {
-			return nil, err
-		}
-
-		rangeSlice = append(rangeSlice, *element)
-		total += countIP(element)
-	}
+	return newFakeAllocatorWithSpec(name, &lbv1.IPPoolSpec{Ranges: ranges})
+}
 
-	rangeSet := allocator.RangeSet(rangeSlice)
-	return &Allocator{
-		name:        name,
-		IPAllocator: allocator.NewIPAllocator(&rangeSet, store.NewFakeStore(name, ranges), 0),
-		checkSum:    CalculateCheckSum(ranges),
-		total:       total,
-	}, nil
+func newFakeAllocatorWithSpec(name string, spec *lbv1.IPPoolSpec) (*Allocator, error) {
+	return newAllocator(name, spec, store.NewFakeStore(name, spec))
 }
 
 func TestAllocator_CheckSubnet(t *testing.T) {
@@ -136,6 +140,18 @@ func TestAllocator_Total(t *testing.T) {
 		t.Fatalf("failed to create allocator %s, error: %s", name, err.Error())
 	}
 
+	name = "a6"
+	a6, err := newFakeAllocator(name, []lbv1.Range{{Subnet: ipv6Subnet}})
+	if err != nil {
+		t.Fatalf("failed to create allocator %s, error: %s", name, err.Error())
+	}
+
+	name = "a7"
+	a7, err := newFakeAllocator(name, []lbv1.Range{{Subnet: cClassSubnet}, {Subnet: ipv6Subnet}})
+	if err != nil {
+		t.Fatalf("failed to create allocator %s, error: %s", name, err.Error())
+	}
+
 	name = "a5"
 	a5, err := newFakeAllocator(name, []lbv1.Range{
 		{
@@ -177,16 +193,27 @@ func TestAllocator_Total(t *testing.T) {
 			allocator: a5,
 			want:      int64(9),
 		},
+		{
+			name:      "ipv6SubnetTotal",
+			allocator: a6,
+			want:      int64(254),
+		},
+		{
+			name:      "dualStackTotal",
+			allocator: a7,
+			want:      int64(507),
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			a := &Allocator{
-				IPAllocator: tt.allocator.IPAllocator,
-				name:        tt.allocator.name,
-				checkSum:    tt.allocator.checkSum,
-				total:       tt.allocator.total,
-				cache:       tt.allocator.cache,
+				ipv4:     tt.allocator.ipv4,
+				ipv6:     tt.allocator.ipv6,
+				name:     tt.allocator.name,
+				checkSum: tt.allocator.checkSum,
+				total:    tt.allocator.total,
+				store:    tt.allocator.store,
 			}
 			if got := a.Total(); got != tt.want {
 				t.Errorf("Allocator.Total() = %v, want %v", got, tt.want)
@@ -275,6 +302,45 @@ func TestMakeRange(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "ipv6IPNet",
+			r: &lbv1.Range{
+				Subnet: ipv6Subnet,
+			},
+			want: &allocator.Range{
+				Subnet: types.IPNet(net.IPNet{
+					IP:   ipv6SubnetIP,
+					Mask: ipv6SubnetMask,
+				}),
+				RangeStart: ipv6SubnetIPStart,
+				RangeEnd:   ipv6SubnetIPEnd,
+				Gateway:    ipv6SubnetIPStart,
+			},
+			wantErr: false,
+		},
+		{
+			name: "ipv6P2PIPNet",
+			r: &lbv1.Range{
+				Subnet: ipv6P2PIPStr,
+			},
+			want: &allocator.Range{
+				Subnet: types.IPNet(net.IPNet{
+					IP:   ipv6P2PIP,
+					Mask: net.CIDRMask(128, 128),
+				}),
+				RangeStart: ipv6P2PIP,
+				RangeEnd:   ipv6P2PIP,
+			},
+			wantErr: false,
+		},
+		{
+			name: "ipv6IPNetWithNetworkIPRangeStart",
+			r: &lbv1.Range{
+				Subnet:     ipv6Subnet,
+				RangeStart: "fd00:100::",
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -289,6 +355,229 @@ func TestMakeRange(t *testing.T) {
 	}
 }
 
+func TestAllocator_Get(t *testing.T) {
+	a, err := newFakeAllocator("dualstack", []lbv1.Range{{Subnet: cClassSubnet}, {Subnet: ipv6Subnet}})
+	if err != nil {
+		t.Fatalf("failed to create allocator, error: %s", err.Error())
+	}
+	single, err := newFakeAllocator("ipv4", []lbv1.Range{{Subnet: cClassSubnet}})
+	if err != nil {
+		t.Fatalf("failed to create allocator, error: %s", err.Error())
+	}
+
+	tests := []struct {
+		name        string
+		allocator   *Allocator
+		id          string
+		family      corev1.IPFamily
+		requestedIP net.IP
+		wantIP      net.IP
+		wantErr     bool
+	}{
+		{
+			name:      "ipv4",
+			allocator: a,
+			id:        "default/lb1",
+			family:    corev1.IPv4Protocol,
+		},
+		{
+			name:      "ipv6",
+			allocator: a,
+			id:        "default/lb1",
+			family:    corev1.IPv6Protocol,
+		},
+		{
+			name:      "ipv6FromIPv4Pool",
+			allocator: single,
+			id:        "default/lb2",
+			family:    corev1.IPv6Protocol,
+			wantErr:   true,
+		},
+		{
+			name:        "requestedIPv4",
+			allocator:   a,
+			id:          "default/lb3",
+			family:      corev1.IPv4Protocol,
+			requestedIP: net.ParseIP("192.168.100.100"),
+			wantIP:      net.ParseIP("192.168.100.100"),
+		},
+		{
+			name:        "requestedIPv6",
+			allocator:   a,
+			id:          "default/lb3",
+			family:      corev1.IPv6Protocol,
+			requestedIP: net.ParseIP("fd00:100::80"),
+			wantIP:      net.ParseIP("fd00:100::80"),
+		},
+		{
+			name:        "requestedIPAllocated",
+			allocator:   a,
+			id:          "default/lb4",
+			family:      corev1.IPv4Protocol,
+			requestedIP: net.ParseIP("192.168.100.100"),
+			wantErr:     true,
+		},
+		{
+			name:        "requestedIPOutOfRange",
+			allocator:   a,
+			id:          "default/lb4",
+			family:      corev1.IPv4Protocol,
+			requestedIP: net.ParseIP("192.168.200.100"),
+			wantErr:     true,
+		},
+		{
+			name:        "requestedIPInOtherFamily",
+			allocator:   a,
+			id:          "default/lb4",
+			family:      corev1.IPv6Protocol,
+			requestedIP: net.ParseIP("192.168.100.101"),
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ipConfig, err := tt.allocator.Get(tt.id, tt.family, tt.requestedIP)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("wantErr %v, returnErr %v", tt.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if got := utils.GetIPFamily(ipConfig.Address.IP); got != tt.family {
+				t.Errorf("got IP %s of family %s, want %s", ipConfig.Address.IP, got, tt.family)
+			}
+			if tt.wantIP != nil && !ipConfig.Address.IP.Equal(tt.wantIP) {
+				t.Errorf("got IP %s, want %s", ipConfig.Address.IP, tt.wantIP)
+			}
+		})
+	}
+
+	// release all the IPs of the dual-stack applicant
+	if err := a.Release("default/lb1", ""); err != nil {
+		t.Fatalf("failed to release, error: %s", err.Error())
+	}
+	if ips := a.store.GetByID("default/lb1", ""); len(ips) != 0 {
+		t.Errorf("IPs %v are not released", ips)
+	}
+}
+
+func TestLBPoolSpecToAllocatorRangeSet(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    *lbv1.IPPoolSpec
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "noExclusion",
+			spec: &lbv1.IPPoolSpec{
+				Ranges: []lbv1.Range{{Subnet: cClassSubnet}},
+			},
+			want: []string{"192.168.100.1-192.168.100.254"},
+		},
+		{
+			name: "excludeIPsAndCIDR",
+			spec: &lbv1.IPPoolSpec{
+				Ranges:  []lbv1.Range{{Subnet: cClassSubnet}},
+				Exclude: []string{"192.168.100.10", "192.168.100.32/30", "192.168.100.254"},
+			},
+			want: []string{"192.168.100.1-192.168.100.9", "192.168.100.11-192.168.100.31", "192.168.100.36-192.168.100.253"},
+		},
+		{
+			name: "excludeOverlappedCIDRs",
+			spec: &lbv1.IPPoolSpec{
+				Ranges:  []lbv1.Range{{Subnet: cClassSubnet}},
+				Exclude: []string{"192.168.100.0/26", "192.168.100.32/27", "192.168.100.128/25"},
+			},
+			want: []string{"192.168.100.64-192.168.100.127"},
+		},
+		{
+			name: "excludeWholeRange",
+			spec: &lbv1.IPPoolSpec{
+				Ranges:  []lbv1.Range{{Subnet: cClassSubnet}, {Subnet: ipv6Subnet}},
+				Exclude: []string{cClassSubnet, "fd00:100::10"},
+			},
+			want: []string{"fd00:100::1-fd00:100::f", "fd00:100::11-fd00:100::ff"},
+		},
+		{
+			name: "invalidExclusion",
+			spec: &lbv1.IPPoolSpec{
+				Ranges:  []lbv1.Range{{Subnet: cClassSubnet}},
+				Exclude: []string{"192.168.100.300"},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs, err := LBPoolSpecToAllocatorRangeSet(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("wantErr %v, returnErr %v", tt.wantErr, err)
+			}
+			got := make([]string, 0, len(rs))
+			for i := range rs {
+				got = append(got, rs[i].String())
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got ranges %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("got ranges %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestAllocator_Reservation(t *testing.T) {
+	a, err := newFakeAllocatorWithSpec("reservation", &lbv1.IPPoolSpec{
+		Ranges: []lbv1.Range{
+			{
+				Subnet:     cClassSubnet,
+				RangeStart: "192.168.100.10",
+				RangeEnd:   "192.168.100.12",
+			},
+		},
+		Exclude: []string{"192.168.100.11"},
+		Reservations: map[string]string{
+			"192.168.100.10": "default/lb1",
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create allocator, error: %s", err.Error())
+	}
+
+	if got := a.Total(); got != 2 {
+		t.Errorf("Allocator.Total() = %v, want 2", got)
+	}
+
+	// the IP reserved for others and the excluded IP are skipped
+	ipConfig, err := a.Get("default/lb2", corev1.IPv4Protocol, nil)
+	if err != nil {
+		t.Fatalf("failed to get IP, error: %s", err.Error())
+	}
+	if want := net.ParseIP("192.168.100.12"); !ipConfig.Address.IP.Equal(want) {
+		t.Errorf("got IP %s, want %s", ipConfig.Address.IP, want)
+	}
+
+	// the reserved IP is allocated to its applicant in priority
+	ipConfig, err = a.Get("default/lb1", corev1.IPv4Protocol, nil)
+	if err != nil {
+		t.Fatalf("failed to get IP, error: %s", err.Error())
+	}
+	if want := net.ParseIP("192.168.100.10"); !ipConfig.Address.IP.Equal(want) {
+		t.Errorf("got IP %s, want %s", ipConfig.Address.IP, want)
+	}
+
+	// no IP is available for others
+	if _, err := a.Get("default/lb3", corev1.IPv4Protocol, nil); !errors.Is(err, ErrPoolExhausted) {
+		t.Errorf("expect ErrPoolExhausted as no IP is available, got %v", err)
+	}
+}
+
 func rangesEqual(r1, r2 *allocator.Range) bool {
 	if r1 == nil || r2 == nil {
 		return r1 == r2
@@ -301,6 +590,68 @@ func rangesEqual(r1, r2 *allocator.Range) bool {
 		r1.Gateway.Equal(r2.Gateway)
 }
 
+func TestAllocator_Quarantine(t *testing.T) {
+	a, err := newFakeAllocatorWithSpec("quarantine", &lbv1.IPPoolSpec{
+		Ranges: []lbv1.Range{
+			{
+				Subnet:     cClassSubnet,
+				RangeStart: "192.168.100.10",
+				RangeEnd:   "192.168.100.11",
+			},
+		},
+		AllocationStrategy: lbv1.LowestFree,
+		QuarantineSeconds:  3600,
+	})
+	if err != nil {
+		t.Fatalf("failed to create allocator, error: %s", err.Error())
+	}
+
+	if _, err := a.Get("default/lb1", corev1.IPv4Protocol, nil); err != nil {
+		t.Fatalf("failed to get IP, error: %s", err.Error())
+	}
+	if err := a.Release("default/lb1", ""); err != nil {
+		t.Fatalf("failed to release IP, error: %s", err.Error())
+	}
+
+	// the quarantined IP is skipped for others
+	ipConfig, err := a.Get("default/lb2", corev1.IPv4Protocol, nil)
+	if err != nil {
+		t.Fatalf("failed to get IP, error: %s", err.Error())
+	}
+	if want := net.ParseIP("192.168.100.11"); !ipConfig.Address.IP.Equal(want) {
+		t.Errorf("got IP %s, want %s", ipConfig.Address.IP, want)
+	}
+	if _, err := a.Get("default/lb3", corev1.IPv4Protocol, net.ParseIP("192.168.100.10")); err == nil {
+		t.Errorf("expect an error as the requested IP is in quarantine")
+	}
+
+	// the original owner reclaims its IP during the quarantine
+	ipConfig, err = a.Get("default/lb1", corev1.IPv4Protocol, nil)
+	if err != nil {
+		t.Fatalf("failed to get IP, error: %s", err.Error())
+	}
+	if want := net.ParseIP("192.168.100.10"); !ipConfig.Address.IP.Equal(want) {
+		t.Errorf("got IP %s, want %s", ipConfig.Address.IP, want)
+	}
+
+	// the IP is free for others after the quarantine passes
+	if err := a.Release("default/lb1", ""); err != nil {
+		t.Fatalf("failed to release IP, error: %s", err.Error())
+	}
+	pool, _ := a.store.GetIPPool()
+	pool.Status.Quarantined["192.168.100.10"] = time.Now().Add(-2 * time.Hour).UTC().Format(time.RFC3339)
+	ipConfig, err = a.Get("default/lb3", corev1.IPv4Protocol, nil)
+	if err != nil {
+		t.Fatalf("failed to get IP, error: %s", err.Error())
+	}
+	if want := net.ParseIP("192.168.100.10"); !ipConfig.Address.IP.Equal(want) {
+		t.Errorf("got IP %s, want %s", ipConfig.Address.IP, want)
+	}
+	if len(pool.Status.Quarantined) != 0 {
+		t.Errorf("expect no quarantined IPs, got %v", pool.Status.Quarantined)
+	}
+}
+
 // Test networkIP
 func TestNetworkIP(t *testing.T) {
 	tests := []struct {
@@ -331,3 +682,544 @@ func TestBroadcastIP(t *testing.T) {
 		t.Errorf("Broadcast address should be %s, but got %s", cClassBroadcast.String(), broadcast.String())
 	}
 }
+
+// statusBackedStore reproduces the store before the in-memory record as the baseline of the benchmark. The allocated
+// IPs are listed from the cache on each call, and the status of the pool is updated with each allocation.
+type statusBackedStore struct {
+	name             string
+	cache            ctllbv1.IPPoolCache
+	client           ctllbv1.IPPoolClient
+	allocationCache  ctllbv1.IPAllocationCache
+	allocationClient ctllbv1.IPAllocationClient
+}
+
+func (s *statusBackedStore) GetIPPool() (*lbv1.IPPool, error) {
+	return s.cache.Get(s.name)
+}
+
+func (s *statusBackedStore) GetAllocated() (map[string]string, error) {
+	pool, err := s.cache.Get(s.name)
+	if err != nil {
+		return nil, err
+	}
+
+	return store.GetAllocated(s.allocationCache, pool)
+}
+
+func (s *statusBackedStore) Lock() error {
+	return nil
+}
+
+func (s *statusBackedStore) Unlock() error {
+	return nil
+}
+
+func (s *statusBackedStore) Close() error {
+	return nil
+}
+
+func (s *statusBackedStore) Reserve(id, _ string, ip net.IP, _ string) (bool, error) {
+	pool, err := s.cache.Get(s.name)
+	if err != nil {
+		return false, err
+	}
+	if owner, ok := store.GetReservation(&pool.Spec, ip); ok && owner != id {
+		return false, nil
+	}
+	if store.IsQuarantined(pool, ip.String(), id, time.Now()) {
+		return false, nil
+	}
+	if allocation, err := s.allocationCache.Get(store.AllocationName(ip)); err == nil {
+		return allocation.Spec.IPPool == s.name && allocation.Spec.Applicant == id, nil
+	}
+	if _, err := s.allocationClient.Create(store.NewAllocation(pool, ip.String(), id)); err != nil {
+		return false, err
+	}
+
+	return true, retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		pool, err := s.client.Get(s.name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		poolCopy := pool.DeepCopy()
+		poolCopy.Status.LastAllocated = ip.String()
+		_, err = s.client.Update(poolCopy)
+		return err
+	})
+}
+
+func (s *statusBackedStore) LastReservedIP(_ string) (net.IP, error) {
+	pool, err := s.cache.Get(s.name)
+	if err != nil {
+		return nil, err
+	}
+
+	return net.ParseIP(pool.Status.LastAllocated), nil
+}
+
+func (s *statusBackedStore) Release(ip net.IP) error {
+	return s.allocationClient.Delete(store.AllocationName(ip), &metav1.DeleteOptions{})
+}
+
+func (s *statusBackedStore) ReleaseByID(id, _ string) error {
+	for _, ip := range s.GetByID(id, "") {
+		if err := s.Release(ip); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *statusBackedStore) GetByID(id, _ string) []net.IP {
+	allocated, _ := s.GetAllocated()
+	var ips []net.IP
+	for ip, applicant := range allocated {
+		if applicant == id {
+			ips = append(ips, net.ParseIP(ip))
+		}
+	}
+
+	return ips
+}
+
+func (s *statusBackedStore) GetUnavailable(_ *lbv1.IPPool, _ string, _ time.Time) map[string]bool {
+	return nil
+}
+
+func (s *statusBackedStore) SetConflictHandler(_ func(ip net.IP)) {}
+
+func (s *statusBackedStore) Probe(_ net.IP) {}
+
+func (s *statusBackedStore) SetQuotaChecker(_ func(string, map[string]string) error) {}
+
+// BenchmarkAllocator_Get measures the allocation rate of a pool backed by IPAllocation objects
+// when the load balancers are created in a burst, against the baseline which lists the allocated IPs from the cache
+// and updates the status of the pool with each allocation
+func BenchmarkAllocator_Get(b *testing.B) {
+	spec := &lbv1.IPPoolSpec{
+		Ranges: []lbv1.Range{{Subnet: "10.0.0.0/16"}},
+	}
+	newStores := map[string]func(clientset *fake.Clientset) (ipPoolStore, error){
+		"in-memory": func(clientset *fake.Clientset) (ipPoolStore, error) {
+			return store.New("benchmark",
+				fakeclients.IPPoolCache(clientset.LoadbalancerV1beta1().IPPools),
+				fakeclients.IPPoolClient(clientset.LoadbalancerV1beta1().IPPools),
+				fakeclients.IPAllocationCache(clientset.LoadbalancerV1beta1().IPAllocations),
+				fakeclients.IPAllocationClient(clientset.LoadbalancerV1beta1().IPAllocations))
+		},
+		"status-backed": func(clientset *fake.Clientset) (ipPoolStore, error) {
+			return &statusBackedStore{
+				name:             "benchmark",
+				cache:            fakeclients.IPPoolCache(clientset.LoadbalancerV1beta1().IPPools),
+				client:           fakeclients.IPPoolClient(clientset.LoadbalancerV1beta1().IPPools),
+				allocationCache:  fakeclients.IPAllocationCache(clientset.LoadbalancerV1beta1().IPAllocations),
+				allocationClient: fakeclients.IPAllocationClient(clientset.LoadbalancerV1beta1().IPAllocations),
+			}, nil
+		},
+	}
+
+	for _, name := range []string{"status-backed", "in-memory"} {
+		b.Run(name, func(b *testing.B) {
+			pool := &lbv1.IPPool{ObjectMeta: metav1.ObjectMeta{Name: "benchmark"}, Spec: *spec}
+			clientset := fake.NewSimpleClientset(pool)
+			s, err := newStores[name](clientset)
+			if err != nil {
+				b.Fatalf("failed to create store, error: %s", err.Error())
+			}
+			a, err := newAllocator(pool.Name, spec, s)
+			if err != nil {
+				b.Fatalf("failed to create allocator, error: %s", err.Error())
+			}
+
+			var count atomic.Int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					id := fmt.Sprintf("default/lb%d", count.Add(1))
+					if _, err := a.Get(id, corev1.IPv4Protocol, nil); err != nil {
+						b.Errorf("failed to allocate IP to %s, error: %s", id, err.Error())
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestAllocator_Quota(t *testing.T) {
+	spec := &lbv1.IPPoolSpec{
+		Ranges: []lbv1.Range{
+			{
+				Subnet:     cClassSubnet,
+				RangeStart: "192.168.100.10",
+				RangeEnd:   "192.168.100.20",
+			},
+		},
+		Quotas: []lbv1.Quota{
+			{Tuple: lbv1.Tuple{Namespace: "default"}, Max: 2},
+			{Tuple: lbv1.Tuple{Namespace: "guaranteed"}, Min: 8},
+		},
+	}
+	pool := &lbv1.IPPool{ObjectMeta: metav1.ObjectMeta{Name: "quota"}, Spec: *spec}
+	clientset := fake.NewSimpleClientset(pool)
+	a, err := NewAllocator(pool.Name, spec,
+		fakeclients.IPPoolCache(clientset.LoadbalancerV1beta1().IPPools),
+		fakeclients.IPPoolClient(clientset.LoadbalancerV1beta1().IPPools),
+		fakeclients.IPAllocationCache(clientset.LoadbalancerV1beta1().IPAllocations),
+		fakeclients.IPAllocationClient(clientset.LoadbalancerV1beta1().IPAllocations))
+	if err != nil {
+		t.Fatalf("failed to create allocator, error: %s", err.Error())
+	}
+	a.SetTenantGetter(func(applicant string) (*Requirement, error) {
+		namespace, _, _ := strings.Cut(applicant, "/")
+		return &Requirement{Namespace: namespace}, nil
+	})
+
+	// the concurrent allocations of the tenant can't exceed the max together
+	var allocated atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := a.Get(fmt.Sprintf("default/lb%d", i), corev1.IPv4Protocol, nil)
+			if err == nil {
+				allocated.Add(1)
+			} else if !errors.Is(err, ErrQuotaExceeded) {
+				t.Errorf("expect ErrQuotaExceeded, got %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if got := allocated.Load(); got != 2 {
+		t.Errorf("got %d allocations, want the max 2", got)
+	}
+
+	// 9 addresses are left, 8 of which are guaranteed to the other tenant
+	if _, err := a.Get("other/lb1", corev1.IPv4Protocol, nil); err != nil {
+		t.Errorf("failed to get the address beyond the guaranteed ones, error: %v", err)
+	}
+	if _, err := a.Get("other/lb2", corev1.IPv4Protocol, nil); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("expect ErrQuotaExceeded for the guaranteed address, got %v", err)
+	}
+	if _, err := a.Get("guaranteed/lb1", corev1.IPv4Protocol, nil); err != nil {
+		t.Errorf("failed to get the guaranteed address, error: %v", err)
+	}
+}
+
+func TestAllocator_PickAfterRelease(t *testing.T) {
+	spec := &lbv1.IPPoolSpec{
+		Ranges: []lbv1.Range{
+			{
+				Subnet:     cClassSubnet,
+				RangeStart: "192.168.100.10",
+				RangeEnd:   "192.168.100.20",
+			},
+		},
+		AllocationStrategy: lbv1.LowestFree,
+		QuarantineSeconds:  3600,
+	}
+	pool := &lbv1.IPPool{ObjectMeta: metav1.ObjectMeta{Name: "lowestfree"}, Spec: *spec}
+	clientset := fake.NewSimpleClientset(pool)
+	// the status writes fail, so the quarantine is only recorded in memory
+	var failing atomic.Bool
+	clientset.PrependReactor("update", "ippools", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return failing.Load(), nil, errors.New("status write fails")
+	})
+	defer failing.Store(false)
+	a, err := NewAllocator(pool.Name, spec,
+		fakeclients.IPPoolCache(clientset.LoadbalancerV1beta1().IPPools),
+		fakeclients.IPPoolClient(clientset.LoadbalancerV1beta1().IPPools),
+		fakeclients.IPAllocationCache(clientset.LoadbalancerV1beta1().IPAllocations),
+		fakeclients.IPAllocationClient(clientset.LoadbalancerV1beta1().IPAllocations))
+	if err != nil {
+		t.Fatalf("failed to create allocator, error: %s", err.Error())
+	}
+
+	ipConfig, err := a.Get("default/lb1", corev1.IPv4Protocol, nil)
+	if err != nil {
+		t.Fatalf("failed to allocate IP, error: %s", err.Error())
+	}
+	failing.Store(true)
+	if err := a.Release("default/lb1", ""); err != nil {
+		t.Fatalf("failed to release IP, error: %s", err.Error())
+	}
+
+	// the lowest IP is quarantined, the next one is picked instead of the same one again and again
+	ipConfig2, err := a.Get("default/lb2", corev1.IPv4Protocol, nil)
+	if err != nil {
+		t.Fatalf("failed to allocate IP right after the release, error: %s", err.Error())
+	}
+	if ipConfig2.Address.IP.Equal(ipConfig.Address.IP) {
+		t.Errorf("the quarantined IP %s is allocated to others", ipConfig.Address.IP)
+	}
+}
+
+func TestAllocator_Rebuild(t *testing.T) {
+	spec := &lbv1.IPPoolSpec{
+		Ranges: []lbv1.Range{
+			{
+				Subnet:     cClassSubnet,
+				RangeStart: "192.168.100.10",
+				RangeEnd:   "192.168.100.20",
+			},
+		},
+		AllocationStrategy: lbv1.LowestFree,
+		QuarantineSeconds:  3600,
+	}
+	pool := &lbv1.IPPool{ObjectMeta: metav1.ObjectMeta{Name: "rebuild"}, Spec: *spec}
+	clientset := fake.NewSimpleClientset(pool)
+	// the status writes fail, so the quarantine is only recorded in memory
+	var failing atomic.Bool
+	clientset.PrependReactor("update", "ippools", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return failing.Load(), nil, errors.New("status write fails")
+	})
+	defer failing.Store(false)
+	a, err := NewAllocator(pool.Name, spec,
+		fakeclients.IPPoolCache(clientset.LoadbalancerV1beta1().IPPools),
+		fakeclients.IPPoolClient(clientset.LoadbalancerV1beta1().IPPools),
+		fakeclients.IPAllocationCache(clientset.LoadbalancerV1beta1().IPAllocations),
+		fakeclients.IPAllocationClient(clientset.LoadbalancerV1beta1().IPAllocations))
+	if err != nil {
+		t.Fatalf("failed to create allocator, error: %s", err.Error())
+	}
+	if _, err := a.Get("default/lb1", corev1.IPv4Protocol, nil); err != nil {
+		t.Fatalf("failed to allocate IP, error: %s", err.Error())
+	}
+	failing.Store(true)
+	if err := a.Release("default/lb1", ""); err != nil {
+		t.Fatalf("failed to release IP, error: %s", err.Error())
+	}
+
+	// the ranges are extended while the quarantine of 192.168.100.10 is not written into the status
+	spec = spec.DeepCopy()
+	spec.Ranges[0].RangeEnd = "192.168.100.30"
+	rebuilt, err := a.Rebuild(spec)
+	if err != nil {
+		t.Fatalf("failed to rebuild allocator, error: %s", err.Error())
+	}
+	if rebuilt.store != a.store || rebuilt.Total() != 21 {
+		t.Fatalf("expect the allocator is rebuilt with %d IPs on the same store", rebuilt.Total())
+	}
+	ipConfig, err := rebuilt.Get("default/lb2", corev1.IPv4Protocol, nil)
+	if err != nil {
+		t.Fatalf("failed to allocate IP, error: %s", err.Error())
+	}
+	if ip := ipConfig.Address.IP.String(); ip != "192.168.100.11" {
+		t.Errorf("got IP %s, want 192.168.100.11 next to the quarantined one", ip)
+	}
+}
+
+func TestAllocator_Cordoned(t *testing.T) {
+	a, err := newFakeAllocatorWithSpec("cordoned", &lbv1.IPPoolSpec{
+		Ranges: []lbv1.Range{
+			{
+				Subnet:     cClassSubnet,
+				RangeStart: "192.168.100.10",
+				RangeEnd:   "192.168.100.20",
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create allocator, error: %s", err.Error())
+	}
+
+	if _, err := a.Get("default/lb1", corev1.IPv4Protocol, nil); err != nil {
+		t.Fatalf("failed to get IP, error: %s", err.Error())
+	}
+	if _, err := a.Get("default/lb3", corev1.IPv4Protocol, nil); err != nil {
+		t.Fatalf("failed to get IP, error: %s", err.Error())
+	}
+	if err := a.Release("default/lb1", ""); err != nil {
+		t.Fatalf("failed to release IP, error: %s", err.Error())
+	}
+	pool, _ := a.store.GetIPPool()
+	pool.Spec.Cordoned = true
+	pool.Spec.Reservations = map[string]string{"192.168.100.18": "default/lb4"}
+
+	// new allocations are refused
+	if _, err := a.Get("default/lb2", corev1.IPv4Protocol, nil); !errors.Is(err, ErrPoolCordoned) {
+		t.Errorf("expect ErrPoolCordoned, got %v", err)
+	}
+	if _, err := a.Get("default/lb2", corev1.IPv4Protocol, net.ParseIP("192.168.100.15")); !errors.Is(err, ErrPoolCordoned) {
+		t.Errorf("expect ErrPoolCordoned for the requested IP, got %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		id          string
+		requestedIP net.IP
+		want        net.IP
+	}{
+		{
+			name: "the applicant reclaims its history entry",
+			id:   "default/lb1",
+			want: net.ParseIP("192.168.100.10"),
+		},
+		{
+			name: "the applicant gets the IP it holds again",
+			id:   "default/lb3",
+			want: net.ParseIP("192.168.100.11"),
+		},
+		{
+			name:        "the applicant requests the IP it holds again",
+			id:          "default/lb3",
+			requestedIP: net.ParseIP("192.168.100.11"),
+			want:        net.ParseIP("192.168.100.11"),
+		},
+		{
+			name: "the applicant takes the IP reserved for it",
+			id:   "default/lb4",
+			want: net.ParseIP("192.168.100.18"),
+		},
+		{
+			name:        "the applicant requests the IP reserved for it",
+			id:          "default/lb4",
+			requestedIP: net.ParseIP("192.168.100.18"),
+			want:        net.ParseIP("192.168.100.18"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ipConfig, err := a.Get(tt.id, corev1.IPv4Protocol, tt.requestedIP)
+			if err != nil {
+				t.Fatalf("failed to get IP, error: %s", err.Error())
+			}
+			if !ipConfig.Address.IP.Equal(tt.want) {
+				t.Errorf("got IP %s, want %s", ipConfig.Address.IP, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllocator_ReAddress(t *testing.T) {
+	for _, strategy := range []lbv1.AllocationStrategy{lbv1.RoundRobin, lbv1.LowestFree} {
+		t.Run(string(strategy), func(t *testing.T) {
+			spec := &lbv1.IPPoolSpec{
+				Ranges: []lbv1.Range{
+					{
+						Subnet:     cClassSubnet,
+						RangeStart: "192.168.100.10",
+						RangeEnd:   "192.168.100.20",
+					},
+				},
+				AllocationStrategy: strategy,
+			}
+			s := store.NewFakeStore("readdress", spec)
+			a, err := newAllocator("readdress", spec, s)
+			if err != nil {
+				t.Fatalf("failed to create allocator, error: %s", err.Error())
+			}
+			if _, err := a.Get("default/lb1", corev1.IPv4Protocol, nil); err != nil {
+				t.Fatalf("failed to get IP, error: %s", err.Error())
+			}
+
+			// the range shrinks to exclude the allocated IP
+			shrunk := spec.DeepCopy()
+			shrunk.Ranges[0].RangeStart = "192.168.100.15"
+			pool, _ := s.GetIPPool()
+			pool.Spec = *shrunk
+			a, err = newAllocator("readdress", shrunk, s)
+			if err != nil {
+				t.Fatalf("failed to create allocator, error: %s", err.Error())
+			}
+
+			ipConfig, err := a.Get("default/lb1", corev1.IPv4Protocol, nil)
+			if err != nil {
+				t.Fatalf("failed to get a new IP, error: %s", err.Error())
+			}
+			if want := net.ParseIP("192.168.100.15"); !ipConfig.Address.IP.Equal(want) {
+				t.Errorf("got IP %s, want %s", ipConfig.Address.IP, want)
+			}
+			if ip := a.GetAllocatedIP("default/lb1", corev1.IPv4Protocol); ip == nil || !ip.Equal(net.ParseIP("192.168.100.15")) {
+				t.Errorf("got allocated IP %s in the ranges, want 192.168.100.15", ip)
+			}
+			// another new IP in the ranges is a duplicate allocation
+			if _, err := a.Get("default/lb1", corev1.IPv4Protocol, nil); err == nil {
+				t.Errorf("expect duplicate allocation error")
+			}
+
+			// only the excluded IP is released
+			if err := a.ReleaseIP("default/lb2", net.ParseIP("192.168.100.10")); err != nil {
+				t.Fatalf("failed to release IP, error: %s", err.Error())
+			}
+			if _, ok := pool.Status.Allocated["192.168.100.10"]; !ok {
+				t.Errorf("IP allocated to others is released")
+			}
+			if err := a.ReleaseIP("default/lb1", net.ParseIP("192.168.100.10")); err != nil {
+				t.Fatalf("failed to release IP, error: %s", err.Error())
+			}
+			want := map[string]string{"192.168.100.15": "default/lb1"}
+			if !reflect.DeepEqual(pool.Status.Allocated, want) {
+				t.Errorf("got allocated %v, want %v", pool.Status.Allocated, want)
+			}
+
+			// the released IP out of the ranges is not reclaimed from the history
+			if err := a.Release("default/lb1", ""); err != nil {
+				t.Fatalf("failed to release IP, error: %s", err.Error())
+			}
+			if ip := a.getHistoryIP(pool, "default/lb1", corev1.IPv4Protocol); ip == nil || !ip.Equal(net.ParseIP("192.168.100.15")) {
+				t.Errorf("got history IP %s, want 192.168.100.15", ip)
+			}
+		})
+	}
+}
+
+// probingStore requires each IP to be probed before it is reserved like the store with the conflict detection
+type probingStore struct {
+	*store.FakeStore
+	inUse  map[string]bool
+	probed map[string]bool
+}
+
+func (s *probingStore) Reserve(applicantID, ifname string, ip net.IP, rangeID string) (bool, error) {
+	if !s.probed[ip.String()] {
+		return false, &store.ProbeRequiredError{IP: ip}
+	}
+	if s.inUse[ip.String()] {
+		return false, nil
+	}
+	return s.FakeStore.Reserve(applicantID, ifname, ip, rangeID)
+}
+
+func (s *probingStore) Probe(ip net.IP) {
+	s.probed[ip.String()] = true
+}
+
+func TestAllocator_Probe(t *testing.T) {
+	spec := &lbv1.IPPoolSpec{
+		Ranges: []lbv1.Range{
+			{
+				Subnet:     cClassSubnet,
+				RangeStart: "192.168.100.10",
+				RangeEnd:   "192.168.100.20",
+			},
+		},
+	}
+	s := &probingStore{
+		FakeStore: store.NewFakeStore("probe", spec),
+		inUse:     map[string]bool{"192.168.100.10": true, "192.168.100.11": true},
+		probed:    make(map[string]bool),
+	}
+	a, err := newAllocator("probe", spec, s)
+	if err != nil {
+		t.Fatalf("failed to create allocator, error: %s", err.Error())
+	}
+
+	// the IPs in use are probed and skipped
+	ipConfig, err := a.Get("default/lb1", corev1.IPv4Protocol, nil)
+	if err != nil {
+		t.Fatalf("failed to get IP, error: %s", err.Error())
+	}
+	if want := net.ParseIP("192.168.100.12"); !ipConfig.Address.IP.Equal(want) {
+		t.Errorf("got IP %s, want %s", ipConfig.Address.IP, want)
+	}
+	if len(s.probed) != 3 {
+		t.Errorf("got probed IPs %v, want 3 of them", s.probed)
+	}
+
+	// the requested IP in use is not allocated
+	if _, err := a.Get("default/lb2", corev1.IPv4Protocol, net.ParseIP("192.168.100.11")); err == nil {
+		t.Errorf("expect the requested IP in use is not allocated")
+	}
+}
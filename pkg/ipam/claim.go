@@ -0,0 +1,46 @@
+package ipam
+
+import (
+	"fmt"
+	"strings"
+
+	ctlcorev1 "github.com/rancher/wrangler/v3/pkg/generated/controllers/core/v1"
+
+	lbv1 "github.com/harvester/harvester-load-balancer/pkg/apis/loadbalancer.harvesterhci.io/v1beta1"
+)
+
+// claimApplicantPrefix distinguishes the applicant IDs of the IP claims from the namespace/name of the load balancers
+const claimApplicantPrefix = "ipclaim:"
+
+// ClaimApplicant returns the applicant ID which the address of the IP claim is booked under
+func ClaimApplicant(namespace, name string) string {
+	return fmt.Sprintf("%s%s/%s", claimApplicantPrefix, namespace, name)
+}
+
+// ParseClaimApplicant returns the namespace and name of the IP claim, ok is false if the applicant isn't a claim
+func ParseClaimApplicant(applicant string) (namespace, name string, ok bool) {
+	key, ok := strings.CutPrefix(applicant, claimApplicantPrefix)
+	if !ok {
+		return "", "", false
+	}
+
+	if namespace, name, ok = strings.Cut(key, "/"); !ok {
+		return "", "", false
+	}
+
+	return namespace, name, true
+}
+
+// NewClaimRequirement returns the requirement of the IP claim to select a pool, the tenant is the namespace of the
+// claim
+func NewClaimRequirement(claim *lbv1.IPClaim, namespaceCache ctlcorev1.NamespaceCache) (*Requirement, error) {
+	r := &Requirement{
+		Network:   claim.Spec.Network,
+		Namespace: claim.Namespace,
+	}
+	if err := r.loadNamespaceLabels(namespaceCache); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
@@ -0,0 +1,33 @@
+package ipam
+
+import "testing"
+
+func TestParseClaimApplicant(t *testing.T) {
+	tests := []struct {
+		applicant     string
+		wantNamespace string
+		wantName      string
+		wantOK        bool
+	}{
+		{
+			applicant:     ClaimApplicant("default", "claim1"),
+			wantNamespace: "default",
+			wantName:      "claim1",
+			wantOK:        true,
+		},
+		{
+			applicant: "default/lb1",
+		},
+		{
+			applicant: "ipclaim:default",
+		},
+	}
+
+	for _, tt := range tests {
+		namespace, name, ok := ParseClaimApplicant(tt.applicant)
+		if namespace != tt.wantNamespace || name != tt.wantName || ok != tt.wantOK {
+			t.Errorf("ParseClaimApplicant(%q) = %q, %q, %v, want %q, %q, %v", tt.applicant, namespace, name, ok,
+				tt.wantNamespace, tt.wantName, tt.wantOK)
+		}
+	}
+}
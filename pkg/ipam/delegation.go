@@ -0,0 +1,76 @@
+package ipam
+
+import (
+	"fmt"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/labels"
+
+	lbv1 "github.com/harvester/harvester-load-balancer/pkg/apis/loadbalancer.harvesterhci.io/v1beta1"
+	ctllbv1 "github.com/harvester/harvester-load-balancer/pkg/generated/controllers/loadbalancer.harvesterhci.io/v1beta1"
+)
+
+// GetChildren returns the pools whose parent is the named pool, sorted by name
+func GetChildren(cache ctllbv1.IPPoolCache, name string) ([]*lbv1.IPPool, error) {
+	pools, err := cache.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	children := make([]*lbv1.IPPool, 0)
+	for _, pool := range pools {
+		if pool.Spec.Parent == name && pool.Name != name {
+			children = append(children, pool)
+		}
+	}
+	sort.Slice(children, func(i, j int) bool {
+		return children[i].Name < children[j].Name
+	})
+
+	return children, nil
+}
+
+// ExcludeDelegated returns the spec which the pool allocates from, the ranges delegated to the child pools are added
+// to the exclusions so that only the child pools allocate them
+func ExcludeDelegated(spec *lbv1.IPPoolSpec, children []*lbv1.IPPool) (*lbv1.IPPoolSpec, error) {
+	if len(children) == 0 {
+		return spec, nil
+	}
+
+	delegated := spec.DeepCopy()
+	for _, child := range children {
+		for i := range child.Spec.Ranges {
+			r, err := MakeRange(&child.Spec.Ranges[i])
+			if err != nil {
+				return nil, fmt.Errorf("invalid range of child pool %s: %w", child.Name, err)
+			}
+			delegated.Exclude = append(delegated.Exclude, fmt.Sprintf("%s-%s", r.RangeStart, r.RangeEnd))
+		}
+	}
+
+	return delegated, nil
+}
+
+// GetDelegatingSpec returns the spec of the pool without the ranges delegated to its child pools
+func GetDelegatingSpec(cache ctllbv1.IPPoolCache, pool *lbv1.IPPool) (*lbv1.IPPoolSpec, error) {
+	children, err := GetChildren(cache, pool.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	return ExcludeDelegated(&pool.Spec, children)
+}
+
+// poolDepth returns how many ancestors the pool has, a broken or cyclic parent chain stops the counting
+func poolDepth(pool *lbv1.IPPool, pools map[string]*lbv1.IPPool) int {
+	depth := 0
+	for parent := pool.Spec.Parent; parent != "" && depth < len(pools); depth++ {
+		p, ok := pools[parent]
+		if !ok {
+			return depth + 1
+		}
+		parent = p.Spec.Parent
+	}
+
+	return depth
+}
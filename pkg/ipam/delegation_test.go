@@ -0,0 +1,133 @@
+package ipam
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	lbv1 "github.com/harvester/harvester-load-balancer/pkg/apis/loadbalancer.harvesterhci.io/v1beta1"
+	"github.com/harvester/harvester-load-balancer/pkg/generated/clientset/versioned/fake"
+	"github.com/harvester/harvester-load-balancer/pkg/utils/fakeclients"
+)
+
+var (
+	parentPool = &lbv1.IPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "parent"},
+		Spec: lbv1.IPPoolSpec{
+			Ranges:  []lbv1.Range{{Subnet: "192.168.0.0/24", RangeStart: "192.168.0.10", RangeEnd: "192.168.0.100"}},
+			Exclude: []string{"192.168.0.50"},
+		},
+	}
+	childPool = &lbv1.IPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "child"},
+		Spec: lbv1.IPPoolSpec{
+			Ranges: []lbv1.Range{{Subnet: "192.168.0.0/24", RangeStart: "192.168.0.20", RangeEnd: "192.168.0.29"}},
+			Parent: "parent",
+		},
+	}
+	grandchildPool = &lbv1.IPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "grandchild"},
+		Spec: lbv1.IPPoolSpec{
+			Ranges: []lbv1.Range{{Subnet: "192.168.0.0/24", RangeStart: "192.168.0.20", RangeEnd: "192.168.0.24"}},
+			Parent: "child",
+		},
+	}
+)
+
+func TestExcludeDelegated(t *testing.T) {
+	clientset := fake.NewSimpleClientset(parentPool, childPool, grandchildPool)
+	cache := fakeclients.IPPoolCache(clientset.LoadbalancerV1beta1().IPPools)
+
+	tests := []struct {
+		name string
+		pool *lbv1.IPPool
+		want string
+	}{
+		{
+			name: "the ranges of the child are excluded from the parent",
+			pool: parentPool,
+			want: "192.168.0.10-192.168.0.19,192.168.0.30-192.168.0.49,192.168.0.51-192.168.0.100",
+		},
+		{
+			name: "the ranges of the grandchild are excluded from the child",
+			pool: childPool,
+			want: "192.168.0.25-192.168.0.29",
+		},
+		{
+			name: "the pool without children is kept",
+			pool: grandchildPool,
+			want: "192.168.0.20-192.168.0.24",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec, err := GetDelegatingSpec(cache, tt.pool)
+			if err != nil {
+				t.Fatalf("GetDelegatingSpec() error = %v", err)
+			}
+			rs, err := LBPoolSpecToAllocatorRangeSet(spec)
+			if err != nil {
+				t.Fatalf("LBPoolSpecToAllocatorRangeSet() error = %v", err)
+			}
+			if rs.String() != tt.want {
+				t.Errorf("range set = %s, want %s", rs.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestParseExclusion_Range(t *testing.T) {
+	tests := []struct {
+		exclusion string
+		wantErr   bool
+	}{
+		{exclusion: "192.168.0.20-192.168.0.29"},
+		{exclusion: "192.168.0.29-192.168.0.20", wantErr: true},
+		{exclusion: "192.168.0.20-fd00::1", wantErr: true},
+		{exclusion: "192.168.0.20-", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.exclusion, func(t *testing.T) {
+			if _, _, err := ParseExclusion(tt.exclusion); (err != nil) != tt.wantErr {
+				t.Errorf("ParseExclusion() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSelector_SelectByIPDelegated(t *testing.T) {
+	pools := make([]runtime.Object, 0, 3)
+	for _, pool := range []*lbv1.IPPool{parentPool, childPool, grandchildPool} {
+		pool = pool.DeepCopy()
+		pool.Spec.Selector.Scope = []lbv1.Tuple{{Namespace: "default"}}
+		pools = append(pools, pool)
+	}
+	clientset := fake.NewSimpleClientset(pools...)
+	selector := NewSelector(fakeclients.IPPoolCache(clientset.LoadbalancerV1beta1().IPPools))
+	r := &Requirement{Namespace: "default"}
+
+	tests := map[string]string{
+		"192.168.0.10": "parent",
+		"192.168.0.27": "child",
+		"192.168.0.22": "grandchild",
+	}
+	for ip, want := range tests {
+		pool, err := selector.SelectByIP(net.ParseIP(ip), r, false)
+		if err != nil {
+			t.Fatalf("SelectByIP() error = %v", err)
+		}
+		if pool == nil || pool.Name != want {
+			t.Errorf("SelectByIP(%s) = %v, want %s", ip, pool, want)
+		}
+	}
+
+	// the IP of the pool scoped to others can't be selected
+	if _, err := selector.SelectByIP(net.ParseIP("192.168.0.10"), &Requirement{Namespace: "other"}, false); !errors.Is(err, ErrOutOfScope) {
+		t.Errorf("SelectByIP() out of the scope returns %v, want ErrOutOfScope", err)
+	}
+}
@@ -0,0 +1,126 @@
+//go:build linux
+
+package detector
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	arpLength       = 28
+	arpOpRequest    = 1
+	arpOpReply      = 2
+	arpHardwareType = 1 // Ethernet
+)
+
+// arpDetector sends an ARP probe of RFC 5227 on the interface in the subnet of the IP, it is in use if an ARP reply
+// comes back before the timeout. ARP only works for IPv4, the IPv6 address is probed by ICMP echo.
+// The packet socket requires the CAP_NET_RAW capability.
+type arpDetector struct {
+	timeout time.Duration
+}
+
+func (d *arpDetector) InUse(ip net.IP) (bool, error) {
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return (&icmpDetector{timeout: d.timeout}).InUse(ip)
+	}
+
+	iface, err := getInterfaceInSubnet(ip4)
+	if err != nil {
+		return false, err
+	}
+
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM, int(htons(unix.ETH_P_ARP)))
+	if err != nil {
+		return false, fmt.Errorf("open packet socket failed, error: %w", err)
+	}
+	defer unix.Close(fd)
+
+	if err := unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: htons(unix.ETH_P_ARP), Ifindex: iface.Index}); err != nil {
+		return false, fmt.Errorf("bind packet socket to %s failed, error: %w", iface.Name, err)
+	}
+	broadcast := &unix.SockaddrLinklayer{Protocol: htons(unix.ETH_P_ARP), Ifindex: iface.Index, Halen: 6}
+	copy(broadcast.Addr[:], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	if err := unix.Sendto(fd, newARPProbe(iface.HardwareAddr, ip4), 0, broadcast); err != nil {
+		return false, fmt.Errorf("send ARP probe of %s on %s failed, error: %w", ip4, iface.Name, err)
+	}
+
+	deadline := time.Now().Add(d.timeout)
+	buf := make([]byte, 128)
+	for {
+		left := time.Until(deadline)
+		if left <= 0 {
+			return false, nil
+		}
+		tv := unix.NsecToTimeval(left.Nanoseconds())
+		if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+			return false, err
+		}
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.EINTR) {
+			continue
+		}
+		if err != nil {
+			return false, fmt.Errorf("receive ARP reply on %s failed, error: %w", iface.Name, err)
+		}
+		if isARPReplyFrom(buf[:n], ip4) {
+			return true, nil
+		}
+	}
+}
+
+// newARPProbe builds an ARP request whose sender IP is all zeros, so that the ARP caches of the other hosts are not
+// polluted by the probe
+func newARPProbe(mac net.HardwareAddr, ip net.IP) []byte {
+	b := make([]byte, arpLength)
+	binary.BigEndian.PutUint16(b[0:2], arpHardwareType)
+	binary.BigEndian.PutUint16(b[2:4], unix.ETH_P_IP)
+	b[4], b[5] = 6, 4
+	binary.BigEndian.PutUint16(b[6:8], arpOpRequest)
+	copy(b[8:14], mac)
+	copy(b[24:28], ip)
+
+	return b
+}
+
+func isARPReplyFrom(b []byte, ip net.IP) bool {
+	return len(b) >= arpLength && binary.BigEndian.Uint16(b[6:8]) == arpOpReply && bytes.Equal(b[14:18], ip)
+}
+
+// getInterfaceInSubnet returns the interface which has an address in the same subnet as the IP
+func getInterfaceInSubnet(ip net.IP) (*net.Interface, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	for i := range ifaces {
+		if ifaces[i].Flags&net.FlagUp == 0 || ifaces[i].Flags&net.FlagLoopback != 0 || len(ifaces[i].HardwareAddr) != 6 {
+			continue
+		}
+		addrs, err := ifaces[i].Addrs()
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.Contains(ip) {
+				return &ifaces[i], nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("no interface is in the subnet of %s", ip)
+}
+
+func htons(v uint16) uint16 {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, v)
+	return binary.NativeEndian.Uint16(b)
+}
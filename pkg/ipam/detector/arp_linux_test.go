@@ -0,0 +1,36 @@
+//go:build linux
+
+package detector
+
+import (
+	"net"
+	"testing"
+)
+
+func TestARPProbe(t *testing.T) {
+	mac, _ := net.ParseMAC("52:54:00:12:34:56")
+	ip := net.ParseIP("192.168.100.10").To4()
+
+	probe := newARPProbe(mac, ip)
+	if len(probe) != arpLength {
+		t.Fatalf("probe length = %d, want %d", len(probe), arpLength)
+	}
+	// the sender IP of the probe is all zeros
+	if !net.IP(probe[14:18]).Equal(net.IPv4zero) || !net.IP(probe[24:28]).Equal(ip) {
+		t.Errorf("unexpected sender IP %v or target IP %v", net.IP(probe[14:18]), net.IP(probe[24:28]))
+	}
+	if isARPReplyFrom(probe, ip) {
+		t.Errorf("expect the request isn't taken as a reply")
+	}
+
+	// the host holding the IP replies with the IP as the sender
+	reply := append([]byte(nil), probe...)
+	reply[7] = arpOpReply
+	copy(reply[14:18], ip)
+	if !isARPReplyFrom(reply, ip) {
+		t.Errorf("expect the reply from %s is recognized", ip)
+	}
+	if isARPReplyFrom(reply, net.ParseIP("192.168.100.11").To4()) || isARPReplyFrom(reply[:20], ip) {
+		t.Errorf("expect the reply from other IPs or the truncated reply is ignored")
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package detector
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// arpDetector is only supported on Linux
+type arpDetector struct {
+	timeout time.Duration
+}
+
+func (d *arpDetector) InUse(_ net.IP) (bool, error) {
+	return false, fmt.Errorf("ARP conflict detection is only supported on Linux")
+}
@@ -0,0 +1,30 @@
+package detector
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	lbv1 "github.com/harvester/harvester-load-balancer/pkg/apis/loadbalancer.harvesterhci.io/v1beta1"
+)
+
+// probeTimeout is how long a probe waits for the reply
+const probeTimeout = time.Second
+
+// Detector checks whether an IP is already in use on the network, e.g. configured statically on some host, before
+// it is allocated
+type Detector interface {
+	InUse(ip net.IP) (bool, error)
+}
+
+// New returns the detector of the conflict detection method
+func New(method lbv1.ConflictDetection) (Detector, error) {
+	switch method {
+	case lbv1.ARP:
+		return &arpDetector{timeout: probeTimeout}, nil
+	case lbv1.ICMP:
+		return &icmpDetector{timeout: probeTimeout}, nil
+	default:
+		return nil, fmt.Errorf("unknown conflict detection %s", method)
+	}
+}
@@ -0,0 +1,34 @@
+package detector
+
+import (
+	"net"
+	"sync"
+)
+
+// Fake is the detector for tests, the IPs in InUseIPs are in use, all the probes fail with Err if it is not nil
+type Fake struct {
+	InUseIPs map[string]bool
+	Err      error
+
+	mutex  sync.Mutex
+	probed []string
+}
+
+func (f *Fake) InUse(ip net.IP) (bool, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.probed = append(f.probed, ip.String())
+	if f.Err != nil {
+		return false, f.Err
+	}
+	return f.InUseIPs[ip.String()], nil
+}
+
+// Probed returns the IPs probed in order
+func (f *Fake) Probed() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return append([]string(nil), f.probed...)
+}
@@ -0,0 +1,80 @@
+package detector
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"os"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	protocolICMP     = 1
+	protocolIPv6ICMP = 58
+)
+
+// icmpDetector sends an ICMP echo request to the IP, it is in use if an echo reply comes back before the timeout.
+// The raw socket requires the CAP_NET_RAW capability.
+type icmpDetector struct {
+	timeout time.Duration
+}
+
+func (d *icmpDetector) InUse(ip net.IP) (bool, error) {
+	network, address, protocol := "ip4:icmp", "0.0.0.0", protocolICMP
+	var requestType, replyType icmp.Type = ipv4.ICMPTypeEcho, ipv4.ICMPTypeEchoReply
+	if ip.To4() == nil {
+		network, address, protocol = "ip6:ipv6-icmp", "::", protocolIPv6ICMP
+		requestType, replyType = ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply
+	}
+
+	conn, err := icmp.ListenPacket(network, address)
+	if err != nil {
+		return false, fmt.Errorf("listen %s failed, error: %w", network, err)
+	}
+	defer conn.Close()
+
+	id, seq := os.Getpid()&0xffff, rand.IntN(0x10000)
+	request := icmp.Message{
+		Type: requestType,
+		Body: &icmp.Echo{ID: id, Seq: seq, Data: []byte("harvester-load-balancer")},
+	}
+	// the kernel computes the checksum of ICMPv6, no pseudo header is required
+	b, err := request.Marshal(nil)
+	if err != nil {
+		return false, err
+	}
+	if err := conn.SetDeadline(time.Now().Add(d.timeout)); err != nil {
+		return false, err
+	}
+	if _, err := conn.WriteTo(b, &net.IPAddr{IP: ip}); err != nil {
+		return false, fmt.Errorf("send echo request to %s failed, error: %w", ip, err)
+	}
+
+	// the raw socket receives all the ICMP messages of the host, only the reply to this request counts
+	buf := make([]byte, 1500)
+	for {
+		n, peer, err := conn.ReadFrom(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return false, nil
+			}
+			return false, err
+		}
+		if addr, ok := peer.(*net.IPAddr); !ok || !addr.IP.Equal(ip) {
+			continue
+		}
+		reply, err := icmp.ParseMessage(protocol, buf[:n])
+		if err != nil || reply.Type != replyType {
+			continue
+		}
+		if echo, ok := reply.Body.(*icmp.Echo); ok && echo.ID == id && echo.Seq == seq {
+			return true, nil
+		}
+	}
+}
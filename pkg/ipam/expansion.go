@@ -0,0 +1,100 @@
+package ipam
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+
+	lbv1 "github.com/harvester/harvester-load-balancer/pkg/apis/loadbalancer.harvesterhci.io/v1beta1"
+)
+
+// ParseExpansion returns the supernet of the expansion policy of the pool and the range whose subnet contains it
+func ParseExpansion(spec *lbv1.IPPoolSpec) (*net.IPNet, *lbv1.Range, error) {
+	e := spec.Expansion
+	_, supernet, err := net.ParseCIDR(e.Supernet)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid supernet %s: %w", e.Supernet, err)
+	}
+	if e.ChunkSize == 0 {
+		return nil, nil, fmt.Errorf("chunk size can't be zero")
+	}
+	if ones, bits := supernet.Mask.Size(); bits-ones < 32 && uint64(e.ChunkSize) > uint64(1)<<(bits-ones) {
+		return nil, nil, fmt.Errorf("chunk size %d is larger than supernet %s", e.ChunkSize, e.Supernet)
+	}
+
+	for i := range spec.Ranges {
+		r, err := MakeRange(&spec.Ranges[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		subnet := net.IPNet(r.Subnet)
+		if subnet.Contains(supernet.IP) && subnet.Contains(broadcastIP(*supernet)) {
+			return supernet, &spec.Ranges[i], nil
+		}
+	}
+
+	return nil, nil, fmt.Errorf("supernet %s is not inside the subnet of any range", e.Supernet)
+}
+
+// NextChunk returns the first range of the chunk size aligned in the supernet which doesn't overlap the ranges of the
+// pool and the other pools, nil if the supernet is exhausted
+func NextChunk(pool *lbv1.IPPool, others []*lbv1.IPPool) (*lbv1.Range, error) {
+	supernet, base, err := ParseExpansion(&pool.Spec)
+	if err != nil {
+		return nil, err
+	}
+	baseRange, err := MakeRange(base)
+	if err != nil {
+		return nil, err
+	}
+
+	// the network and broadcast addresses of the subnet can't be in a range
+	subnet := net.IPNet(baseRange.Subnet)
+	isIPv4 := supernet.IP.To4() != nil
+	occupied := []ipInterval{newIPInterval(networkIP(subnet), networkIP(subnet))}
+	if isIPv4 {
+		occupied = append(occupied, newIPInterval(broadcastIP(subnet), broadcastIP(subnet)))
+	}
+	for _, p := range append([]*lbv1.IPPool{pool}, others...) {
+		rs, err := LBRangesToAllocatorRangeSet(p.Spec.Ranges)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ranges of pool %s: %w", p.Name, err)
+		}
+		for i := range rs {
+			occupied = append(occupied, newIPInterval(rs[i].RangeStart, rs[i].RangeEnd))
+		}
+	}
+
+	size := big.NewInt(int64(pool.Spec.Expansion.ChunkSize))
+	one := big.NewInt(1)
+	superStart, superEnd := ipToInt(networkIP(*supernet)), ipToInt(broadcastIP(*supernet))
+	for cur := superStart; ; {
+		end := big.NewInt(0).Add(cur, size)
+		end.Sub(end, one)
+		if end.Cmp(superEnd) > 0 {
+			return nil, nil
+		}
+
+		// skip to the first aligned chunk after the occupied intervals overlapping the current one
+		var next *big.Int
+		for _, o := range occupied {
+			if o.isIPv4 != isIPv4 || o.end.Cmp(cur) < 0 || o.start.Cmp(end) > 0 {
+				continue
+			}
+			n := big.NewInt(0).Sub(o.end, superStart)
+			n.Div(n, size).Add(n, one).Mul(n, size).Add(n, superStart)
+			if next == nil || n.Cmp(next) > 0 {
+				next = n
+			}
+		}
+		if next == nil {
+			return &lbv1.Range{
+				RangeStart: intToIP(cur, isIPv4).String(),
+				RangeEnd:   intToIP(end, isIPv4).String(),
+				Subnet:     base.Subnet,
+				Gateway:    base.Gateway,
+			}, nil
+		}
+		cur = next
+	}
+}
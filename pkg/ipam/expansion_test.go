@@ -0,0 +1,75 @@
+package ipam
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	lbv1 "github.com/harvester/harvester-load-balancer/pkg/apis/loadbalancer.harvesterhci.io/v1beta1"
+)
+
+func TestNextChunk(t *testing.T) {
+	newPool := func(name string, expansion *lbv1.Expansion, ranges ...lbv1.Range) *lbv1.IPPool {
+		return &lbv1.IPPool{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       lbv1.IPPoolSpec{Ranges: ranges, Expansion: expansion},
+		}
+	}
+	expansion := &lbv1.Expansion{Supernet: "10.0.0.0/24", ChunkSize: 64}
+	base := lbv1.Range{Subnet: "10.0.0.0/16", RangeStart: "10.0.1.1", RangeEnd: "10.0.1.10", Gateway: "10.0.255.254"}
+
+	tests := []struct {
+		name    string
+		pool    *lbv1.IPPool
+		others  []*lbv1.IPPool
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "the network address is skipped",
+			pool: newPool("pool", expansion, base),
+			want: "10.0.0.64-10.0.0.127",
+		},
+		{
+			name: "the appended chunks and the other pools are skipped",
+			pool: newPool("pool", expansion, base, lbv1.Range{Subnet: "10.0.0.0/16", RangeStart: "10.0.0.64", RangeEnd: "10.0.0.127"}),
+			others: []*lbv1.IPPool{
+				newPool("other", nil, lbv1.Range{Subnet: "10.0.0.0/16", RangeStart: "10.0.0.130", RangeEnd: "10.0.0.130"}),
+			},
+			want: "10.0.0.192-10.0.0.255",
+		},
+		{
+			name: "the supernet is exhausted",
+			pool: newPool("pool", expansion, base, lbv1.Range{Subnet: "10.0.0.0/16", RangeStart: "10.0.0.64", RangeEnd: "10.0.0.255"}),
+		},
+		{
+			name:    "the supernet is out of the subnets",
+			pool:    newPool("pool", &lbv1.Expansion{Supernet: "10.1.0.0/24", ChunkSize: 64}, base),
+			wantErr: true,
+		},
+		{
+			name:    "the chunk is larger than the supernet",
+			pool:    newPool("pool", &lbv1.Expansion{Supernet: "10.0.0.0/24", ChunkSize: 512}, base),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NextChunk(tt.pool, tt.others)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NextChunk() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			got := ""
+			if r != nil {
+				got = r.RangeStart + "-" + r.RangeEnd
+				if r.Subnet != base.Subnet || r.Gateway != base.Gateway {
+					t.Errorf("NextChunk() = %+v, want the subnet and gateway of %+v", r, base)
+				}
+			}
+			if got != tt.want {
+				t.Errorf("NextChunk() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package ipam
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	ctlcorev1 "github.com/rancher/wrangler/v3/pkg/generated/controllers/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+
+	lbv1 "github.com/harvester/harvester-load-balancer/pkg/apis/loadbalancer.harvesterhci.io/v1beta1"
+	ctllbv1 "github.com/harvester/harvester-load-balancer/pkg/generated/controllers/loadbalancer.harvesterhci.io/v1beta1"
+)
+
+// ErrQuotaExceeded is returned if the tenant would hold more addresses than its quota of the pool, or the addresses
+// guaranteed to other tenants would be taken
+var ErrQuotaExceeded = errors.New("quota is exceeded")
+
+// TenantGetter returns the tenant of the applicant namespace/name, nil if the applicant no longer exists
+type TenantGetter func(applicant string) (*Requirement, error)
+
+// NewTenantGetter returns a TenantGetter which finds the tenants of the load balancers and the IP claims from the
+// caches, the claims have no tenant if the claim cache is nil
+func NewTenantGetter(cache ctllbv1.LoadBalancerCache, claimCache ctllbv1.IPClaimCache,
+	namespaceCache ctlcorev1.NamespaceCache) TenantGetter {
+	return func(applicant string) (*Requirement, error) {
+		if namespace, name, ok := ParseClaimApplicant(applicant); ok {
+			if claimCache == nil {
+				return nil, nil
+			}
+			claim, err := claimCache.Get(namespace, name)
+			if apierrors.IsNotFound(err) {
+				return nil, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			return NewClaimRequirement(claim, namespaceCache)
+		}
+
+		namespace, name, ok := strings.Cut(applicant, "/")
+		if !ok {
+			return nil, nil
+		}
+		lb, err := cache.Get(namespace, name)
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return NewRequirement(lb, namespaceCache)
+	}
+}
+
+// GetQuotaUsage counts the allocated addresses held by the tenant of each quota of the pool.
+// The addresses of the skipped applicant are not counted, so that the usage before its allocation is returned.
+func GetQuotaUsage(pool *lbv1.IPPool, allocated map[string]string, getTenant TenantGetter, skipped string) ([]int64, error) {
+	used := make([]int64, len(pool.Spec.Quotas))
+	if len(pool.Spec.Quotas) == 0 {
+		return used, nil
+	}
+
+	tenants := make(map[string]*Requirement)
+	for _, applicant := range allocated {
+		if applicant == skipped {
+			continue
+		}
+		r, ok := tenants[applicant]
+		if !ok {
+			var err error
+			if r, err = getTenant(applicant); err != nil {
+				return nil, fmt.Errorf("get tenant of %s failed, error: %w", applicant, err)
+			}
+			tenants[applicant] = r
+		}
+		if r == nil {
+			continue
+		}
+		for i := range pool.Spec.Quotas {
+			if isMatch(&pool.Spec.Quotas[i].Tuple, r, false) {
+				used[i]++
+			}
+		}
+	}
+
+	return used, nil
+}
+
+// CheckQuota checks whether the tenant can take count more addresses from the pool which has available free addresses.
+// The tenant can't exceed the max of any quota it matches, and the free addresses of the pool must be enough for
+// the minimums of the quotas it doesn't match.
+func CheckQuota(pool *lbv1.IPPool, r *Requirement, count int64, used []int64, available int64) error {
+	var guaranteed int64
+	for i := range pool.Spec.Quotas {
+		quota := &pool.Spec.Quotas[i]
+		if isMatch(&quota.Tuple, r, false) {
+			if quota.Max > 0 && used[i]+count > int64(quota.Max) {
+				return fmt.Errorf("%w, %s holds %d of max %d addresses in pool %s", ErrQuotaExceeded,
+					tenantString(&quota.Tuple), used[i], quota.Max, pool.Name)
+			}
+			continue
+		}
+		if left := int64(quota.Min) - used[i]; left > 0 {
+			guaranteed += left
+		}
+	}
+
+	if guaranteed > 0 && available-count < guaranteed {
+		return fmt.Errorf("%w, the %d available addresses in pool %s are guaranteed to other tenants", ErrQuotaExceeded,
+			available, pool.Name)
+	}
+
+	return nil
+}
+
+func tenantString(t *lbv1.Tuple) string {
+	var fields []string
+	if t.Project != "" {
+		fields = append(fields, "project "+t.Project)
+	}
+	if t.Namespace != "" {
+		fields = append(fields, "namespace "+t.Namespace)
+	}
+	if t.GuestCluster != "" {
+		fields = append(fields, "guest cluster "+t.GuestCluster)
+	}
+	if len(fields) == 0 {
+		return "tenant"
+	}
+
+	return strings.Join(fields, " ")
+}
@@ -0,0 +1,128 @@
+package ipam
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	lbv1 "github.com/harvester/harvester-load-balancer/pkg/apis/loadbalancer.harvesterhci.io/v1beta1"
+)
+
+func newQuotaPool(available int64, quotas ...lbv1.Quota) *lbv1.IPPool {
+	return &lbv1.IPPool{
+		Spec:   lbv1.IPPoolSpec{Quotas: quotas},
+		Status: lbv1.IPPoolStatus{Available: available},
+	}
+}
+
+func TestGetQuotaUsage(t *testing.T) {
+	pool := newQuotaPool(10,
+		lbv1.Quota{Tuple: lbv1.Tuple{Namespace: "ns1"}, Max: 2},
+		lbv1.Quota{Tuple: lbv1.Tuple{Project: "p1", Namespace: All, GuestCluster: All}, Max: 3},
+	)
+	tenants := map[string]*Requirement{
+		"ns1/lb1": {Namespace: "ns1"},
+		"ns2/lb2": {Project: "p1", Namespace: "ns2"},
+		"ns3/lb3": {Project: "p1", Namespace: "ns3", Cluster: "c1"},
+	}
+	getTenant := func(applicant string) (*Requirement, error) {
+		return tenants[applicant], nil
+	}
+	allocated := map[string]string{
+		"192.168.100.10": "ns1/lb1",
+		"fd00:100::a":    "ns1/lb1",
+		"192.168.100.11": "ns2/lb2",
+		"192.168.100.12": "ns3/lb3",
+		"192.168.100.13": "ns4/lb4",
+	}
+
+	used, err := GetQuotaUsage(pool, allocated, getTenant, "")
+	if err != nil {
+		t.Fatalf("GetQuotaUsage() error = %v", err)
+	}
+	if want := []int64{2, 2}; !reflect.DeepEqual(used, want) {
+		t.Errorf("GetQuotaUsage() = %v, want %v", used, want)
+	}
+
+	used, err = GetQuotaUsage(pool, allocated, getTenant, "ns1/lb1")
+	if err != nil {
+		t.Fatalf("GetQuotaUsage() error = %v", err)
+	}
+	if want := []int64{0, 2}; !reflect.DeepEqual(used, want) {
+		t.Errorf("GetQuotaUsage() skipping ns1/lb1 = %v, want %v", used, want)
+	}
+}
+
+func TestCheckQuota(t *testing.T) {
+	tests := []struct {
+		name    string
+		pool    *lbv1.IPPool
+		r       *Requirement
+		count   int64
+		used    []int64
+		wantErr bool
+	}{
+		{
+			name: "no quota",
+			pool: newQuotaPool(1),
+			r:    &Requirement{Namespace: "ns1"},
+			used: []int64{},
+		},
+		{
+			name:  "under the max",
+			pool:  newQuotaPool(10, lbv1.Quota{Tuple: lbv1.Tuple{Namespace: "ns1"}, Max: 2}),
+			r:     &Requirement{Namespace: "ns1"},
+			count: 1,
+			used:  []int64{1},
+		},
+		{
+			name:    "over the max",
+			pool:    newQuotaPool(10, lbv1.Quota{Tuple: lbv1.Tuple{Namespace: "ns1"}, Max: 2}),
+			r:       &Requirement{Namespace: "ns1"},
+			count:   2,
+			used:    []int64{1},
+			wantErr: true,
+		},
+		{
+			name:  "the max of other tenants",
+			pool:  newQuotaPool(10, lbv1.Quota{Tuple: lbv1.Tuple{Namespace: "ns1"}, Max: 2}),
+			r:     &Requirement{Namespace: "ns2"},
+			count: 1,
+			used:  []int64{2},
+		},
+		{
+			name:    "the available addresses are guaranteed to other tenants",
+			pool:    newQuotaPool(2, lbv1.Quota{Tuple: lbv1.Tuple{Namespace: "ns1"}, Min: 3}),
+			r:       &Requirement{Namespace: "ns2"},
+			count:   1,
+			used:    []int64{1},
+			wantErr: true,
+		},
+		{
+			name:  "the available addresses are more than the guaranteed ones",
+			pool:  newQuotaPool(3, lbv1.Quota{Tuple: lbv1.Tuple{Namespace: "ns1"}, Min: 3}),
+			r:     &Requirement{Namespace: "ns2"},
+			count: 1,
+			used:  []int64{2},
+		},
+		{
+			name:  "the guaranteed addresses are taken by the tenant itself",
+			pool:  newQuotaPool(1, lbv1.Quota{Tuple: lbv1.Tuple{Namespace: "ns1"}, Min: 3}),
+			r:     &Requirement{Namespace: "ns1"},
+			count: 1,
+			used:  []int64{0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckQuota(tt.pool, tt.r, tt.count, tt.used, tt.pool.Status.Available)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckQuota() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrQuotaExceeded) {
+				t.Errorf("CheckQuota() error = %v, want ErrQuotaExceeded", err)
+			}
+		})
+	}
+}
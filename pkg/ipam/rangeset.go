@@ -1,9 +1,16 @@
 package ipam
 
 import (
+	"fmt"
+	"math/big"
+	"net"
+	"sort"
+	"strings"
+
 	"github.com/containernetworking/plugins/plugins/ipam/host-local/backend/allocator"
 
 	lbv1 "github.com/harvester/harvester-load-balancer/pkg/apis/loadbalancer.harvesterhci.io/v1beta1"
+	"github.com/harvester/harvester-load-balancer/pkg/ipam/store"
 )
 
 func LBRangesToAllocatorRangeSet(ranges []lbv1.Range) (allocator.RangeSet, error) {
@@ -18,3 +25,139 @@ func LBRangesToAllocatorRangeSet(ranges []lbv1.Range) (allocator.RangeSet, error
 
 	return ars, nil
 }
+
+// LBPoolSpecToAllocatorRangeSet converts the ranges of the pool to a range set without the excluded IPs
+func LBPoolSpecToAllocatorRangeSet(spec *lbv1.IPPoolSpec) (allocator.RangeSet, error) {
+	rs, err := LBRangesToAllocatorRangeSet(spec.Ranges)
+	if err != nil {
+		return nil, err
+	}
+	if len(spec.Exclude) == 0 {
+		return rs, nil
+	}
+
+	exclusions := make([]ipInterval, 0, len(spec.Exclude))
+	for _, e := range spec.Exclude {
+		start, end, err := ParseExclusion(e)
+		if err != nil {
+			return nil, err
+		}
+		exclusions = append(exclusions, newIPInterval(start, end))
+	}
+	sort.Slice(exclusions, func(i, j int) bool {
+		return exclusions[i].start.Cmp(exclusions[j].start) < 0
+	})
+
+	result := make(allocator.RangeSet, 0, len(rs))
+	for i := range rs {
+		result = append(result, excludeFromRange(&rs[i], exclusions)...)
+	}
+
+	return result, nil
+}
+
+// ParseExclusion parses an excluded IP, CIDR or IP range in the form of start-end, returns the first and the last IP
+// of it
+func ParseExclusion(exclusion string) (start, end net.IP, err error) {
+	if first, last, ok := strings.Cut(exclusion, "-"); ok {
+		start, end = net.ParseIP(first), net.ParseIP(last)
+		if start == nil || end == nil || (start.To4() == nil) != (end.To4() == nil) ||
+			ipToInt(start).Cmp(ipToInt(end)) > 0 {
+			return nil, nil, fmt.Errorf("invalid exclusion %s, the range should be start-end in the same IP family", exclusion)
+		}
+		return start, end, nil
+	}
+	if strings.Contains(exclusion, "/") {
+		_, ipNet, err := net.ParseCIDR(exclusion)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid exclusion %s: %w", exclusion, err)
+		}
+		return networkIP(*ipNet), broadcastIP(*ipNet), nil
+	}
+
+	ip := net.ParseIP(exclusion)
+	if ip == nil {
+		return nil, nil, fmt.Errorf("invalid exclusion %s, it should be an IP, a CIDR or an IP range", exclusion)
+	}
+	return ip, ip, nil
+}
+
+// ipInterval is an inclusive interval of IPs in the same IP family
+type ipInterval struct {
+	start, end *big.Int
+	isIPv4     bool
+}
+
+func newIPInterval(start, end net.IP) ipInterval {
+	return ipInterval{
+		start:  ipToInt(start),
+		end:    ipToInt(end),
+		isIPv4: start.To4() != nil,
+	}
+}
+
+// excludeFromRange splits the range into the sub ranges which don't contain any excluded IP
+// the exclusions must be sorted by the start IP
+func excludeFromRange(r *allocator.Range, exclusions []ipInterval) []allocator.Range {
+	isIPv4 := r.RangeStart.To4() != nil
+	cur, end := ipToInt(r.RangeStart), ipToInt(r.RangeEnd)
+	one := big.NewInt(1)
+
+	ranges := make([]allocator.Range, 0, 1)
+	for _, e := range exclusions {
+		if e.isIPv4 != isIPv4 || e.end.Cmp(cur) < 0 {
+			continue
+		}
+		if e.start.Cmp(end) > 0 {
+			break
+		}
+		if e.start.Cmp(cur) > 0 {
+			ranges = append(ranges, subRange(r, cur, big.NewInt(0).Sub(e.start, one), isIPv4))
+		}
+		cur = big.NewInt(0).Add(e.end, one)
+		if cur.Cmp(end) > 0 {
+			return ranges
+		}
+	}
+	ranges = append(ranges, subRange(r, cur, end, isIPv4))
+
+	return ranges
+}
+
+func subRange(r *allocator.Range, start, end *big.Int, isIPv4 bool) allocator.Range {
+	return allocator.Range{
+		RangeStart: intToIP(start, isIPv4),
+		RangeEnd:   intToIP(end, isIPv4),
+		Subnet:     r.Subnet,
+		Gateway:    r.Gateway,
+	}
+}
+
+// intToIP returns the IP with 16 bytes representation as same as what the function net.ParseIP returns
+func intToIP(i *big.Int, isIPv4 bool) net.IP {
+	if isIPv4 {
+		return net.IP(i.FillBytes(make([]byte, net.IPv4len))).To16()
+	}
+	return net.IP(i.FillBytes(make([]byte, net.IPv6len)))
+}
+
+// CheckIPInPool returns an error if the IP can't be allocated to the applicant from the pool
+func CheckIPInPool(ip net.IP, spec *lbv1.IPPoolSpec, applicant string) error {
+	rs, err := LBPoolSpecToAllocatorRangeSet(spec)
+	if err != nil {
+		return err
+	}
+
+	r, err := rs.RangeFor(ip)
+	if err != nil {
+		return err
+	}
+	if ip.Equal(r.Gateway) {
+		return fmt.Errorf("IP %s is the gateway of range %s", ip, r.String())
+	}
+	if owner, ok := store.GetReservation(spec, ip); ok && owner != applicant {
+		return fmt.Errorf("IP %s is reserved for %s", ip, owner)
+	}
+
+	return nil
+}
@@ -1,8 +1,14 @@
 package ipam
 
 import (
+	"errors"
 	"fmt"
+	"net"
+	"sort"
 
+	ctlcorev1 "github.com/rancher/wrangler/v3/pkg/generated/controllers/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 
 	lbv1 "github.com/harvester/harvester-load-balancer/pkg/apis/loadbalancer.harvesterhci.io/v1beta1"
@@ -13,6 +19,9 @@ import (
 const All = "*"
 const EmptySelector = ""
 
+// ErrOutOfScope is returned by SelectByIP if the pool containing the IP doesn't match the requirement
+var ErrOutOfScope = errors.New("pool is out of scope")
+
 type Selector struct {
 	ctllbv1.IPPoolCache
 }
@@ -27,6 +36,48 @@ type Requirement struct {
 	Project   string
 	Namespace string
 	Cluster   string
+	// NamespaceLabels are the labels of the namespace matched by the namespace selectors of the scopes
+	NamespaceLabels map[string]string
+}
+
+// NewRequirement returns the requirement of the load balancer to select a pool, which also tells its tenant.
+// The labels of the namespace are read from the cache if it is not nil. The namespace of a Cluster type load balancer
+// is in the guest cluster, the Harvester namespace of the same name is unrelated, so it has no labels.
+func NewRequirement(lb *lbv1.LoadBalancer, namespaceCache ctlcorev1.NamespaceCache) (*Requirement, error) {
+	r := &Requirement{
+		Network:   lb.Annotations[utils.AnnotationKeyNetwork],
+		Project:   lb.Annotations[utils.AnnotationKeyProject],
+		Namespace: lb.Annotations[utils.AnnotationKeyNamespace],
+		Cluster:   lb.Annotations[utils.AnnotationKeyCluster],
+	}
+	if r.Namespace == "" {
+		r.Namespace = lb.Namespace
+	}
+	if lb.Spec.WorkloadType == lbv1.Cluster {
+		return r, nil
+	}
+	if err := r.loadNamespaceLabels(namespaceCache); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// loadNamespaceLabels reads the labels of the namespace from the cache if it is not nil
+func (r *Requirement) loadNamespaceLabels(namespaceCache ctlcorev1.NamespaceCache) error {
+	if namespaceCache == nil {
+		return nil
+	}
+
+	ns, err := namespaceCache.Get(r.Namespace)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("get namespace %s failed, error: %w", r.Namespace, err)
+	}
+	if err == nil {
+		r.NamespaceLabels = ns.Labels
+	}
+
+	return nil
 }
 
 func NewSelector(cache ctllbv1.IPPoolCache) *Selector {
@@ -63,11 +114,64 @@ func (m *Matcher) Matches(r *Requirement) bool {
 	return false
 }
 
+// SelectByIP returns the pool whose ranges contain the IP, nil if there is no such pool.
+// The ranges of a child pool are inside the ranges of its parent, the deepest pool is returned as the IP is delegated
+// to it. The pool must be the global pool or match the requirement, so that the IP of a pool scoped to others can't
+// be taken. In loose mode, the pool is also matched loosely as same as it is selected automatically.
+func (s *Selector) SelectByIP(ip net.IP, r *Requirement, looseMode bool) (*lbv1.IPPool, error) {
+	pools, err := s.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	byName := make(map[string]*lbv1.IPPool, len(pools))
+	for _, pool := range pools {
+		byName[pool.Name] = pool
+	}
+
+	var selected *lbv1.IPPool
+	selectedDepth := -1
+	for _, pool := range pools {
+		rs, err := LBRangesToAllocatorRangeSet(pool.Spec.Ranges)
+		if err != nil {
+			return nil, err
+		}
+		if !rs.Contains(ip) {
+			continue
+		}
+		if depth := poolDepth(pool, byName); depth > selectedDepth {
+			selected, selectedDepth = pool, depth
+		}
+	}
+	if selected == nil || isGlobalPool(selected) || NewMatcher(selected.Spec.Selector).Matches(r) ||
+		(looseMode && NewMatcherWithMode(selected.Spec.Selector, true).Matches(r)) {
+		return selected, nil
+	}
+
+	return nil, fmt.Errorf("%w, IP %s is in pool %s", ErrOutOfScope, ip, selected.Name)
+}
+
+func isGlobalPool(pool *lbv1.IPPool) bool {
+	return pool.Labels != nil && pool.Labels[utils.KeyGlobalIPPool] == utils.ValueTrue
+}
+
 // Select returns the pool that matches the requirement.
 // Priority:
 // 1. the pool that matches the requirement and has the highest priority
 // 2. the global pool
 func (s *Selector) Select(r *Requirement, looseMode bool) (*lbv1.IPPool, error) {
+	pools, err := s.SelectAll(r, looseMode)
+	if err != nil || len(pools) == 0 {
+		return nil, err
+	}
+
+	return pools[0], nil
+}
+
+// SelectAll returns all the pools that match the requirement in priority order, followed by the global pool.
+// The cordoned pools are skipped.
+// The first one is the pool returned by Select.
+func (s *Selector) SelectAll(r *Requirement, looseMode bool) ([]*lbv1.IPPool, error) {
 	if r == nil {
 		return nil, fmt.Errorf("the requirement to select a pool can't be empty")
 	}
@@ -76,47 +180,94 @@ func (s *Selector) Select(r *Requirement, looseMode bool) (*lbv1.IPPool, error)
 		return nil, err
 	}
 
-	var selectedPool, globalPool *lbv1.IPPool
-	var priority uint32
-	for _, pool := range pools {
-		if pool.Labels != nil && pool.Labels[utils.KeyGlobalIPPool] == utils.ValueTrue {
+	var selectedPools []*lbv1.IPPool
+	var globalPool *lbv1.IPPool
+	// the pools are iterated in reverse order, so the last listed one of the pools with the same priority goes first
+	for i := len(pools) - 1; i >= 0; i-- {
+		pool := pools[i]
+		// the cordoned pools refuse new allocations
+		if pool.Spec.Cordoned {
+			continue
+		}
+		if isGlobalPool(pool) {
 			globalPool = pool
 			continue
 		}
-		// If the priority is not zero, every pool has different priority value.
-		if NewMatcherWithMode(pool.Spec.Selector, looseMode).Matches(r) && pool.Spec.Selector.Priority >= priority {
-			selectedPool = pool
-			priority = pool.Spec.Selector.Priority
+		if NewMatcherWithMode(pool.Spec.Selector, looseMode).Matches(r) {
+			selectedPools = append(selectedPools, pool)
 		}
 	}
+	// If the priority is not zero, every pool has different priority value.
+	sort.SliceStable(selectedPools, func(i, j int) bool {
+		return selectedPools[i].Spec.Selector.Priority > selectedPools[j].Spec.Selector.Priority
+	})
 	// If there is no pool matches the requirement, we will use the global pool if existing.
-	if selectedPool != nil {
-		return selectedPool, nil
+	if globalPool != nil {
+		selectedPools = append(selectedPools, globalPool)
 	}
-	return globalPool, nil
+
+	return selectedPools, nil
 }
 
 func isMatch(t *lbv1.Tuple, r *Requirement, looseMode bool) bool {
 	// by default, the strict match is used
 	if !looseMode {
 		// if the value of requirement is *, we think it matches any value.
-		if (t.Project == All || r.Project == All || t.Project == r.Project) &&
-			(t.Namespace == All || r.Namespace == All || t.Namespace == r.Namespace) &&
-			(t.GuestCluster == All || r.Cluster == All || t.GuestCluster == r.Cluster) {
-			return true
-		}
-
-		return false
+		return matchPattern(t.Project, r.Project) && matchNamespace(t, r) && matchPattern(t.GuestCluster, r.Cluster)
 	}
 
 	// is loose mode, pool scope `Project` and `GuestCluster` are processed specially
 	// the EmptySelector ("") means matching all
 	// as they are not exposed on UI for user to set
-	if (t.Project == All || r.Project == All || t.Project == r.Project || t.Project == EmptySelector) &&
-		(t.Namespace == All || r.Namespace == All || t.Namespace == r.Namespace) &&
-		(t.GuestCluster == All || r.Cluster == All || t.GuestCluster == r.Cluster || t.GuestCluster == EmptySelector) {
+	return (t.Project == EmptySelector || matchPattern(t.Project, r.Project)) && matchNamespace(t, r) &&
+		(t.GuestCluster == EmptySelector || matchPattern(t.GuestCluster, r.Cluster))
+}
+
+// matchPattern returns true if the value matches the glob pattern, or the value is *
+func matchPattern(pattern, value string) bool {
+	return value == All || GlobOverlap(pattern, value)
+}
+
+// matchNamespace matches the namespace of the requirement with the namespace selector of the tuple if set,
+// otherwise with the namespace pattern
+func matchNamespace(t *lbv1.Tuple, r *Requirement) bool {
+	if t.NamespaceSelector == nil {
+		return matchPattern(t.Namespace, r.Namespace)
+	}
+	if r.Namespace == All {
 		return true
 	}
+	selector, err := metav1.LabelSelectorAsSelector(t.NamespaceSelector)
+	if err != nil {
+		return false
+	}
 
-	return false
+	return selector.Matches(labels.Set(r.NamespaceLabels))
+}
+
+// GlobOverlap returns true if there is a value matched by both glob patterns, where * matches any sequence of
+// characters and ? matches any single character. A value without wildcards is a pattern matching only itself.
+func GlobOverlap(a, b string) bool {
+	// overlap[i][j] tells whether a[i:] and b[j:] match a common value, it is filled from the tails
+	overlap := make([][]bool, len(a)+1)
+	for i := range overlap {
+		overlap[i] = make([]bool, len(b)+1)
+	}
+	for i := len(a); i >= 0; i-- {
+		for j := len(b); j >= 0; j-- {
+			switch {
+			case i == len(a) && j == len(b):
+				overlap[i][j] = true
+			case i < len(a) && a[i] == '*':
+				// the * matches nothing, or the next character of b is matched by it
+				overlap[i][j] = overlap[i+1][j] || (j < len(b) && overlap[i][j+1])
+			case j < len(b) && b[j] == '*':
+				overlap[i][j] = overlap[i][j+1] || (i < len(a) && overlap[i+1][j])
+			case i < len(a) && j < len(b):
+				overlap[i][j] = (a[i] == '?' || b[j] == '?' || a[i] == b[j]) && overlap[i+1][j+1]
+			}
+		}
+	}
+
+	return overlap[0][0]
 }
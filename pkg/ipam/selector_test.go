@@ -3,11 +3,14 @@ package ipam
 import (
 	"testing"
 
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
 
 	lbv1 "github.com/harvester/harvester-load-balancer/pkg/apis/loadbalancer.harvesterhci.io/v1beta1"
 
 	"github.com/harvester/harvester-load-balancer/pkg/generated/clientset/versioned/fake"
+	"github.com/harvester/harvester-load-balancer/pkg/utils"
 	"github.com/harvester/harvester-load-balancer/pkg/utils/fakeclients"
 )
 
@@ -330,3 +333,180 @@ func TestSelectorGuestClusterCaseLooseMatchWithoutGlobalPool_Select(t *testing.T
 	lbClientset := fake.NewSimpleClientset(vlan100WithScopePool, vlan100NoScopePool)
 	testFunc(t, lbClientset, testcases)
 }
+
+func TestSelector_SelectAll(t *testing.T) {
+	lbClientset := fake.NewSimpleClientset(defaultPool, defaultPriorityPool, globalPool)
+	selector := NewSelector(fakeclients.IPPoolCache(lbClientset.LoadbalancerV1beta1().IPPools))
+
+	pools, err := selector.SelectAll(&Requirement{
+		Network:   vlan10Network,
+		Namespace: defaultNamespace,
+	}, false)
+	if err != nil {
+		t.Fatalf("SelectAll() error = %v", err)
+	}
+
+	// the matching pools in priority order followed by the global pool
+	want := []string{defaultPriorityPoolName, defaultPoolName, globalPoolName}
+	if len(pools) != len(want) {
+		t.Fatalf("SelectAll() got %d pools, want %v", len(pools), want)
+	}
+	for i := range want {
+		if pools[i].Name != want[i] {
+			t.Errorf("SelectAll()[%d] = %s, want %s", i, pools[i].Name, want[i])
+		}
+	}
+}
+
+func TestSelector_SkipCordoned(t *testing.T) {
+	cordonedPool := defaultPriorityPool.DeepCopy()
+	cordonedPool.Spec.Cordoned = true
+	lbClientset := fake.NewSimpleClientset(defaultPool, cordonedPool)
+	selector := NewSelector(fakeclients.IPPoolCache(lbClientset.LoadbalancerV1beta1().IPPools))
+
+	pool, err := selector.Select(&Requirement{
+		Network:   vlan10Network,
+		Namespace: defaultNamespace,
+	}, false)
+	if err != nil {
+		t.Fatalf("Select() error = %v", err)
+	}
+	if pool == nil || pool.Name != defaultPoolName {
+		t.Errorf("Select() = %v, want %s", pool, defaultPoolName)
+	}
+}
+
+func TestGlobOverlap(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"", "", true},
+		{"ns1", "ns1", true},
+		{"ns1", "ns2", false},
+		{"*", "", true},
+		{"*", "ns1", true},
+		{"prod-*", "prod-a", true},
+		{"prod-*", "dev-a", false},
+		{"prod-*", "dev-*", false},
+		{"prod-*", "*-db", true},
+		{"ns?", "ns12", false},
+		{"ns?", "ns1", true},
+		{"c-1/*", "c-1/p-1", true},
+		{"*a*", "*b*", true},
+		{"a*b", "a", false},
+	}
+
+	for _, tt := range tests {
+		if got := GlobOverlap(tt.a, tt.b); got != tt.want {
+			t.Errorf("GlobOverlap(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+		if got := GlobOverlap(tt.b, tt.a); got != tt.want {
+			t.Errorf("GlobOverlap(%q, %q) = %v, want %v", tt.b, tt.a, got, tt.want)
+		}
+	}
+}
+
+func TestMatcher_PatternAndNamespaceSelector(t *testing.T) {
+	tests := []struct {
+		name  string
+		tuple lbv1.Tuple
+		r     *Requirement
+		want  bool
+	}{
+		{
+			name:  "namespace pattern",
+			tuple: lbv1.Tuple{Namespace: "team-*"},
+			r:     &Requirement{Namespace: "team-a"},
+			want:  true,
+		},
+		{
+			name:  "namespace pattern mismatch",
+			tuple: lbv1.Tuple{Namespace: "team-*"},
+			r:     &Requirement{Namespace: "default"},
+		},
+		{
+			name: "namespace selector",
+			tuple: lbv1.Tuple{NamespaceSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{"tier": "prod"},
+			}},
+			r:    &Requirement{Namespace: "ns1", NamespaceLabels: map[string]string{"tier": "prod"}},
+			want: true,
+		},
+		{
+			name: "namespace selector mismatch",
+			tuple: lbv1.Tuple{NamespaceSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{"tier": "prod"},
+			}},
+			r: &Requirement{Namespace: "ns1", NamespaceLabels: map[string]string{"tier": "dev"}},
+		},
+		{
+			name: "namespace selector ignores the namespace",
+			tuple: lbv1.Tuple{Namespace: "ns2", NamespaceSelector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "tier", Operator: metav1.LabelSelectorOpExists},
+				},
+			}},
+			r:    &Requirement{Namespace: "ns1", NamespaceLabels: map[string]string{"tier": "dev"}},
+			want: true,
+		},
+		{
+			name:  "project pattern",
+			tuple: lbv1.Tuple{Project: "c-1/*", Namespace: All},
+			r:     &Requirement{Project: "c-1/p-1", Namespace: "ns1"},
+			want:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMatcher(lbv1.Selector{Scope: []lbv1.Tuple{tt.tuple}})
+			if got := m.Matches(tt.r); got != tt.want {
+				t.Errorf("Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewRequirement_NamespaceLabels(t *testing.T) {
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default", Labels: map[string]string{"tier": "prod"}}}
+	namespaceCache := fakeclients.NamespaceCache(k8sfake.NewSimpleClientset(namespace).CoreV1().Namespaces)
+	m := NewMatcher(lbv1.Selector{Scope: []lbv1.Tuple{{NamespaceSelector: &metav1.LabelSelector{
+		MatchLabels: map[string]string{"tier": "prod"},
+	}}}})
+
+	tests := []struct {
+		name      string
+		lb        *lbv1.LoadBalancer
+		wantMatch bool
+	}{
+		{
+			name:      "the labels of the namespace of a VM type lb",
+			lb:        &lbv1.LoadBalancer{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "lb1"}},
+			wantMatch: true,
+		},
+		{
+			name: "the guest cluster namespace has no labels even if a Harvester namespace has the same name",
+			lb: &lbv1.LoadBalancer{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace:   "guest",
+					Name:        "lb1",
+					Annotations: map[string]string{utils.AnnotationKeyNamespace: "default", utils.AnnotationKeyCluster: "rke2"},
+				},
+				Spec: lbv1.LoadBalancerSpec{WorkloadType: lbv1.Cluster},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewRequirement(tt.lb, namespaceCache)
+			if err != nil {
+				t.Fatalf("NewRequirement() error = %v", err)
+			}
+			if got := m.Matches(r); got != tt.wantMatch {
+				t.Errorf("Matches() = %v, want %v, the namespace labels are %v", got, tt.wantMatch, r.NamespaceLabels)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package store
+
+import (
+	"time"
+
+	lbv1 "github.com/harvester/harvester-load-balancer/pkg/apis/loadbalancer.harvesterhci.io/v1beta1"
+)
+
+// ConflictRecheckPeriod is how long an IP found in use on the network is skipped before it is probed again
+const ConflictRecheckPeriod = 10 * time.Minute
+
+// ProbePeriod is how long an IP found free on the network is reserved without being probed again
+const ProbePeriod = 30 * time.Second
+
+// IsConflicted returns true if the IP was found in use on the network by the conflict detection of the pool within
+// the recheck period
+func IsConflicted(pool *lbv1.IPPool, ip string, now time.Time) bool {
+	foundTime, ok := pool.Status.Conflicted[ip]
+	if !ok || pool.Spec.ConflictDetection == "" {
+		return false
+	}
+
+	return !isConflictExpired(foundTime, now)
+}
+
+func isConflictExpired(foundTime string, now time.Time) bool {
+	t, err := time.Parse(time.RFC3339, foundTime)
+	if err != nil {
+		return true
+	}
+
+	return !now.Before(t.Add(ConflictRecheckPeriod))
+}
+
+// pruneConflicted removes the conflicted IPs beyond the recheck period, all of them are removed if the conflict
+// detection is disabled
+func pruneConflicted(pool *lbv1.IPPool, now time.Time) {
+	for ip, foundTime := range pool.Status.Conflicted {
+		if pool.Spec.ConflictDetection == "" || isConflictExpired(foundTime, now) {
+			delete(pool.Status.Conflicted, ip)
+		}
+	}
+	if len(pool.Status.Conflicted) == 0 {
+		pool.Status.Conflicted = nil
+	}
+}
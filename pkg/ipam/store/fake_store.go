@@ -2,6 +2,7 @@ package store
 
 import (
 	"net"
+	"time"
 
 	"github.com/containernetworking/plugins/plugins/ipam/host-local/backend"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -11,19 +12,43 @@ import (
 
 // FakeStore for testdata
 type FakeStore struct {
-	pool *lbv1.IPPool
+	pool         *lbv1.IPPool
+	quotaChecker func(applicantID string, allocated map[string]string) error
 }
 
 // Store implements the Store interface
 var _ backend.Store = &Store{}
 
-func NewFakeStore(name string, ranges []lbv1.Range) *FakeStore {
+func NewFakeStore(name string, spec *lbv1.IPPoolSpec) *FakeStore {
 	return &FakeStore{pool: &lbv1.IPPool{
 		ObjectMeta: v1.ObjectMeta{Name: name},
-		Spec:       lbv1.IPPoolSpec{Ranges: ranges},
+		Spec:       *spec,
 	}}
 }
 
+func (f *FakeStore) GetIPPool() (*lbv1.IPPool, error) {
+	return f.pool, nil
+}
+
+// GetAllocated returns the allocated IPs, the fake store records them in the status of the pool
+func (f *FakeStore) GetAllocated() (map[string]string, error) {
+	return f.pool.Status.Allocated, nil
+}
+
+// GetUnavailable only checks the status, the fake store doesn't record quarantines or conflicts in memory
+func (f *FakeStore) GetUnavailable(pool *lbv1.IPPool, applicantID string, now time.Time) map[string]bool {
+	return getUnavailable(pool, applicantID, now)
+}
+
+func (f *FakeStore) SetConflictHandler(_ func(ip net.IP)) {}
+
+// Probe does nothing, the fake store doesn't detect conflicts
+func (f *FakeStore) Probe(_ net.IP) {}
+
+func (f *FakeStore) SetQuotaChecker(checker func(applicantID string, allocated map[string]string) error) {
+	f.quotaChecker = checker
+}
+
 func (f *FakeStore) Lock() error {
 	return nil
 }
@@ -39,15 +64,34 @@ func (f *FakeStore) Close() error {
 func (f *FakeStore) Reserve(applicantID, _ string, ip net.IP, _ string) (bool, error) {
 	ipStr := ip.String()
 
+	if owner, ok := GetReservation(&f.pool.Spec, ip); ok && owner != applicantID {
+		return false, nil
+	}
+
+	now := time.Now()
+	if IsQuarantined(f.pool, ipStr, applicantID, now) {
+		return false, nil
+	}
+
+	// the IP allocated to the applicant is reserved again as same as the store
 	if f.pool.Status.Allocated != nil {
-		if _, ok := f.pool.Status.Allocated[ipStr]; ok {
-			return false, nil
+		if owner, ok := f.pool.Status.Allocated[ipStr]; ok {
+			return owner == applicantID, nil
+		}
+	}
+
+	if f.quotaChecker != nil {
+		if err := f.quotaChecker(applicantID, f.pool.Status.Allocated); err != nil {
+			return false, err
 		}
 	}
 
 	if f.pool.Status.AllocatedHistory != nil {
 		delete(f.pool.Status.AllocatedHistory, ipStr)
 	}
+	delete(f.pool.Status.AllocatedHistoryTimestamps, ipStr)
+	delete(f.pool.Status.Quarantined, ipStr)
+	pruneQuarantined(f.pool, now)
 	if f.pool.Status.Allocated == nil {
 		f.pool.Status.Allocated = make(map[string]string)
 	}
@@ -70,12 +114,11 @@ func (f *FakeStore) Release(ip net.IP) error {
 
 	ipStr := ip.String()
 	if _, ok := f.pool.Status.Allocated[ipStr]; ok {
-		if f.pool.Status.AllocatedHistory == nil {
-			f.pool.Status.AllocatedHistory = make(map[string]string)
-		}
-		f.pool.Status.AllocatedHistory[ipStr] = f.pool.Status.Allocated[ipStr]
+		now := time.Now()
+		recordHistory(f.pool, ipStr, f.pool.Status.Allocated[ipStr], now)
 		delete(f.pool.Status.Allocated, ipStr)
 		f.pool.Status.Available++
+		quarantine(f.pool, ipStr, now)
 	}
 
 	return nil
@@ -86,15 +129,13 @@ func (f *FakeStore) ReleaseByID(applicantID, _ string) error {
 		return nil
 	}
 
+	now := time.Now()
 	for ip, applicant := range f.pool.Status.Allocated {
 		if applicant == applicantID {
-			if f.pool.Status.AllocatedHistory == nil {
-				f.pool.Status.AllocatedHistory = make(map[string]string)
-			}
-			f.pool.Status.AllocatedHistory[ip] = applicant
+			recordHistory(f.pool, ip, applicant, now)
 			delete(f.pool.Status.Allocated, ip)
 			f.pool.Status.Available++
-			break
+			quarantine(f.pool, ip, now)
 		}
 	}
 
@@ -0,0 +1,53 @@
+package store
+
+import (
+	"time"
+
+	lbv1 "github.com/harvester/harvester-load-balancer/pkg/apis/loadbalancer.harvesterhci.io/v1beta1"
+)
+
+// IsQuarantined returns true if the IP was released within the quarantine period of the pool.
+// The previous owner recorded in the allocated history is still allowed to reclaim the IP.
+func IsQuarantined(pool *lbv1.IPPool, ip, applicantID string, now time.Time) bool {
+	releasedTime, ok := pool.Status.Quarantined[ip]
+	if !ok || pool.Spec.QuarantineSeconds == 0 {
+		return false
+	}
+	if owner, ok := pool.Status.AllocatedHistory[ip]; ok && owner == applicantID {
+		return false
+	}
+
+	return !isQuarantineExpired(pool, releasedTime, now)
+}
+
+func isQuarantineExpired(pool *lbv1.IPPool, releasedTime string, now time.Time) bool {
+	t, err := time.Parse(time.RFC3339, releasedTime)
+	if err != nil {
+		return true
+	}
+
+	return !now.Before(t.Add(time.Duration(pool.Spec.QuarantineSeconds) * time.Second))
+}
+
+// quarantine records the release time of the IP if the pool has a quarantine period
+func quarantine(pool *lbv1.IPPool, ip string, now time.Time) {
+	if pool.Spec.QuarantineSeconds == 0 {
+		return
+	}
+	if pool.Status.Quarantined == nil {
+		pool.Status.Quarantined = make(map[string]string)
+	}
+	pool.Status.Quarantined[ip] = now.UTC().Format(time.RFC3339)
+}
+
+// pruneQuarantined removes the IPs whose quarantine period has passed
+func pruneQuarantined(pool *lbv1.IPPool, now time.Time) {
+	for ip, releasedTime := range pool.Status.Quarantined {
+		if pool.Spec.QuarantineSeconds == 0 || isQuarantineExpired(pool, releasedTime, now) {
+			delete(pool.Status.Quarantined, ip)
+		}
+	}
+	if len(pool.Status.Quarantined) == 0 {
+		pool.Status.Quarantined = nil
+	}
+}
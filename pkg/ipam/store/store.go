@@ -3,35 +3,133 @@ package store
 import (
 	"fmt"
 	"net"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/containernetworking/plugins/plugins/ipam/host-local/backend"
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 
+	lbv1 "github.com/harvester/harvester-load-balancer/pkg/apis/loadbalancer.harvesterhci.io/v1beta1"
 	ctllbv1 "github.com/harvester/harvester-load-balancer/pkg/generated/controllers/loadbalancer.harvesterhci.io/v1beta1"
+	"github.com/harvester/harvester-load-balancer/pkg/ipam/detector"
+	"github.com/harvester/harvester-load-balancer/pkg/utils"
 )
 
-// Store is a CRD store that records the allocated IP in the status of IPPool CR
+// Store is a CRD store that records each allocated IP as an IPAllocation object.
+// The uniqueness of an allocation is guaranteed by the object name derived from the IP, so the concurrent allocations
+// don't conflict on the IPPool. The IPPool status only keeps the aggregated information and the allocated history.
+//
+// The store keeps an authoritative in-memory record of the allocated IPs which is rebuilt when the store is created,
+// that is when the controller gains the leadership or the pool is changed, so the allocations never rely on a stale
+// cache. The allocations of a pool are serialized by Lock and Unlock, and the status of the pool is written in the
+// background with coalescing.
 type Store struct {
-	iPPoolName   string
-	iPPoolCache  ctllbv1.IPPoolCache
-	iPPoolClient ctllbv1.IPPoolClient
+	iPPoolName       string
+	iPPoolCache      ctllbv1.IPPoolCache
+	iPPoolClient     ctllbv1.IPPoolClient
+	allocationCache  ctllbv1.IPAllocationCache
+	allocationClient ctllbv1.IPAllocationClient
+
+	// lock serializes the allocations and releases of the pool
+	lock sync.Mutex
+	// mutex protects the in-memory record
+	mutex sync.RWMutex
+	// allocated maps the allocated IPs to their applicants
+	allocated map[string]string
+	// released records the IPs released by this store, the quarantine of which may not be written into the status yet
+	released map[string]releasedRecord
+	// lastReserved is the IP reserved last time
+	lastReserved string
+	// conflicted records the IPs found in use by this store, which may not be written into the status yet
+	conflicted map[string]time.Time
+	// probed records the IPs found free by this store, they are reserved without probing within the probe period
+	probed map[string]time.Time
+
+	// newDetector returns the detector of the conflict detection method of the pool
+	newDetector func(method lbv1.ConflictDetection) (detector.Detector, error)
+	// conflictHandler is called when an IP is found in use on the network
+	conflictHandler func(ip net.IP)
+	// quotaChecker checks whether the applicant can take one more IP, it is called with the lock held
+	quotaChecker func(applicantID string, allocated map[string]string) error
+
+	writer *statusWriter
+}
+
+// ProbeRequiredError is returned by Reserve if the IP has to be probed by the conflict detection of the pool before
+// it is reserved. The probe waits for the reply from the network, so the caller probes the IP by Probe without
+// holding the lock of the store and reserves it again.
+type ProbeRequiredError struct {
+	IP net.IP
+}
+
+func (e *ProbeRequiredError) Error() string {
+	return fmt.Sprintf("IP %s has to be probed before it is reserved", e.IP)
+}
+
+type releasedRecord struct {
+	applicantID string
+	time        time.Time
 }
 
 // Store implements the Store interface
 var _ backend.Store = &Store{}
 
-func New(ipPoolName string, ipPoolCache ctllbv1.IPPoolCache, ipPoolClient ctllbv1.IPPoolClient) *Store {
+func New(ipPoolName string, ipPoolCache ctllbv1.IPPoolCache, ipPoolClient ctllbv1.IPPoolClient,
+	allocationCache ctllbv1.IPAllocationCache, allocationClient ctllbv1.IPAllocationClient) (*Store, error) {
+	ipPool, err := ipPoolCache.Get(ipPoolName)
+	if err != nil {
+		return nil, err
+	}
+	allocated, err := GetAllocated(allocationCache, ipPool)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Store{
-		iPPoolName:   ipPoolName,
-		iPPoolCache:  ipPoolCache,
-		iPPoolClient: ipPoolClient,
+		iPPoolName:       ipPoolName,
+		iPPoolCache:      ipPoolCache,
+		iPPoolClient:     ipPoolClient,
+		allocationCache:  allocationCache,
+		allocationClient: allocationClient,
+		allocated:        allocated,
+		released:         make(map[string]releasedRecord),
+		lastReserved:     ipPool.Status.LastAllocated,
+		conflicted:       make(map[string]time.Time),
+		probed:           make(map[string]time.Time),
+		newDetector:      detector.New,
+		writer:           newStatusWriter(ipPoolName, ipPoolClient),
+	}, nil
+}
+
+// GetIPPool returns the IP pool which the store allocates IPs from
+func (s *Store) GetIPPool() (*lbv1.IPPool, error) {
+	return s.iPPoolCache.Get(s.iPPoolName)
+}
+
+// GetAllocated returns the allocated IPs of the pool and their applicants
+func (s *Store) GetAllocated() (map[string]string, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	allocated := make(map[string]string, len(s.allocated))
+	for ip, applicant := range s.allocated {
+		allocated[ip] = applicant
 	}
+
+	return allocated, nil
 }
 
 func (s *Store) Lock() error {
+	s.lock.Lock()
 	return nil
 }
 
 func (s *Store) Unlock() error {
+	s.lock.Unlock()
 	return nil
 }
 
@@ -47,126 +145,314 @@ func (s *Store) Reserve(applicantID, _ string, ip net.IP, _ string) (bool, error
 
 	ipStr := ip.String()
 
-	if ipPool.Status.Allocated != nil {
-		if id, ok := ipPool.Status.Allocated[ipStr]; ok {
-			if id != applicantID {
-				// the ip has been allocated to other application
-				return false, nil
-			}
-			// pool allocated ip to lb, but lb failed to book it (e.g. failed to update status due to conflict)
-			// when lb allocates again, return success
-			return true, nil
-		}
+	// the ip is statically reserved for other application
+	if owner, ok := GetReservation(&ipPool.Spec, ip); ok && owner != applicantID {
+		return false, nil
+	}
+
+	// the ip was released by other application recently
+	now := time.Now()
+	if IsQuarantined(ipPool, ipStr, applicantID, now) || s.isQuarantined(ipPool, ipStr, applicantID, now) {
+		return false, nil
+	}
+
+	s.mutex.RLock()
+	owner, ok := s.allocated[ipStr]
+	s.mutex.RUnlock()
+	if ok {
+		// pool allocated ip to lb, but lb failed to book it (e.g. failed to update status due to conflict)
+		// when lb allocates again, return success
+		return owner == applicantID, nil
 	}
 
-	ipPoolCopy := ipPool.DeepCopy()
-	if ipPoolCopy.Status.AllocatedHistory != nil {
-		delete(ipPoolCopy.Status.AllocatedHistory, ipStr)
+	// the quotas are checked against the in-memory record with the lock held, so the concurrent allocations of a
+	// tenant can't exceed its quota together
+	if s.quotaChecker != nil {
+		allocated, _ := s.GetAllocated()
+		if err := s.quotaChecker(applicantID, allocated); err != nil {
+			return false, err
+		}
 	}
-	if ipPoolCopy.Status.Allocated == nil {
-		ipPoolCopy.Status.Allocated = make(map[string]string)
+
+	// the ip may be in use out of the records, e.g. configured statically on some host
+	if ipPool.Spec.ConflictDetection != "" {
+		if s.IsConflicted(ipPool, ipStr, now) {
+			return false, nil
+		}
+		if !s.isProbed(ipStr, now) {
+			return false, &ProbeRequiredError{IP: ip}
+		}
 	}
-	ipPoolCopy.Status.Allocated[ipStr] = applicantID
-	ipPoolCopy.Status.LastAllocated = ipStr
-	ipPoolCopy.Status.Available--
 
-	if _, err := s.iPPoolClient.Update(ipPoolCopy); err != nil {
-		return false, fmt.Errorf("fail to reserve %s into %s", ipStr, s.iPPoolName)
+	if _, err := s.allocationClient.Create(NewAllocation(ipPool, ipStr, applicantID)); err != nil {
+		if !apierrors.IsAlreadyExists(err) {
+			return false, fmt.Errorf("fail to reserve %s into %s, error: %w", ipStr, s.iPPoolName, err)
+		}
+		// the allocation is not in the in-memory record, e.g. it was created by the previous leader just now
+		allocation, err := s.allocationClient.Get(AllocationName(ip), metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		if allocation.Spec.IPPool != s.iPPoolName {
+			return false, nil
+		}
+		s.mutex.Lock()
+		s.allocated[ipStr] = allocation.Spec.Applicant
+		s.mutex.Unlock()
+		return allocation.Spec.Applicant == applicantID, nil
 	}
 
+	s.mutex.Lock()
+	s.allocated[ipStr] = applicantID
+	delete(s.released, ipStr)
+	delete(s.conflicted, ipStr)
+	delete(s.probed, ipStr)
+	s.lastReserved = ipStr
+	s.mutex.Unlock()
+
+	s.writer.submit(func(pool *lbv1.IPPool) {
+		delete(pool.Status.AllocatedHistory, ipStr)
+		delete(pool.Status.AllocatedHistoryTimestamps, ipStr)
+		delete(pool.Status.Quarantined, ipStr)
+		delete(pool.Status.Conflicted, ipStr)
+		pruneQuarantined(pool, now)
+		pruneConflicted(pool, now)
+		pool.Status.LastAllocated = ipStr
+	})
+
 	return true, nil
 }
 
-func (s *Store) LastReservedIP(_ string) (net.IP, error) {
-	ipPool, err := s.iPPoolCache.Get(s.iPPoolName)
-	if err != nil {
-		return nil, err
+// isQuarantined checks the IPs released by the store whose quarantine may not be written into the status yet
+func (s *Store) isQuarantined(pool *lbv1.IPPool, ip, applicantID string, now time.Time) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	record, ok := s.released[ip]
+	if !ok {
+		return false
+	}
+	if !now.Before(record.time.Add(time.Duration(pool.Spec.QuarantineSeconds) * time.Second)) {
+		delete(s.released, ip)
+		return false
 	}
 
-	return net.ParseIP(ipPool.Status.LastAllocated), nil
+	return record.applicantID != applicantID
 }
 
-func (s *Store) Release(ip net.IP) error {
-	ipPool, err := s.iPPoolCache.Get(s.iPPoolName)
-	if err != nil {
-		return err
+// IsConflicted checks whether the IP was found in use on the network recently, including the IPs found by the store
+// which may not be written into the status yet
+func (s *Store) IsConflicted(pool *lbv1.IPPool, ip string, now time.Time) bool {
+	if pool.Spec.ConflictDetection == "" {
+		return false
 	}
-	if ipPool.Status.Allocated == nil {
-		return nil
+
+	s.mutex.RLock()
+	foundTime, ok := s.conflicted[ip]
+	s.mutex.RUnlock()
+	if ok && now.Before(foundTime.Add(ConflictRecheckPeriod)) {
+		return true
 	}
 
-	// tolerant duplicated release
-	// e.g. lb released ip but failed to update self, then release again
-	// still, need to check applicant ID
-	// luckily the host-local/backend/allocator only calls ReleaseByID
-	ipStr := ip.String()
-	if _, ok := ipPool.Status.Allocated[ipStr]; ok {
-		ipPoolCopy := ipPool.DeepCopy()
+	return IsConflicted(pool, ip, now)
+}
 
-		if ipPool.Status.AllocatedHistory == nil {
-			ipPoolCopy.Status.AllocatedHistory = make(map[string]string)
+// GetUnavailable returns the IPs which can't be reserved to the applicant for now besides the allocated and the
+// reserved ones, i.e. the IPs quarantined after being released by others and the IPs found in use on the network,
+// including the ones recorded by the store which may not be written into the status yet
+func (s *Store) GetUnavailable(pool *lbv1.IPPool, applicantID string, now time.Time) map[string]bool {
+	unavailable := getUnavailable(pool, applicantID, now)
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	for ip, record := range s.released {
+		if record.applicantID != applicantID && now.Before(record.time.Add(time.Duration(pool.Spec.QuarantineSeconds)*time.Second)) {
+			unavailable[ip] = true
 		}
-		ipPoolCopy.Status.AllocatedHistory[ipStr] = ipPool.Status.Allocated[ipStr]
-		delete(ipPoolCopy.Status.Allocated, ipStr)
-		ipPoolCopy.Status.Available++
+	}
+	if pool.Spec.ConflictDetection != "" {
+		for ip, foundTime := range s.conflicted {
+			if now.Before(foundTime.Add(ConflictRecheckPeriod)) {
+				unavailable[ip] = true
+			}
+		}
+	}
 
-		_, err = s.iPPoolClient.Update(ipPoolCopy)
-		if err != nil {
-			return err
+	return unavailable
+}
+
+// getUnavailable returns the quarantined and the conflicted IPs recorded in the status which can't be reserved to the
+// applicant for now
+func getUnavailable(pool *lbv1.IPPool, applicantID string, now time.Time) map[string]bool {
+	unavailable := make(map[string]bool)
+	for ip := range pool.Status.Quarantined {
+		if IsQuarantined(pool, ip, applicantID, now) {
+			unavailable[ip] = true
+		}
+	}
+	for ip := range pool.Status.Conflicted {
+		if IsConflicted(pool, ip, now) {
+			unavailable[ip] = true
 		}
 	}
 
-	return nil
+	return unavailable
 }
 
-func (s *Store) ReleaseByID(applicantID, _ string) error {
-	ipPool, err := s.iPPoolCache.Get(s.iPPoolName)
-	if err != nil {
-		return err
+// SetConflictHandler sets the handler called when an IP is found in use on the network
+func (s *Store) SetConflictHandler(handler func(ip net.IP)) {
+	s.conflictHandler = handler
+}
+
+// SetQuotaChecker sets the checker called before an IP is reserved to a new applicant, the reservation fails with the
+// error returned by the checker
+func (s *Store) SetQuotaChecker(checker func(applicantID string, allocated map[string]string) error) {
+	s.quotaChecker = checker
+}
+
+// isProbed checks whether the IP was found free by the conflict detection within the probe period
+func (s *Store) isProbed(ip string, now time.Time) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	probedTime, ok := s.probed[ip]
+	if ok && !now.Before(probedTime.Add(ProbePeriod)) {
+		delete(s.probed, ip)
+		return false
+	}
+
+	return ok
+}
+
+// Probe probes the IP by the conflict detection of the pool, it is called without holding the lock of the store, so
+// the allocations of the pool don't wait for the network. The IP found in use is recorded into the status and skipped
+// until the recheck period passes, the IP found free is reserved without probing within the probe period.
+// An IP which can't be probed, e.g. there is no local interface in its subnet, is taken as free.
+func (s *Store) Probe(ip net.IP) {
+	pool, err := s.iPPoolCache.Get(s.iPPoolName)
+	if err != nil || pool.Spec.ConflictDetection == "" {
+		return
 	}
-	if ipPool.Status.Allocated == nil {
+
+	ipStr := ip.String()
+	now := time.Now()
+	inUse := false
+	if d, err := s.newDetector(pool.Spec.ConflictDetection); err != nil {
+		logrus.Warnf("fail to probe IP %s of pool %s, take it as free, error: %s", ipStr, s.iPPoolName, err.Error())
+	} else if inUse, err = d.InUse(ip); err != nil {
+		logrus.Warnf("fail to probe IP %s of pool %s, take it as free, error: %s", ipStr, s.iPPoolName, err.Error())
+	}
+
+	s.mutex.Lock()
+	for probedIP, probedTime := range s.probed {
+		if !now.Before(probedTime.Add(ProbePeriod)) {
+			delete(s.probed, probedIP)
+		}
+	}
+	if !inUse {
+		s.probed[ipStr] = now
+		s.mutex.Unlock()
+		return
+	}
+	s.conflicted[ipStr] = now
+	s.mutex.Unlock()
+
+	logrus.Warnf("IP %s of pool %s is in use on the network, skip it", ipStr, s.iPPoolName)
+	s.writer.submit(func(pool *lbv1.IPPool) {
+		if pool.Status.Conflicted == nil {
+			pool.Status.Conflicted = make(map[string]string)
+		}
+		pool.Status.Conflicted[ipStr] = now.UTC().Format(time.RFC3339)
+	})
+	if s.conflictHandler != nil {
+		s.conflictHandler(ip)
+	}
+}
+
+func (s *Store) LastReservedIP(_ string) (net.IP, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return net.ParseIP(s.lastReserved), nil
+}
+
+func (s *Store) Release(ip net.IP) error {
+	// tolerant duplicated release
+	// e.g. lb released ip but failed to update self, then release again
+	// still, need to check applicant ID
+	// luckily the host-local/backend/allocator only calls ReleaseByID
+	ipStr := ip.String()
+	s.mutex.RLock()
+	applicantID, ok := s.allocated[ipStr]
+	s.mutex.RUnlock()
+	if !ok {
 		return nil
 	}
 
-	ipPoolCopy := ipPool.DeepCopy()
-	found := false
+	return s.release(map[string]string{ipStr: applicantID})
+}
 
+func (s *Store) ReleaseByID(applicantID, _ string) error {
 	// tolerant duplicated release
 	// e.g. lb released ip but failed to update self, then release again
 	// the host-local/backend/allocator only calls ReleaseByID
-	for ip, applicant := range ipPool.Status.Allocated {
+	// a dual-stack applicant has one IP per IP family, release all of them
+	released := make(map[string]string)
+	s.mutex.RLock()
+	for ip, applicant := range s.allocated {
 		if applicant == applicantID {
-			if ipPool.Status.AllocatedHistory == nil {
-				ipPoolCopy.Status.AllocatedHistory = make(map[string]string)
-			}
-			ipPoolCopy.Status.AllocatedHistory[ip] = applicant
-			delete(ipPoolCopy.Status.Allocated, ip)
-			ipPoolCopy.Status.Available++
-			found = true
-			break
+			released[ip] = applicant
 		}
 	}
+	s.mutex.RUnlock()
+	if len(released) == 0 {
+		return nil
+	}
 
-	if found {
-		_, err = s.iPPoolClient.Update(ipPoolCopy)
-		if err != nil {
-			return err
+	return s.release(released)
+}
+
+// release deletes the IPAllocation objects of the IPs and records them into the allocated history
+func (s *Store) release(released map[string]string) error {
+	ipPool, err := s.iPPoolCache.Get(s.iPPoolName)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for ipStr, applicantID := range released {
+		err := s.allocationClient.Delete(AllocationName(net.ParseIP(ipStr)), &metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("fail to release %s from %s, error: %w", ipStr, s.iPPoolName, err)
+		}
+		s.mutex.Lock()
+		delete(s.allocated, ipStr)
+		if ipPool.Spec.QuarantineSeconds > 0 {
+			s.released[ipStr] = releasedRecord{applicantID: applicantID, time: now}
 		}
+		s.mutex.Unlock()
 	}
 
+	s.writer.submit(func(pool *lbv1.IPPool) {
+		pruneQuarantined(pool, now)
+		for ipStr, applicantID := range released {
+			delete(pool.Status.Allocated, ipStr)
+			recordHistory(pool, ipStr, applicantID, now)
+			quarantine(pool, ipStr, now)
+		}
+	})
+
 	return nil
 }
 
 func (s *Store) GetByID(applicantID, _ string) []net.IP {
-	ipPool, err := s.iPPoolCache.Get(s.iPPoolName)
-	if err != nil || ipPool.Status.Allocated == nil {
-		return nil
-	}
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
 
-	// each ID can only have max 1 IP
-	ips := make([]net.IP, 0, 1)
+	// each ID can only have max 1 IP per IP family
+	ips := make([]net.IP, 0, 2)
 
-	for ip, applicant := range ipPool.Status.Allocated {
+	for ip, applicant := range s.allocated {
 		if applicantID == applicant {
 			ips = append(ips, net.ParseIP(ip))
 		}
@@ -174,3 +460,87 @@ func (s *Store) GetByID(applicantID, _ string) []net.IP {
 
 	return ips
 }
+
+// AllocationName returns the name of the IPAllocation object of the IP.
+// The IPv6 address is expanded and its colons are replaced with hyphens to be a valid object name.
+func AllocationName(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.String()
+	}
+
+	ip16 := ip.To16()
+	groups := make([]string, 0, net.IPv6len/2)
+	for i := 0; i < net.IPv6len; i += 2 {
+		groups = append(groups, fmt.Sprintf("%02x%02x", ip16[i], ip16[i+1]))
+	}
+
+	return strings.Join(groups, "-")
+}
+
+// GetAllocated returns the allocated IPs of the pool and their applicants, including the IPs recorded in the status
+// of the pool by previous versions which have not been migrated to IPAllocation objects
+func GetAllocated(cache ctllbv1.IPAllocationCache, pool *lbv1.IPPool) (map[string]string, error) {
+	allocations, err := cache.List(labels.SelectorFromSet(labels.Set{utils.LabelKeyIPPool: pool.Name}))
+	if err != nil {
+		return nil, fmt.Errorf("list allocations of pool %s failed, error: %w", pool.Name, err)
+	}
+
+	allocated := make(map[string]string, len(allocations)+len(pool.Status.Allocated))
+	for ip, applicant := range pool.Status.Allocated {
+		allocated[ip] = applicant
+	}
+	for _, allocation := range allocations {
+		if allocation.Spec.IPPool == pool.Name {
+			allocated[allocation.Spec.Address] = allocation.Spec.Applicant
+		}
+	}
+
+	return allocated, nil
+}
+
+// NewAllocation returns an IPAllocation object recording the IP allocated to the applicant from the pool.
+// The pool is the owner of the object, so the allocations are removed with the pool.
+func NewAllocation(pool *lbv1.IPPool, ip, applicantID string) *lbv1.IPAllocation {
+	return &lbv1.IPAllocation{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   AllocationName(net.ParseIP(ip)),
+			Labels: map[string]string{utils.LabelKeyIPPool: pool.Name},
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					APIVersion: lbv1.SchemeGroupVersion.String(),
+					Kind:       "IPPool",
+					Name:       pool.Name,
+					UID:        pool.UID,
+				},
+			},
+		},
+		Spec: lbv1.IPAllocationSpec{
+			IPPool:    pool.Name,
+			Address:   ip,
+			Applicant: applicantID,
+		},
+	}
+}
+
+// GetReservation returns the applicant which the IP is statically reserved for
+func GetReservation(spec *lbv1.IPPoolSpec, ip net.IP) (string, bool) {
+	for ipStr, applicant := range spec.Reservations {
+		if ip.Equal(net.ParseIP(ipStr)) {
+			return applicant, true
+		}
+	}
+
+	return "", false
+}
+
+// recordHistory records the released IP and its applicant with the release time into the allocated history
+func recordHistory(pool *lbv1.IPPool, ip, applicantID string, now time.Time) {
+	if pool.Status.AllocatedHistory == nil {
+		pool.Status.AllocatedHistory = make(map[string]string)
+	}
+	if pool.Status.AllocatedHistoryTimestamps == nil {
+		pool.Status.AllocatedHistoryTimestamps = make(map[string]string)
+	}
+	pool.Status.AllocatedHistory[ip] = applicantID
+	pool.Status.AllocatedHistoryTimestamps[ip] = now.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,233 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	lbv1 "github.com/harvester/harvester-load-balancer/pkg/apis/loadbalancer.harvesterhci.io/v1beta1"
+	"github.com/harvester/harvester-load-balancer/pkg/generated/clientset/versioned/fake"
+	"github.com/harvester/harvester-load-balancer/pkg/ipam/detector"
+	"github.com/harvester/harvester-load-balancer/pkg/utils/fakeclients"
+)
+
+func newTestStore(t *testing.T, pool *lbv1.IPPool) *Store {
+	clientset := fake.NewSimpleClientset(pool)
+	s, err := New(pool.Name,
+		fakeclients.IPPoolCache(clientset.LoadbalancerV1beta1().IPPools),
+		fakeclients.IPPoolClient(clientset.LoadbalancerV1beta1().IPPools),
+		fakeclients.IPAllocationCache(clientset.LoadbalancerV1beta1().IPAllocations),
+		fakeclients.IPAllocationClient(clientset.LoadbalancerV1beta1().IPAllocations))
+	if err != nil {
+		t.Fatalf("failed to create store, error: %s", err.Error())
+	}
+	return s
+}
+
+func TestAllocationName(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{ip: "192.168.100.10", want: "192.168.100.10"},
+		{ip: "::ffff:192.168.100.10", want: "192.168.100.10"},
+		{ip: "fd00:100::a", want: "fd00-0100-0000-0000-0000-0000-0000-000a"},
+	}
+
+	for _, tt := range tests {
+		if got := AllocationName(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("AllocationName(%s) = %s, want %s", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestStore_ReserveAndRelease(t *testing.T) {
+	s := newTestStore(t, &lbv1.IPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "pool1"},
+		Status: lbv1.IPPoolStatus{
+			Allocated: map[string]string{"192.168.100.20": "default/lb0"},
+		},
+	})
+	ip := net.ParseIP("192.168.100.10")
+
+	if ok, err := s.Reserve("default/lb1", "", ip, ""); err != nil || !ok {
+		t.Fatalf("Reserve() = %v, %v, want true", ok, err)
+	}
+	// the reservation is idempotent for the same applicant
+	if ok, err := s.Reserve("default/lb1", "", ip, ""); err != nil || !ok {
+		t.Errorf("Reserve() again = %v, %v, want true", ok, err)
+	}
+	// the IP can't be allocated to others
+	if ok, err := s.Reserve("default/lb2", "", ip, ""); err != nil || ok {
+		t.Errorf("Reserve() by others = %v, %v, want false", ok, err)
+	}
+	// the IP recorded by previous versions can't be allocated to others
+	if ok, err := s.Reserve("default/lb2", "", net.ParseIP("192.168.100.20"), ""); err != nil || ok {
+		t.Errorf("Reserve() of the legacy allocated IP = %v, %v, want false", ok, err)
+	}
+
+	allocation, err := s.allocationClient.Get(AllocationName(ip), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get allocation, error: %s", err.Error())
+	}
+	if allocation.Spec.IPPool != "pool1" || allocation.Spec.Applicant != "default/lb1" || allocation.Spec.Address != ip.String() {
+		t.Errorf("unexpected allocation %+v", allocation.Spec)
+	}
+
+	allocated, err := s.GetAllocated()
+	if err != nil {
+		t.Fatalf("failed to get allocated IPs, error: %s", err.Error())
+	}
+	if len(allocated) != 2 || allocated["192.168.100.10"] != "default/lb1" || allocated["192.168.100.20"] != "default/lb0" {
+		t.Errorf("unexpected allocated IPs %v", allocated)
+	}
+	if ips := s.GetByID("default/lb1", ""); len(ips) != 1 || !ips[0].Equal(ip) {
+		t.Errorf("GetByID() = %v, want [%s]", ips, ip)
+	}
+
+	if err := s.ReleaseByID("default/lb1", ""); err != nil {
+		t.Fatalf("ReleaseByID() error = %v", err)
+	}
+	if err := s.Release(net.ParseIP("192.168.100.20")); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	if allocated, _ := s.GetAllocated(); len(allocated) != 0 {
+		t.Errorf("expect no allocated IPs, got %v", allocated)
+	}
+
+	s.writer.wait()
+	pool, _ := s.GetIPPool()
+	if pool.Status.AllocatedHistory["192.168.100.10"] != "default/lb1" || pool.Status.AllocatedHistory["192.168.100.20"] != "default/lb0" {
+		t.Errorf("unexpected allocated history %v", pool.Status.AllocatedHistory)
+	}
+	if len(pool.Status.AllocatedHistoryTimestamps) != 2 {
+		t.Errorf("unexpected allocated history timestamps %v", pool.Status.AllocatedHistoryTimestamps)
+	}
+
+	// the released IP can be allocated to others
+	if ok, err := s.Reserve("default/lb2", "", ip, ""); err != nil || !ok {
+		t.Errorf("Reserve() after release = %v, %v, want true", ok, err)
+	}
+	s.writer.wait()
+	if pool, _ := s.GetIPPool(); pool.Status.LastAllocated != ip.String() {
+		t.Errorf("LastAllocated = %s, want %s", pool.Status.LastAllocated, ip)
+	}
+}
+
+func TestStore_ConcurrentReserve(t *testing.T) {
+	s := newTestStore(t, &lbv1.IPPool{ObjectMeta: metav1.ObjectMeta{Name: "pool1"}})
+	ip := net.ParseIP("192.168.100.10")
+
+	var wg sync.WaitGroup
+	var reserved atomic.Int32
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			_ = s.Lock()
+			defer func() { _ = s.Unlock() }()
+			if ok, err := s.Reserve(id, "", ip, ""); err == nil && ok {
+				reserved.Add(1)
+			}
+		}(fmt.Sprintf("default/lb%d", i))
+	}
+	wg.Wait()
+	s.writer.wait()
+
+	if got := reserved.Load(); got != 1 {
+		t.Errorf("the IP is reserved %d times, want 1", got)
+	}
+}
+
+func TestStore_ProbeFailed(t *testing.T) {
+	s := newTestStore(t, &lbv1.IPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "pool1"},
+		Spec:       lbv1.IPPoolSpec{ConflictDetection: lbv1.ARP},
+	})
+	s.newDetector = func(lbv1.ConflictDetection) (detector.Detector, error) {
+		return &detector.Fake{Err: fmt.Errorf("no interface in the subnet")}, nil
+	}
+	ip := net.ParseIP("192.168.100.10")
+
+	// the IP which can't be probed is taken as free
+	s.Probe(ip)
+	if ok, err := s.Reserve("default/lb1", "", ip, ""); err != nil || !ok {
+		t.Errorf("Reserve() of the IP failed to be probed = %v, %v, want true", ok, err)
+	}
+
+	// the IP found free is probed again after the probe period
+	ip = net.ParseIP("192.168.100.11")
+	s.Probe(ip)
+	s.probed[ip.String()] = time.Now().Add(-ProbePeriod)
+	var probeErr *ProbeRequiredError
+	if _, err := s.Reserve("default/lb1", "", ip, ""); !errors.As(err, &probeErr) {
+		t.Errorf("Reserve() of the IP probed beyond the probe period returns %v, want ProbeRequiredError", err)
+	}
+}
+
+func TestStore_ReserveConflicted(t *testing.T) {
+	s := newTestStore(t, &lbv1.IPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "pool1"},
+		Spec:       lbv1.IPPoolSpec{ConflictDetection: lbv1.ARP},
+		Status: lbv1.IPPoolStatus{
+			Allocated: map[string]string{"192.168.100.20": "default/lb0"},
+		},
+	})
+	d := &detector.Fake{InUseIPs: map[string]bool{"192.168.100.10": true, "192.168.100.20": true}}
+	s.newDetector = func(lbv1.ConflictDetection) (detector.Detector, error) { return d, nil }
+	var conflicts []string
+	s.SetConflictHandler(func(ip net.IP) { conflicts = append(conflicts, ip.String()) })
+
+	// reserve as the allocator does, the IP is probed without holding the lock if it has to be probed
+	reserve := func(id, ip string) (bool, error) {
+		ok, err := s.Reserve(id, "", net.ParseIP(ip), "")
+		var probeErr *ProbeRequiredError
+		if !errors.As(err, &probeErr) {
+			return ok, err
+		}
+		s.Probe(probeErr.IP)
+		return s.Reserve(id, "", net.ParseIP(ip), "")
+	}
+
+	// the IP has to be probed before it is reserved
+	var probeErr *ProbeRequiredError
+	if _, err := s.Reserve("default/lb1", "", net.ParseIP("192.168.100.10"), ""); !errors.As(err, &probeErr) {
+		t.Errorf("Reserve() of the IP not probed yet returns %v, want ProbeRequiredError", err)
+	}
+	// the IP in use on the network is skipped and reported
+	if ok, err := reserve("default/lb1", "192.168.100.10"); err != nil || ok {
+		t.Errorf("Reserve() of the IP in use = %v, %v, want false", ok, err)
+	}
+	// the conflicted IP isn't probed again within the recheck period
+	if ok, err := reserve("default/lb1", "192.168.100.10"); err != nil || ok {
+		t.Errorf("Reserve() of the conflicted IP = %v, %v, want false", ok, err)
+	}
+	// the IP allocated to the applicant answers the probe but isn't probed
+	if ok, err := reserve("default/lb0", "192.168.100.20"); err != nil || !ok {
+		t.Errorf("Reserve() of the allocated IP = %v, %v, want true", ok, err)
+	}
+	if ok, err := reserve("default/lb1", "192.168.100.11"); err != nil || !ok {
+		t.Errorf("Reserve() of the free IP = %v, %v, want true", ok, err)
+	}
+
+	if probed := d.Probed(); len(probed) != 2 || probed[0] != "192.168.100.10" || probed[1] != "192.168.100.11" {
+		t.Errorf("unexpected probed IPs %v", probed)
+	}
+	if len(conflicts) != 1 || conflicts[0] != "192.168.100.10" {
+		t.Errorf("unexpected reported conflicts %v", conflicts)
+	}
+	s.writer.wait()
+	pool, _ := s.GetIPPool()
+	if _, ok := pool.Status.Conflicted["192.168.100.10"]; !ok || len(pool.Status.Conflicted) != 1 {
+		t.Errorf("unexpected conflicted IPs %v", pool.Status.Conflicted)
+	}
+	if !IsConflicted(pool, "192.168.100.10", time.Now()) || IsConflicted(pool, "192.168.100.10", time.Now().Add(ConflictRecheckPeriod)) {
+		t.Errorf("expect the conflicted IP is skipped within the recheck period only")
+	}
+}
@@ -0,0 +1,114 @@
+package store
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+
+	lbv1 "github.com/harvester/harvester-load-balancer/pkg/apis/loadbalancer.harvesterhci.io/v1beta1"
+	ctllbv1 "github.com/harvester/harvester-load-balancer/pkg/generated/controllers/loadbalancer.harvesterhci.io/v1beta1"
+)
+
+const (
+	// writeRetryInterval is how long the writer waits before writing the failed mutations again, it doubles on each
+	// failure up to maxWriteRetryInterval
+	writeRetryInterval    = time.Second
+	maxWriteRetryInterval = time.Minute
+)
+
+// statusWriter writes the status of an IP pool in the background.
+// The mutations submitted while a write is in flight are coalesced into the next write, and each write is retried on
+// conflict, so a burst of allocations results in a few updates of the IP pool instead of one per allocation.
+// The mutations of a failed write are queued again in front of the pending ones, so the status doesn't diverge from
+// the in-memory record of the store.
+type statusWriter struct {
+	name          string
+	client        ctllbv1.IPPoolClient
+	retryInterval time.Duration
+
+	mutex   sync.Mutex
+	pending []func(pool *lbv1.IPPool)
+	writing bool
+	wg      sync.WaitGroup
+}
+
+func newStatusWriter(name string, client ctllbv1.IPPoolClient) *statusWriter {
+	return &statusWriter{
+		name:          name,
+		client:        client,
+		retryInterval: writeRetryInterval,
+	}
+}
+
+// submit queues the mutation and starts a writer if there isn't one
+func (w *statusWriter) submit(mutate func(pool *lbv1.IPPool)) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.pending = append(w.pending, mutate)
+	if !w.writing {
+		w.writing = true
+		w.wg.Add(1)
+		go w.run()
+	}
+}
+
+// wait blocks until all the submitted mutations are written
+func (w *statusWriter) wait() {
+	w.wg.Wait()
+}
+
+func (w *statusWriter) run() {
+	defer w.wg.Done()
+
+	interval := w.retryInterval
+	for {
+		w.mutex.Lock()
+		batch := w.pending
+		w.pending = nil
+		if len(batch) == 0 {
+			w.writing = false
+			w.mutex.Unlock()
+			return
+		}
+		w.mutex.Unlock()
+
+		err := w.write(batch)
+		if err == nil {
+			interval = w.retryInterval
+			continue
+		}
+		// the status is removed with the pool
+		if apierrors.IsNotFound(err) {
+			logrus.Infof("IP pool %s is not found, %d status changes are dropped", w.name, len(batch))
+			continue
+		}
+		logrus.Warnf("update status of IP pool %s failed, retry %d changes in %s, error: %s", w.name, len(batch),
+			interval, err.Error())
+		w.mutex.Lock()
+		w.pending = append(batch, w.pending...)
+		w.mutex.Unlock()
+		time.Sleep(interval)
+		interval = min(interval*2, maxWriteRetryInterval)
+	}
+}
+
+// write applies the mutations to the latest IP pool and updates it, the update is retried on conflict
+func (w *statusWriter) write(batch []func(pool *lbv1.IPPool)) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		ipPool, err := w.client.Get(w.name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		ipPoolCopy := ipPool.DeepCopy()
+		for _, mutate := range batch {
+			mutate(ipPoolCopy)
+		}
+		_, err = w.client.Update(ipPoolCopy)
+		return err
+	})
+}
@@ -0,0 +1,94 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+
+	lbv1 "github.com/harvester/harvester-load-balancer/pkg/apis/loadbalancer.harvesterhci.io/v1beta1"
+	"github.com/harvester/harvester-load-balancer/pkg/generated/clientset/versioned/fake"
+	"github.com/harvester/harvester-load-balancer/pkg/utils/fakeclients"
+)
+
+func TestStatusWriter_Coalesce(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&lbv1.IPPool{ObjectMeta: metav1.ObjectMeta{Name: "pool1"}})
+	client := fakeclients.IPPoolClient(clientset.LoadbalancerV1beta1().IPPools)
+	w := newStatusWriter("pool1", client)
+
+	// the mutations submitted while a write is in flight are written together
+	w.mutex.Lock()
+	w.writing = true
+	w.wg.Add(1)
+	w.mutex.Unlock()
+	for i := 0; i < 10; i++ {
+		ip := fmt.Sprintf("192.168.100.%d", i)
+		w.submit(func(pool *lbv1.IPPool) {
+			if pool.Status.AllocatedHistory == nil {
+				pool.Status.AllocatedHistory = make(map[string]string)
+			}
+			pool.Status.AllocatedHistory[ip] = "default/lb1"
+		})
+	}
+	clientset.ClearActions()
+	w.run()
+	w.wait()
+
+	updates := 0
+	for _, action := range clientset.Actions() {
+		if action.GetVerb() == "update" {
+			updates++
+		}
+	}
+	if updates != 1 {
+		t.Errorf("the pool is updated %d times, want 1", updates)
+	}
+
+	pool, err := client.Get("pool1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get pool, error: %s", err.Error())
+	}
+	if len(pool.Status.AllocatedHistory) != 10 {
+		t.Errorf("expect 10 allocated history entries, got %v", pool.Status.AllocatedHistory)
+	}
+}
+
+func TestStatusWriter_RetryFailed(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&lbv1.IPPool{ObjectMeta: metav1.ObjectMeta{Name: "pool1"}})
+	// the first two writes fail for reasons other than conflicts
+	failures := 2
+	clientset.PrependReactor("update", "ippools", func(k8stesting.Action) (bool, runtime.Object, error) {
+		if failures > 0 {
+			failures--
+			return true, nil, errors.New("apiserver is unavailable")
+		}
+		return false, nil, nil
+	})
+	client := fakeclients.IPPoolClient(clientset.LoadbalancerV1beta1().IPPools)
+	w := newStatusWriter("pool1", client)
+	w.retryInterval = time.Millisecond
+
+	w.submit(func(pool *lbv1.IPPool) {
+		pool.Status.LastAllocated = "192.168.100.10"
+	})
+	w.wait()
+
+	pool, err := client.Get("pool1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get pool, error: %s", err.Error())
+	}
+	if pool.Status.LastAllocated != "192.168.100.10" {
+		t.Errorf("expect the failed change is written again, got last allocated %q", pool.Status.LastAllocated)
+	}
+
+	// the changes of the removed pool are dropped
+	w = newStatusWriter("pool2", client)
+	w.submit(func(pool *lbv1.IPPool) {
+		pool.Status.LastAllocated = "192.168.100.10"
+	})
+	w.wait()
+}
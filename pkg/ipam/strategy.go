@@ -0,0 +1,152 @@
+package ipam
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/containernetworking/plugins/plugins/ipam/host-local/backend/allocator"
+	corev1 "k8s.io/api/core/v1"
+
+	lbv1 "github.com/harvester/harvester-load-balancer/pkg/apis/loadbalancer.harvesterhci.io/v1beta1"
+	"github.com/harvester/harvester-load-balancer/pkg/ipam/store"
+	"github.com/harvester/harvester-load-balancer/pkg/utils"
+)
+
+// maxPickRetries is how many times to pick another IP if the picked one is allocated to others concurrently
+const maxPickRetries = 3
+
+// ipSpace lines up the ranges of one IP family in ascending order, so that each IP can be located by its offset
+type ipSpace struct {
+	ranges allocator.RangeSet
+	starts []*big.Int
+	sizes  []*big.Int
+	total  *big.Int
+	isIPv4 bool
+}
+
+func newIPSpace(rs allocator.RangeSet) *ipSpace {
+	if len(rs) == 0 {
+		return nil
+	}
+
+	ranges := slices.Clone(rs)
+	slices.SortFunc(ranges, func(a, b allocator.Range) int {
+		return ipToInt(a.RangeStart).Cmp(ipToInt(b.RangeStart))
+	})
+
+	s := &ipSpace{
+		ranges: ranges,
+		starts: make([]*big.Int, 0, len(ranges)),
+		sizes:  make([]*big.Int, 0, len(ranges)),
+		total:  big.NewInt(0),
+		isIPv4: ranges[0].RangeStart.To4() != nil,
+	}
+	for i := range ranges {
+		start := ipToInt(ranges[i].RangeStart)
+		size := big.NewInt(0).Sub(ipToInt(ranges[i].RangeEnd), start)
+		size.Add(size, big.NewInt(1))
+		s.starts = append(s.starts, start)
+		s.sizes = append(s.sizes, size)
+		s.total.Add(s.total, size)
+	}
+
+	return s
+}
+
+// ipAt returns the IP at the offset and the range it belongs to, the offset must be in [0, total)
+func (s *ipSpace) ipAt(offset *big.Int) (net.IP, *allocator.Range) {
+	o := big.NewInt(0).Set(offset)
+	for i := range s.ranges {
+		if o.Cmp(s.sizes[i]) < 0 {
+			return intToIP(o.Add(o, s.starts[i]), s.isIPv4), &s.ranges[i]
+		}
+		o.Sub(o, s.sizes[i])
+	}
+
+	return nil, nil
+}
+
+// pickIP picks a free IP of the IP family per the allocation strategy.
+// The IPs are probed one by one from the start offset decided by the strategy. As only the allocated IPs,
+// the reserved IPs, the quarantined IPs, the conflicted IPs and the gateways are not free, the first free IP is found
+// within limited steps.
+func (a *Allocator) pickIP(id string, family corev1.IPFamily, pool *lbv1.IPPool) (net.IP, error) {
+	space := a.getIPSpace(family)
+	if space == nil {
+		return nil, fmt.Errorf("pool %s has no %s range", a.name, family)
+	}
+
+	// duplicate allocation is not allowed as same as the host-local IPAllocator, which ignores the IPs out of the
+	// ranges so that an applicant being re-addressed can get a new IP
+	for _, ip := range a.store.GetByID(id, "") {
+		if utils.GetIPFamily(ip) == family && space.ranges.Contains(ip) {
+			return nil, fmt.Errorf("%s has been allocated to %s, %s", ip, id, utils.DuplicateAllocationKeyWord)
+		}
+	}
+
+	allocated, err := a.store.GetAllocated()
+	if err != nil {
+		return nil, err
+	}
+
+	start, err := a.startOffset(id, space)
+	if err != nil {
+		return nil, err
+	}
+
+	// the quarantined and the conflicted IPs recorded by the store are skipped before they are written into the
+	// status, otherwise the same IP would be picked and refused by the store again and again
+	unavailable := a.store.GetUnavailable(pool, id, time.Now())
+
+	limit := big.NewInt(int64(len(allocated) + len(pool.Spec.Reservations) + len(unavailable) + len(space.ranges) + 1))
+	if limit.Cmp(space.total) > 0 {
+		limit = space.total
+	}
+	offset := big.NewInt(0)
+	for i := big.NewInt(0); i.Cmp(limit) < 0; i.Add(i, big.NewInt(1)) {
+		offset.Add(start, i).Mod(offset, space.total)
+		if ip, r := space.ipAt(offset); isFreeIP(ip, r, id, pool, allocated, unavailable) {
+			return ip, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w, no %s addresses available in pool %s", ErrPoolExhausted, family, a.name)
+}
+
+func (a *Allocator) startOffset(id string, space *ipSpace) (*big.Int, error) {
+	switch a.strategy {
+	case lbv1.LowestFree:
+		return big.NewInt(0), nil
+	case lbv1.Random:
+		return rand.Int(rand.Reader, space.total)
+	case lbv1.Hash:
+		// the same applicant always starts from the same offset
+		sum := sha256.Sum256([]byte(id))
+		return big.NewInt(0).Mod(big.NewInt(0).SetBytes(sum[:]), space.total), nil
+	default:
+		return nil, fmt.Errorf("unknown allocation strategy %s", a.strategy)
+	}
+}
+
+func isFreeIP(ip net.IP, r *allocator.Range, id string, pool *lbv1.IPPool, allocated map[string]string,
+	unavailable map[string]bool) bool {
+	if ip == nil || ip.Equal(r.Gateway) {
+		return false
+	}
+	if _, ok := allocated[ip.String()]; ok {
+		return false
+	}
+	if owner, ok := store.GetReservation(&pool.Spec, ip); ok && owner != id {
+		return false
+	}
+	if unavailable[ip.String()] {
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,225 @@
+package ipam
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	lbv1 "github.com/harvester/harvester-load-balancer/pkg/apis/loadbalancer.harvesterhci.io/v1beta1"
+)
+
+func TestIPSpace_IPAt(t *testing.T) {
+	rs, err := LBRangesToAllocatorRangeSet([]lbv1.Range{
+		{
+			Subnet:     cClassSubnet,
+			RangeStart: "192.168.100.20",
+			RangeEnd:   "192.168.100.29",
+		},
+		{
+			Subnet:     cClassSubnet,
+			RangeStart: "192.168.100.10",
+			RangeEnd:   "192.168.100.14",
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to make ranges, error: %s", err.Error())
+	}
+	space := newIPSpace(rs)
+
+	tests := []struct {
+		name   string
+		offset int64
+		want   net.IP
+	}{
+		{
+			name:   "firstIPOfLowerRange",
+			offset: 0,
+			want:   net.ParseIP("192.168.100.10"),
+		},
+		{
+			name:   "lastIPOfLowerRange",
+			offset: 4,
+			want:   net.ParseIP("192.168.100.14"),
+		},
+		{
+			name:   "firstIPOfHigherRange",
+			offset: 5,
+			want:   net.ParseIP("192.168.100.20"),
+		},
+		{
+			name:   "lastIP",
+			offset: 14,
+			want:   net.ParseIP("192.168.100.29"),
+		},
+	}
+
+	if space.total.Int64() != 15 {
+		t.Errorf("ipSpace.total = %v, want 15", space.total)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := space.ipAt(big.NewInt(tt.offset)); !got.Equal(tt.want) {
+				t.Errorf("ipSpace.ipAt(%d) = %s, want %s", tt.offset, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllocator_Strategy(t *testing.T) {
+	ranges := []lbv1.Range{
+		{
+			Subnet:     cClassSubnet,
+			RangeStart: "192.168.100.1",
+			RangeEnd:   "192.168.100.20",
+		},
+		{
+			Subnet: ipv6Subnet,
+		},
+	}
+
+	tests := []struct {
+		name      string
+		strategy  lbv1.AllocationStrategy
+		allocated map[string]string
+		id        string
+		family    corev1.IPFamily
+		want      net.IP
+		wantErr   bool
+	}{
+		{
+			name:     "lowestFreeSkipsGateway",
+			strategy: lbv1.LowestFree,
+			id:       "default/lb1",
+			family:   corev1.IPv4Protocol,
+			want:     net.ParseIP("192.168.100.2"),
+		},
+		{
+			name:     "lowestFreeSkipsAllocated",
+			strategy: lbv1.LowestFree,
+			allocated: map[string]string{
+				"192.168.100.2": "default/lb2",
+				"192.168.100.3": "default/lb3",
+				"192.168.100.5": "default/lb5",
+			},
+			id:     "default/lb1",
+			family: corev1.IPv4Protocol,
+			want:   net.ParseIP("192.168.100.4"),
+		},
+		{
+			name:     "lowestFreeIPv6",
+			strategy: lbv1.LowestFree,
+			id:       "default/lb1",
+			family:   corev1.IPv6Protocol,
+			want:     net.ParseIP("fd00:100::2"),
+		},
+		{
+			name:     "duplicateAllocation",
+			strategy: lbv1.LowestFree,
+			allocated: map[string]string{
+				"192.168.100.2": "default/lb1",
+			},
+			id:      "default/lb1",
+			family:  corev1.IPv4Protocol,
+			wantErr: true,
+		},
+		{
+			name:     "noFreeIP",
+			strategy: lbv1.Random,
+			allocated: func() map[string]string {
+				allocated := make(map[string]string)
+				for i := 2; i <= 20; i++ {
+					allocated[net.IPv4(192, 168, 100, byte(i)).String()] = "default/others"
+				}
+				return allocated
+			}(),
+			id:      "default/lb1",
+			family:  corev1.IPv4Protocol,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := &lbv1.IPPoolSpec{Ranges: ranges, AllocationStrategy: tt.strategy}
+			a, err := newFakeAllocatorWithSpec(tt.name, spec)
+			if err != nil {
+				t.Fatalf("failed to create allocator, error: %s", err.Error())
+			}
+			pool, _ := a.store.GetIPPool()
+			pool.Status.Allocated = tt.allocated
+
+			ipConfig, err := a.Get(tt.id, tt.family, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("wantErr %v, returnErr %v", tt.wantErr, err)
+			}
+			if err == nil && !ipConfig.Address.IP.Equal(tt.want) {
+				t.Errorf("got IP %s, want %s", ipConfig.Address.IP, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllocator_RandomStrategy(t *testing.T) {
+	spec := &lbv1.IPPoolSpec{
+		Ranges:             []lbv1.Range{{Subnet: cClassSubnet}},
+		AllocationStrategy: lbv1.Random,
+	}
+	a, err := newFakeAllocatorWithSpec("random", spec)
+	if err != nil {
+		t.Fatalf("failed to create allocator, error: %s", err.Error())
+	}
+
+	// all the IPs except the gateway are allocated without any duplication
+	allocated := make(map[string]bool)
+	for i := int64(0); i < a.Total(); i++ {
+		ipConfig, err := a.Get(fmt.Sprintf("default/lb%d", i), corev1.IPv4Protocol, nil)
+		if err != nil {
+			t.Fatalf("failed to get IP, error: %s", err.Error())
+		}
+		ip := ipConfig.Address.IP.String()
+		if allocated[ip] {
+			t.Fatalf("IP %s is allocated twice", ip)
+		}
+		if ip == "192.168.100.1" {
+			t.Fatalf("gateway %s is allocated", ip)
+		}
+		allocated[ip] = true
+	}
+}
+
+func TestAllocator_HashStrategy(t *testing.T) {
+	spec := &lbv1.IPPoolSpec{
+		Ranges:             []lbv1.Range{{Subnet: cClassSubnet}, {Subnet: ipv6Subnet}},
+		AllocationStrategy: lbv1.Hash,
+	}
+	ids := []string{"default/lb1", "default/lb2", "test/lb1"}
+
+	for _, family := range []corev1.IPFamily{corev1.IPv4Protocol, corev1.IPv6Protocol} {
+		for _, id := range ids {
+			// an lb gets the same IP from a new pool which has no allocation history
+			a1, err := newFakeAllocatorWithSpec("hash1", spec)
+			if err != nil {
+				t.Fatalf("failed to create allocator, error: %s", err.Error())
+			}
+			a2, err := newFakeAllocatorWithSpec("hash2", spec)
+			if err != nil {
+				t.Fatalf("failed to create allocator, error: %s", err.Error())
+			}
+
+			ip1, err := a1.Get(id, family, nil)
+			if err != nil {
+				t.Fatalf("failed to get IP, error: %s", err.Error())
+			}
+			ip2, err := a2.Get(id, family, nil)
+			if err != nil {
+				t.Fatalf("failed to get IP, error: %s", err.Error())
+			}
+			if !ip1.Address.IP.Equal(ip2.Address.IP) {
+				t.Errorf("%s gets different %s IPs %s and %s", id, family, ip1.Address.IP, ip2.Address.IP)
+			}
+		}
+	}
+}
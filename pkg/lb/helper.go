@@ -1,5 +1,9 @@
 package lb
 
+import (
+	lbv1 "github.com/harvester/harvester-load-balancer/pkg/apis/loadbalancer.harvesterhci.io/v1beta1"
+)
+
 func NewBackendServers(serverCount int) *BackendServers {
 	cnt := serverCount
 	if cnt < 0 {
@@ -51,3 +55,22 @@ func (bs *BackendServers) SetWithAddressBackendServerCount(cnt int) {
 	}
 	bs.withAddressBackendServerCount = cnt
 }
+
+// IsHealthCheckEnabled returns true if the lb or any of its listeners has a health check
+func IsHealthCheckEnabled(lb *lbv1.LoadBalancer) bool {
+	if IsLBHealthCheckEnabled(lb) {
+		return true
+	}
+	for _, listener := range lb.Spec.Listeners {
+		if listener.HealthCheck != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// IsLBHealthCheckEnabled returns true if the health check of the lb has a port to probe or it is in the vmi mode
+func IsLBHealthCheckEnabled(lb *lbv1.LoadBalancer) bool {
+	hc := lb.Spec.HealthCheck
+	return hc != nil && (hc.Port != 0 || hc.Mode == lbv1.VMIMode)
+}
@@ -3,6 +3,7 @@ package lb
 import (
 	"errors"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
 
 	lbv1 "github.com/harvester/harvester-load-balancer/pkg/apis/loadbalancer.harvesterhci.io/v1beta1"
@@ -15,13 +16,13 @@ type Manager interface {
 	EnsureLoadBalancer(lb *lbv1.LoadBalancer) error
 	DeleteLoadBalancer(lb *lbv1.LoadBalancer) error
 
-	// Step 2. Ensure loadbalancer external IP
-	EnsureLoadBalancerServiceIP(lb *lbv1.LoadBalancer) (string, error)
+	// Step 2. Ensure loadbalancer external IPs, a dual-stack loadbalancer has one IP per IP family
+	EnsureLoadBalancerServiceIP(lb *lbv1.LoadBalancer) ([]string, error)
 
 	// Step 3. Ensure service backend servers
 	EnsureBackendServers(lb *lbv1.LoadBalancer) (*BackendServers, error)
 
-	// []BackendServer: the matched backend servers (not onDeleting, have IPv4 address)
+	// []BackendServer: the matched backend servers (not onDeleting, have address in the IP families of the loadbalancer)
 	// uint32: the matched backend servers count (not onDeleting)
 	ListBackendServers(lb *lbv1.LoadBalancer) (*BackendServers, error)
 
@@ -38,6 +39,7 @@ type BackendServer interface {
 	GetNamespace() string
 	GetName() string
 	GetAddress() (string, bool)
+	GetAddressByFamily(family corev1.IPFamily) (string, bool)
 }
 
 type BackendServers struct {
@@ -3,9 +3,11 @@ package servicelb
 import (
 	"net"
 
+	corev1 "k8s.io/api/core/v1"
 	kubevirtv1 "kubevirt.io/api/core/v1"
 
 	"github.com/harvester/harvester-load-balancer/pkg/lb"
+	"github.com/harvester/harvester-load-balancer/pkg/utils"
 )
 
 type Server struct {
@@ -15,9 +17,21 @@ type Server struct {
 var _ lb.BackendServer = &Server{}
 
 func (s *Server) GetAddress() (string, bool) {
+	return s.GetAddressByFamily(corev1.IPv4Protocol)
+}
+
+// GetAddressByFamily returns the first IP of the IP family among all the interfaces
+func (s *Server) GetAddressByFamily(family corev1.IPFamily) (string, bool) {
 	for _, networkInterface := range s.Status.Interfaces {
-		if ip := net.ParseIP(networkInterface.IP); ip.To4() != nil {
-			return networkInterface.IP, true
+		ips := networkInterface.IPs
+		// the IPs may be empty in the status reported by old versions of kubevirt
+		if len(ips) == 0 {
+			ips = []string{networkInterface.IP}
+		}
+		for _, ipStr := range ips {
+			if ip := net.ParseIP(ipStr); ip != nil && utils.GetIPFamily(ip) == family {
+				return ip.String(), true
+			}
 		}
 	}
 
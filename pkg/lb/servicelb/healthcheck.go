@@ -0,0 +1,262 @@
+package servicelb
+
+import (
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+
+	lbv1 "github.com/harvester/harvester-load-balancer/pkg/apis/loadbalancer.harvesterhci.io/v1beta1"
+	pkglb "github.com/harvester/harvester-load-balancer/pkg/lb"
+)
+
+// healthCheck is one health check of the lb, the workers of the uid probe the port of all the endpoints unless it is
+// in the vmi mode
+type healthCheck struct {
+	uid  string
+	port int32
+	*lbv1.HealthCheck
+}
+
+func (c *healthCheck) needsProbe() bool {
+	return c.Mode != lbv1.VMIMode
+}
+
+func (c *healthCheck) needsVMI() bool {
+	return c.Mode == lbv1.VMIMode || c.Mode == lbv1.ProbeAndVMIMode
+}
+
+// the VMI is healthy if all the expected conditions are true, the Ready condition is expected by default
+func (c *healthCheck) isVMIHealthy(vmi *kubevirtv1.VirtualMachineInstance) bool {
+	conditions := c.VMIConditions
+	if len(conditions) == 0 {
+		conditions = []lbv1.VMICondition{lbv1.VMIReady}
+	}
+	for _, condition := range conditions {
+		condType := kubevirtv1.VirtualMachineInstanceReady
+		if condition == lbv1.VMIAgentConnected {
+			condType = kubevirtv1.VirtualMachineInstanceAgentConnected
+		}
+		if !isVMIConditionTrue(vmi, condType) {
+			return false
+		}
+	}
+	return true
+}
+
+func isVMIConditionTrue(vmi *kubevirtv1.VirtualMachineInstance, condType kubevirtv1.VirtualMachineInstanceConditionType) bool {
+	for _, cond := range vmi.Status.Conditions {
+		if cond.Type == condType {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// endpointGroup is the listeners whose endpoints are in the same endpointslices, the readiness of the endpoints is
+// combined from the results of the health checks per the policy
+type endpointGroup struct {
+	// name is the name of the IPv4 endpointslice, the IPv6 one has the suffix -ipv6
+	name string
+	// listener is empty for the default group
+	listener  string
+	listeners []lbv1.Listener
+	checks    []healthCheck
+	policy    lbv1.HealthCheckPolicy
+}
+
+// the default group contains all the listeners unless the policy is perport, then the listeners with their own
+// health checks are split into their own groups, and the default group is omitted if no listener is left in it
+func getEndpointGroups(lb *lbv1.LoadBalancer) []*endpointGroup {
+	lbCheck := (*healthCheck)(nil)
+	if pkglb.IsLBHealthCheckEnabled(lb) {
+		lbCheck = &healthCheck{
+			uid: marshalUID(lb.Namespace, lb.Name),
+			//#nosec
+			port:        int32(lb.Spec.HealthCheck.Port),
+			HealthCheck: lb.Spec.HealthCheck,
+		}
+	}
+
+	defaultGroup := &endpointGroup{name: lb.Name, policy: lb.Spec.HealthCheckPolicy}
+	groups := []*endpointGroup{defaultGroup}
+	for i := range lb.Spec.Listeners {
+		listener := lb.Spec.Listeners[i]
+		if listener.HealthCheck == nil {
+			defaultGroup.listeners = append(defaultGroup.listeners, listener)
+			continue
+		}
+		check := getListenerHealthCheck(lb, &listener)
+		if lb.Spec.HealthCheckPolicy != lbv1.PerPortHealth {
+			defaultGroup.listeners = append(defaultGroup.listeners, listener)
+			defaultGroup.checks = append(defaultGroup.checks, check)
+			continue
+		}
+		groups = append(groups, &endpointGroup{
+			name:      getListenerEndpointSliceName(lb.Name, listener.Name),
+			listener:  listener.Name,
+			listeners: []lbv1.Listener{listener},
+			checks:    []healthCheck{check},
+			policy:    lbv1.AllHealthy,
+		})
+	}
+	// the endpoints of the empty default group would be always ready, as it has no health check to probe
+	if len(defaultGroup.listeners) == 0 && len(groups) > 1 {
+		return groups[1:]
+	}
+	if lbCheck != nil {
+		defaultGroup.checks = append([]healthCheck{*lbCheck}, defaultGroup.checks...)
+	}
+
+	return groups
+}
+
+func getListenerHealthCheck(lb *lbv1.LoadBalancer, listener *lbv1.Listener) healthCheck {
+	check := healthCheck{
+		uid:         marshalListenerUID(lb.Namespace, lb.Name, listener.Name),
+		port:        listener.BackendPort,
+		HealthCheck: listener.HealthCheck,
+	}
+	if listener.HealthCheck.Port != 0 {
+		//#nosec
+		check.port = int32(listener.HealthCheck.Port)
+	}
+	return check
+}
+
+func (g *endpointGroup) hasCheck(uid string) bool {
+	for _, check := range g.checks {
+		if check.uid == uid {
+			return true
+		}
+	}
+	return false
+}
+
+// healthState records the endpoint groups of the lbs and the results of the health checks, the results are reported
+// by the prober workers while the groups are updated by the controller
+type healthState struct {
+	mutex sync.RWMutex
+	// lb uid -> endpoint groups
+	groups map[string][]*endpointGroup
+	// health check uid -> endpoint ip -> healthy
+	results map[string]map[string]bool
+	// health check uid -> endpoint ip -> the conditions of the VMI are true
+	vmiResults map[string]map[string]bool
+}
+
+func newHealthState() *healthState {
+	return &healthState{
+		groups:     make(map[string][]*endpointGroup),
+		results:    make(map[string]map[string]bool),
+		vmiResults: make(map[string]map[string]bool),
+	}
+}
+
+// setGroups returns the uids of the health checks which are removed from the lb
+func (s *healthState) setGroups(lbUID string, groups []*endpointGroup) []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var removed []string
+	for _, g := range s.groups[lbUID] {
+		for _, check := range g.checks {
+			if !hasCheck(groups, check.uid) {
+				removed = append(removed, check.uid)
+				delete(s.results, check.uid)
+				delete(s.vmiResults, check.uid)
+			}
+		}
+	}
+	s.groups[lbUID] = groups
+
+	return removed
+}
+
+func (s *healthState) getGroups(lbUID string) []*endpointGroup {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.groups[lbUID]
+}
+
+// removeLB returns the uids of all the health checks of the lb
+func (s *healthState) removeLB(lbUID string) []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var removed []string
+	for _, g := range s.groups[lbUID] {
+		for _, check := range g.checks {
+			removed = append(removed, check.uid)
+			delete(s.results, check.uid)
+			delete(s.vmiResults, check.uid)
+		}
+	}
+	delete(s.groups, lbUID)
+
+	return removed
+}
+
+func (s *healthState) setResult(uid, ip string, isHealthy bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.results[uid] == nil {
+		s.results[uid] = make(map[string]bool)
+	}
+	s.results[uid][ip] = isHealthy
+}
+
+// setVMIResults replaces the VMI results of the health check as they are evaluated for all the endpoints at once
+func (s *healthState) setVMIResults(uid string, results map[string]bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.vmiResults[uid] = results
+}
+
+func (s *healthState) removeResult(uid, ip string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.results[uid], ip)
+}
+
+// isReady combines the results of the health checks of the group, the endpoint is not ready before the health check
+// reports the result
+func (s *healthState) isReady(g *endpointGroup, ip string) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if len(g.checks) == 0 {
+		return true
+	}
+	for i := range g.checks {
+		check := &g.checks[i]
+		isHealthy := true
+		if check.needsProbe() {
+			isHealthy = s.results[check.uid][ip]
+		}
+		if check.needsVMI() {
+			isHealthy = isHealthy && s.vmiResults[check.uid][ip]
+		}
+		if g.policy == lbv1.AnyHealthy && isHealthy {
+			return true
+		}
+		if g.policy != lbv1.AnyHealthy && !isHealthy {
+			return false
+		}
+	}
+
+	return g.policy != lbv1.AnyHealthy
+}
+
+func hasCheck(groups []*endpointGroup, uid string) bool {
+	for _, g := range groups {
+		if g.hasCheck(uid) {
+			return true
+		}
+	}
+	return false
+}
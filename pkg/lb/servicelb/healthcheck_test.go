@@ -0,0 +1,426 @@
+package servicelb
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	kubevirtv1 "kubevirt.io/api/core/v1"
+
+	lbv1 "github.com/harvester/harvester-load-balancer/pkg/apis/loadbalancer.harvesterhci.io/v1beta1"
+	"github.com/harvester/harvester-load-balancer/pkg/generated/clientset/versioned/fake"
+	pkglb "github.com/harvester/harvester-load-balancer/pkg/lb"
+	"github.com/harvester/harvester-load-balancer/pkg/prober"
+	"github.com/harvester/harvester-load-balancer/pkg/utils/fakeclients"
+)
+
+func getTestLBWithListenerHealthChecks(policy lbv1.HealthCheckPolicy) *lbv1.LoadBalancer {
+	lb := getTestLB()
+	lb.Spec.HealthCheckPolicy = policy
+	lb.Spec.HealthCheck = &lbv1.HealthCheck{Port: 22}
+	lb.Spec.Listeners = []lbv1.Listener{
+		{Name: "http", Port: 80, Protocol: corev1.ProtocolTCP, BackendPort: 8080, HealthCheck: &lbv1.HealthCheck{Protocol: lbv1.HTTPHealthCheck}},
+		{Name: "dns", Port: 53, Protocol: corev1.ProtocolUDP, BackendPort: 53, HealthCheck: &lbv1.HealthCheck{Port: 5353, Protocol: lbv1.UDPHealthCheck}},
+		{Name: "ssh", Port: 22, Protocol: corev1.ProtocolTCP, BackendPort: 22},
+	}
+	return lb
+}
+
+func TestGetEndpointGroups(t *testing.T) {
+	type group struct {
+		name      string
+		listeners []string
+		checks    map[string]int32
+	}
+	lbUID := marshalUID(testNamespace, testVMName)
+	httpUID := marshalListenerUID(testNamespace, testVMName, "http")
+	dnsUID := marshalListenerUID(testNamespace, testVMName, "dns")
+
+	tests := []struct {
+		name string
+		lb   *lbv1.LoadBalancer
+		want []group
+	}{
+		{
+			name: "all the health checks are in the default group",
+			lb:   getTestLBWithListenerHealthChecks(lbv1.AllHealthy),
+			want: []group{
+				{name: testVMName, listeners: []string{"http", "dns", "ssh"}, checks: map[string]int32{lbUID: 22, httpUID: 8080, dnsUID: 5353}},
+			},
+		},
+		{
+			name: "the listeners with their own health checks are split in the perport policy",
+			lb:   getTestLBWithListenerHealthChecks(lbv1.PerPortHealth),
+			want: []group{
+				{name: testVMName, listeners: []string{"ssh"}, checks: map[string]int32{lbUID: 22}},
+				{name: testVMName + "-listener-http", listeners: []string{"http"}, checks: map[string]int32{httpUID: 8080}},
+				{name: testVMName + "-listener-dns", listeners: []string{"dns"}, checks: map[string]int32{dnsUID: 5353}},
+			},
+		},
+		{
+			name: "the default group is omitted if all the listeners have their own health checks",
+			lb: func() *lbv1.LoadBalancer {
+				lb := getTestLBWithListenerHealthChecks(lbv1.PerPortHealth)
+				lb.Spec.Listeners = lb.Spec.Listeners[:1]
+				return lb
+			}(),
+			want: []group{
+				{name: testVMName + "-listener-http", listeners: []string{"http"}, checks: map[string]int32{httpUID: 8080}},
+			},
+		},
+		{
+			name: "no health check",
+			lb: func() *lbv1.LoadBalancer {
+				lb := getTestLB()
+				lb.Spec.Listeners = []lbv1.Listener{{Name: "ssh", Port: 22, Protocol: corev1.ProtocolTCP, BackendPort: 22}}
+				return lb
+			}(),
+			want: []group{
+				{name: testVMName, listeners: []string{"ssh"}, checks: map[string]int32{}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		groups := getEndpointGroups(tt.lb)
+		got := make([]group, 0, len(groups))
+		for _, g := range groups {
+			gg := group{name: g.name, checks: map[string]int32{}}
+			for _, l := range g.listeners {
+				gg.listeners = append(gg.listeners, l.Name)
+			}
+			for _, c := range g.checks {
+				gg.checks[c.uid] = c.port
+			}
+			got = append(got, gg)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q. getEndpointGroups() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHealthState_IsReady(t *testing.T) {
+	const ip = "192.168.100.10"
+	checks := []healthCheck{{uid: "a", HealthCheck: &lbv1.HealthCheck{}}, {uid: "b", HealthCheck: &lbv1.HealthCheck{}}}
+
+	tests := []struct {
+		name    string
+		policy  lbv1.HealthCheckPolicy
+		results map[string]bool
+		want    bool
+	}{
+		{
+			name:    "all pass",
+			policy:  lbv1.AllHealthy,
+			results: map[string]bool{"a": true, "b": true},
+			want:    true,
+		},
+		{
+			name:    "one of all fails",
+			policy:  lbv1.AllHealthy,
+			results: map[string]bool{"a": true, "b": false},
+		},
+		{
+			name:    "one of all is not probed yet",
+			policy:  "",
+			results: map[string]bool{"a": true},
+		},
+		{
+			name:    "any passes",
+			policy:  lbv1.AnyHealthy,
+			results: map[string]bool{"a": false, "b": true},
+			want:    true,
+		},
+		{
+			name:    "none of any passes",
+			policy:  lbv1.AnyHealthy,
+			results: map[string]bool{"a": false, "b": false},
+		},
+	}
+
+	for _, tt := range tests {
+		s := newHealthState()
+		for uid, isHealthy := range tt.results {
+			s.setResult(uid, ip, isHealthy)
+		}
+		if got := s.isReady(&endpointGroup{checks: checks, policy: tt.policy}, ip); got != tt.want {
+			t.Errorf("%q. isReady() = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+
+	if !newHealthState().isReady(&endpointGroup{}, ip) {
+		t.Errorf("the endpoint of the group without health checks should be ready")
+	}
+}
+
+func TestHealthCheck_IsVMIHealthy(t *testing.T) {
+	ready := kubevirtv1.VirtualMachineInstanceCondition{Type: kubevirtv1.VirtualMachineInstanceReady, Status: corev1.ConditionTrue}
+	agentConnected := kubevirtv1.VirtualMachineInstanceCondition{Type: kubevirtv1.VirtualMachineInstanceAgentConnected, Status: corev1.ConditionTrue}
+	notReady := kubevirtv1.VirtualMachineInstanceCondition{Type: kubevirtv1.VirtualMachineInstanceReady, Status: corev1.ConditionFalse}
+
+	tests := []struct {
+		name             string
+		vmiConditions    []lbv1.VMICondition
+		conditions       []kubevirtv1.VirtualMachineInstanceCondition
+		wantIsVMIHealthy bool
+	}{
+		{
+			name:             "ready by default",
+			conditions:       []kubevirtv1.VirtualMachineInstanceCondition{ready},
+			wantIsVMIHealthy: true,
+		},
+		{
+			name:       "not ready by default",
+			conditions: []kubevirtv1.VirtualMachineInstanceCondition{notReady, agentConnected},
+		},
+		{
+			name:          "no conditions",
+			vmiConditions: []lbv1.VMICondition{lbv1.VMIReady},
+		},
+		{
+			name:          "agent is not connected",
+			vmiConditions: []lbv1.VMICondition{lbv1.VMIReady, lbv1.VMIAgentConnected},
+			conditions:    []kubevirtv1.VirtualMachineInstanceCondition{ready},
+		},
+		{
+			name:             "ready and agent connected",
+			vmiConditions:    []lbv1.VMICondition{lbv1.VMIReady, lbv1.VMIAgentConnected},
+			conditions:       []kubevirtv1.VirtualMachineInstanceCondition{ready, agentConnected},
+			wantIsVMIHealthy: true,
+		},
+	}
+
+	for _, tt := range tests {
+		check := &healthCheck{HealthCheck: &lbv1.HealthCheck{Mode: lbv1.VMIMode, VMIConditions: tt.vmiConditions}}
+		vmi := getTestVM(testNamespace, nil, false)
+		vmi.Status.Conditions = tt.conditions
+		if got := check.isVMIHealthy(vmi); got != tt.wantIsVMIHealthy {
+			t.Errorf("%q. isVMIHealthy() = %t, want %t", tt.name, got, tt.wantIsVMIHealthy)
+		}
+	}
+}
+
+func TestHealthState_IsReadyWithVMI(t *testing.T) {
+	const ip = "192.168.100.10"
+
+	tests := []struct {
+		name        string
+		mode        lbv1.HealthCheckMode
+		probeResult bool
+		vmiResult   bool
+		want        bool
+	}{
+		{
+			name:      "vmi mode ignores the probe",
+			mode:      lbv1.VMIMode,
+			vmiResult: true,
+			want:      true,
+		},
+		{
+			name:        "vmi mode fails",
+			mode:        lbv1.VMIMode,
+			probeResult: true,
+		},
+		{
+			name:        "probe mode ignores the vmi",
+			mode:        lbv1.ProbeMode,
+			probeResult: true,
+			want:        true,
+		},
+		{
+			name:        "probe and vmi both pass",
+			mode:        lbv1.ProbeAndVMIMode,
+			probeResult: true,
+			vmiResult:   true,
+			want:        true,
+		},
+		{
+			name:        "probe passes but vmi fails",
+			mode:        lbv1.ProbeAndVMIMode,
+			probeResult: true,
+		},
+		{
+			name:      "vmi passes but probe fails",
+			mode:      lbv1.ProbeAndVMIMode,
+			vmiResult: true,
+		},
+	}
+
+	for _, tt := range tests {
+		s := newHealthState()
+		s.setResult("a", ip, tt.probeResult)
+		s.setVMIResults("a", map[string]bool{ip: tt.vmiResult})
+		g := &endpointGroup{checks: []healthCheck{{uid: "a", HealthCheck: &lbv1.HealthCheck{Mode: tt.mode}}}}
+		if got := s.isReady(g, ip); got != tt.want {
+			t.Errorf("%q. isReady() = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestManager_VMIModeEndpointConditions(t *testing.T) {
+	const ip = "192.168.100.10"
+	clientset := fake.NewSimpleClientset()
+	m := &Manager{
+		endpointSliceClient: fakeclients.EndpointSliceClient(clientset.DiscoveryV1().EndpointSlices),
+		endpointSliceCache:  fakeclients.EndpointSliceCache(clientset.DiscoveryV1().EndpointSlices),
+		vmiCache:            fakeclients.VirtualMachineInstanceCache(clientset.KubevirtV1().VirtualMachineInstances),
+		health:              newHealthState(),
+	}
+	m.Manager = prober.NewManager(context.Background(), m.updateHealthCondition)
+
+	vmi := getTestVM(testNamespace, []kubevirtv1.VirtualMachineInstanceNetworkInterface{{Name: "eth0", IP: ip}}, false)
+	vmi.Status.Conditions = []kubevirtv1.VirtualMachineInstanceCondition{
+		{Type: kubevirtv1.VirtualMachineInstanceReady, Status: corev1.ConditionTrue},
+	}
+	vmi, err := clientset.KubevirtV1().VirtualMachineInstances(testNamespace).Create(context.TODO(), vmi, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lb := getTestLB()
+	lb.Spec.Listeners = []lbv1.Listener{{Name: "ssh", Port: 22, Protocol: corev1.ProtocolTCP, BackendPort: 22}}
+	lb.Spec.HealthCheck = &lbv1.HealthCheck{
+		Mode:          lbv1.VMIMode,
+		VMIConditions: []lbv1.VMICondition{lbv1.VMIReady, lbv1.VMIAgentConnected},
+	}
+	servers := []pkglb.BackendServer{&Server{VirtualMachineInstance: vmi}}
+
+	isReady := func() bool {
+		groups := getEndpointGroups(lb)
+		eps, err := m.ensureEndpointSlice(lb, groups[0], corev1.IPv4Protocol, servers)
+		if err != nil {
+			t.Fatalf("ensure endpointslice failed, error: %v", err)
+		}
+		epsList := [][]*discoveryv1.EndpointSlice{{eps}}
+		if err := m.ensureProbes(lb, groups, epsList); err != nil {
+			t.Fatalf("ensure probes failed, error: %v", err)
+		}
+		if options, _ := m.GetWorkerHealthOptionMap(marshalUID(lb.Namespace, lb.Name)); len(options) > 0 {
+			t.Errorf("no prober is expected in the vmi mode")
+		}
+		return isEndpointConditionsReady(&epsList[0][0].Endpoints[0].Conditions)
+	}
+
+	if isReady() {
+		t.Errorf("the endpoint should not be ready before the guest agent is connected")
+	}
+
+	vmi.Status.Conditions = append(vmi.Status.Conditions, kubevirtv1.VirtualMachineInstanceCondition{
+		Type: kubevirtv1.VirtualMachineInstanceAgentConnected, Status: corev1.ConditionTrue,
+	})
+	if vmi, err = clientset.KubevirtV1().VirtualMachineInstances(testNamespace).Update(context.TODO(), vmi, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	servers = []pkglb.BackendServer{&Server{VirtualMachineInstance: vmi}}
+	if !isReady() {
+		t.Errorf("the endpoint should be ready after the guest agent is connected")
+	}
+
+	if err := clientset.KubevirtV1().VirtualMachineInstances(testNamespace).Delete(context.TODO(), vmi.Name, metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if isReady() {
+		t.Errorf("the endpoint of the missing vmi should not be ready")
+	}
+}
+
+func TestManager_PerPortEndpointSlices(t *testing.T) {
+	const ip = "192.168.100.10"
+	clientset := fake.NewSimpleClientset()
+	m := &Manager{
+		endpointSliceClient: fakeclients.EndpointSliceClient(clientset.DiscoveryV1().EndpointSlices),
+		endpointSliceCache:  fakeclients.EndpointSliceCache(clientset.DiscoveryV1().EndpointSlices),
+		health:              newHealthState(),
+	}
+	lb := getTestLBWithListenerHealthChecks(lbv1.PerPortHealth)
+	servers := []pkglb.BackendServer{&Server{VirtualMachineInstance: getTestVM(testNamespace,
+		[]kubevirtv1.VirtualMachineInstanceNetworkInterface{{Name: "eth0", IP: ip}}, false)}}
+
+	ensureEndpointSlices := func(lb *lbv1.LoadBalancer) []*endpointGroup {
+		groups := getEndpointGroups(lb)
+		for _, g := range groups {
+			if _, err := m.ensureEndpointSlice(lb, g, corev1.IPv4Protocol, servers); err != nil {
+				t.Fatalf("ensure endpointslice %s failed, error: %v", g.name, err)
+			}
+		}
+		if err := m.removeStaleEndpointSlices(lb, groups, []corev1.IPFamily{corev1.IPv4Protocol}); err != nil {
+			t.Fatalf("remove stale endpointslices failed, error: %v", err)
+		}
+		m.health.setGroups(marshalUID(lb.Namespace, lb.Name), groups)
+		return groups
+	}
+	isReady := func(epsName string) bool {
+		eps, err := m.endpointSliceCache.Get(lb.Namespace, epsName)
+		if err != nil {
+			t.Fatalf("get endpointslice %s failed, error: %v", epsName, err)
+		}
+		return isEndpointConditionsReady(&eps.Endpoints[0].Conditions)
+	}
+
+	ensureEndpointSlices(lb)
+	httpEPS, err := m.endpointSliceCache.Get(lb.Namespace, getListenerEndpointSliceName(lb.Name, "http"))
+	if err != nil {
+		t.Fatalf("the endpointslice of listener http is not created, error: %v", err)
+	}
+	if len(httpEPS.Ports) != 1 || *httpEPS.Ports[0].Name != "http" || httpEPS.Labels[KeyListener] != "http" {
+		t.Errorf("the endpointslice of listener http is wrong: %+v", httpEPS)
+	}
+
+	// the failing http health check only makes the endpoint of the http listener not ready
+	if err := m.updateHealthCondition(marshalListenerUID(lb.Namespace, lb.Name, "http"), ip+":8080", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.updateHealthCondition(marshalListenerUID(lb.Namespace, lb.Name, "dns"), ip+":5353", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.updateHealthCondition(marshalUID(lb.Namespace, lb.Name), ip+":22", true); err != nil {
+		t.Fatal(err)
+	}
+	if isReady(getListenerEndpointSliceName(lb.Name, "http")) {
+		t.Errorf("the endpoint of listener http should not be ready")
+	}
+	if !isReady(getListenerEndpointSliceName(lb.Name, "dns")) {
+		t.Errorf("the endpoint of listener dns should be ready")
+	}
+	if !isReady(lb.Name) {
+		t.Errorf("the endpoint of listener ssh should be ready")
+	}
+
+	// the default endpointslice is removed if all the listeners have their own health checks, and the endpoint
+	// whose health checks all fail is not counted
+	lb.Spec.Listeners = lb.Spec.Listeners[:1]
+	ensureEndpointSlices(lb)
+	if _, err := m.endpointSliceCache.Get(lb.Namespace, lb.Name); err == nil {
+		t.Errorf("the default endpointslice should be removed")
+	}
+	if count, err := m.GetProbeReadyBackendServerCount(lb); err != nil || count != 0 {
+		t.Errorf("GetProbeReadyBackendServerCount() = %d, %v, want 0", count, err)
+	}
+	lb = getTestLBWithListenerHealthChecks(lbv1.PerPortHealth)
+	ensureEndpointSlices(lb)
+
+	// the endpointslices of the listeners are removed when the policy is not perport
+	lb.Spec.HealthCheckPolicy = lbv1.AnyHealthy
+	ensureEndpointSlices(lb)
+	list, err := m.endpointSliceCache.List(lb.Namespace, labels.Everything())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Name != lb.Name || len(list[0].Ports) != 3 {
+		t.Errorf("only the default endpointslice with all the listeners is expected, got %+v", list)
+	}
+	// any passing health check makes the endpoint ready
+	if err := m.updateHealthCondition(marshalListenerUID(lb.Namespace, lb.Name, "http"), ip+":8080", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.updateHealthCondition(marshalListenerUID(lb.Namespace, lb.Name, "dns"), ip+":5353", true); err != nil {
+		t.Fatal(err)
+	}
+	if !isReady(lb.Name) {
+		t.Errorf("the endpoint should be ready in the any policy")
+	}
+}
@@ -3,6 +3,7 @@ package servicelb
 import (
 	"context"
 	"fmt"
+	"net"
 	"reflect"
 	"slices"
 	"strconv"
@@ -15,6 +16,7 @@ import (
 	discoveryv1 "k8s.io/api/discovery/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/util/intstr"
 
 	"github.com/harvester/harvester-load-balancer/pkg/apis/loadbalancer.harvesterhci.io"
@@ -29,6 +31,8 @@ import (
 const (
 	KeyLabel       = loadbalancer.GroupName + "/servicelb"
 	KeyServiceName = "kubernetes.io/service-name"
+	// KeyListener labels the endpointslice of the listener with its own health check in the perport policy
+	KeyListener = loadbalancer.GroupName + "/listener"
 
 	defaultSuccessThreshold = 1
 	defaultFailureThreshold = 3
@@ -43,6 +47,7 @@ type Manager struct {
 	endpointSliceCache  ctldiscoveryv1.EndpointSliceCache
 	vmiCache            ctlkubevirtv1.VirtualMachineInstanceCache
 	healthHandler       pkglb.HealthCheckHandler
+	health              *healthState
 	*prober.Manager
 }
 
@@ -57,6 +62,7 @@ func NewManager(ctx context.Context, serviceClient ctlCorev1.ServiceClient, serv
 		endpointSliceClient: endpointSliceClient,
 		endpointSliceCache:  endpointSliceCache,
 		vmiCache:            vmiCache,
+		health:              newHealthState(),
 	}
 	m.Manager = prober.NewManager(ctx, m.updateHealthCondition)
 
@@ -69,26 +75,46 @@ func (m *Manager) updateHealthCondition(uid, address string, isHealthy bool) err
 		return err
 	}
 
-	eps, err := m.endpointSliceCache.Get(ns, name)
-	if err != nil && !errors.IsNotFound(err) {
-		return fmt.Errorf("fail to get endpointslice %s/%s, error: %w", ns, name, err)
-	} else if errors.IsNotFound(err) {
-		logrus.Warnf("endpointSlice %s/%s is not found", ns, name)
-		return nil
-	}
-
 	ip, _, err := unMarshalPorberAddress(address)
 	if err != nil {
 		return err
 	}
 
+	m.health.setResult(uid, ip, isHealthy)
+	for _, g := range m.health.getGroups(marshalUID(ns, name)) {
+		if !g.hasCheck(uid) {
+			continue
+		}
+		if err := m.updateEndpointCondition(ns, name, g, ip); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// update the Ready condition of the endpoint per the combined result of the health checks of the group
+func (m *Manager) updateEndpointCondition(ns, name string, g *endpointGroup, ip string) error {
+	uid := marshalUID(ns, name)
+	isReady := m.health.isReady(g, ip)
+
+	// the endpoints of different IP families are in different endpointslices
+	epsName := getEndpointSliceName(g.name, utils.GetIPFamilyFromString(ip))
+	eps, err := m.endpointSliceCache.Get(ns, epsName)
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("fail to get endpointslice %s/%s, error: %w", ns, epsName, err)
+	} else if errors.IsNotFound(err) {
+		logrus.Warnf("endpointSlice %s/%s is not found", ns, epsName)
+		return nil
+	}
+
 	for i := range eps.Endpoints {
 		if len(eps.Endpoints[i].Addresses) != 1 {
 			return fmt.Errorf("the length of lb %s endpoint addresses is %v, endpoint: %+v", uid, len(eps.Endpoints[i].Addresses), eps.Endpoints[i])
 		}
 		if eps.Endpoints[i].Addresses[0] == ip {
 			// only update the Ready condition when necessary
-			if needUpdateEndpointConditions(&eps.Endpoints[i].Conditions, isHealthy) {
+			if needUpdateEndpointConditions(&eps.Endpoints[i].Conditions, isReady) {
 				// notify controller that some endpoint conditions change ( success <---> fail)
 				// otherweise, the controller needs to watch all endpointslice object to know the health probe result on time
 				// or the controller actively loop Enqueue all lbs which enables health check
@@ -98,10 +124,10 @@ func (m *Manager) updateHealthCondition(uid, address string, isHealthy bool) err
 					}
 				}
 				epsCopy := eps.DeepCopy()
-				updateEndpointConditions(&epsCopy.Endpoints[i].Conditions, isHealthy)
-				logrus.Infof("update condition of lb %s endpoint ip %s to %t", uid, ip, isHealthy)
+				updateEndpointConditions(&epsCopy.Endpoints[i].Conditions, isReady)
+				logrus.Infof("update condition of lb %s endpointslice %s endpoint ip %s to %t", uid, epsName, ip, isReady)
 				if _, err := m.endpointSliceClient.Update(epsCopy); err != nil {
-					return fmt.Errorf("fail to update condition of lb %s endpoint ip %s to %t, error: %w", uid, ip, isHealthy, err)
+					return fmt.Errorf("fail to update condition of lb %s endpointslice %s endpoint ip %s to %t, error: %w", uid, epsName, ip, isReady, err)
 				}
 			}
 			break
@@ -148,25 +174,32 @@ func (m *Manager) updateAllConditions(lb *lbv1.LoadBalancer, eps *discoveryv1.En
 }
 
 // if probe is disabled, then return the endpint count
+// the endpoints of all the IP families of the lb are counted
+// in the perport policy, the endpoint is counted if it is ready for any listener
 func (m *Manager) GetProbeReadyBackendServerCount(lb *lbv1.LoadBalancer) (int, error) {
-	eps, err := m.endpointSliceCache.Get(lb.Namespace, lb.Name)
-	if err != nil && !errors.IsNotFound(err) {
-		return 0, err
-	} else if errors.IsNotFound(err) {
-		logrus.Warnf("lb %s/%s endpointSlice is not found", lb.Namespace, lb.Name)
-		return 0, err
-	}
+	ready := make(map[string]struct{})
+	for _, family := range utils.GetIPFamilies(lb.Spec.IPFamilyPolicy) {
+		for _, g := range getEndpointGroups(lb) {
+			epsName := getEndpointSliceName(g.name, family)
+			eps, err := m.endpointSliceCache.Get(lb.Namespace, epsName)
+			if err != nil && !errors.IsNotFound(err) {
+				return 0, err
+			} else if errors.IsNotFound(err) {
+				logrus.Warnf("lb %s/%s endpointSlice %s is not found", lb.Namespace, lb.Name, epsName)
+				return 0, err
+			}
 
-	count := 0
-	// if use `for _, ep := range eps.Endpoints`
-	// get: G601: Implicit memory aliasing in for loop. (gosec)
-	for i := range eps.Endpoints {
-		if !isDummyEndpoint(&eps.Endpoints[i]) && isEndpointConditionsReady(&eps.Endpoints[i].Conditions) {
-			count++
+			// if use `for _, ep := range eps.Endpoints`
+			// get: G601: Implicit memory aliasing in for loop. (gosec)
+			for i := range eps.Endpoints {
+				if !isDummyEndpoint(&eps.Endpoints[i]) && isEndpointConditionsReady(&eps.Endpoints[i].Conditions) {
+					ready[eps.Endpoints[i].Addresses[0]] = struct{}{}
+				}
+			}
 		}
 	}
 
-	return count, nil
+	return len(ready), nil
 }
 
 func (m *Manager) EnsureLoadBalancer(lb *lbv1.LoadBalancer) error {
@@ -212,6 +245,7 @@ func (m *Manager) getServiceBackendServers(lb *lbv1.LoadBalancer) (*pkglb.Backen
 	}
 
 	servers := pkglb.NewBackendServers(len(vmis))
+	families := utils.GetIPFamilies(lb.Spec.IPFamilyPolicy)
 	matchedCnt := 0
 	qualifiedCnt := 0
 	for _, vmi := range vmis {
@@ -220,9 +254,13 @@ func (m *Manager) getServiceBackendServers(lb *lbv1.LoadBalancer) (*pkglb.Backen
 		}
 		matchedCnt += 1
 		newServer := &Server{VirtualMachineInstance: vmi}
-		if _, ok := newServer.GetAddress(); ok {
-			servers.Append(newServer)
-			qualifiedCnt += 1
+		// the server is qualified if it has an address in any IP family of the lb
+		for _, family := range families {
+			if _, ok := newServer.GetAddressByFamily(family); ok {
+				servers.Append(newServer)
+				qualifiedCnt += 1
+				break
+			}
 		}
 	}
 	servers.SetMatchedBackendServerCount(matchedCnt)
@@ -238,21 +276,57 @@ func (m *Manager) EnsureBackendServers(lb *lbv1.LoadBalancer) (*pkglb.BackendSer
 		return nil, fmt.Errorf("service is not existing, ensure it first")
 	}
 
-	var eps *discoveryv1.EndpointSlice
-	eps, err := m.endpointSliceCache.Get(lb.Namespace, lb.Name)
+	servers, err := m.getServiceBackendServers(lb)
 	if err != nil {
-		if !errors.IsNotFound(err) {
-			return nil, fmt.Errorf("fail to get endpointslice, error: %w", err)
+		return nil, err
+	}
+
+	// one endpointslice per IP family as the address type of an endpointslice is immutable
+	// and one per endpoint group as the readiness of the endpoints differs between the groups
+	families := utils.GetIPFamilies(lb.Spec.IPFamilyPolicy)
+	groups := getEndpointGroups(lb)
+	epsList := make([][]*discoveryv1.EndpointSlice, len(groups))
+	for i, g := range groups {
+		for _, family := range families {
+			eps, err := m.ensureEndpointSlice(lb, g, family, servers.GetBackendServers())
+			if err != nil {
+				return nil, err
+			}
+			epsList[i] = append(epsList[i], eps)
 		}
-		eps = nil
+	}
+	if err := m.removeStaleEndpointSlices(lb, groups, families); err != nil {
+		return nil, err
 	}
 
-	servers, err := m.getServiceBackendServers(lb)
+	// always ensure probs
+	if err := m.ensureProbes(lb, groups, epsList); err != nil {
+		return nil, fmt.Errorf("fail to ensure probs, error: %w", err)
+	}
+
+	// always ensure dummy endpoint
+	for i := range groups {
+		for j, eps := range epsList[i] {
+			if err := m.ensureDummyEndpoint(lb, eps, families[j]); err != nil {
+				return nil, fmt.Errorf("fail to ensure dummy endpointslice, error: %w", err)
+			}
+		}
+	}
+
+	return servers, nil
+}
+
+func (m *Manager) ensureEndpointSlice(lb *lbv1.LoadBalancer, g *endpointGroup, family corev1.IPFamily, servers []pkglb.BackendServer) (*discoveryv1.EndpointSlice, error) {
+	epsName := getEndpointSliceName(g.name, family)
+	eps, err := m.endpointSliceCache.Get(lb.Namespace, epsName)
 	if err != nil {
-		return nil, err
+		if !errors.IsNotFound(err) {
+			return nil, fmt.Errorf("fail to get endpointslice, error: %w", err)
+		}
+		eps = nil
 	}
 
-	epsNew, err := m.constructEndpointSliceFromBackendServers(eps, lb, servers.GetBackendServers())
+	epsNew, err := m.constructEndpointSliceFromBackendServers(eps, lb, g, family, servers)
 	if err != nil {
 		return nil, err
 	}
@@ -266,7 +340,7 @@ func (m *Manager) EnsureBackendServers(lb *lbv1.LoadBalancer) (*pkglb.BackendSer
 		}
 	} else {
 		if !reflect.DeepEqual(eps, epsNew) {
-			logrus.Debugf("update endpointslice %s/%s", lb.Namespace, lb.Name)
+			logrus.Debugf("update endpointslice %s/%s", lb.Namespace, epsName)
 			eps, err = m.endpointSliceClient.Update(epsNew)
 			if err != nil {
 				return nil, fmt.Errorf("fail to update endpointslice, error: %w", err)
@@ -274,68 +348,195 @@ func (m *Manager) EnsureBackendServers(lb *lbv1.LoadBalancer) (*pkglb.BackendSer
 		}
 	}
 
-	// always ensure probs
-	if err := m.ensureProbes(lb, eps); err != nil {
-		return nil, fmt.Errorf("fail to ensure probs, error: %w", err)
+	return eps, nil
+}
+
+// the endpointslices of the listeners are removed if the listeners are removed, have no health checks any more
+// or the policy is not perport any more, the default ones are removed if the default group is omitted
+func (m *Manager) removeStaleEndpointSlices(lb *lbv1.LoadBalancer, groups []*endpointGroup, families []corev1.IPFamily) error {
+	selector, err := labels.Parse(KeyServiceName + "=" + lb.Name)
+	if err != nil {
+		return err
+	}
+	epsList, err := m.endpointSliceCache.List(lb.Namespace, selector)
+	if err != nil {
+		return fmt.Errorf("fail to list endpointslices of lb %s/%s, error: %w", lb.Namespace, lb.Name, err)
 	}
 
-	// always ensure dummy endpoint
-	if err := m.ensureDummyEndpoint(lb, eps); err != nil {
-		return nil, fmt.Errorf("fail to ensure dummy endpointslice, error: %w", err)
+	expected := make(map[string]struct{}, len(groups)*len(families))
+	for _, g := range groups {
+		for _, family := range families {
+			expected[getEndpointSliceName(g.name, family)] = struct{}{}
+		}
+	}
+	defaults := make(map[string]struct{}, len(families))
+	for _, family := range families {
+		defaults[getEndpointSliceName(lb.Name, family)] = struct{}{}
+	}
+	for _, eps := range epsList {
+		if _, ok := expected[eps.Name]; ok {
+			continue
+		}
+		if _, ok := defaults[eps.Name]; !ok && eps.Labels[KeyListener] == "" {
+			continue
+		}
+		logrus.Infof("remove stale endpointslice %s/%s of lb %s", eps.Namespace, eps.Name, lb.Name)
+		if err := m.endpointSliceClient.Delete(eps.Namespace, eps.Name, &metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("fail to delete endpointslice %s/%s, error: %w", eps.Namespace, eps.Name, err)
+		}
 	}
 
-	return servers, nil
+	return nil
 }
 
-func (m *Manager) EnsureLoadBalancerServiceIP(lb *lbv1.LoadBalancer) (string, error) {
+func (m *Manager) EnsureLoadBalancerServiceIP(lb *lbv1.LoadBalancer) ([]string, error) {
 	// ensure service is existing
 	svc, err := m.getService(lb)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	if svc == nil {
-		return "", fmt.Errorf("service is not existing, ensure it first")
+		return nil, fmt.Errorf("service is not existing, ensure it first")
 	}
 	// kube-vip will update this field, wait if not existing
-	if len(svc.Status.LoadBalancer.Ingress) > 0 {
-		return svc.Status.LoadBalancer.Ingress[0].IP, nil
+	ips := make([]string, 0, len(svc.Status.LoadBalancer.Ingress))
+	for _, ingress := range svc.Status.LoadBalancer.Ingress {
+		if ingress.IP != "" {
+			ips = append(ips, ingress.IP)
+		}
+	}
+	if len(ips) > 0 {
+		return ips, nil
 	}
 	// no ip, wait
-	return "", pkglb.ErrWaitExternalIP
+	return nil, pkglb.ErrWaitExternalIP
 }
 
 func (m *Manager) ListBackendServers(lb *lbv1.LoadBalancer) (*pkglb.BackendServers, error) {
 	return m.getServiceBackendServers(lb)
 }
 
-func (m *Manager) ensureProbes(lb *lbv1.LoadBalancer, eps *discoveryv1.EndpointSlice) error {
-	// disabled
-	if lb.Spec.HealthCheck == nil || lb.Spec.HealthCheck.Port == 0 {
-		if _, err := m.removeLBProbers(lb); err != nil {
+func (m *Manager) ensureProbes(lb *lbv1.LoadBalancer, groups []*endpointGroup, epsList [][]*discoveryv1.EndpointSlice) error {
+	// stop probing the removed health checks
+	for _, uid := range m.health.setGroups(marshalUID(lb.Namespace, lb.Name), groups) {
+		if _, err := m.RemoveWorkersByUid(uid); err != nil {
 			return err
 		}
-		// user may disable the healthy checker e.g. it is not working as expected
-		// then set all endpoints to be Ready thus they can continue to work
-		if err := m.updateAllConditions(lb, eps, true); err != nil {
-			return err
-		}
-		return nil
 	}
 
-	uid := marshalUID(lb.Namespace, lb.Name)
-	targetProbers := make(map[string]prober.HealthOption)
-	// indexing to skip G601 in go v121
-	for i := range eps.Endpoints {
-		if len(eps.Endpoints[i].Addresses) == 0 || isDummyEndpoint(&eps.Endpoints[i]) {
+	for i, g := range groups {
+		// disabled
+		if len(g.checks) == 0 {
+			// user may disable the healthy checker e.g. it is not working as expected
+			// then set all endpoints to be Ready thus they can continue to work
+			for _, eps := range epsList[i] {
+				if err := m.updateAllConditions(lb, eps, true); err != nil {
+					return err
+				}
+			}
 			continue
 		}
-		targetProbers[marshalPorberAddress(lb, &eps.Endpoints[i])] = m.generateOneProber(lb, &eps.Endpoints[i])
+
+		hasVMICheck := false
+		for k := range g.checks {
+			check := &g.checks[k]
+			if check.needsVMI() {
+				if err := m.evaluateVMIConditions(check, epsList[i]); err != nil {
+					return err
+				}
+				hasVMICheck = true
+			}
+			if !check.needsProbe() {
+				// the vmi mode needs no workers, stop the ones started in the previous mode
+				if _, err := m.RemoveWorkersByUid(check.uid); err != nil {
+					return err
+				}
+				continue
+			}
+
+			targetProbers := make(map[string]prober.HealthOption)
+			for _, eps := range epsList[i] {
+				// indexing to skip G601 in go v121
+				for j := range eps.Endpoints {
+					if len(eps.Endpoints[j].Addresses) == 0 || isDummyEndpoint(&eps.Endpoints[j]) {
+						continue
+					}
+					option, err := m.generateOneProber(lb, check, &eps.Endpoints[j])
+					if err != nil {
+						return err
+					}
+					targetProbers[option.Address] = option
+				}
+			}
+
+			// get a copy of data for safe operation
+			activeProbers, _ := m.GetWorkerHealthOptionMap(check.uid)
+
+			if err := m.updateAllProbers(check.uid, activeProbers, targetProbers); err != nil {
+				return err
+			}
+		}
+
+		// the workers update the conditions when they report, while the VMI results change with the VMIs
+		if hasVMICheck {
+			for j, eps := range epsList[i] {
+				epsNew, err := m.updateGroupConditions(lb, g, eps)
+				if err != nil {
+					return err
+				}
+				epsList[i][j] = epsNew
+			}
+		}
 	}
 
-	// get a copy of data for safe operation
-	activeProbers, _ := m.GetWorkerHealthOptionMap(uid)
+	return nil
+}
+
+// the VMI conditions are evaluated every time the lb is enqueued, the VMI controller enqueues the lb when its VMIs change
+func (m *Manager) evaluateVMIConditions(check *healthCheck, epsList []*discoveryv1.EndpointSlice) error {
+	results := make(map[string]bool)
+	for _, eps := range epsList {
+		for i := range eps.Endpoints {
+			ep := &eps.Endpoints[i]
+			if len(ep.Addresses) == 0 || ep.TargetRef == nil || isDummyEndpoint(ep) {
+				continue
+			}
+			vmi, err := m.vmiCache.Get(ep.TargetRef.Namespace, ep.TargetRef.Name)
+			if err != nil && !errors.IsNotFound(err) {
+				return fmt.Errorf("fail to get vmi %s/%s, error: %w", ep.TargetRef.Namespace, ep.TargetRef.Name, err)
+			}
+			results[ep.Addresses[0]] = err == nil && check.isVMIHealthy(vmi)
+		}
+	}
+	m.health.setVMIResults(check.uid, results)
+
+	return nil
+}
+
+// update the Ready conditions of all the endpoints per the combined results of the health checks of the group
+func (m *Manager) updateGroupConditions(lb *lbv1.LoadBalancer, g *endpointGroup, eps *discoveryv1.EndpointSlice) (*discoveryv1.EndpointSlice, error) {
+	epsCopy := eps.DeepCopy()
+	updated := false
+	for i := range epsCopy.Endpoints {
+		ep := &epsCopy.Endpoints[i]
+		if len(ep.Addresses) == 0 || isDummyEndpoint(ep) {
+			continue
+		}
+		if isReady := m.health.isReady(g, ep.Addresses[0]); needUpdateEndpointConditions(&ep.Conditions, isReady) {
+			updateEndpointConditions(&ep.Conditions, isReady)
+			updated = true
+		}
+	}
 
-	return m.updateAllProbers(uid, activeProbers, targetProbers)
+	if !updated {
+		return eps, nil
+	}
+	logrus.Infof("update conditions of lb %s/%s endpointslice %s", lb.Namespace, lb.Name, eps.Name)
+	epsNew, err := m.endpointSliceClient.Update(epsCopy)
+	if err != nil {
+		return nil, fmt.Errorf("fail to update conditions of lb %s/%s endpointslice %s, error: %w", lb.Namespace, lb.Name, eps.Name, err)
+	}
+	return epsNew, nil
 }
 
 // according to the activeProbers and targetProbers
@@ -354,7 +555,7 @@ func (m *Manager) updateAllProbers(uid string, activeProbers, targetProbers map[
 					return err
 				}
 				// add target one
-				if err := m.AddWorker(uid, tp.Address, tp); err != nil {
+				if err := m.addWorker(uid, tp); err != nil {
 					return err
 				}
 			}
@@ -371,12 +572,15 @@ func (m *Manager) updateAllProbers(uid string, activeProbers, targetProbers map[
 		if _, err := m.RemoveWorker(uid, ap.Address); err != nil {
 			return err
 		}
+		if ip, _, err := unMarshalPorberAddress(ap.Address); err == nil {
+			m.health.removeResult(uid, ip)
+		}
 	}
 
 	// add all remainings of targetProbers
 	for _, tp := range targetProbers {
 		logrus.Debugf("+probe %s %s", uid, tp.Address)
-		if err := m.AddWorker(uid, tp.Address, tp); err != nil {
+		if err := m.addWorker(uid, tp); err != nil {
 			return err
 		}
 	}
@@ -384,8 +588,21 @@ func (m *Manager) updateAllProbers(uid string, activeProbers, targetProbers map[
 	return nil
 }
 
+// the result of the new worker starts from the initial condition until it is probed
+func (m *Manager) addWorker(uid string, option prober.HealthOption) error {
+	if err := m.AddWorker(uid, option.Address, option); err != nil {
+		return err
+	}
+	ip, _, err := unMarshalPorberAddress(option.Address)
+	if err != nil {
+		return err
+	}
+	m.health.setResult(uid, ip, option.InitialCondition)
+	return nil
+}
+
 // without at least one Ready (dummy) endpoint, the service may route traffic to local host
-func (m *Manager) ensureDummyEndpoint(lb *lbv1.LoadBalancer, eps *discoveryv1.EndpointSlice) error {
+func (m *Manager) ensureDummyEndpoint(lb *lbv1.LoadBalancer, eps *discoveryv1.EndpointSlice, family corev1.IPFamily) error {
 	dummyCount := 0
 	activeCount := 0
 	// if use `for _, ep := range eps.Endpoints`
@@ -401,7 +618,7 @@ func (m *Manager) ensureDummyEndpoint(lb *lbv1.LoadBalancer, eps *discoveryv1.En
 	// add the dummy endpoint
 	if activeCount == 0 && dummyCount == 0 {
 		epsCopy := eps.DeepCopy()
-		epsCopy.Endpoints = appendDummyEndpoint(epsCopy.Endpoints, lb)
+		epsCopy.Endpoints = appendDummyEndpoint(epsCopy.Endpoints, lb, family)
 		if _, err := m.endpointSliceClient.Update(epsCopy); err != nil {
 			return fmt.Errorf("fail to append dummy endpoint to lb %v endpoint, error: %w", lb.Name, err)
 		}
@@ -424,53 +641,107 @@ func (m *Manager) ensureDummyEndpoint(lb *lbv1.LoadBalancer, eps *discoveryv1.En
 }
 
 func (m *Manager) removeLBProbers(lb *lbv1.LoadBalancer) (int, error) {
-	return m.RemoveWorkersByUid(marshalUID(lb.Namespace, lb.Name))
+	uid := marshalUID(lb.Namespace, lb.Name)
+	cnt, err := m.RemoveWorkersByUid(uid)
+	if err != nil {
+		return cnt, err
+	}
+	for _, checkUID := range m.health.removeLB(uid) {
+		if checkUID == uid {
+			continue
+		}
+		n, err := m.RemoveWorkersByUid(checkUID)
+		if err != nil {
+			return cnt, err
+		}
+		cnt += n
+	}
+	return cnt, nil
 }
 
-func (m *Manager) generateOneProber(lb *lbv1.LoadBalancer, ep *discoveryv1.Endpoint) prober.HealthOption {
+func (m *Manager) generateOneProber(lb *lbv1.LoadBalancer, check *healthCheck, ep *discoveryv1.Endpoint) (prober.HealthOption, error) {
 	option := prober.HealthOption{
-		Address:          marshalPorberAddress(lb, ep),
+		Address:          marshalPorberAddress(ep, check.port),
 		InitialCondition: true,
+		Protocol:         prober.TCP,
 	}
-	if lb.Spec.HealthCheck.SuccessThreshold == 0 {
+	if check.SuccessThreshold == 0 {
 		option.SuccessThreshold = defaultSuccessThreshold
 	} else {
-		option.SuccessThreshold = lb.Spec.HealthCheck.SuccessThreshold
+		option.SuccessThreshold = check.SuccessThreshold
 	}
-	if lb.Spec.HealthCheck.FailureThreshold == 0 {
+	if check.FailureThreshold == 0 {
 		option.FailureThreshold = defaultFailureThreshold
 	} else {
-		option.FailureThreshold = lb.Spec.HealthCheck.FailureThreshold
+		option.FailureThreshold = check.FailureThreshold
 	}
-	if lb.Spec.HealthCheck.TimeoutSeconds == 0 {
+	if check.TimeoutSeconds == 0 {
 		option.Timeout = defaultTimeout
 	} else {
 		// escape gosec error: G115: integer overflow conversion uint -> int64 (gosec)
 		//#nosec
-		option.Timeout = time.Duration(lb.Spec.HealthCheck.TimeoutSeconds) * time.Second
+		option.Timeout = time.Duration(check.TimeoutSeconds) * time.Second
 	}
-	if lb.Spec.HealthCheck.PeriodSeconds == 0 {
+	if check.PeriodSeconds == 0 {
 		option.Period = defaultPeriod
 	} else {
 		//#nosec
-		option.Period = time.Duration(lb.Spec.HealthCheck.PeriodSeconds) * time.Second
+		option.Period = time.Duration(check.PeriodSeconds) * time.Second
 	}
 	if ep.Conditions.Ready != nil {
 		option.InitialCondition = *ep.Conditions.Ready
 	}
-	return option
+	switch hc := check.HealthCheck; hc.Protocol {
+	case lbv1.HTTPHealthCheck, lbv1.HTTPSHealthCheck:
+		statuses, err := prober.ParseStatusRanges(hc.ExpectedStatuses)
+		if err != nil {
+			return option, fmt.Errorf("fail to parse expected statuses of lb %s/%s, error: %w", lb.Namespace, lb.Name, err)
+		}
+		option.Protocol = prober.Protocol(hc.Protocol)
+		option.HTTP = &prober.HTTPOption{
+			Path:               hc.Path,
+			Host:               hc.Host,
+			ExpectedStatuses:   statuses,
+			BodyMatch:          hc.BodyMatch,
+			InsecureSkipVerify: hc.InsecureSkipVerify,
+		}
+	case lbv1.GRPCHealthCheck:
+		option.Protocol = prober.GRPC
+		option.GRPC = &prober.GRPCOption{
+			Service:            hc.Service,
+			Authority:          hc.Host,
+			TLS:                hc.TLS,
+			InsecureSkipVerify: hc.InsecureSkipVerify,
+		}
+	case lbv1.UDPHealthCheck:
+		option.Protocol = prober.UDP
+		option.UDP = &prober.UDPOption{
+			Payload:       []byte(hc.Payload),
+			ResponseMatch: hc.ResponseMatch,
+			Preset:        prober.UDPPreset(hc.UDPPreset),
+			DNSQuery:      hc.DNSQuery,
+		}
+	}
+	return option, nil
 }
 
 // the prober.Manager is managed per uid
-// lb namespace/name is a qualified group uid
+// lb namespace/name is a qualified group uid of the lb health check
+// lb namespace/name/listener is a qualified group uid of the listener health check
 func marshalUID(ns, name string) (uid string) {
 	uid = ns + "/" + name
 	return
 }
 
+func marshalListenerUID(ns, name, listener string) (uid string) {
+	uid = ns + "/" + name + "/" + listener
+	return
+}
+
+// the listener is omitted as the health condition is updated per lb
 func unMarshalUID(uid string) (namespace, name string, err error) {
 	fields := strings.Split(uid, "/")
-	if len(fields) != 2 {
+	if len(fields) != 2 && len(fields) != 3 {
 		err = fmt.Errorf("invalid uid %s", uid)
 		return
 	}
@@ -478,19 +749,16 @@ func unMarshalUID(uid string) (namespace, name string, err error) {
 	return
 }
 
-func marshalPorberAddress(lb *lbv1.LoadBalancer, ep *discoveryv1.Endpoint) string {
-	//#nosec
-	return ep.Addresses[0] + ":" + strconv.Itoa(int(lb.Spec.HealthCheck.Port))
+func marshalPorberAddress(ep *discoveryv1.Endpoint, port int32) string {
+	return net.JoinHostPort(ep.Addresses[0], strconv.Itoa(int(port)))
 }
 
-// probe address is like: 10.52.0.214:80
+// probe address is like: 10.52.0.214:80 or [fd00::10]:80
 func unMarshalPorberAddress(address string) (ip, port string, err error) {
-	fields := strings.Split(address, ":")
-	if len(fields) != 2 {
-		err = fmt.Errorf("invalid probe address %s", address)
-		return
+	ip, port, err = net.SplitHostPort(address)
+	if err != nil {
+		err = fmt.Errorf("invalid probe address %s, error: %w", address, err)
 	}
-	ip, port = fields[0], fields[1]
 	return
 }
 
@@ -546,6 +814,10 @@ func constructService(cur *corev1.Service, lb *lbv1.LoadBalancer) *corev1.Servic
 			KeyLabel: utils.ValueTrue,
 		}
 		svc.Spec.Type = corev1.ServiceTypeLoadBalancer
+		if lb.Spec.IPFamilyPolicy == lbv1.IPv6 || lb.Spec.IPFamilyPolicy == lbv1.DualStack {
+			policy := corev1.IPFamilyPolicyPreferDualStack
+			svc.Spec.IPFamilyPolicy = &policy
+		}
 	}
 
 	if lb.Spec.IPAM == lbv1.DHCP {
@@ -553,6 +825,25 @@ func constructService(cur *corev1.Service, lb *lbv1.LoadBalancer) *corev1.Servic
 		svc.Spec.LoadBalancerIP = utils.Address4AskDHCP
 	} else {
 		svc.Spec.LoadBalancerIP = lb.Status.AllocatedAddress.IP
+		// the previous addresses are exposed together with the new ones during the migration to another pool
+		if lb.Spec.IPFamilyPolicy == lbv1.IPv6 || lb.Spec.IPFamilyPolicy == lbv1.DualStack || lb.Status.PoolMigration != nil {
+			ips := make([]string, 0, 4)
+			for _, address := range []lbv1.AllocatedAddress{lb.Status.AllocatedAddress, lb.Status.SecondaryAllocatedAddress} {
+				if address.IP != "" {
+					ips = append(ips, address.IP)
+				}
+			}
+			if lb.Status.PoolMigration != nil {
+				ips = append(ips, lb.Status.PoolMigration.Addresses...)
+			}
+			if svc.Annotations == nil {
+				svc.Annotations = make(map[string]string)
+			}
+			svc.Annotations[utils.AnnotationKeyKubevipLoadBalancerIPs] = strings.Join(ips, ",")
+		} else {
+			// the IPv4 service only has the annotation during the migration
+			delete(svc.Annotations, utils.AnnotationKeyKubevipLoadBalancerIPs)
+		}
 	}
 
 	ports := make([]corev1.ServicePort, 0, len(lb.Spec.Listeners))
@@ -571,12 +862,17 @@ func constructService(cur *corev1.Service, lb *lbv1.LoadBalancer) *corev1.Servic
 }
 
 const dummyEndpointIPv4Address = "10.52.0.255"
+const dummyEndpointIPv6Address = "fd00:10:52::ff"
 const dummyEndpointID = "dummy347-546a-4642-9da6-5608endpoint"
 
-func appendDummyEndpoint(eps []discoveryv1.Endpoint, lb *lbv1.LoadBalancer) []discoveryv1.Endpoint {
+func appendDummyEndpoint(eps []discoveryv1.Endpoint, lb *lbv1.LoadBalancer, family corev1.IPFamily) []discoveryv1.Endpoint {
 	cond := true
+	address := dummyEndpointIPv4Address
+	if family == corev1.IPv6Protocol {
+		address = dummyEndpointIPv6Address
+	}
 	endpoint := discoveryv1.Endpoint{
-		Addresses: []string{dummyEndpointIPv4Address},
+		Addresses: []string{address},
 		TargetRef: &corev1.ObjectReference{
 			Namespace: lb.Namespace,
 			Name:      lb.Name,
@@ -594,13 +890,27 @@ func isDummyEndpoint(ep *discoveryv1.Endpoint) bool {
 	return ep.TargetRef.UID == dummyEndpointID
 }
 
-func (m *Manager) constructEndpointSliceFromBackendServers(cur *discoveryv1.EndpointSlice, lb *lbv1.LoadBalancer, servers []pkglb.BackendServer) (*discoveryv1.EndpointSlice, error) {
+// the IPv4 endpointslice keeps the name of the lb to be compatible with previous versions
+func getEndpointSliceName(lbName string, family corev1.IPFamily) string {
+	if family == corev1.IPv6Protocol {
+		return lbName + "-ipv6"
+	}
+	return lbName
+}
+
+// the endpointslice name of the listener in the perport policy, getEndpointSliceName appends the IPv6 suffix to it
+func getListenerEndpointSliceName(lbName, listener string) string {
+	return lbName + "-listener-" + listener
+}
+
+func (m *Manager) constructEndpointSliceFromBackendServers(cur *discoveryv1.EndpointSlice, lb *lbv1.LoadBalancer, g *endpointGroup,
+	family corev1.IPFamily, servers []pkglb.BackendServer) (*discoveryv1.EndpointSlice, error) {
 	eps := &discoveryv1.EndpointSlice{}
 	if cur != nil {
 		eps = cur.DeepCopy()
 	} else {
 		eps.Namespace = lb.Namespace
-		eps.Name = lb.Name
+		eps.Name = getEndpointSliceName(g.name, family)
 		eps.OwnerReferences = []metav1.OwnerReference{
 			{
 				APIVersion: lb.APIVersion,
@@ -612,15 +922,21 @@ func (m *Manager) constructEndpointSliceFromBackendServers(cur *discoveryv1.Endp
 			KeyLabel:       utils.ValueTrue,
 			KeyServiceName: lb.Name,
 		}
+		if g.listener != "" {
+			eps.Labels[KeyListener] = g.listener
+		}
 		eps.AddressType = discoveryv1.AddressTypeIPv4
+		if family == corev1.IPv6Protocol {
+			eps.AddressType = discoveryv1.AddressTypeIPv6
+		}
 	}
 
-	ports := make([]discoveryv1.EndpointPort, 0, len(lb.Spec.Listeners))
-	for i := range lb.Spec.Listeners {
+	ports := make([]discoveryv1.EndpointPort, 0, len(g.listeners))
+	for i := range g.listeners {
 		port := discoveryv1.EndpointPort{
-			Name:     &(lb.Spec.Listeners[i].Name),
-			Protocol: &(lb.Spec.Listeners[i].Protocol),
-			Port:     &(lb.Spec.Listeners[i].BackendPort),
+			Name:     &(g.listeners[i].Name),
+			Protocol: &(g.listeners[i].Protocol),
+			Port:     &(g.listeners[i].BackendPort),
 		}
 		ports = append(ports, port)
 	}
@@ -633,7 +949,7 @@ func (m *Manager) constructEndpointSliceFromBackendServers(cur *discoveryv1.Endp
 	for _, server := range servers {
 		existing = false
 		// already checked when getting servers, but keep to take care of history data
-		address, ok := server.GetAddress()
+		address, ok := server.GetAddressByFamily(family)
 		if !ok {
 			continue
 		}
@@ -668,7 +984,7 @@ func (m *Manager) constructEndpointSliceFromBackendServers(cur *discoveryv1.Endp
 	}
 	// a dummy endpoint avoids the LB traffic is routed to other services/local host accidentally
 	if len(endpoints) == 0 {
-		endpoints = appendDummyEndpoint(endpoints, lb)
+		endpoints = appendDummyEndpoint(endpoints, lb, family)
 	}
 	eps.Endpoints = endpoints
 
@@ -9,6 +9,7 @@ import (
 
 	lbv1 "github.com/harvester/harvester-load-balancer/pkg/apis/loadbalancer.harvesterhci.io/v1beta1"
 	"github.com/harvester/harvester-load-balancer/pkg/generated/clientset/versioned/fake"
+	"github.com/harvester/harvester-load-balancer/pkg/utils"
 	"github.com/harvester/harvester-load-balancer/pkg/utils/fakeclients"
 )
 
@@ -33,6 +34,12 @@ func getTestLB() *lbv1.LoadBalancer {
 	}
 }
 
+func getTestLBWithIPFamilyPolicy(policy lbv1.IPFamilyPolicy) *lbv1.LoadBalancer {
+	lb := getTestLB()
+	lb.Spec.IPFamilyPolicy = policy
+	return lb
+}
+
 func getTestVM(namespace string, interfaces []kubevirtv1.VirtualMachineInstanceNetworkInterface, deletionTimeStamp bool) *kubevirtv1.VirtualMachineInstance {
 	vmi := &kubevirtv1.VirtualMachineInstance{
 		ObjectMeta: metav1.ObjectMeta{
@@ -156,6 +163,43 @@ func TestGetBackendServer(t *testing.T) {
 			matchedRunningBackendServerCount: 1,
 			withAddressBackendServerCount:    0,
 		},
+		{
+			name: "return 1 valid server with valid IPv6 for IPv6 LB",
+			lb:   getTestLBWithIPFamilyPolicy(lbv1.IPv6),
+			vmi: getTestVM(testNamespace, []kubevirtv1.VirtualMachineInstanceNetworkInterface{
+				{
+					Name: "eth0",
+					IP:   "192.168.100.10",
+					IPs:  []string{"192.168.100.10", "fd00:100::10"},
+				},
+			}, false),
+			matchedRunningBackendServerCount: 1,
+			withAddressBackendServerCount:    1,
+		},
+		{
+			name: "match 1 VM, valid 0 VM, as it has no IPv6 for IPv6 LB",
+			lb:   getTestLBWithIPFamilyPolicy(lbv1.IPv6),
+			vmi: getTestVM(testNamespace, []kubevirtv1.VirtualMachineInstanceNetworkInterface{
+				{
+					Name: "eth0",
+					IP:   "192.168.100.10",
+				},
+			}, false),
+			matchedRunningBackendServerCount: 1,
+			withAddressBackendServerCount:    0,
+		},
+		{
+			name: "return 1 valid server with only IPv4 for dual-stack LB",
+			lb:   getTestLBWithIPFamilyPolicy(lbv1.DualStack),
+			vmi: getTestVM(testNamespace, []kubevirtv1.VirtualMachineInstanceNetworkInterface{
+				{
+					Name: "eth0",
+					IP:   "192.168.100.10",
+				},
+			}, false),
+			matchedRunningBackendServerCount: 1,
+			withAddressBackendServerCount:    1,
+		},
 	}
 
 	for _, tt := range tests {
@@ -183,3 +227,66 @@ func TestGetBackendServer(t *testing.T) {
 		})
 	}
 }
+
+func TestProberAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		address string
+		ip      string
+		port    string
+		wantErr bool
+	}{
+		{
+			name:    "IPv4 address",
+			address: "10.52.0.214:80",
+			ip:      "10.52.0.214",
+			port:    "80",
+		},
+		{
+			name:    "IPv6 address",
+			address: "[fd00::10]:80",
+			ip:      "fd00::10",
+			port:    "80",
+		},
+		{
+			name:    "invalid address",
+			address: "fd00::10:80",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ip, port, err := unMarshalPorberAddress(tt.address)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unMarshalPorberAddress() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if ip != tt.ip || port != tt.port {
+				t.Errorf("unMarshalPorberAddress() = %s, %s, want %s, %s", ip, port, tt.ip, tt.port)
+			}
+		})
+	}
+}
+
+func TestConstructService_PoolMigration(t *testing.T) {
+	lb := getTestLB()
+	lb.Spec.IPAM = lbv1.Pool
+	lb.Status.AllocatedAddress = lbv1.AllocatedAddress{IPPool: "pool2", IP: "192.168.100.20"}
+	lb.Status.PoolMigration = &lbv1.PoolMigration{IPPool: "pool1", Addresses: []string{"192.168.100.10"}}
+
+	// both the new and the previous addresses are exposed during the migration
+	svc := constructService(nil, lb)
+	if svc.Spec.LoadBalancerIP != "192.168.100.20" {
+		t.Errorf("LoadBalancerIP = %s, want 192.168.100.20", svc.Spec.LoadBalancerIP)
+	}
+	if got := svc.Annotations[utils.AnnotationKeyKubevipLoadBalancerIPs]; got != "192.168.100.20,192.168.100.10" {
+		t.Errorf("kube-vip load balancer IPs = %s, want 192.168.100.20,192.168.100.10", got)
+	}
+
+	// only the new address is exposed after the migration
+	lb.Status.PoolMigration = nil
+	svc = constructService(svc, lb)
+	if got, ok := svc.Annotations[utils.AnnotationKeyKubevipLoadBalancerIPs]; ok {
+		t.Errorf("expect no kube-vip load balancer IPs, got %s", got)
+	}
+}
@@ -0,0 +1,202 @@
+package prober
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/http2"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// the gRPC health checking protocol, refer to https://github.com/grpc/grpc/blob/master/doc/health-checking.md
+const (
+	grpcHealthCheckPath = "/grpc.health.v1.Health/Check"
+	// the field number of HealthCheckRequest.service and HealthCheckResponse.status
+	grpcHealthFieldNumber = 1
+	// the length prefixed message consists of 1 byte compressed flag and 4 bytes message length
+	grpcMessagePrefixLength = 5
+	grpcMaxMessageLength    = 1024
+	grpcStatusOK            = "0"
+)
+
+type grpcServingStatus uint64
+
+const (
+	grpcUnknown grpcServingStatus = iota
+	grpcServing
+	grpcNotServing
+	grpcServiceUnknown
+)
+
+func (s grpcServingStatus) String() string {
+	switch s {
+	case grpcUnknown:
+		return "UNKNOWN"
+	case grpcServing:
+		return "SERVING"
+	case grpcNotServing:
+		return "NOT_SERVING"
+	case grpcServiceUnknown:
+		return "SERVICE_UNKNOWN"
+	default:
+		return strconv.FormatUint(uint64(s), 10)
+	}
+}
+
+type GRPCOption struct {
+	Service            string
+	Authority          string
+	TLS                bool
+	InsecureSkipVerify bool
+}
+
+type grpcProber struct {
+	scheme    string
+	service   string
+	authority string
+	transport *http2.Transport
+}
+
+func newGRPCProber(option *GRPCOption) *grpcProber {
+	if option == nil {
+		option = &GRPCOption{}
+	}
+	p := &grpcProber{
+		scheme:    "http",
+		service:   option.Service,
+		authority: option.Authority,
+		transport: &http2.Transport{},
+	}
+	if option.TLS {
+		p.scheme = "https"
+		p.transport.TLSClientConfig = &tls.Config{
+			// escape gosec error: G402: TLS InsecureSkipVerify may be true, it is configured by the user
+			//#nosec
+			InsecureSkipVerify: option.InsecureSkipVerify,
+			ServerName:         option.Authority,
+		}
+	} else {
+		// gRPC without TLS is HTTP/2 over cleartext TCP with prior knowledge
+		p.transport.AllowHTTP = true
+		p.transport.DialTLSContext = func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		}
+	}
+
+	return p
+}
+
+func (p *grpcProber) Probe(address string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	// every probe is a new connection to detect the backend server which refuses new connections
+	defer p.transport.CloseIdleConnections()
+
+	url := p.scheme + "://" + address + grpcHealthCheckPath
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(encodeHealthCheckRequest(p.service)))
+	if err != nil {
+		return fmt.Errorf("fail to create request %s, error: %w", url, err)
+	}
+	if p.authority != "" {
+		req.Host = p.authority
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	req.Header.Set("User-Agent", "harvester-load-balancer-prober")
+
+	resp, err := p.transport.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected http status code %d from %s", resp.StatusCode, url)
+	}
+	// the trailers are only available after the body is read to the end
+	body, err := io.ReadAll(io.LimitReader(resp.Body, grpcMessagePrefixLength+grpcMaxMessageLength))
+	if err != nil {
+		return fmt.Errorf("fail to read the response from %s, error: %w", url, err)
+	}
+	// the error without any message is sent in the headers only
+	code, message := resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+	if code == "" {
+		code, message = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	}
+	if code != grpcStatusOK {
+		return fmt.Errorf("grpc health check of %s failed, code: %s, message: %s", address, code, message)
+	}
+
+	status, err := decodeHealthCheckResponse(body)
+	if err != nil {
+		return fmt.Errorf("fail to decode the response from %s, error: %w", address, err)
+	}
+	if status != grpcServing {
+		return fmt.Errorf("grpc service %q of %s is %s", p.service, address, status)
+	}
+
+	return nil
+}
+
+// encode HealthCheckRequest{service} as an uncompressed length prefixed message
+func encodeHealthCheckRequest(service string) []byte {
+	var msg []byte
+	if service != "" {
+		msg = protowire.AppendTag(msg, grpcHealthFieldNumber, protowire.BytesType)
+		msg = protowire.AppendString(msg, service)
+	}
+	b := make([]byte, grpcMessagePrefixLength, grpcMessagePrefixLength+len(msg))
+	//#nosec
+	binary.BigEndian.PutUint32(b[1:], uint32(len(msg)))
+	return append(b, msg...)
+}
+
+// decode the status of HealthCheckResponse from an uncompressed length prefixed message
+func decodeHealthCheckResponse(b []byte) (grpcServingStatus, error) {
+	if len(b) < grpcMessagePrefixLength {
+		return grpcUnknown, fmt.Errorf("message is too short")
+	}
+	if b[0] != 0 {
+		return grpcUnknown, fmt.Errorf("compressed message is not supported")
+	}
+	length := binary.BigEndian.Uint32(b[1:grpcMessagePrefixLength])
+	msg := b[grpcMessagePrefixLength:]
+	if uint32(len(msg)) != length {
+		return grpcUnknown, fmt.Errorf("message length %d does not match the prefix %d", len(msg), length)
+	}
+
+	status := grpcUnknown
+	for len(msg) > 0 {
+		num, typ, n := protowire.ConsumeTag(msg)
+		if n < 0 {
+			return grpcUnknown, protowire.ParseError(n)
+		}
+		msg = msg[n:]
+		if num == grpcHealthFieldNumber && typ == protowire.VarintType {
+			v, n := protowire.ConsumeVarint(msg)
+			if n < 0 {
+				return grpcUnknown, protowire.ParseError(n)
+			}
+			status = grpcServingStatus(v)
+			msg = msg[n:]
+			continue
+		}
+		// skip the unknown fields
+		n = protowire.ConsumeFieldValue(num, typ, msg)
+		if n < 0 {
+			return grpcUnknown, protowire.ParseError(n)
+		}
+		msg = msg[n:]
+	}
+
+	return status, nil
+}
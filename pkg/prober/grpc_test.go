@@ -0,0 +1,164 @@
+package prober
+
+import (
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// fakeHealthServer implements grpc.health.v1.Health/Check, the services are SERVING except notServingService
+const notServingService = "not.serving"
+
+func fakeHealthServer(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != grpcHealthCheckPath || r.Header.Get("Content-Type") != "application/grpc" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil || len(body) < grpcMessagePrefixLength {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	service := ""
+	if msg := body[grpcMessagePrefixLength:]; len(msg) > 0 {
+		_, _, n := protowire.ConsumeTag(msg)
+		v, _ := protowire.ConsumeString(msg[n:])
+		service = v
+	}
+
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	if service == "unknown" {
+		// trailers-only response
+		w.Header().Set("Grpc-Status", "5")
+		w.Header().Set("Grpc-Message", "unknown service")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	status := grpcServing
+	if service == notServingService {
+		status = grpcNotServing
+	}
+	msg := protowire.AppendTag(nil, grpcHealthFieldNumber, protowire.VarintType)
+	msg = protowire.AppendVarint(msg, uint64(status))
+	prefix := make([]byte, grpcMessagePrefixLength)
+	binary.BigEndian.PutUint32(prefix[1:], uint32(len(msg)))
+	_, _ = w.Write(append(prefix, msg...))
+	w.Header().Set("Grpc-Status", grpcStatusOK)
+}
+
+func TestGRPCProber(t *testing.T) {
+	cleartextServer := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(fakeHealthServer), &http2.Server{}))
+	defer cleartextServer.Close()
+	tlsServer := httptest.NewUnstartedServer(http.HandlerFunc(fakeHealthServer))
+	tlsServer.EnableHTTP2 = true
+	tlsServer.StartTLS()
+	defer tlsServer.Close()
+	cleartextAddress := strings.TrimPrefix(cleartextServer.URL, "http://")
+	tlsAddress := strings.TrimPrefix(tlsServer.URL, "https://")
+
+	tests := []struct {
+		name    string
+		option  *GRPCOption
+		address string
+		wantErr bool
+	}{
+		{
+			name:    "the whole server is serving",
+			address: cleartextAddress,
+		},
+		{
+			name:    "service is serving",
+			option:  &GRPCOption{Service: "helloworld.Greeter"},
+			address: cleartextAddress,
+		},
+		{
+			name:    "service is not serving",
+			option:  &GRPCOption{Service: notServingService},
+			address: cleartextAddress,
+			wantErr: true,
+		},
+		{
+			name:    "service is unknown",
+			option:  &GRPCOption{Service: "unknown"},
+			address: cleartextAddress,
+			wantErr: true,
+		},
+		{
+			name:    "tls with unknown certificate",
+			option:  &GRPCOption{TLS: true},
+			address: tlsAddress,
+			wantErr: true,
+		},
+		{
+			name:    "tls skips verifying the certificate",
+			option:  &GRPCOption{TLS: true, InsecureSkipVerify: true},
+			address: tlsAddress,
+		},
+		{
+			name:    "cleartext to the tls server",
+			address: tlsAddress,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		if err := newGRPCProber(tt.option).Probe(tt.address, time.Second); (err != nil) != tt.wantErr {
+			t.Errorf("%q. Probe() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestDecodeHealthCheckResponse(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    []byte
+		want    grpcServingStatus
+		wantErr bool
+	}{
+		{
+			name: "serving",
+			body: []byte{0, 0, 0, 0, 2, 0x08, 0x01},
+			want: grpcServing,
+		},
+		{
+			name: "unknown fields are skipped",
+			body: []byte{0, 0, 0, 0, 5, 0x12, 0x01, 'a', 0x08, 0x02},
+			want: grpcNotServing,
+		},
+		{
+			name: "empty message is UNKNOWN",
+			body: []byte{0, 0, 0, 0, 0},
+			want: grpcUnknown,
+		},
+		{
+			name:    "compressed",
+			body:    []byte{1, 0, 0, 0, 2, 0x08, 0x01},
+			wantErr: true,
+		},
+		{
+			name:    "length mismatch",
+			body:    []byte{0, 0, 0, 0, 3, 0x08, 0x01},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := decodeHealthCheckResponse(tt.body)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q. decodeHealthCheckResponse() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q. decodeHealthCheckResponse() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -1,6 +1,19 @@
 package prober
 
-import "time"
+import (
+	"reflect"
+	"time"
+)
+
+type Protocol string
+
+const (
+	TCP   Protocol = "tcp"
+	HTTP  Protocol = "http"
+	HTTPS Protocol = "https"
+	GRPC  Protocol = "grpc"
+	UDP   Protocol = "udp"
+)
 
 type HealthOption struct {
 	Address          string
@@ -9,6 +22,14 @@ type HealthOption struct {
 	Timeout          time.Duration
 	Period           time.Duration
 	InitialCondition bool
+	// Protocol defaults to TCP if it is empty
+	Protocol Protocol
+	// HTTP is only used by the HTTP and HTTPS protocols
+	HTTP *HTTPOption
+	// GRPC is only used by the GRPC protocol
+	GRPC *GRPCOption
+	// UDP is only used by the UDP protocol
+	UDP *UDPOption
 }
 
 type healthCondition struct {
@@ -19,5 +40,6 @@ type healthCondition struct {
 
 func (ho *HealthOption) Equal(h HealthOption) bool {
 	return ho.Address == h.Address && ho.SuccessThreshold == h.SuccessThreshold && ho.FailureThreshold == h.FailureThreshold &&
-		ho.Timeout == h.Timeout && ho.Period == h.Period
+		ho.Timeout == h.Timeout && ho.Period == h.Period && ho.Protocol == h.Protocol && reflect.DeepEqual(ho.HTTP, h.HTTP) &&
+		reflect.DeepEqual(ho.GRPC, h.GRPC) && reflect.DeepEqual(ho.UDP, h.UDP)
 }
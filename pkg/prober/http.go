@@ -0,0 +1,178 @@
+package prober
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultHTTPPath = "/"
+	// the body is read up to 10KiB to match the regular expression, the same as the kubelet
+	maxBodyLength = 10 * 1024
+)
+
+// DefaultStatusRanges are the expected status codes if none is specified, the same as the kubelet
+var DefaultStatusRanges = []StatusRange{{Start: http.StatusOK, End: http.StatusBadRequest - 1}}
+
+type HTTPOption struct {
+	Path               string
+	Host               string
+	ExpectedStatuses   []StatusRange
+	BodyMatch          string
+	InsecureSkipVerify bool
+}
+
+// StatusRange is a closed interval of the HTTP status codes
+type StatusRange struct {
+	Start int
+	End   int
+}
+
+func (r StatusRange) contains(code int) bool {
+	return code >= r.Start && code <= r.End
+}
+
+// ParseStatusRanges parses the status codes like 200 or the ranges of them like 200-299
+func ParseStatusRanges(statuses []string) ([]StatusRange, error) {
+	ranges := make([]StatusRange, 0, len(statuses))
+	for _, s := range statuses {
+		start, end, isRange := strings.Cut(strings.TrimSpace(s), "-")
+		if !isRange {
+			end = start
+		}
+		r := StatusRange{}
+		var err error
+		if r.Start, err = parseStatusCode(start); err != nil {
+			return nil, fmt.Errorf("invalid status %s, error: %w", s, err)
+		}
+		if r.End, err = parseStatusCode(end); err != nil {
+			return nil, fmt.Errorf("invalid status %s, error: %w", s, err)
+		}
+		if r.Start > r.End {
+			return nil, fmt.Errorf("invalid status %s, the start is greater than the end", s)
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+func parseStatusCode(s string) (int, error) {
+	code, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		return 0, err
+	}
+	if code < 100 || code > 599 {
+		return 0, fmt.Errorf("status code %d is out of range [100, 599]", code)
+	}
+	return code, nil
+}
+
+type httpProber struct {
+	scheme           string
+	path             string
+	host             string
+	expectedStatuses []StatusRange
+	bodyMatch        *regexp.Regexp
+	client           *http.Client
+}
+
+func newHTTPProber(protocol Protocol, option *HTTPOption) (*httpProber, error) {
+	if option == nil {
+		option = &HTTPOption{}
+	}
+	p := &httpProber{
+		scheme:           string(protocol),
+		path:             option.Path,
+		host:             option.Host,
+		expectedStatuses: option.ExpectedStatuses,
+	}
+	if p.path == "" {
+		p.path = defaultHTTPPath
+	}
+	if len(p.expectedStatuses) == 0 {
+		p.expectedStatuses = DefaultStatusRanges
+	}
+	if option.BodyMatch != "" {
+		re, err := regexp.Compile(option.BodyMatch)
+		if err != nil {
+			return nil, fmt.Errorf("invalid body match %s, error: %w", option.BodyMatch, err)
+		}
+		p.bodyMatch = re
+	}
+
+	transport := &http.Transport{
+		// every probe is a new connection to detect the backend server which refuses new connections
+		DisableKeepAlives: true,
+		Proxy:             nil,
+	}
+	if protocol == HTTPS {
+		transport.TLSClientConfig = &tls.Config{
+			// escape gosec error: G402: TLS InsecureSkipVerify may be true, it is configured by the user
+			//#nosec
+			InsecureSkipVerify: option.InsecureSkipVerify,
+			ServerName:         option.Host,
+		}
+	}
+	p.client = &http.Client{
+		Transport: transport,
+		// the redirection is taken as the result of the probe instead of being followed
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	return p, nil
+}
+
+func (p *httpProber) Probe(address string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	url := p.scheme + "://" + address + p.path
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("fail to create request %s, error: %w", url, err)
+	}
+	if p.host != "" {
+		req.Host = p.host
+	}
+	req.Header.Set("User-Agent", "harvester-load-balancer-prober")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if !p.isExpectedStatus(resp.StatusCode) {
+		return fmt.Errorf("unexpected status code %d from %s", resp.StatusCode, url)
+	}
+	if p.bodyMatch == nil {
+		return nil
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodyLength))
+	if err != nil {
+		return fmt.Errorf("fail to read the response body from %s, error: %w", url, err)
+	}
+	if !p.bodyMatch.Match(body) {
+		return fmt.Errorf("the response body from %s does not match %s", url, p.bodyMatch.String())
+	}
+
+	return nil
+}
+
+func (p *httpProber) isExpectedStatus(code int) bool {
+	for _, r := range p.expectedStatuses {
+		if r.contains(code) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,203 @@
+package prober
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseStatusRanges(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []string
+		want     []StatusRange
+		wantErr  bool
+	}{
+		{
+			name:     "single codes and ranges",
+			statuses: []string{"200", "300-399"},
+			want:     []StatusRange{{Start: 200, End: 200}, {Start: 300, End: 399}},
+		},
+		{
+			name:     "not a number",
+			statuses: []string{"2xx"},
+			wantErr:  true,
+		},
+		{
+			name:     "out of range",
+			statuses: []string{"99"},
+			wantErr:  true,
+		},
+		{
+			name:     "reversed range",
+			statuses: []string{"299-200"},
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseStatusRanges(tt.statuses)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q. ParseStatusRanges() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q. ParseStatusRanges() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHTTPProber(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/healthz":
+			_, _ = w.Write([]byte("status: ok"))
+		case "/host":
+			_, _ = w.Write([]byte(r.Host))
+		case "/redirect":
+			http.Redirect(w, r, "/healthz", http.StatusFound)
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+	httpServer := httptest.NewServer(handler)
+	defer httpServer.Close()
+	httpsServer := httptest.NewTLSServer(handler)
+	defer httpsServer.Close()
+	httpAddress := strings.TrimPrefix(httpServer.URL, "http://")
+	httpsAddress := strings.TrimPrefix(httpsServer.URL, "https://")
+
+	tests := []struct {
+		name     string
+		protocol Protocol
+		option   *HTTPOption
+		address  string
+		wantErr  bool
+	}{
+		{
+			name:     "default path returns 503",
+			protocol: HTTP,
+			address:  httpAddress,
+			wantErr:  true,
+		},
+		{
+			name:     "expected status",
+			protocol: HTTP,
+			option:   &HTTPOption{Path: "/healthz"},
+			address:  httpAddress,
+		},
+		{
+			name:     "503 is expected",
+			protocol: HTTP,
+			option:   &HTTPOption{Path: "/unavailable", ExpectedStatuses: []StatusRange{{Start: 500, End: 503}}},
+			address:  httpAddress,
+		},
+		{
+			name:     "redirection is not followed",
+			protocol: HTTP,
+			option:   &HTTPOption{Path: "/redirect", ExpectedStatuses: []StatusRange{{Start: 200, End: 299}}},
+			address:  httpAddress,
+			wantErr:  true,
+		},
+		{
+			name:     "body matches",
+			protocol: HTTP,
+			option:   &HTTPOption{Path: "/healthz", BodyMatch: "status: (ok|ready)"},
+			address:  httpAddress,
+		},
+		{
+			name:     "body does not match",
+			protocol: HTTP,
+			option:   &HTTPOption{Path: "/healthz", BodyMatch: "^ready$"},
+			address:  httpAddress,
+			wantErr:  true,
+		},
+		{
+			name:     "host header",
+			protocol: HTTP,
+			option:   &HTTPOption{Path: "/host", Host: "example.com", BodyMatch: "^example.com$"},
+			address:  httpAddress,
+		},
+		{
+			name:     "https with unknown certificate",
+			protocol: HTTPS,
+			option:   &HTTPOption{Path: "/healthz"},
+			address:  httpsAddress,
+			wantErr:  true,
+		},
+		{
+			name:     "https skips verifying the certificate",
+			protocol: HTTPS,
+			option:   &HTTPOption{Path: "/healthz", InsecureSkipVerify: true},
+			address:  httpsAddress,
+		},
+	}
+
+	for _, tt := range tests {
+		p, err := newHTTPProber(tt.protocol, tt.option)
+		if err != nil {
+			t.Errorf("%q. newHTTPProber() error = %v", tt.name, err)
+			continue
+		}
+		if err := p.Probe(tt.address, time.Second); (err != nil) != tt.wantErr {
+			t.Errorf("%q. Probe() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestHealthOption_Equal(t *testing.T) {
+	base := HealthOption{
+		Address:  "10.0.0.1:80",
+		Protocol: HTTP,
+		HTTP:     &HTTPOption{Path: "/healthz", ExpectedStatuses: []StatusRange{{Start: 200, End: 299}}},
+	}
+
+	tests := []struct {
+		name   string
+		modify func(o *HealthOption)
+		want   bool
+	}{
+		{
+			name:   "unchanged",
+			modify: func(o *HealthOption) {},
+			want:   true,
+		},
+		{
+			name:   "protocol changed",
+			modify: func(o *HealthOption) { o.Protocol = HTTPS },
+		},
+		{
+			name:   "path changed",
+			modify: func(o *HealthOption) { o.HTTP.Path = "/ready" },
+		},
+		{
+			name:   "expected statuses changed",
+			modify: func(o *HealthOption) { o.HTTP.ExpectedStatuses = []StatusRange{{Start: 200, End: 200}} },
+		},
+		{
+			name:   "body match changed",
+			modify: func(o *HealthOption) { o.HTTP.BodyMatch = "ok" },
+		},
+		{
+			name:   "insecure skip verify changed",
+			modify: func(o *HealthOption) { o.HTTP.InsecureSkipVerify = true },
+		},
+		{
+			name:   "grpc option changed",
+			modify: func(o *HealthOption) { o.GRPC = &GRPCOption{Service: "helloworld.Greeter"} },
+		},
+	}
+
+	for _, tt := range tests {
+		o := base
+		httpOption := *base.HTTP
+		o.HTTP = &httpOption
+		tt.modify(&o)
+		if got := base.Equal(o); got != tt.want {
+			t.Errorf("%q. Equal() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
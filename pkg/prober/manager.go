@@ -2,6 +2,7 @@ package prober
 
 import (
 	"context"
+	"fmt"
 	"sync"
 
 	"github.com/sirupsen/logrus"
@@ -61,12 +62,17 @@ func (m *Manager) AddWorker(uid string, address string, option HealthOption) err
 		m.workers[uid] = wm
 	}
 
+	p, err := m.getProber(option)
+	if err != nil {
+		return fmt.Errorf("fail to create prober for uid %s, address %s, error: %w", uid, address, err)
+	}
+
 	// stop if duplicated
 	if w, ok := wm[address]; ok {
 		logrus.Infof("porber worker already exists, uid %s, address %s, will stop it", uid, address)
 		w.stop()
 	}
-	w := newWorker(uid, m.tcpProber, option, m.conditionChan)
+	w := newWorker(uid, p, option, m.conditionChan)
 	wm[address] = w
 
 	go w.run()
@@ -115,3 +121,19 @@ func (m *Manager) RemoveWorkersByUid(uid string) (int, error) {
 
 	return cnt, nil
 }
+
+// the TCP prober is shared by all workers, the other probers are created per worker as it carries the options
+func (m *Manager) getProber(option HealthOption) (Prober, error) {
+	switch option.Protocol {
+	case "", TCP:
+		return m.tcpProber, nil
+	case HTTP, HTTPS:
+		return newHTTPProber(option.Protocol, option.HTTP)
+	case GRPC:
+		return newGRPCProber(option.GRPC), nil
+	case UDP:
+		return newUDPProber(option.UDP)
+	default:
+		return nil, fmt.Errorf("unsupported protocol %s", option.Protocol)
+	}
+}
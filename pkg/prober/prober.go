@@ -2,6 +2,7 @@ package prober
 
 import (
 	"context"
+	"net"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -17,9 +18,23 @@ type tcpProber struct {
 }
 
 func (t *tcpProber) Probe(address string, timeout time.Duration) error {
+	// the tcp-shaker only supports IPv4, fall back to a full TCP handshake for IPv6
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+			return dialTCP(address, timeout)
+		}
+	}
 	return t.CheckAddr(address, timeout)
 }
 
+func dialTCP(address string, timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
 func newTCPProber(ctx context.Context) *tcpProber {
 	checker := tcp.NewChecker()
 	go func() {
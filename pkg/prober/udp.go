@@ -0,0 +1,167 @@
+package prober
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	maxDatagramLength = 65535
+	defaultDNSQuery   = "."
+	maxDNSLabelLength = 63
+)
+
+type UDPPreset string
+
+const (
+	DNSPreset UDPPreset = "dns"
+)
+
+type UDPOption struct {
+	Payload       []byte
+	ResponseMatch string
+	Preset        UDPPreset
+	DNSQuery      string
+}
+
+type udpProber struct {
+	payload       []byte
+	responseMatch *regexp.Regexp
+	preset        UDPPreset
+	dnsQuery      dnsmessage.Name
+}
+
+func newUDPProber(option *UDPOption) (*udpProber, error) {
+	if option == nil {
+		option = &UDPOption{}
+	}
+	p := &udpProber{
+		payload: option.Payload,
+		preset:  option.Preset,
+	}
+
+	switch option.Preset {
+	case "":
+		if option.ResponseMatch != "" {
+			re, err := regexp.Compile(option.ResponseMatch)
+			if err != nil {
+				return nil, fmt.Errorf("invalid response match %s, error: %w", option.ResponseMatch, err)
+			}
+			p.responseMatch = re
+		}
+	case DNSPreset:
+		name, err := ParseDNSQuery(option.DNSQuery)
+		if err != nil {
+			return nil, err
+		}
+		p.dnsQuery = name
+	default:
+		return nil, fmt.Errorf("unsupported udp preset %s", option.Preset)
+	}
+
+	return p, nil
+}
+
+// ParseDNSQuery parses the domain name queried by the DNS preset, the empty one is the root zone
+func ParseDNSQuery(query string) (dnsmessage.Name, error) {
+	if query == "" {
+		query = defaultDNSQuery
+	}
+	if !strings.HasSuffix(query, ".") {
+		query += "."
+	}
+	if query != defaultDNSQuery {
+		for _, label := range strings.Split(strings.TrimSuffix(query, "."), ".") {
+			if len(label) == 0 || len(label) > maxDNSLabelLength {
+				return dnsmessage.Name{}, fmt.Errorf("invalid dns query %s, the length of each label must be in [1, %d]", query, maxDNSLabelLength)
+			}
+		}
+	}
+	name, err := dnsmessage.NewName(query)
+	if err != nil {
+		return dnsmessage.Name{}, fmt.Errorf("invalid dns query %s, error: %w", query, err)
+	}
+	return name, nil
+}
+
+func (p *udpProber) Probe(address string, timeout time.Duration) error {
+	conn, err := net.DialTimeout("udp", address, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+
+	payload := p.payload
+	var id uint16
+	if p.preset == DNSPreset {
+		//#nosec
+		id = uint16(rand.Uint32())
+		if payload, err = newDNSQuery(id, p.dnsQuery); err != nil {
+			return err
+		}
+	}
+	if _, err := conn.Write(payload); err != nil {
+		return err
+	}
+
+	buf := make([]byte, maxDatagramLength)
+	n, err := conn.Read(buf)
+	if err != nil {
+		// the connected UDP socket reports the ICMP port unreachable as an error, while silence means the server
+		// may have received the datagram
+		if errors.Is(err, os.ErrDeadlineExceeded) && p.preset == "" && p.responseMatch == nil {
+			return nil
+		}
+		return err
+	}
+
+	switch {
+	case p.preset == DNSPreset:
+		return checkDNSResponse(id, buf[:n])
+	case p.responseMatch != nil && !p.responseMatch.Match(buf[:n]):
+		return fmt.Errorf("the response from %s does not match %s", address, p.responseMatch.String())
+	default:
+		return nil
+	}
+}
+
+func newDNSQuery(id uint16, name dnsmessage.Name) ([]byte, error) {
+	msg := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{
+			{Name: name, Type: dnsmessage.TypeNS, Class: dnsmessage.ClassINET},
+		},
+	}
+	b, err := msg.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("fail to pack dns query %s, error: %w", name.String(), err)
+	}
+	return b, nil
+}
+
+// NXDOMAIN is an answer from a working server as well as NOERROR
+func checkDNSResponse(id uint16, b []byte) error {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(b)
+	if err != nil {
+		return fmt.Errorf("invalid dns response, error: %w", err)
+	}
+	if header.ID != id || !header.Response {
+		return fmt.Errorf("dns response does not match the query")
+	}
+	if header.RCode != dnsmessage.RCodeSuccess && header.RCode != dnsmessage.RCodeNameError {
+		return fmt.Errorf("dns response code is %s", header.RCode.String())
+	}
+	return nil
+}
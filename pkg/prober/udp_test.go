@@ -0,0 +1,147 @@
+package prober
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// startUDPServer answers every datagram with the result of the reply function, nothing is sent if it returns nil
+func startUDPServer(t *testing.T, reply func(req []byte) []byte) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("fail to listen udp, error: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, maxDatagramLength)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if resp := reply(buf[:n]); resp != nil {
+				_, _ = conn.WriteTo(resp, addr)
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func dnsReply(rcode dnsmessage.RCode) func(req []byte) []byte {
+	return func(req []byte) []byte {
+		var msg dnsmessage.Message
+		if err := msg.Unpack(req); err != nil {
+			return nil
+		}
+		msg.Header.Response = true
+		msg.Header.RCode = rcode
+		b, _ := msg.Pack()
+		return b
+	}
+}
+
+func TestUDPProber(t *testing.T) {
+	echoAddress := startUDPServer(t, func(req []byte) []byte { return append([]byte("echo "), req...) })
+	silentAddress := startUDPServer(t, func([]byte) []byte { return nil })
+	dnsAddress := startUDPServer(t, dnsReply(dnsmessage.RCodeNameError))
+	failingDNSAddress := startUDPServer(t, dnsReply(dnsmessage.RCodeServerFailure))
+	// no one listens on the port after it is closed, the ICMP port unreachable is reported
+	closed, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("fail to listen udp, error: %v", err)
+	}
+	closedAddress := closed.LocalAddr().String()
+	closed.Close()
+
+	tests := []struct {
+		name    string
+		option  *UDPOption
+		address string
+		wantErr bool
+	}{
+		{
+			name:    "response matches",
+			option:  &UDPOption{Payload: []byte("ping"), ResponseMatch: "^echo ping$"},
+			address: echoAddress,
+		},
+		{
+			name:    "response does not match",
+			option:  &UDPOption{Payload: []byte("ping"), ResponseMatch: "^pong$"},
+			address: echoAddress,
+			wantErr: true,
+		},
+		{
+			name:    "no response is expected",
+			option:  &UDPOption{Payload: []byte("<14>health check")},
+			address: silentAddress,
+		},
+		{
+			name:    "response is expected",
+			option:  &UDPOption{Payload: []byte("ping"), ResponseMatch: "pong"},
+			address: silentAddress,
+			wantErr: true,
+		},
+		{
+			name:    "port is unreachable",
+			option:  &UDPOption{Payload: []byte("ping")},
+			address: closedAddress,
+			wantErr: true,
+		},
+		{
+			name:    "dns answers NXDOMAIN",
+			option:  &UDPOption{Preset: DNSPreset, DNSQuery: "example.com"},
+			address: dnsAddress,
+		},
+		{
+			name:    "dns answers SERVFAIL",
+			option:  &UDPOption{Preset: DNSPreset},
+			address: failingDNSAddress,
+			wantErr: true,
+		},
+		{
+			name:    "echo is not a dns server",
+			option:  &UDPOption{Preset: DNSPreset},
+			address: echoAddress,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		p, err := newUDPProber(tt.option)
+		if err != nil {
+			t.Errorf("%q. newUDPProber() error = %v", tt.name, err)
+			continue
+		}
+		if err := p.Probe(tt.address, 500*time.Millisecond); (err != nil) != tt.wantErr {
+			t.Errorf("%q. Probe() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestParseDNSQuery(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    string
+		wantErr bool
+	}{
+		{query: "", want: "."},
+		{query: "example.com", want: "example.com."},
+		{query: "example.com.", want: "example.com."},
+		{query: "example..com", wantErr: true},
+		{query: "a123456789a123456789a123456789a123456789a123456789a123456789abcd.com", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseDNSQuery(tt.query)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q. ParseDNSQuery() error = %v, wantErr %v", tt.query, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got.String() != tt.want {
+			t.Errorf("%q. ParseDNSQuery() = %s, want %s", tt.query, got.String(), tt.want)
+		}
+	}
+}
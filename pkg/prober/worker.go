@@ -9,7 +9,7 @@ import (
 type Worker struct {
 	HealthOption
 	uid            string
-	tcpProber      Prober
+	prober         Prober
 	successCounter uint
 	failureCounter uint
 	condition      bool
@@ -19,9 +19,9 @@ type Worker struct {
 	logSuccess     bool
 }
 
-func newWorker(uid string, tcpProber Prober, option HealthOption, conditionChan chan healthCondition) *Worker {
+func newWorker(uid string, prober Prober, option HealthOption, conditionChan chan healthCondition) *Worker {
 	return &Worker{
-		tcpProber:      tcpProber,
+		prober:         prober,
 		uid:            uid,
 		HealthOption:   option,
 		successCounter: 0,
@@ -52,9 +52,8 @@ func (w *Worker) stop() {
 	w.stopCh <- struct{}{}
 }
 
-// probe only supports TCP
 func (w *Worker) probe() error {
-	return w.tcpProber.Probe(w.Address, w.Timeout)
+	return w.prober.Probe(w.Address, w.Timeout)
 }
 
 func (w *Worker) doProbe() {
@@ -19,6 +19,13 @@ const (
 
 	DuplicateAllocationKeyWord = "duplicate allocation is not allowed"
 
+	// IPAllocation objects have such label: loadbalancer.harvesterhci.io/ippool: pool1
+	LabelKeyIPPool = lb.GroupName + "/ippool"
+
+	// kube-vip reads the comma separated IPs of an IPv6 or dual-stack service from this annotation
+	// value format: kube-vip.io/loadbalancerIPs: "192.168.5.12,fd00:5::12"
+	AnnotationKeyKubevipLoadBalancerIPs = "kube-vip.io/loadbalancerIPs"
+
 	// refer https://github.com/rancher/rancher/blob/e5d419fce68de6dc631a818a2e7e206f2221ebc3/pkg/controllers/provisioningv2/harvestercleanup/controller.go#L29
 	// redefine following annotation for LB usage
 	//   removedAllPVCsAnnotationKey             = "harvesterhci.io/removeAllPersistentVolumeClaims"
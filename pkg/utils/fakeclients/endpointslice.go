@@ -0,0 +1,81 @@
+package fakeclients
+
+import (
+	"context"
+
+	"github.com/rancher/wrangler/v3/pkg/generic"
+	discoveryv1api "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/rest"
+
+	discoveryv1 "github.com/harvester/harvester-load-balancer/pkg/generated/clientset/versioned/typed/discovery.k8s.io/v1"
+)
+
+type EndpointSliceClient func(string) discoveryv1.EndpointSliceInterface
+
+func (c EndpointSliceClient) Update(eps *discoveryv1api.EndpointSlice) (*discoveryv1api.EndpointSlice, error) {
+	return c(eps.Namespace).Update(context.TODO(), eps, metav1.UpdateOptions{})
+}
+
+func (c EndpointSliceClient) Get(namespace, name string, options metav1.GetOptions) (*discoveryv1api.EndpointSlice, error) {
+	return c(namespace).Get(context.TODO(), name, options)
+}
+
+func (c EndpointSliceClient) Create(eps *discoveryv1api.EndpointSlice) (*discoveryv1api.EndpointSlice, error) {
+	return c(eps.Namespace).Create(context.TODO(), eps, metav1.CreateOptions{})
+}
+
+func (c EndpointSliceClient) Delete(namespace, name string, options *metav1.DeleteOptions) error {
+	return c(namespace).Delete(context.TODO(), name, *options)
+}
+
+func (c EndpointSliceClient) List(_ string, _ metav1.ListOptions) (*discoveryv1api.EndpointSliceList, error) {
+	panic("implement me")
+}
+
+func (c EndpointSliceClient) UpdateStatus(*discoveryv1api.EndpointSlice) (*discoveryv1api.EndpointSlice, error) {
+	panic("implement me")
+}
+
+func (c EndpointSliceClient) Watch(_ string, _ metav1.ListOptions) (watch.Interface, error) {
+	panic("implement me")
+}
+
+func (c EndpointSliceClient) Patch(_, _ string, _ types.PatchType, _ []byte, _ ...string) (result *discoveryv1api.EndpointSlice, err error) {
+	panic("implement me")
+}
+
+func (c EndpointSliceClient) WithImpersonation(_ rest.ImpersonationConfig) (generic.ClientInterface[*discoveryv1api.EndpointSlice, *discoveryv1api.EndpointSliceList], error) {
+	panic("implement me")
+}
+
+type EndpointSliceCache func(string) discoveryv1.EndpointSliceInterface
+
+func (c EndpointSliceCache) Get(namespace, name string) (*discoveryv1api.EndpointSlice, error) {
+	return c(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+}
+
+func (c EndpointSliceCache) List(namespace string, selector labels.Selector) ([]*discoveryv1api.EndpointSlice, error) {
+	list, err := c(namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: selector.String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*discoveryv1api.EndpointSlice, 0, len(list.Items))
+	for i := range list.Items {
+		result = append(result, &list.Items[i])
+	}
+	return result, err
+}
+
+func (c EndpointSliceCache) AddIndexer(_ string, _ generic.Indexer[*discoveryv1api.EndpointSlice]) {
+	panic("implement me")
+}
+
+func (c EndpointSliceCache) GetByIndex(_, _ string) ([]*discoveryv1api.EndpointSlice, error) {
+	panic("implement me")
+}
@@ -0,0 +1,51 @@
+package fakeclients
+
+import (
+	"context"
+
+	"github.com/rancher/wrangler/v3/pkg/generic"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	corev1type "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+)
+
+type EventClient func(namespace string) corev1type.EventInterface
+
+func (c EventClient) Create(event *v1.Event) (*v1.Event, error) {
+	return c(event.Namespace).Create(context.TODO(), event, metav1.CreateOptions{})
+}
+
+func (c EventClient) Update(event *v1.Event) (*v1.Event, error) {
+	return c(event.Namespace).Update(context.TODO(), event, metav1.UpdateOptions{})
+}
+
+func (c EventClient) UpdateStatus(_ *v1.Event) (*v1.Event, error) {
+	panic("implement me")
+}
+
+func (c EventClient) Delete(namespace, name string, options *metav1.DeleteOptions) error {
+	return c(namespace).Delete(context.TODO(), name, *options)
+}
+
+func (c EventClient) Get(namespace, name string, opts metav1.GetOptions) (*v1.Event, error) {
+	return c(namespace).Get(context.TODO(), name, opts)
+}
+
+func (c EventClient) List(namespace string, opts metav1.ListOptions) (*v1.EventList, error) {
+	return c(namespace).List(context.TODO(), opts)
+}
+
+func (c EventClient) Watch(_ string, _ metav1.ListOptions) (watch.Interface, error) {
+	panic("implement me")
+}
+
+func (c EventClient) Patch(_, _ string, _ types.PatchType, _ []byte, _ ...string) (result *v1.Event, err error) {
+	panic("implement me")
+}
+
+func (c EventClient) WithImpersonation(_ rest.ImpersonationConfig) (generic.ClientInterface[*v1.Event, *v1.EventList], error) {
+	panic("implement me")
+}
@@ -0,0 +1,81 @@
+package fakeclients
+
+import (
+	"context"
+
+	"github.com/rancher/wrangler/v3/pkg/generic"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/rest"
+
+	lbv1beta1 "github.com/harvester/harvester-load-balancer/pkg/apis/loadbalancer.harvesterhci.io/v1beta1"
+	lbv1 "github.com/harvester/harvester-load-balancer/pkg/generated/clientset/versioned/typed/loadbalancer.harvesterhci.io/v1beta1"
+)
+
+type IPAllocationCache func() lbv1.IPAllocationInterface
+
+func (i IPAllocationCache) Get(name string) (*lbv1beta1.IPAllocation, error) {
+	return i().Get(context.TODO(), name, metav1.GetOptions{})
+}
+
+func (i IPAllocationCache) List(selector labels.Selector) ([]*lbv1beta1.IPAllocation, error) {
+	list, err := i().List(context.TODO(), metav1.ListOptions{
+		LabelSelector: selector.String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*lbv1beta1.IPAllocation, 0, len(list.Items))
+	for i := range list.Items {
+		result = append(result, &list.Items[i])
+	}
+	return result, err
+}
+
+func (i IPAllocationCache) AddIndexer(_ string, _ generic.Indexer[*lbv1beta1.IPAllocation]) {
+	panic("implement me")
+}
+
+func (i IPAllocationCache) GetByIndex(_, _ string) ([]*lbv1beta1.IPAllocation, error) {
+	panic("implement me")
+}
+
+type IPAllocationClient func() lbv1.IPAllocationInterface
+
+func (c IPAllocationClient) Create(allocation *lbv1beta1.IPAllocation) (*lbv1beta1.IPAllocation, error) {
+	return c().Create(context.TODO(), allocation, metav1.CreateOptions{})
+}
+
+func (c IPAllocationClient) Update(allocation *lbv1beta1.IPAllocation) (*lbv1beta1.IPAllocation, error) {
+	return c().Update(context.TODO(), allocation, metav1.UpdateOptions{})
+}
+
+func (c IPAllocationClient) UpdateStatus(_ *lbv1beta1.IPAllocation) (*lbv1beta1.IPAllocation, error) {
+	panic("implement me")
+}
+
+func (c IPAllocationClient) Delete(name string, options *metav1.DeleteOptions) error {
+	return c().Delete(context.TODO(), name, *options)
+}
+
+func (c IPAllocationClient) Get(name string, options metav1.GetOptions) (*lbv1beta1.IPAllocation, error) {
+	return c().Get(context.TODO(), name, options)
+}
+
+func (c IPAllocationClient) List(opts metav1.ListOptions) (*lbv1beta1.IPAllocationList, error) {
+	return c().List(context.TODO(), opts)
+}
+
+func (c IPAllocationClient) Watch(_ metav1.ListOptions) (watch.Interface, error) {
+	panic("implement me")
+}
+
+func (c IPAllocationClient) Patch(_ string, _ types.PatchType, _ []byte, _ ...string) (*lbv1beta1.IPAllocation, error) {
+	panic("implement me")
+}
+
+func (c IPAllocationClient) WithImpersonation(_ rest.ImpersonationConfig) (generic.NonNamespacedClientInterface[*lbv1beta1.IPAllocation, *lbv1beta1.IPAllocationList], error) {
+	panic("implement me")
+}
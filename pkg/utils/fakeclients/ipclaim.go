@@ -0,0 +1,81 @@
+package fakeclients
+
+import (
+	"context"
+
+	"github.com/rancher/wrangler/v3/pkg/generic"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/rest"
+
+	lbv1beta1 "github.com/harvester/harvester-load-balancer/pkg/apis/loadbalancer.harvesterhci.io/v1beta1"
+	lbv1 "github.com/harvester/harvester-load-balancer/pkg/generated/clientset/versioned/typed/loadbalancer.harvesterhci.io/v1beta1"
+)
+
+type IPClaimCache func(string) lbv1.IPClaimInterface
+
+func (c IPClaimCache) Get(namespace, name string) (*lbv1beta1.IPClaim, error) {
+	return c(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+}
+
+func (c IPClaimCache) List(namespace string, selector labels.Selector) ([]*lbv1beta1.IPClaim, error) {
+	list, err := c(namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: selector.String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*lbv1beta1.IPClaim, 0, len(list.Items))
+	for i := range list.Items {
+		result = append(result, &list.Items[i])
+	}
+	return result, err
+}
+
+func (c IPClaimCache) AddIndexer(indexName string, indexer generic.Indexer[*lbv1beta1.IPClaim]) {
+	panic("implement me")
+}
+
+func (c IPClaimCache) GetByIndex(indexName, key string) ([]*lbv1beta1.IPClaim, error) {
+	panic("implement me")
+}
+
+type IPClaimClient func(string) lbv1.IPClaimInterface
+
+func (c IPClaimClient) Update(claim *lbv1beta1.IPClaim) (*lbv1beta1.IPClaim, error) {
+	return c(claim.Namespace).Update(context.TODO(), claim, metav1.UpdateOptions{})
+}
+
+func (c IPClaimClient) Get(namespace, name string, options metav1.GetOptions) (*lbv1beta1.IPClaim, error) {
+	return c(namespace).Get(context.TODO(), name, options)
+}
+
+func (c IPClaimClient) Create(claim *lbv1beta1.IPClaim) (*lbv1beta1.IPClaim, error) {
+	return c(claim.Namespace).Create(context.TODO(), claim, metav1.CreateOptions{})
+}
+
+func (c IPClaimClient) Delete(namespace, name string, _ *metav1.DeleteOptions) error {
+	return c(namespace).Delete(context.TODO(), name, metav1.DeleteOptions{})
+}
+
+func (c IPClaimClient) List(_ string, _ metav1.ListOptions) (*lbv1beta1.IPClaimList, error) {
+	panic("implement me")
+}
+
+func (c IPClaimClient) Patch(_, _ string, _ types.PatchType, _ []byte, _ ...string) (*lbv1beta1.IPClaim, error) {
+	panic("implement me")
+}
+
+func (c IPClaimClient) UpdateStatus(*lbv1beta1.IPClaim) (*lbv1beta1.IPClaim, error) {
+	panic("implement me")
+}
+
+func (c IPClaimClient) Watch(_ string, _ metav1.ListOptions) (watch.Interface, error) {
+	panic("implement me")
+}
+
+func (c IPClaimClient) WithImpersonation(_ rest.ImpersonationConfig) (generic.ClientInterface[*lbv1beta1.IPClaim, *lbv1beta1.IPClaimList], error) {
+	panic("implement me")
+}
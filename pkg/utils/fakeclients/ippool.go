@@ -6,6 +6,9 @@ import (
 	"github.com/rancher/wrangler/v3/pkg/generic"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/rest"
 
 	lbv1beta1 "github.com/harvester/harvester-load-balancer/pkg/apis/loadbalancer.harvesterhci.io/v1beta1"
 	lbv1 "github.com/harvester/harvester-load-balancer/pkg/generated/clientset/versioned/typed/loadbalancer.harvesterhci.io/v1beta1"
@@ -38,3 +41,41 @@ func (i IPPoolCache) AddIndexer(indexName string, indexer generic.Indexer[*lbv1b
 func (i IPPoolCache) GetByIndex(indexName, key string) ([]*lbv1beta1.IPPool, error) {
 	panic("implement me")
 }
+
+type IPPoolClient func() lbv1.IPPoolInterface
+
+func (c IPPoolClient) Create(pool *lbv1beta1.IPPool) (*lbv1beta1.IPPool, error) {
+	return c().Create(context.TODO(), pool, metav1.CreateOptions{})
+}
+
+func (c IPPoolClient) Update(pool *lbv1beta1.IPPool) (*lbv1beta1.IPPool, error) {
+	return c().Update(context.TODO(), pool, metav1.UpdateOptions{})
+}
+
+func (c IPPoolClient) UpdateStatus(_ *lbv1beta1.IPPool) (*lbv1beta1.IPPool, error) {
+	panic("implement me")
+}
+
+func (c IPPoolClient) Delete(name string, options *metav1.DeleteOptions) error {
+	return c().Delete(context.TODO(), name, *options)
+}
+
+func (c IPPoolClient) Get(name string, options metav1.GetOptions) (*lbv1beta1.IPPool, error) {
+	return c().Get(context.TODO(), name, options)
+}
+
+func (c IPPoolClient) List(opts metav1.ListOptions) (*lbv1beta1.IPPoolList, error) {
+	return c().List(context.TODO(), opts)
+}
+
+func (c IPPoolClient) Watch(_ metav1.ListOptions) (watch.Interface, error) {
+	panic("implement me")
+}
+
+func (c IPPoolClient) Patch(_ string, _ types.PatchType, _ []byte, _ ...string) (*lbv1beta1.IPPool, error) {
+	panic("implement me")
+}
+
+func (c IPPoolClient) WithImpersonation(_ rest.ImpersonationConfig) (generic.NonNamespacedClientInterface[*lbv1beta1.IPPool, *lbv1beta1.IPPoolList], error) {
+	panic("implement me")
+}
@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"net"
+
+	corev1 "k8s.io/api/core/v1"
+
+	lbv1 "github.com/harvester/harvester-load-balancer/pkg/apis/loadbalancer.harvesterhci.io/v1beta1"
+)
+
+// GetIPFamilies returns the IP families required by the policy, the first one is the primary family
+// The policy defaults to IPv4 to be compatible with previous versions
+func GetIPFamilies(policy lbv1.IPFamilyPolicy) []corev1.IPFamily {
+	switch policy {
+	case lbv1.IPv6:
+		return []corev1.IPFamily{corev1.IPv6Protocol}
+	case lbv1.DualStack:
+		return []corev1.IPFamily{corev1.IPv4Protocol, corev1.IPv6Protocol}
+	default:
+		return []corev1.IPFamily{corev1.IPv4Protocol}
+	}
+}
+
+// IsIPFamilyCovered checks whether the single IP family covers all the families required by the policy
+// The family defaults to IPv4
+func IsIPFamilyCovered(family corev1.IPFamily, policy lbv1.IPFamilyPolicy) bool {
+	if family == "" {
+		family = corev1.IPv4Protocol
+	}
+	for _, f := range GetIPFamilies(policy) {
+		if f != family {
+			return false
+		}
+	}
+	return true
+}
+
+// GetIPFamily returns the IP family of an IP, or an empty value if the IP is invalid
+func GetIPFamily(ip net.IP) corev1.IPFamily {
+	if ip == nil {
+		return ""
+	}
+	if ip.To4() != nil {
+		return corev1.IPv4Protocol
+	}
+	return corev1.IPv6Protocol
+}
+
+// GetIPFamilyFromString parses the IP string and returns its IP family
+func GetIPFamilyFromString(ipStr string) corev1.IPFamily {
+	return GetIPFamily(net.ParseIP(ipStr))
+}
@@ -0,0 +1,7 @@
+package ipclaim
+
+const (
+	createErr = "can't create IP claim %s/%s because %w"
+	updateErr = "can't update IP claim %s/%s because %w"
+	deleteErr = "can't delete IP claim %s/%s because %w"
+)
@@ -0,0 +1,118 @@
+package ipclaim
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+
+	"github.com/harvester/webhook/pkg/server/admission"
+	admissionregv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	lbv1 "github.com/harvester/harvester-load-balancer/pkg/apis/loadbalancer.harvesterhci.io/v1beta1"
+	ctllbv1 "github.com/harvester/harvester-load-balancer/pkg/generated/controllers/loadbalancer.harvesterhci.io/v1beta1"
+	"github.com/harvester/harvester-load-balancer/pkg/utils"
+)
+
+type ipClaimValidator struct {
+	admission.DefaultValidator
+	ipPoolCache ctllbv1.IPPoolCache
+	lbCache     ctllbv1.LoadBalancerCache
+}
+
+var _ admission.Validator = &ipClaimValidator{}
+
+func NewIPClaimValidator(ipPoolCache ctllbv1.IPPoolCache, lbCache ctllbv1.LoadBalancerCache) admission.Validator {
+	return &ipClaimValidator{
+		ipPoolCache: ipPoolCache,
+		lbCache:     lbCache,
+	}
+}
+
+func (i *ipClaimValidator) Create(_ *admission.Request, newObj runtime.Object) error {
+	claim := newObj.(*lbv1.IPClaim)
+
+	if err := i.checkSpec(claim); err != nil {
+		return fmt.Errorf(createErr, claim.Namespace, claim.Name, err)
+	}
+
+	return nil
+}
+
+// Update rejects the spec change, the claim is re-created to book another address
+func (i *ipClaimValidator) Update(_ *admission.Request, oldObj, newObj runtime.Object) error {
+	oldClaim := oldObj.(*lbv1.IPClaim)
+	claim := newObj.(*lbv1.IPClaim)
+
+	if claim.DeletionTimestamp != nil {
+		return nil
+	}
+	if !reflect.DeepEqual(oldClaim.Spec, claim.Spec) {
+		return fmt.Errorf(updateErr, claim.Namespace, claim.Name, fmt.Errorf("spec is immutable"))
+	}
+
+	return nil
+}
+
+// Delete rejects deleting the claim whose address is still used by a load balancer
+func (i *ipClaimValidator) Delete(_ *admission.Request, oldObj runtime.Object) error {
+	claim := oldObj.(*lbv1.IPClaim)
+
+	lbs, err := i.lbCache.List(claim.Namespace, labels.Everything())
+	if err != nil {
+		return fmt.Errorf(deleteErr, claim.Namespace, claim.Name, err)
+	}
+	for _, lb := range lbs {
+		if lb.Spec.IPClaim == claim.Name {
+			return fmt.Errorf(deleteErr, claim.Namespace, claim.Name, fmt.Errorf("it is referred to by loadbalancer %s", lb.Name))
+		}
+	}
+
+	return nil
+}
+
+func (i *ipClaimValidator) Resource() admission.Resource {
+	return admission.Resource{
+		Names:      []string{"ipclaims"},
+		Scope:      admissionregv1.NamespacedScope,
+		APIGroup:   lbv1.SchemeGroupVersion.Group,
+		APIVersion: lbv1.SchemeGroupVersion.Version,
+		ObjectType: &lbv1.IPClaim{},
+		OperationTypes: []admissionregv1.OperationType{
+			admissionregv1.Create,
+			admissionregv1.Update,
+			admissionregv1.Delete,
+		},
+	}
+}
+
+// checkSpec checks the IP family and the requested IP of the claim, and the pool exists if it is specified
+func (i *ipClaimValidator) checkSpec(claim *lbv1.IPClaim) error {
+	family := claim.Spec.IPFamily
+	if family == "" {
+		family = corev1.IPv4Protocol
+	}
+	if family != corev1.IPv4Protocol && family != corev1.IPv6Protocol {
+		return fmt.Errorf("invalid IP family %s", family)
+	}
+
+	if claim.Spec.RequestedIP != "" {
+		ip := net.ParseIP(claim.Spec.RequestedIP)
+		if ip == nil {
+			return fmt.Errorf("invalid IP %s", claim.Spec.RequestedIP)
+		}
+		if utils.GetIPFamily(ip) != family {
+			return fmt.Errorf("IP %s is not in the IP family %s", claim.Spec.RequestedIP, family)
+		}
+	}
+
+	if claim.Spec.IPPool != "" {
+		if _, err := i.ipPoolCache.Get(claim.Spec.IPPool); err != nil {
+			return fmt.Errorf("get pool %s failed, error: %w", claim.Spec.IPPool, err)
+		}
+	}
+
+	return nil
+}
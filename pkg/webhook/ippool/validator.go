@@ -3,29 +3,42 @@ package ippool
 import (
 	"fmt"
 	"net"
+	"reflect"
+	"sort"
+	"strings"
 
 	"github.com/containernetworking/plugins/plugins/ipam/host-local/backend/allocator"
 	"github.com/harvester/webhook/pkg/server/admission"
+	ctlcorev1 "github.com/rancher/wrangler/v3/pkg/generated/controllers/core/v1"
 	admissionregv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 
 	lbv1 "github.com/harvester/harvester-load-balancer/pkg/apis/loadbalancer.harvesterhci.io/v1beta1"
 	ctllbv1 "github.com/harvester/harvester-load-balancer/pkg/generated/controllers/loadbalancer.harvesterhci.io/v1beta1"
 	"github.com/harvester/harvester-load-balancer/pkg/ipam"
+	"github.com/harvester/harvester-load-balancer/pkg/ipam/store"
 	"github.com/harvester/harvester-load-balancer/pkg/utils"
 )
 
 type ipPoolValidator struct {
 	admission.DefaultValidator
-	ipPoolCache ctllbv1.IPPoolCache
+	ipPoolCache     ctllbv1.IPPoolCache
+	allocationCache ctllbv1.IPAllocationCache
+	claimCache      ctllbv1.IPClaimCache
+	namespaceCache  ctlcorev1.NamespaceCache
 }
 
 var _ admission.Validator = &ipPoolValidator{}
 
-func NewIPPoolValidator(ipPoolCache ctllbv1.IPPoolCache) admission.Validator {
+func NewIPPoolValidator(ipPoolCache ctllbv1.IPPoolCache, allocationCache ctllbv1.IPAllocationCache,
+	claimCache ctllbv1.IPClaimCache, namespaceCache ctlcorev1.NamespaceCache) admission.Validator {
 	return &ipPoolValidator{
-		ipPoolCache: ipPoolCache,
+		ipPoolCache:     ipPoolCache,
+		allocationCache: allocationCache,
+		claimCache:      claimCache,
+		namespaceCache:  namespaceCache,
 	}
 }
 
@@ -40,7 +53,7 @@ func (i *ipPoolValidator) Create(_ *admission.Request, newObj runtime.Object) er
 		return fmt.Errorf(createErr, pool.Name, err)
 	}
 
-	others, err := i.getOtherPoolsRanges(pool.Name)
+	others, err := i.getOtherPoolsRanges(pool)
 	if err != nil {
 		return fmt.Errorf(createErr, pool.Name, err)
 	}
@@ -49,10 +62,50 @@ func (i *ipPoolValidator) Create(_ *admission.Request, newObj runtime.Object) er
 		return fmt.Errorf(createErr, pool.Name, err)
 	}
 
+	if err := i.checkParent(pool, rs); err != nil {
+		return fmt.Errorf(createErr, pool.Name, err)
+	}
+
+	children, err := ipam.GetChildren(i.ipPoolCache, pool.Name)
+	if err != nil {
+		return fmt.Errorf(createErr, pool.Name, err)
+	}
+
+	if err := checkChildren(rs, children); err != nil {
+		return fmt.Errorf(createErr, pool.Name, err)
+	}
+
+	// the range set without the excluded IPs and the IPs delegated to the child pools
+	spec, err := ipam.ExcludeDelegated(&pool.Spec, children)
+	if err != nil {
+		return fmt.Errorf(createErr, pool.Name, err)
+	}
+	availableRS, err := ipam.LBPoolSpecToAllocatorRangeSet(spec)
+	if err != nil {
+		return fmt.Errorf(createErr, pool.Name, err)
+	}
+
+	allocated, err := store.GetAllocated(i.allocationCache, pool)
+	if err != nil {
+		return fmt.Errorf(createErr, pool.Name, err)
+	}
+
+	if err := checkReservations(availableRS, pool, allocated); err != nil {
+		return fmt.Errorf(createErr, pool.Name, err)
+	}
+
 	if err := i.checkSelector(pool); err != nil {
 		return fmt.Errorf(createErr, pool.Name, err)
 	}
 
+	if err := checkQuotas(pool); err != nil {
+		return fmt.Errorf(createErr, pool.Name, err)
+	}
+
+	if err := checkExpansion(pool); err != nil {
+		return fmt.Errorf(createErr, pool.Name, err)
+	}
+
 	return nil
 }
 
@@ -72,7 +125,7 @@ func (i *ipPoolValidator) Update(_ *admission.Request, _, newObj runtime.Object)
 		return fmt.Errorf(updateErr, pool.Name, err)
 	}
 
-	others, err := i.getOtherPoolsRanges(pool.Name)
+	others, err := i.getOtherPoolsRanges(pool)
 	if err != nil {
 		return fmt.Errorf(updateErr, pool.Name, err)
 	}
@@ -81,7 +134,42 @@ func (i *ipPoolValidator) Update(_ *admission.Request, _, newObj runtime.Object)
 		return fmt.Errorf(updateErr, pool.Name, err)
 	}
 
-	if err := checkAllocated(rs, pool.Status.Allocated); err != nil {
+	if err := i.checkParent(pool, rs); err != nil {
+		return fmt.Errorf(updateErr, pool.Name, err)
+	}
+
+	children, err := ipam.GetChildren(i.ipPoolCache, pool.Name)
+	if err != nil {
+		return fmt.Errorf(updateErr, pool.Name, err)
+	}
+
+	if err := checkChildren(rs, children); err != nil {
+		return fmt.Errorf(updateErr, pool.Name, err)
+	}
+
+	// the range set without the excluded IPs and the IPs delegated to the child pools
+	spec, err := ipam.ExcludeDelegated(&pool.Spec, children)
+	if err != nil {
+		return fmt.Errorf(updateErr, pool.Name, err)
+	}
+	availableRS, err := ipam.LBPoolSpecToAllocatorRangeSet(spec)
+	if err != nil {
+		return fmt.Errorf(updateErr, pool.Name, err)
+	}
+
+	allocated, err := store.GetAllocated(i.allocationCache, pool)
+	if err != nil {
+		return fmt.Errorf(updateErr, pool.Name, err)
+	}
+
+	// the ranges and exclusions can't be changed to exclude the allocated IPs unless the pool re-addresses them
+	if !pool.Spec.ReAddress {
+		if err := checkAllocated(availableRS, allocated); err != nil {
+			return fmt.Errorf(updateErr, pool.Name, err)
+		}
+	}
+
+	if err := checkReservations(availableRS, pool, allocated); err != nil {
 		return fmt.Errorf(updateErr, pool.Name, err)
 	}
 
@@ -89,13 +177,44 @@ func (i *ipPoolValidator) Update(_ *admission.Request, _, newObj runtime.Object)
 		return fmt.Errorf(updateErr, pool.Name, err)
 	}
 
+	if err := checkQuotas(pool); err != nil {
+		return fmt.Errorf(updateErr, pool.Name, err)
+	}
+
+	if err := checkExpansion(pool); err != nil {
+		return fmt.Errorf(updateErr, pool.Name, err)
+	}
+
 	return nil
 }
 
 func (i *ipPoolValidator) Delete(_ *admission.Request, oldObj runtime.Object) error {
 	pool := oldObj.(*lbv1.IPPool)
 
-	if len(pool.Status.Allocated) != 0 {
+	children, err := ipam.GetChildren(i.ipPoolCache, pool.Name)
+	if err != nil {
+		return err
+	}
+	if len(children) != 0 {
+		return fmt.Errorf("can't delete pool before deleting its child pool %s", children[0].Name)
+	}
+
+	// the claim would book an address from the pool again if it isn't bound yet
+	claims, err := i.claimCache.List(metav1.NamespaceAll, labels.Everything())
+	if err != nil {
+		return err
+	}
+	for _, claim := range claims {
+		if claim.Spec.IPPool == pool.Name || claim.Status.AllocatedAddress.IPPool == pool.Name {
+			return fmt.Errorf("can't delete pool before deleting IP claim %s/%s", claim.Namespace, claim.Name)
+		}
+	}
+
+	allocated, err := store.GetAllocated(i.allocationCache, pool)
+	if err != nil {
+		return err
+	}
+	if len(allocated) != 0 {
 		return fmt.Errorf("can't delete pool before releasing all the allocated IP")
 	}
 
@@ -117,7 +236,9 @@ func (i *ipPoolValidator) Resource() admission.Resource {
 	}
 }
 
-func (i *ipPoolValidator) getOtherPoolsRanges(myName string) ([]allocator.RangeSet, error) {
+// getOtherPoolsRanges returns the ranges of the pools which the pool's ranges can't overlap, the ranges of its
+// ancestors and descendants are delegated to or from it
+func (i *ipPoolValidator) getOtherPoolsRanges(pool *lbv1.IPPool) ([]allocator.RangeSet, error) {
 	pools, err := i.ipPoolCache.List(labels.Everything())
 	if err != nil {
 		return nil, err
@@ -127,20 +248,128 @@ func (i *ipPoolValidator) getOtherPoolsRanges(myName string) ([]allocator.RangeS
 		return nil, nil
 	}
 
+	byName := make(map[string]*lbv1.IPPool, lengthOfPools)
+	for _, p := range pools {
+		byName[p.Name] = p
+	}
+	ancestors := getAncestors(pool, byName)
+
 	rangSets := make([]allocator.RangeSet, 0, lengthOfPools)
 	for _, p := range pools {
-		if p.Name != myName {
-			r, err := ipam.LBRangesToAllocatorRangeSet(p.Spec.Ranges)
-			if err != nil {
-				return nil, err
-			}
-			rangSets = append(rangSets, r)
+		if p.Name == pool.Name || ancestors[p.Name] || getAncestors(p, byName)[pool.Name] {
+			continue
+		}
+		r, err := ipam.LBRangesToAllocatorRangeSet(p.Spec.Ranges)
+		if err != nil {
+			return nil, err
 		}
+		rangSets = append(rangSets, r)
 	}
 
 	return rangSets, nil
 }
 
+// getAncestors returns the names of the ancestors of the pool, the walk stops at a missing pool or a cycle
+func getAncestors(pool *lbv1.IPPool, pools map[string]*lbv1.IPPool) map[string]bool {
+	ancestors := make(map[string]bool)
+	for parent := pool.Spec.Parent; parent != "" && parent != pool.Name && !ancestors[parent]; {
+		ancestors[parent] = true
+		p, ok := pools[parent]
+		if !ok {
+			break
+		}
+		parent = p.Spec.Parent
+	}
+
+	return ancestors
+}
+
+// checkParent checks the parent exists without a cycle, and the ranges are inside the ranges of the parent and don't
+// contain the IPs allocated or reserved by the parent
+func (i *ipPoolValidator) checkParent(pool *lbv1.IPPool, rs allocator.RangeSet) error {
+	if pool.Spec.Parent == "" {
+		return nil
+	}
+	if pool.Spec.Parent == pool.Name {
+		return fmt.Errorf("pool can't be the parent of itself")
+	}
+
+	parent, err := i.ipPoolCache.Get(pool.Spec.Parent)
+	if err != nil {
+		return fmt.Errorf("get parent pool %s failed, error: %w", pool.Spec.Parent, err)
+	}
+	visited := make(map[string]bool)
+	for name := parent.Spec.Parent; name != "" && !visited[name]; {
+		if name == pool.Name {
+			return fmt.Errorf("parent pool %s is a descendant of the pool", parent.Name)
+		}
+		visited[name] = true
+		p, err := i.ipPoolCache.Get(name)
+		if err != nil {
+			break
+		}
+		name = p.Spec.Parent
+	}
+
+	parentRS, err := ipam.LBRangesToAllocatorRangeSet(parent.Spec.Ranges)
+	if err != nil {
+		return err
+	}
+	if err := checkInside(rs, parentRS); err != nil {
+		return fmt.Errorf("%w of parent pool %s", err, parent.Name)
+	}
+
+	allocated, err := store.GetAllocated(i.allocationCache, parent)
+	if err != nil {
+		return err
+	}
+	for ipStr, applicant := range allocated {
+		if ip := net.ParseIP(ipStr); ip != nil && rs.Contains(ip) {
+			return fmt.Errorf("IP %s allocated to %s from parent pool %s can't be delegated", ipStr, applicant, parent.Name)
+		}
+	}
+	for ipStr, applicant := range parent.Spec.Reservations {
+		if ip := net.ParseIP(ipStr); ip != nil && rs.Contains(ip) {
+			return fmt.Errorf("IP %s reserved for %s in parent pool %s can't be delegated", ipStr, applicant, parent.Name)
+		}
+	}
+
+	return nil
+}
+
+// checkChildren checks the ranges still contain the ranges delegated to the child pools
+func checkChildren(rs allocator.RangeSet, children []*lbv1.IPPool) error {
+	for _, child := range children {
+		childRS, err := ipam.LBRangesToAllocatorRangeSet(child.Spec.Ranges)
+		if err != nil {
+			return err
+		}
+		if err := checkInside(childRS, rs); err != nil {
+			return fmt.Errorf("the ranges of child pool %s are not inside the ranges: %w", child.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// checkInside checks each range of rs is inside one range of the outer range set
+func checkInside(rs, outer allocator.RangeSet) error {
+	for i := range rs {
+		inside := false
+		for j := range outer {
+			if outer[j].Contains(rs[i].RangeStart) && outer[j].Contains(rs[i].RangeEnd) {
+				inside = true
+				break
+			}
+		}
+		if !inside {
+			return fmt.Errorf("range %s is not inside the ranges", rs[i].String())
+		}
+	}
+
+	return nil
+}
+
 func checkRange(r allocator.RangeSet, others ...allocator.RangeSet) error {
 	// check overlaps among the ranges of rangeSet r
 	for i, r1 := range r {
@@ -176,6 +405,75 @@ func checkAllocated(rs allocator.RangeSet, allocated map[string]string) error {
 	return nil
 }
 
+// checkReservations checks the reserved IPs are available in the pool and not allocated to others.
+// Each load balancer can only reserve one IP per IP family.
+func checkReservations(rs allocator.RangeSet, pool *lbv1.IPPool, allocated map[string]string) error {
+	reserved := make(map[string]string, len(pool.Spec.Reservations))
+	for ipStr, applicant := range pool.Spec.Reservations {
+		ip := net.ParseIP(ipStr)
+		if ip == nil {
+			return fmt.Errorf("invalid reserved ip %s", ipStr)
+		}
+
+		r, err := rs.RangeFor(ip)
+		if err != nil {
+			return fmt.Errorf("reserved IP %s is out of the ranges or excluded", ipStr)
+		}
+		if ip.Equal(r.Gateway) {
+			return fmt.Errorf("reserved IP %s is the gateway", ipStr)
+		}
+
+		if fields := strings.Split(applicant, "/"); len(fields) != 2 || fields[0] == "" || fields[1] == "" {
+			return fmt.Errorf("reserved IP %s has invalid applicant %s, it should be namespace/name", ipStr, applicant)
+		}
+
+		key := applicant + "/" + string(utils.GetIPFamily(ip))
+		if other, ok := reserved[key]; ok {
+			return fmt.Errorf("IP %s and %s are reserved for %s in the same IP family", other, ipStr, applicant)
+		}
+		reserved[key] = ipStr
+
+		if owner, ok := allocated[ip.String()]; ok && owner != applicant {
+			return fmt.Errorf("reserved IP %s has been allocated to %s", ipStr, owner)
+		}
+	}
+
+	return nil
+}
+
+// checkQuotas checks each tenant has at most one quota and the minimum of a quota doesn't exceed its maximum
+func checkQuotas(pool *lbv1.IPPool) error {
+	quotas := pool.Spec.Quotas
+	for j := range quotas {
+		for k := j + 1; k < len(quotas); k++ {
+			if reflect.DeepEqual(quotas[j].Tuple, quotas[k].Tuple) {
+				return fmt.Errorf("tenant %+v has more than one quota", quotas[j].Tuple)
+			}
+		}
+
+		if quotas[j].Max > 0 && quotas[j].Min > quotas[j].Max {
+			return fmt.Errorf("quota of tenant %+v has min %d greater than max %d", quotas[j].Tuple, quotas[j].Min, quotas[j].Max)
+		}
+	}
+
+	return nil
+}
+
+// checkExpansion checks the expansion policy is valid, a child pool can't be expanded out of its parent
+func checkExpansion(pool *lbv1.IPPool) error {
+	if pool.Spec.Expansion == nil {
+		return nil
+	}
+	if pool.Spec.Parent != "" {
+		return fmt.Errorf("child pool can't be expanded")
+	}
+	if _, _, err := ipam.ParseExpansion(&pool.Spec); err != nil {
+		return fmt.Errorf("invalid expansion: %w", err)
+	}
+
+	return nil
+}
+
 // checkSelector checks if the selector is valid.
 // It's allowed to create a global IP pool only when there is no global IP pool.
 // When a pool checking scope overlaps with other pools, ignore the global IP pool.
@@ -184,7 +482,7 @@ func (i *ipPoolValidator) checkSelector(pool *lbv1.IPPool) error {
 		return i.checkGlobalIPPool(pool)
 	}
 
-	if err := checkSelectorItself(pool); err != nil {
+	if err := i.checkSelectorItself(pool); err != nil {
 		return fmt.Errorf("selector %+v is invalid: %w", pool.Spec.Selector, err)
 	}
 
@@ -209,24 +507,26 @@ func (i *ipPoolValidator) checkGlobalIPPool(pool *lbv1.IPPool) error {
 	return nil
 }
 
-func checkSelectorItself(pool *lbv1.IPPool) error {
-	r := &ipam.Requirement{Network: pool.Spec.Selector.Network}
-	lenOfSelectorScope := len(pool.Spec.Selector.Scope)
-
-	for i, t := range pool.Spec.Selector.Scope {
-		r.Project = t.Project
-		r.Namespace = t.Namespace
-		r.Cluster = t.GuestCluster
-		s := lbv1.Selector{
-			Priority: pool.Spec.Selector.Priority,
-			Network:  pool.Spec.Selector.Network,
+func (i *ipPoolValidator) checkSelectorItself(pool *lbv1.IPPool) error {
+	scope := pool.Spec.Selector.Scope
+	for j := range scope {
+		if scope[j].NamespaceSelector == nil {
+			continue
 		}
-		if i != lenOfSelectorScope-1 {
-			s.Scope = pool.Spec.Selector.Scope[i+1:]
+		if _, err := metav1.LabelSelectorAsSelector(scope[j].NamespaceSelector); err != nil {
+			return fmt.Errorf("invalid namespace selector: %w", err)
 		}
+	}
 
-		if ipam.NewMatcher(s).Matches(r) {
-			return fmt.Errorf("scope overlaps")
+	for j := range scope {
+		for k := j + 1; k < len(scope); k++ {
+			overlap, err := i.tuplesOverlap(&scope[j], &scope[k])
+			if err != nil {
+				return err
+			}
+			if overlap != "" {
+				return fmt.Errorf("scope overlaps, %s", overlap)
+			}
 		}
 	}
 
@@ -247,17 +547,17 @@ func (i *ipPoolValidator) checkSelectorWithOthers(pool *lbv1.IPPool) error {
 		// priority could not be same if it's not zero
 		if p.Spec.Selector.Priority != 0 && p.Spec.Selector.Priority == pool.Spec.Selector.Priority {
 			return fmt.Errorf("the priority can't be the same as the pool %s", p.Name)
-		} else if p.Spec.Selector.Priority == 0 && pool.Spec.Selector.Priority == 0 {
+		} else if p.Spec.Selector.Priority == 0 && pool.Spec.Selector.Priority == 0 && p.Spec.Selector.Network == pool.Spec.Selector.Network {
 			// check the scope overlaps if both of them are zero
-			r := &ipam.Requirement{
-				Network: pool.Spec.Selector.Network,
-			}
-			for _, t := range pool.Spec.Selector.Scope {
-				r.Project = t.Project
-				r.Namespace = t.Namespace
-				r.Cluster = t.GuestCluster
-				if ipam.NewMatcher(p.Spec.Selector).Matches(r) {
-					return fmt.Errorf("scope selector is same as the pool %s with priority 0 Project %v Namespace %v GuestCluster %v, set a different priority or scope", p.Name, t.Project, t.Namespace, t.GuestCluster)
+			for j := range pool.Spec.Selector.Scope {
+				for k := range p.Spec.Selector.Scope {
+					overlap, err := i.tuplesOverlap(&pool.Spec.Selector.Scope[j], &p.Spec.Selector.Scope[k])
+					if err != nil {
+						return err
+					}
+					if overlap != "" {
+						return fmt.Errorf("scope selector is same as the pool %s with priority 0, %s, set a different priority or scope", p.Name, overlap)
+					}
 				}
 			}
 		}
@@ -265,3 +565,39 @@ func (i *ipPoolValidator) checkSelectorWithOthers(pool *lbv1.IPPool) error {
 
 	return nil
 }
+
+// tuplesOverlap describes how a load balancer may be matched by both tuples, empty if it can't be.
+// The namespace patterns are compared unless any tuple selects the namespaces by labels, in which case the existing
+// namespaces matched by both tuples are reported as the ambiguous matches.
+func (i *ipPoolValidator) tuplesOverlap(t1, t2 *lbv1.Tuple) (string, error) {
+	if !ipam.GlobOverlap(t1.Project, t2.Project) || !ipam.GlobOverlap(t1.GuestCluster, t2.GuestCluster) {
+		return "", nil
+	}
+	if t1.NamespaceSelector == nil && t2.NamespaceSelector == nil {
+		if !ipam.GlobOverlap(t1.Namespace, t2.Namespace) {
+			return "", nil
+		}
+		return fmt.Sprintf("Project %v Namespace %v GuestCluster %v", t1.Project, t1.Namespace, t1.GuestCluster), nil
+	}
+
+	namespaces, err := i.namespaceCache.List(labels.Everything())
+	if err != nil {
+		return "", err
+	}
+	m1 := ipam.NewMatcher(lbv1.Selector{Scope: []lbv1.Tuple{*t1}})
+	m2 := ipam.NewMatcher(lbv1.Selector{Scope: []lbv1.Tuple{*t2}})
+	var ambiguous []string
+	for _, ns := range namespaces {
+		// the project and the guest cluster overlap already
+		r := &ipam.Requirement{Project: ipam.All, Namespace: ns.Name, Cluster: ipam.All, NamespaceLabels: ns.Labels}
+		if m1.Matches(r) && m2.Matches(r) {
+			ambiguous = append(ambiguous, ns.Name)
+		}
+	}
+	if len(ambiguous) == 0 {
+		return "", nil
+	}
+	sort.Strings(ambiguous)
+
+	return fmt.Sprintf("namespaces %s are matched ambiguously", strings.Join(ambiguous, ", ")), nil
+}
@@ -3,14 +3,19 @@ package ippool
 import (
 	"net"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/containernetworking/cni/pkg/types"
 	"github.com/containernetworking/plugins/plugins/ipam/host-local/backend/allocator"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
 
 	lbv1 "github.com/harvester/harvester-load-balancer/pkg/apis/loadbalancer.harvesterhci.io/v1beta1"
 	"github.com/harvester/harvester-load-balancer/pkg/generated/clientset/versioned/fake"
 	"github.com/harvester/harvester-load-balancer/pkg/ipam"
+	"github.com/harvester/harvester-load-balancer/pkg/ipam/store"
 	"github.com/harvester/harvester-load-balancer/pkg/utils"
 	"github.com/harvester/harvester-load-balancer/pkg/utils/fakeclients"
 )
@@ -114,6 +119,167 @@ func TestCheckAllocated(t *testing.T) {
 	}
 }
 
+func TestCheckAllocatedWithExclusion(t *testing.T) {
+	specs := []lbv1.IPPoolSpec{
+		{
+			Ranges:  []lbv1.Range{{Subnet: "192.168.0.0/24"}},
+			Exclude: []string{"192.168.0.20"},
+		},
+		{
+			Ranges:  []lbv1.Range{{Subnet: "192.168.0.0/24"}},
+			Exclude: []string{"192.168.0.8/29"},
+		},
+		{
+			Ranges:  []lbv1.Range{{Subnet: "192.168.0.0/24"}},
+			Exclude: []string{"192.168.0.16/28"},
+		},
+	}
+	allocated := map[string]string{
+		"192.168.0.11": "default/lb1",
+	}
+
+	expected := []bool{true, false, true}
+
+	for i := range specs {
+		rs, err := ipam.LBPoolSpecToAllocatorRangeSet(&specs[i])
+		if err != nil {
+			t.Fatalf("case%d failed, transfer %+v to rangeset failed, error: %s", i, specs[i], err.Error())
+		}
+		if err := checkAllocated(rs, allocated); (err == nil) != expected[i] {
+			t.Errorf("case%d failed, checkAllocated(%v, %v)", i, rs, allocated)
+		}
+	}
+}
+
+func TestUpdateReAddress(t *testing.T) {
+	pool := &lbv1.IPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "pool1"},
+		Spec: lbv1.IPPoolSpec{
+			Ranges: []lbv1.Range{{Subnet: "192.168.0.0/24", RangeStart: "192.168.0.20"}},
+		},
+	}
+	clientSet := fake.NewSimpleClientset(pool, store.NewAllocation(pool, "192.168.0.11", "default/lb1"))
+	validator := &ipPoolValidator{
+		ipPoolCache:     fakeclients.IPPoolCache(clientSet.LoadbalancerV1beta1().IPPools),
+		allocationCache: fakeclients.IPAllocationCache(clientSet.LoadbalancerV1beta1().IPAllocations),
+	}
+
+	tests := []struct {
+		name      string
+		reAddress bool
+		wantErr   bool
+	}{
+		{
+			name:    "allocated IP is excluded",
+			wantErr: true,
+		},
+		{
+			name:      "allocated IP is re-addressed",
+			reAddress: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			updated := pool.DeepCopy()
+			updated.Spec.ReAddress = tt.reAddress
+			if err := validator.Update(nil, pool, updated); (err != nil) != tt.wantErr {
+				t.Errorf("Update() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckQuotas(t *testing.T) {
+	tests := []struct {
+		name    string
+		quotas  []lbv1.Quota
+		wantErr bool
+	}{
+		{
+			name: "valid quotas",
+			quotas: []lbv1.Quota{
+				{Tuple: lbv1.Tuple{Namespace: "ns1"}, Max: 2, Min: 1},
+				{Tuple: lbv1.Tuple{Project: "p1", Namespace: "*"}, Min: 5},
+			},
+		},
+		{
+			name: "duplicate tenant",
+			quotas: []lbv1.Quota{
+				{Tuple: lbv1.Tuple{Namespace: "ns1"}, Max: 2},
+				{Tuple: lbv1.Tuple{Namespace: "ns1"}, Max: 3},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "min greater than max",
+			quotas:  []lbv1.Quota{{Tuple: lbv1.Tuple{Namespace: "ns1"}, Max: 2, Min: 3}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := &lbv1.IPPool{Spec: lbv1.IPPoolSpec{Quotas: tt.quotas}}
+			if err := checkQuotas(pool); (err != nil) != tt.wantErr {
+				t.Errorf("checkQuotas() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckReservations(t *testing.T) {
+	spec := lbv1.IPPoolSpec{
+		Ranges:  []lbv1.Range{{Subnet: "192.168.0.0/24"}, {Subnet: "fd00::/120"}},
+		Exclude: []string{"192.168.0.100"},
+	}
+	rs, err := ipam.LBPoolSpecToAllocatorRangeSet(&spec)
+	if err != nil {
+		t.Fatalf("transfer %+v to rangeset failed, error: %s", spec, err.Error())
+	}
+	allocated := map[string]string{
+		"192.168.0.11": "default/lb1",
+	}
+
+	reservationsList := []map[string]string{
+		{
+			"192.168.0.11": "default/lb1",
+			"fd00::11":     "default/lb1",
+		},
+		{
+			"192.168.0.11": "default/lb2",
+		},
+		{
+			"192.168.0.100": "default/lb2",
+		},
+		{
+			"192.168.0.1": "default/lb2",
+		},
+		{
+			"192.168.0.12": "lb2",
+		},
+		{
+			"192.168.0.12": "default/lb2",
+			"192.168.0.13": "default/lb2",
+		},
+		{
+			"xxxxxxxx": "default/lb2",
+		},
+	}
+
+	expected := []bool{true, false, false, false, false, false, false}
+
+	for i, reservations := range reservationsList {
+		pool := &lbv1.IPPool{
+			Spec: *spec.DeepCopy(),
+		}
+		pool.Spec.Reservations = reservations
+		if err := checkReservations(rs, pool, allocated); (err == nil) != expected[i] {
+			t.Errorf("case%d failed, checkReservations(%v, %v), error: %v", i, rs, reservations, err)
+		}
+	}
+}
+
 func TestCheckSelector(t *testing.T) {
 	cases, err := utils.GetSubdirectories("./testdata")
 	if err != nil {
@@ -136,6 +302,10 @@ func TestCheckSelector(t *testing.T) {
 		"case7": true,
 		// case8: There are scope overlaps of the input IP pool with the existing IP pools
 		"case8": false,
+		// case9: The namespace patterns of the input IP pool and the existing IP pools don't overlap
+		"case9": true,
+		// case10: The namespace patterns of the input IP pool and the existing IP pools overlap
+		"case10": false,
 	}
 
 	for _, c := range cases {
@@ -162,3 +332,280 @@ func TestCheckSelector(t *testing.T) {
 		}
 	}
 }
+
+func TestCheckSelectorWithNamespaceSelector(t *testing.T) {
+	existing := &lbv1.IPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "existing"},
+		Spec: lbv1.IPPoolSpec{
+			Ranges: []lbv1.Range{{Subnet: "192.168.10.0/24"}},
+			Selector: lbv1.Selector{
+				Scope: []lbv1.Tuple{{Project: "*", Namespace: "prod-*", GuestCluster: "*"}},
+			},
+		},
+	}
+	clientset := fake.NewSimpleClientset(existing)
+	k8sclientset := k8sfake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "prod-a", Labels: map[string]string{"tier": "prod"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "dev-a", Labels: map[string]string{"tier": "dev"}}},
+	)
+	validator := &ipPoolValidator{
+		ipPoolCache:    fakeclients.IPPoolCache(clientset.LoadbalancerV1beta1().IPPools),
+		namespaceCache: fakeclients.NamespaceCache(k8sclientset.CoreV1().Namespaces),
+	}
+
+	tests := []struct {
+		name     string
+		selector *metav1.LabelSelector
+		wantErr  string
+	}{
+		{
+			name:     "no namespace is matched by both",
+			selector: &metav1.LabelSelector{MatchLabels: map[string]string{"tier": "dev"}},
+		},
+		{
+			name:     "the namespace is matched ambiguously",
+			selector: &metav1.LabelSelector{MatchLabels: map[string]string{"tier": "prod"}},
+			wantErr:  "namespaces prod-a are matched ambiguously",
+		},
+		{
+			name: "invalid selector",
+			selector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "tier", Operator: "invalid"},
+			}},
+			wantErr: "invalid namespace selector",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := &lbv1.IPPool{
+				ObjectMeta: metav1.ObjectMeta{Name: "input"},
+				Spec: lbv1.IPPoolSpec{
+					Ranges: []lbv1.Range{{Subnet: "192.168.11.0/24"}},
+					Selector: lbv1.Selector{
+						Scope: []lbv1.Tuple{{Project: "*", NamespaceSelector: tt.selector, GuestCluster: "*"}},
+					},
+				},
+			}
+			err := validator.checkSelector(pool)
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("checkSelector() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("checkSelector() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckParent(t *testing.T) {
+	parent := &lbv1.IPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "parent"},
+		Spec: lbv1.IPPoolSpec{
+			Ranges:       []lbv1.Range{{Subnet: "192.168.0.0/24", RangeStart: "192.168.0.10", RangeEnd: "192.168.0.100"}},
+			Reservations: map[string]string{"192.168.0.95": "default/lb2"},
+		},
+	}
+	child1 := &lbv1.IPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "child1"},
+		Spec: lbv1.IPPoolSpec{
+			Ranges: []lbv1.Range{{Subnet: "192.168.0.0/24", RangeStart: "192.168.0.20", RangeEnd: "192.168.0.29"}},
+			Parent: "parent",
+		},
+	}
+	clientSet := fake.NewSimpleClientset(parent, child1, store.NewAllocation(parent, "192.168.0.15", "default/lb1"))
+	validator := &ipPoolValidator{
+		ipPoolCache:     fakeclients.IPPoolCache(clientSet.LoadbalancerV1beta1().IPPools),
+		allocationCache: fakeclients.IPAllocationCache(clientSet.LoadbalancerV1beta1().IPAllocations),
+	}
+
+	newChild := func(parent, start, end string) *lbv1.IPPool {
+		return &lbv1.IPPool{
+			ObjectMeta: metav1.ObjectMeta{Name: "child2"},
+			Spec: lbv1.IPPoolSpec{
+				Ranges: []lbv1.Range{{Subnet: "192.168.0.0/24", RangeStart: start, RangeEnd: end}},
+				Parent: parent,
+			},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		pool    *lbv1.IPPool
+		wantErr bool
+	}{
+		{
+			name: "child inside the parent",
+			pool: newChild("parent", "192.168.0.30", "192.168.0.39"),
+		},
+		{
+			name:    "child overlaps its sibling",
+			pool:    newChild("parent", "192.168.0.25", "192.168.0.35"),
+			wantErr: true,
+		},
+		{
+			name:    "child out of the parent",
+			pool:    newChild("parent", "192.168.0.90", "192.168.0.110"),
+			wantErr: true,
+		},
+		{
+			name:    "child overlaps the parent without being its child",
+			pool:    newChild("", "192.168.0.30", "192.168.0.39"),
+			wantErr: true,
+		},
+		{
+			name:    "child contains the IP allocated by the parent",
+			pool:    newChild("parent", "192.168.0.10", "192.168.0.19"),
+			wantErr: true,
+		},
+		{
+			name:    "child contains the IP reserved by the parent",
+			pool:    newChild("parent", "192.168.0.90", "192.168.0.99"),
+			wantErr: true,
+		},
+		{
+			name:    "parent doesn't exist",
+			pool:    newChild("missing", "192.168.0.150", "192.168.0.159"),
+			wantErr: true,
+		},
+		{
+			name: "grandchild inside the child",
+			pool: newChild("child1", "192.168.0.20", "192.168.0.24"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validator.Create(nil, tt.pool); (err != nil) != tt.wantErr {
+				t.Errorf("Create() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// the parent can't be a descendant of the pool
+	cyclic := parent.DeepCopy()
+	cyclic.Spec.Parent = child1.Name
+	if err := validator.Update(nil, parent, cyclic); err == nil {
+		t.Errorf("expect the cyclic parent is rejected")
+	}
+	// the parent can't shrink to drop the delegated ranges
+	shrunk := parent.DeepCopy()
+	shrunk.Spec.Ranges[0].RangeStart = "192.168.0.25"
+	if err := validator.Update(nil, parent, shrunk); err == nil {
+		t.Errorf("expect the parent shrinking out of the child ranges is rejected")
+	}
+	// the parent can't reserve the delegated IPs
+	reserved := parent.DeepCopy()
+	reserved.Spec.Reservations["192.168.0.21"] = "default/lb3"
+	if err := validator.Update(nil, parent, reserved); err == nil {
+		t.Errorf("expect the reservation of the delegated IP is rejected")
+	}
+	if err := validator.Delete(nil, parent); err == nil || !strings.Contains(err.Error(), "child pool") {
+		t.Errorf("expect the parent with child pools can't be deleted")
+	}
+}
+
+func TestCheckExpansion(t *testing.T) {
+	ranges := []lbv1.Range{{Subnet: "192.168.0.0/24", RangeStart: "192.168.0.10", RangeEnd: "192.168.0.20"}}
+	tests := []struct {
+		name      string
+		parent    string
+		expansion *lbv1.Expansion
+		wantErr   bool
+	}{
+		{
+			name: "no expansion",
+		},
+		{
+			name:      "supernet inside the subnet",
+			expansion: &lbv1.Expansion{Supernet: "192.168.0.128/25", ChunkSize: 16},
+		},
+		{
+			name:      "supernet out of the subnet",
+			expansion: &lbv1.Expansion{Supernet: "192.168.1.0/25", ChunkSize: 16},
+			wantErr:   true,
+		},
+		{
+			name:      "zero chunk size",
+			expansion: &lbv1.Expansion{Supernet: "192.168.0.128/25"},
+			wantErr:   true,
+		},
+		{
+			name:      "child pool",
+			parent:    "parent",
+			expansion: &lbv1.Expansion{Supernet: "192.168.0.128/25", ChunkSize: 16},
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := &lbv1.IPPool{
+				Spec: lbv1.IPPoolSpec{Ranges: ranges, Parent: tt.parent, Expansion: tt.expansion},
+			}
+			if err := checkExpansion(pool); (err != nil) != tt.wantErr {
+				t.Errorf("checkExpansion() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDeleteWithIPClaim(t *testing.T) {
+	pool := &lbv1.IPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "pool"},
+		Spec: lbv1.IPPoolSpec{
+			Ranges: []lbv1.Range{{Subnet: "192.168.0.0/24"}},
+		},
+	}
+	tests := []struct {
+		name    string
+		claim   *lbv1.IPClaim
+		wantErr bool
+	}{
+		{
+			name: "no claim",
+		},
+		{
+			name: "claim bound to the pool",
+			claim: &lbv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "claim"},
+				Status: lbv1.IPClaimStatus{
+					AllocatedAddress: lbv1.AllocatedAddress{IPPool: "pool", IP: "192.168.0.10"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "claim pending on the pool",
+			claim: &lbv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "claim"},
+				Spec:       lbv1.IPClaimSpec{IPPool: "pool"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "claim of another pool",
+			claim: &lbv1.IPClaim{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "claim"},
+				Spec:       lbv1.IPClaimSpec{IPPool: "other"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientSet := fake.NewSimpleClientset(pool)
+			if tt.claim != nil {
+				clientSet = fake.NewSimpleClientset(pool, tt.claim)
+			}
+			validator := &ipPoolValidator{
+				ipPoolCache:     fakeclients.IPPoolCache(clientSet.LoadbalancerV1beta1().IPPools),
+				allocationCache: fakeclients.IPAllocationCache(clientSet.LoadbalancerV1beta1().IPAllocations),
+				claimCache:      fakeclients.IPClaimCache(clientSet.LoadbalancerV1beta1().IPClaims),
+			}
+			if err := validator.Delete(nil, pool); (err != nil) != tt.wantErr {
+				t.Errorf("Delete() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
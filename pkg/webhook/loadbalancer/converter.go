@@ -2,10 +2,12 @@ package loadbalancer
 
 import (
 	"fmt"
+	"net"
 
 	"github.com/harvester/webhook/pkg/server/conversion"
 	"github.com/sirupsen/logrus"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime/schema"
@@ -15,6 +17,7 @@ import (
 	lbv1beta1 "github.com/harvester/harvester-load-balancer/pkg/apis/loadbalancer.harvesterhci.io/v1beta1"
 	ctlkubevirtv1 "github.com/harvester/harvester-load-balancer/pkg/generated/controllers/kubevirt.io/v1"
 	ctllbv1 "github.com/harvester/harvester-load-balancer/pkg/generated/controllers/loadbalancer.harvesterhci.io/v1beta1"
+	"github.com/harvester/harvester-load-balancer/pkg/ipam/store"
 	"github.com/harvester/harvester-load-balancer/pkg/lb/servicelb"
 	"github.com/harvester/harvester-load-balancer/pkg/utils"
 )
@@ -22,16 +25,19 @@ import (
 const keyVMName = "harvesterhci.io/vmName"
 
 type converter struct {
-	vmiCache    ctlkubevirtv1.VirtualMachineInstanceCache
-	ippoolCache ctllbv1.IPPoolCache
+	vmiCache        ctlkubevirtv1.VirtualMachineInstanceCache
+	ippoolCache     ctllbv1.IPPoolCache
+	allocationCache ctllbv1.IPAllocationCache
 }
 
 var _ conversion.Converter = &converter{}
 
-func NewConverter(vmiCache ctlkubevirtv1.VirtualMachineInstanceCache, ippoolCache ctllbv1.IPPoolCache) conversion.Converter {
+func NewConverter(vmiCache ctlkubevirtv1.VirtualMachineInstanceCache, ippoolCache ctllbv1.IPPoolCache,
+	allocationCache ctllbv1.IPAllocationCache) conversion.Converter {
 	return &converter{
-		vmiCache:    vmiCache,
-		ippoolCache: ippoolCache,
+		vmiCache:        vmiCache,
+		ippoolCache:     ippoolCache,
+		allocationCache: allocationCache,
 	}
 }
 
@@ -186,10 +192,20 @@ func (c *converter) convertBackendServersToBackendServerSelector(backendServers
 	return selector, nil
 }
 
-// List all the pools and find the pool which has allocated the address of the lb
+// Find the pool which has allocated the address of the lb by the IPAllocation object of the address,
+// or list all the pools if the allocation recorded by previous versions has not been migrated
 func (c *converter) getPoolByAddressOfV1alpha1LB(addr, lbName, lbNamespace string) (string, error) {
 	name := fmt.Sprintf("%s/%s", lbNamespace, lbName)
 
+	if ip := net.ParseIP(addr); ip != nil {
+		allocation, err := c.allocationCache.Get(store.AllocationName(ip))
+		if err == nil && allocation.Spec.Applicant == name {
+			return allocation.Spec.IPPool, nil
+		} else if err != nil && !apierrors.IsNotFound(err) {
+			return "", err
+		}
+	}
+
 	pools, err := c.ippoolCache.List(labels.Everything())
 	if err != nil {
 		return "", err
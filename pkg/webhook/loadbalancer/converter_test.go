@@ -8,6 +8,8 @@ import (
 	"testing"
 
 	"github.com/harvester/webhook/pkg/server/conversion"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 
@@ -31,7 +33,8 @@ func TestConverter_Convert(t *testing.T) {
 	}
 	virtualMachineInstanceCache := fakeclients.VirtualMachineInstanceCache(fake.NewSimpleClientset(vmis...).KubevirtV1().VirtualMachineInstances)
 	ippoolCache := fakeclients.IPPoolCache(fake.NewSimpleClientset(pools...).LoadbalancerV1beta1().IPPools)
-	converter := NewConverter(virtualMachineInstanceCache, ippoolCache)
+	allocationCache := fakeclients.IPAllocationCache(fake.NewSimpleClientset().LoadbalancerV1beta1().IPAllocations)
+	converter := NewConverter(virtualMachineInstanceCache, ippoolCache, allocationCache)
 	cases, err := utils.GetSubdirectories(converterCaseDirectory)
 	if err != nil {
 		t.Error(err)
@@ -51,6 +54,48 @@ func TestConverter_Convert(t *testing.T) {
 	}
 }
 
+// v1alpha1 has no health checks, the listeners are converted without the health checks of them
+func TestConverter_ListenerHealthCheck(t *testing.T) {
+	converter := NewConverter(nil, nil, nil)
+	lb := &lbv1beta1.LoadBalancer{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: lbv1beta1.SchemeGroupVersion.String(),
+			Kind:       "LoadBalancer",
+		},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "lb1"},
+		Spec: lbv1beta1.LoadBalancerSpec{
+			IPAM:              lbv1beta1.Pool,
+			HealthCheckPolicy: lbv1beta1.PerPortHealth,
+			Listeners: []lbv1beta1.Listener{
+				{
+					Name:        "http",
+					Port:        80,
+					Protocol:    corev1.ProtocolTCP,
+					BackendPort: 8080,
+					HealthCheck: &lbv1beta1.HealthCheck{Protocol: lbv1beta1.HTTPHealthCheck, Path: "/healthz"},
+				},
+			},
+		},
+	}
+	expected := []*lbv1alpha1.Listener{{Name: "http", Port: 80, Protocol: corev1.ProtocolTCP, BackendPort: 8080}}
+
+	obj, err := toUnstructured(lb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	converted, err := converter.Convert(obj, lbv1alpha1.SchemeGroupVersion.String())
+	if err != nil {
+		t.Fatalf("convert from v1beta1 to v1alpha1 failed, error: %v", err)
+	}
+	convertedObj, err := toObj(converted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if listeners := convertedObj.(*lbv1alpha1.LoadBalancer).Spec.Listeners; !reflect.DeepEqual(listeners, expected) {
+		t.Errorf("expected listeners: %+v, got: %+v", expected, listeners)
+	}
+}
+
 func getLBResourceFromYAMLFile(filepath string) (*lbv1alpha1.LoadBalancer, *lbv1beta1.LoadBalancer, error) {
 	lbs, err := utils.ParseFromFile(filepath)
 	if err != nil {
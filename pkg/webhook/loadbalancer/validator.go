@@ -2,33 +2,59 @@ package loadbalancer
 
 import (
 	"fmt"
+	"net"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/harvester/webhook/pkg/server/admission"
+	ctlcorev1 "github.com/rancher/wrangler/v3/pkg/generated/controllers/core/v1"
 	"github.com/sirupsen/logrus"
 	admissionregv1 "k8s.io/api/admissionregistration/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
 
 	lbv1 "github.com/harvester/harvester-load-balancer/pkg/apis/loadbalancer.harvesterhci.io/v1beta1"
 	ctlkubevirtv1 "github.com/harvester/harvester-load-balancer/pkg/generated/controllers/kubevirt.io/v1"
+	ctllbv1 "github.com/harvester/harvester-load-balancer/pkg/generated/controllers/loadbalancer.harvesterhci.io/v1beta1"
+	"github.com/harvester/harvester-load-balancer/pkg/ipam"
+	"github.com/harvester/harvester-load-balancer/pkg/ipam/store"
+	pkglb "github.com/harvester/harvester-load-balancer/pkg/lb"
+	"github.com/harvester/harvester-load-balancer/pkg/prober"
 	"github.com/harvester/harvester-load-balancer/pkg/utils"
 )
 
 type validator struct {
 	admission.DefaultValidator
 
-	vmCache  ctlkubevirtv1.VirtualMachineCache
-	vmiCache ctlkubevirtv1.VirtualMachineInstanceCache
+	vmCache         ctlkubevirtv1.VirtualMachineCache
+	vmiCache        ctlkubevirtv1.VirtualMachineInstanceCache
+	ipPoolCache     ctllbv1.IPPoolCache
+	allocationCache ctllbv1.IPAllocationCache
+	claimCache      ctllbv1.IPClaimCache
+	lbCache         ctllbv1.LoadBalancerCache
+	namespaceCache  ctlcorev1.NamespaceCache
 }
 
 const defaultGuestClusterName = "kubernetes"
 
 var _ admission.Validator = &validator{}
 
-func NewValidator(vmCache ctlkubevirtv1.VirtualMachineCache, vmiCache ctlkubevirtv1.VirtualMachineInstanceCache) admission.Validator {
+func NewValidator(vmCache ctlkubevirtv1.VirtualMachineCache, vmiCache ctlkubevirtv1.VirtualMachineInstanceCache,
+	ipPoolCache ctllbv1.IPPoolCache, allocationCache ctllbv1.IPAllocationCache, claimCache ctllbv1.IPClaimCache,
+	lbCache ctllbv1.LoadBalancerCache, namespaceCache ctlcorev1.NamespaceCache) admission.Validator {
 	return &validator{
-		vmCache:  vmCache,
-		vmiCache: vmiCache,
+		vmCache:         vmCache,
+		vmiCache:        vmiCache,
+		ipPoolCache:     ipPoolCache,
+		allocationCache: allocationCache,
+		claimCache:      claimCache,
+		lbCache:         lbCache,
+		namespaceCache:  namespaceCache,
 	}
 }
 
@@ -43,6 +69,22 @@ func (v *validator) Create(_ *admission.Request, newObj runtime.Object) error {
 		return fmt.Errorf("create loadbalancer %s/%s failed with healthyCheck: %w", lb.Namespace, lb.Name, err)
 	}
 
+	if err := checkIPFamilyPolicy(lb); err != nil {
+		return fmt.Errorf("create loadbalancer %s/%s failed: %w", lb.Namespace, lb.Name, err)
+	}
+
+	if err := v.checkRequestedIPs(lb); err != nil {
+		return fmt.Errorf("create loadbalancer %s/%s failed with requestedIPs: %w", lb.Namespace, lb.Name, err)
+	}
+
+	if err := v.checkIPClaim(lb); err != nil {
+		return fmt.Errorf("create loadbalancer %s/%s failed with ipClaim: %w", lb.Namespace, lb.Name, err)
+	}
+
+	if err := v.checkQuota(lb); err != nil {
+		return fmt.Errorf("create loadbalancer %s/%s failed with quota: %w", lb.Namespace, lb.Name, err)
+	}
+
 	// when a guest-cluster is on remove, Harvester controller deletes all its LBs automatically
 	// but the guest-cluster side might try to recreate them
 	// this check blocks the recreation until the guest-cluster if fully gone
@@ -78,6 +120,30 @@ func (v *validator) Update(_ *admission.Request, oldObj, newObj runtime.Object)
 		return fmt.Errorf("update loadbalancer %s/%s failed: %w", lb.Namespace, lb.Name, err)
 	}
 
+	if err := checkIPFamilyPolicyChange(oldLb, lb); err != nil {
+		return fmt.Errorf("update loadbalancer %s/%s failed: %w", lb.Namespace, lb.Name, err)
+	}
+
+	// the requested IPs have been allocated to the lb if unchanged
+	if !slices.Equal(oldLb.Spec.RequestedIPs, lb.Spec.RequestedIPs) || oldLb.Spec.IPPool != lb.Spec.IPPool {
+		if err := v.checkRequestedIPs(lb); err != nil {
+			return fmt.Errorf("update loadbalancer %s/%s failed with requestedIPs: %w", lb.Namespace, lb.Name, err)
+		}
+	}
+
+	if oldLb.Spec.IPClaim != lb.Spec.IPClaim || oldLb.Spec.IPPool != lb.Spec.IPPool || len(lb.Spec.RequestedIPs) > 0 {
+		if err := v.checkIPClaim(lb); err != nil {
+			return fmt.Errorf("update loadbalancer %s/%s failed with ipClaim: %w", lb.Namespace, lb.Name, err)
+		}
+	}
+
+	// the lb takes addresses from another pool or of another IP family
+	if oldLb.Spec.IPPool != lb.Spec.IPPool || oldLb.Spec.IPFamilyPolicy != lb.Spec.IPFamilyPolicy {
+		if err := v.checkQuota(lb); err != nil {
+			return fmt.Errorf("update loadbalancer %s/%s failed with quota: %w", lb.Namespace, lb.Name, err)
+		}
+	}
+
 	return nil
 }
 
@@ -155,13 +221,56 @@ func checkHealthyCheck(lb *lbv1.LoadBalancer) error {
 		return nil
 	}
 
-	if lb.Spec.HealthCheck != nil && lb.Spec.HealthCheck.Port != 0 {
+	switch lb.Spec.HealthCheckPolicy {
+	case "", lbv1.AllHealthy, lbv1.AnyHealthy, lbv1.PerPortHealth:
+	default:
+		return fmt.Errorf("healthcheck policy %s is not supported", lb.Spec.HealthCheckPolicy)
+	}
+
+	if err := checkLBHealthCheck(lb); err != nil {
+		return err
+	}
+	if lb.Spec.HealthCheckPolicy == lbv1.PerPortHealth && pkglb.IsLBHealthCheckEnabled(lb) && !hasListenerWithoutHealthCheck(lb) {
+		return fmt.Errorf("healthcheck is not used by any listener as all the listeners have their own healthchecks in the %s policy",
+			lb.Spec.HealthCheckPolicy)
+	}
+	for i := range lb.Spec.Listeners {
+		if err := checkListenerHealthCheck(&lb.Spec.Listeners[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func hasListenerWithoutHealthCheck(lb *lbv1.LoadBalancer) bool {
+	for i := range lb.Spec.Listeners {
+		if lb.Spec.Listeners[i].HealthCheck == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func checkLBHealthCheck(lb *lbv1.LoadBalancer) error {
+	if lb.Spec.HealthCheck == nil {
+		return nil
+	}
+	if err := checkHealthCheckMode(lb.Spec.HealthCheck); err != nil {
+		return err
+	}
+	if lb.Spec.HealthCheck.Mode == lbv1.ProbeAndVMIMode && lb.Spec.HealthCheck.Port == 0 {
+		return fmt.Errorf("healthcheck port is required in the %s mode", lb.Spec.HealthCheck.Mode)
+	}
+
+	if lb.Spec.HealthCheck.Port != 0 {
+		expectedProtocol := getHealthCheckListenerProtocol(lb.Spec.HealthCheck)
 		wrongProtocol := false
 		for _, listener := range lb.Spec.Listeners {
-			// check listener port and protocol, only TCP is supported now
+			// check listener port and protocol
 			//#nosec
 			if uint(listener.BackendPort) == lb.Spec.HealthCheck.Port {
-				if listener.Protocol == corev1.ProtocolTCP {
+				if listener.Protocol == expectedProtocol {
 					if lb.Spec.HealthCheck.SuccessThreshold == 0 {
 						return fmt.Errorf("healthcheck SuccessThreshold should > 0")
 					}
@@ -174,14 +283,14 @@ func checkHealthyCheck(lb *lbv1.LoadBalancer) error {
 					if lb.Spec.HealthCheck.TimeoutSeconds == 0 {
 						return fmt.Errorf("healthcheck TimeoutSeconds should > 0")
 					}
-					return nil
+					return checkHealthCheckProtocol(lb.Spec.HealthCheck)
 				}
-				// not the expected TCP
+				// not the expected protocol
 				wrongProtocol = true
 			}
 		}
 		if wrongProtocol {
-			return fmt.Errorf("healthcheck port %v can only be a TCP backend port", lb.Spec.HealthCheck.Port)
+			return fmt.Errorf("healthcheck port %v can only be a %s backend port", lb.Spec.HealthCheck.Port, expectedProtocol)
 		}
 		return fmt.Errorf("healthcheck port %v is not in listener backend port list", lb.Spec.HealthCheck.Port)
 	}
@@ -189,6 +298,149 @@ func checkHealthyCheck(lb *lbv1.LoadBalancer) error {
 	return nil
 }
 
+// the listener health check probes the backend port of the listener unless the port is specified,
+// its thresholds default to the ones of the controller if they are not set
+func checkListenerHealthCheck(listener *lbv1.Listener) error {
+	hc := listener.HealthCheck
+	if hc == nil {
+		return nil
+	}
+
+	// the name is a part of the endpointslice name in the perport policy
+	if errs := validation.IsDNS1123Label(listener.Name); len(errs) > 0 {
+		return fmt.Errorf("listener %q with a healthcheck must have a valid name, error: %s", listener.Name, strings.Join(errs, ", "))
+	}
+	if err := checkHealthCheckMode(hc); err != nil {
+		return fmt.Errorf("listener %s %w", listener.Name, err)
+	}
+	if hc.Mode == lbv1.VMIMode {
+		return nil
+	}
+	if hc.Port > maxPort {
+		return fmt.Errorf("listener %s healthcheck port %v must <= %v", listener.Name, hc.Port, maxPort)
+	}
+	//#nosec
+	if expectedProtocol := getHealthCheckListenerProtocol(hc); (hc.Port == 0 || hc.Port == uint(listener.BackendPort)) &&
+		listener.Protocol != expectedProtocol {
+		return fmt.Errorf("listener %s healthcheck protocol %s can only probe a %s backend port", listener.Name, hc.Protocol, expectedProtocol)
+	}
+	if err := checkHealthCheckProtocol(hc); err != nil {
+		return fmt.Errorf("listener %s %w", listener.Name, err)
+	}
+
+	return nil
+}
+
+// the vmi mode takes the conditions of the VMIs as the health, the options of the probe are not used
+func checkHealthCheckMode(hc *lbv1.HealthCheck) error {
+	switch hc.Mode {
+	case "", lbv1.ProbeMode:
+		if len(hc.VMIConditions) > 0 {
+			return fmt.Errorf("healthcheck vmiConditions are only valid in the %s and %s modes", lbv1.VMIMode, lbv1.ProbeAndVMIMode)
+		}
+		return nil
+	case lbv1.VMIMode:
+		if hc.Port != 0 || hc.Protocol != "" {
+			return fmt.Errorf("healthcheck port and protocol are not used in the %s mode", hc.Mode)
+		}
+		if err := checkHealthCheckProtocol(hc); err != nil {
+			return err
+		}
+	case lbv1.ProbeAndVMIMode:
+	default:
+		return fmt.Errorf("healthcheck mode %s is not supported", hc.Mode)
+	}
+
+	for _, condition := range hc.VMIConditions {
+		if condition != lbv1.VMIReady && condition != lbv1.VMIAgentConnected {
+			return fmt.Errorf("healthcheck vmiCondition %s is not supported", condition)
+		}
+	}
+
+	return nil
+}
+
+// the udp probe checks the UDP backend port, the others check the TCP backend port
+func getHealthCheckListenerProtocol(hc *lbv1.HealthCheck) corev1.Protocol {
+	if hc.Protocol == lbv1.UDPHealthCheck {
+		return corev1.ProtocolUDP
+	}
+	return corev1.ProtocolTCP
+}
+
+// the protocol specific options are only valid for their own protocols
+func checkHealthCheckProtocol(hc *lbv1.HealthCheck) error {
+	isHTTP := hc.Protocol == lbv1.HTTPHealthCheck || hc.Protocol == lbv1.HTTPSHealthCheck
+	isGRPC := hc.Protocol == lbv1.GRPCHealthCheck
+	isUDP := hc.Protocol == lbv1.UDPHealthCheck
+	switch hc.Protocol {
+	case "", lbv1.TCPHealthCheck, lbv1.HTTPHealthCheck, lbv1.HTTPSHealthCheck, lbv1.GRPCHealthCheck, lbv1.UDPHealthCheck:
+	default:
+		return fmt.Errorf("healthcheck protocol %s is not supported", hc.Protocol)
+	}
+
+	if !isHTTP && (hc.Path != "" || len(hc.ExpectedStatuses) > 0 || hc.BodyMatch != "") {
+		return fmt.Errorf("healthcheck path, expectedStatuses and bodyMatch are only valid for the http and https protocols")
+	}
+	if !isGRPC && (hc.Service != "" || hc.TLS) {
+		return fmt.Errorf("healthcheck service and tls are only valid for the grpc protocol")
+	}
+	if !isUDP && (hc.Payload != "" || hc.ResponseMatch != "" || hc.UDPPreset != "" || hc.DNSQuery != "") {
+		return fmt.Errorf("healthcheck payload, responseMatch, udpPreset and dnsQuery are only valid for the udp protocol")
+	}
+	if !isHTTP && !isGRPC && hc.Host != "" {
+		return fmt.Errorf("healthcheck host is only valid for the http, https and grpc protocols")
+	}
+	if hc.InsecureSkipVerify && hc.Protocol != lbv1.HTTPSHealthCheck && !(isGRPC && hc.TLS) {
+		return fmt.Errorf("healthcheck insecureSkipVerify is only valid for the https protocol and the grpc protocol over tls")
+	}
+	if isUDP {
+		return checkUDPHealthCheck(hc)
+	}
+	if !isHTTP {
+		return nil
+	}
+
+	if hc.Path != "" && !strings.HasPrefix(hc.Path, "/") {
+		return fmt.Errorf("healthcheck path %s must start with /", hc.Path)
+	}
+	if _, err := prober.ParseStatusRanges(hc.ExpectedStatuses); err != nil {
+		return fmt.Errorf("healthcheck expectedStatuses is invalid, error: %w", err)
+	}
+	if hc.BodyMatch != "" {
+		if _, err := regexp.Compile(hc.BodyMatch); err != nil {
+			return fmt.Errorf("healthcheck bodyMatch %s is not a valid regular expression, error: %w", hc.BodyMatch, err)
+		}
+	}
+
+	return nil
+}
+
+func checkUDPHealthCheck(hc *lbv1.HealthCheck) error {
+	switch hc.UDPPreset {
+	case "":
+		if hc.DNSQuery != "" {
+			return fmt.Errorf("healthcheck dnsQuery is only valid for the dns preset")
+		}
+		if hc.ResponseMatch != "" {
+			if _, err := regexp.Compile(hc.ResponseMatch); err != nil {
+				return fmt.Errorf("healthcheck responseMatch %s is not a valid regular expression, error: %w", hc.ResponseMatch, err)
+			}
+		}
+	case lbv1.DNSPreset:
+		if hc.Payload != "" || hc.ResponseMatch != "" {
+			return fmt.Errorf("healthcheck payload and responseMatch can't be set with the udpPreset %s", hc.UDPPreset)
+		}
+		if _, err := prober.ParseDNSQuery(hc.DNSQuery); err != nil {
+			return fmt.Errorf("healthcheck dnsQuery is invalid, error: %w", err)
+		}
+	default:
+		return fmt.Errorf("healthcheck udpPreset %s is not supported", hc.UDPPreset)
+	}
+
+	return nil
+}
+
 // change the IPAM may cause IP leaking
 // user may re-create the LB to change the IPAM
 // if IPAM is not set, it defaults to lbv1.Pool
@@ -211,6 +463,206 @@ func checkWorkloadType(oldLb, newLb *lbv1.LoadBalancer) error {
 	return nil
 }
 
+// DHCP only gets IPv4 address by now
+func checkIPFamilyPolicy(lb *lbv1.LoadBalancer) error {
+	if lb.Spec.IPAM == lbv1.DHCP && lb.Spec.IPFamilyPolicy != "" && lb.Spec.IPFamilyPolicy != lbv1.IPv4 {
+		return fmt.Errorf("IPFamilyPolicy %s is not supported with IPAM %s", lb.Spec.IPFamilyPolicy, lbv1.DHCP)
+	}
+
+	return nil
+}
+
+// change the IPFamilyPolicy requires re-allocating the addresses and re-creating the endpointslices
+// user may re-create the LB to change the IPFamilyPolicy
+// if IPFamilyPolicy is not set, it defaults to lbv1.IPv4
+func checkIPFamilyPolicyChange(oldLb, newLb *lbv1.LoadBalancer) error {
+	oldPolicy, newPolicy := oldLb.Spec.IPFamilyPolicy, newLb.Spec.IPFamilyPolicy
+	if oldPolicy == "" {
+		oldPolicy = lbv1.IPv4
+	}
+	if newPolicy == "" {
+		newPolicy = lbv1.IPv4
+	}
+	if oldPolicy != newPolicy {
+		return fmt.Errorf("can't change the IPFamilyPolicy from %v to %v", oldPolicy, newPolicy)
+	}
+
+	return nil
+}
+
+// checkRequestedIPs checks the requested IPs are in the IP families of the lb, and they are in the IP pool and not allocated to others
+// if the IP pool is not specified, all the requested IPs must be in the same pool
+func (v *validator) checkRequestedIPs(lb *lbv1.LoadBalancer) error {
+	if len(lb.Spec.RequestedIPs) == 0 {
+		return nil
+	}
+	if lb.Spec.IPAM == lbv1.DHCP {
+		return fmt.Errorf("can't request IPs with IPAM %s", lbv1.DHCP)
+	}
+
+	families := utils.GetIPFamilies(lb.Spec.IPFamilyPolicy)
+	requested := make(map[corev1.IPFamily]string, len(lb.Spec.RequestedIPs))
+	ips := make([]net.IP, 0, len(lb.Spec.RequestedIPs))
+	for _, ipStr := range lb.Spec.RequestedIPs {
+		ip := net.ParseIP(ipStr)
+		if ip == nil {
+			return fmt.Errorf("invalid IP %s", ipStr)
+		}
+		family := utils.GetIPFamily(ip)
+		if !slices.Contains(families, family) {
+			return fmt.Errorf("IP %s is not in the IP families %v of the loadbalancer", ipStr, families)
+		}
+		if other, ok := requested[family]; ok {
+			return fmt.Errorf("IP %s and %s are in the same IP family %s", other, ipStr, family)
+		}
+		requested[family] = ipStr
+		ips = append(ips, ip)
+	}
+
+	var pool *lbv1.IPPool
+	var err error
+	if lb.Spec.IPPool != "" {
+		pool, err = v.ipPoolCache.Get(lb.Spec.IPPool)
+		if err != nil {
+			return fmt.Errorf("get pool %s failed, error: %w", lb.Spec.IPPool, err)
+		}
+	} else {
+		r, err := ipam.NewRequirement(lb, v.namespaceCache)
+		if err != nil {
+			return err
+		}
+		pool, err = ipam.NewSelector(v.ipPoolCache).SelectByIP(ips[0], r, lb.Spec.WorkloadType == lbv1.Cluster)
+		if err != nil {
+			return fmt.Errorf("select pool by IP %s failed, error: %w", ips[0], err)
+		}
+		if pool == nil {
+			return fmt.Errorf("IP %s is not in any pool", ips[0])
+		}
+	}
+
+	allocated, err := store.GetAllocated(v.allocationCache, pool)
+	if err != nil {
+		return err
+	}
+
+	// the IPs delegated to the child pools are not available in the pool
+	spec, err := ipam.GetDelegatingSpec(v.ipPoolCache, pool)
+	if err != nil {
+		return err
+	}
+
+	applicant := fmt.Sprintf("%s/%s", lb.Namespace, lb.Name)
+	for _, ip := range ips {
+		if err := ipam.CheckIPInPool(ip, spec, applicant); err != nil {
+			return fmt.Errorf("IP %s is not available in pool %s: %w", ip, pool.Name, err)
+		}
+		if owner, ok := allocated[ip.String()]; ok && owner != applicant {
+			return fmt.Errorf("IP %s has been allocated to %s in pool %s", ip, owner, pool.Name)
+		}
+		if store.IsQuarantined(pool, ip.String(), applicant, time.Now()) {
+			return fmt.Errorf("IP %s is in quarantine in pool %s", ip, pool.Name)
+		}
+	}
+
+	return nil
+}
+
+// checkIPClaim checks the IP claim which the lb refers to exists in the namespace of the lb and matches its IP family,
+// the claim can't be shared with other lbs
+func (v *validator) checkIPClaim(lb *lbv1.LoadBalancer) error {
+	if lb.Spec.IPClaim == "" {
+		return nil
+	}
+	if lb.Spec.IPAM == lbv1.DHCP {
+		return fmt.Errorf("can't refer to IP claim with IPAM %s", lbv1.DHCP)
+	}
+	if lb.Spec.IPPool != "" || len(lb.Spec.RequestedIPs) > 0 {
+		return fmt.Errorf("can't refer to IP claim %s together with ipPool or requestedIPs", lb.Spec.IPClaim)
+	}
+
+	claim, err := v.claimCache.Get(lb.Namespace, lb.Spec.IPClaim)
+	if err != nil {
+		return fmt.Errorf("get IP claim %s failed, error: %w", lb.Spec.IPClaim, err)
+	}
+	// the claim books a single address, it has to cover every IP family the lb asks for
+	if !utils.IsIPFamilyCovered(claim.Spec.IPFamily, lb.Spec.IPFamilyPolicy) {
+		return fmt.Errorf("IP claim %s of IP family %s doesn't cover IPFamilyPolicy %s", claim.Name, claim.Spec.IPFamily, lb.Spec.IPFamilyPolicy)
+	}
+
+	lbs, err := v.lbCache.List(lb.Namespace, labels.Everything())
+	if err != nil {
+		return err
+	}
+	for _, other := range lbs {
+		if other.Name != lb.Name && other.Spec.IPClaim == lb.Spec.IPClaim {
+			return fmt.Errorf("IP claim %s has been referred to by loadbalancer %s", lb.Spec.IPClaim, other.Name)
+		}
+	}
+
+	return nil
+}
+
+// checkQuota checks the tenant of the lb has enough quota left in the pool which the lb is allocated from
+// the address of the IP claim has been counted when the claim was bound
+func (v *validator) checkQuota(lb *lbv1.LoadBalancer) error {
+	if lb.Spec.IPAM == lbv1.DHCP || lb.Spec.IPClaim != "" {
+		return nil
+	}
+
+	r, err := ipam.NewRequirement(lb, v.namespaceCache)
+	if err != nil {
+		return err
+	}
+	pool, err := v.getIPPool(lb, r)
+	if err != nil || pool == nil || len(pool.Spec.Quotas) == 0 {
+		return err
+	}
+
+	allocated, err := store.GetAllocated(v.allocationCache, pool)
+	if err != nil {
+		return err
+	}
+	applicant := fmt.Sprintf("%s/%s", lb.Namespace, lb.Name)
+	used, err := ipam.GetQuotaUsage(pool, allocated, ipam.NewTenantGetter(v.lbCache, v.claimCache, v.namespaceCache), applicant)
+	if err != nil {
+		return err
+	}
+
+	return ipam.CheckQuota(pool, r, int64(len(utils.GetIPFamilies(lb.Spec.IPFamilyPolicy))), used, pool.Status.Available)
+}
+
+// getIPPool returns the pool specified by the lb, the pool containing the requested IP or the pool matched
+// automatically, nil if there is no such pool
+func (v *validator) getIPPool(lb *lbv1.LoadBalancer, r *ipam.Requirement) (*lbv1.IPPool, error) {
+	if lb.Spec.IPPool != "" {
+		pool, err := v.ipPoolCache.Get(lb.Spec.IPPool)
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return pool, err
+	}
+
+	if len(lb.Spec.RequestedIPs) > 0 {
+		return ipam.NewSelector(v.ipPoolCache).SelectByIP(net.ParseIP(lb.Spec.RequestedIPs[0]), r, lb.Spec.WorkloadType == lbv1.Cluster)
+	}
+
+	pools, err := ipam.NewSelector(v.ipPoolCache).SelectAll(r, false)
+	if err != nil {
+		return nil, err
+	}
+	// the Cluster type lb re-tries in loose mode as same as the controller
+	if len(pools) == 0 && lb.Spec.WorkloadType == lbv1.Cluster {
+		if pools, err = ipam.NewSelector(v.ipPoolCache).SelectAll(r, true); err != nil {
+			return nil, err
+		}
+	}
+	if len(pools) == 0 {
+		return nil, nil
+	}
+
+	return pools[0], nil
+}
+
 func (v *validator) checkGuestClusterIsOnRemove(lb *lbv1.LoadBalancer) error {
 	if lb.Spec.WorkloadType != lbv1.Cluster {
 		return nil
@@ -11,6 +11,7 @@ import (
 	kubevirtv1 "kubevirt.io/api/core/v1"
 
 	"github.com/harvester/harvester-load-balancer/pkg/generated/clientset/versioned/fake"
+	"github.com/harvester/harvester-load-balancer/pkg/ipam/store"
 	"github.com/harvester/harvester-load-balancer/pkg/utils"
 	"github.com/harvester/harvester-load-balancer/pkg/utils/fakeclients"
 )
@@ -234,6 +235,520 @@ func TestCheckListeners(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "http health check right case",
+			lb: &lbv1.LoadBalancer{
+				Spec: lbv1.LoadBalancerSpec{
+					Listeners: []lbv1.Listener{
+						{Name: "a", BackendPort: 80, Protocol: corev1.ProtocolTCP},
+					},
+					HealthCheck: &lbv1.HealthCheck{Port: 80, SuccessThreshold: 1, FailureThreshold: 1, PeriodSeconds: 1, TimeoutSeconds: 1,
+						Protocol: lbv1.HTTPHealthCheck, Path: "/healthz", Host: "example.com", ExpectedStatuses: []string{"200", "300-399"}, BodyMatch: "^ok$"},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "https health check skips verifying the certificate",
+			lb: &lbv1.LoadBalancer{
+				Spec: lbv1.LoadBalancerSpec{
+					Listeners: []lbv1.Listener{
+						{Name: "a", BackendPort: 80, Protocol: corev1.ProtocolTCP},
+					},
+					HealthCheck: &lbv1.HealthCheck{Port: 80, SuccessThreshold: 1, FailureThreshold: 1, PeriodSeconds: 1, TimeoutSeconds: 1,
+						Protocol: lbv1.HTTPSHealthCheck, InsecureSkipVerify: true},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "health check protocol is not supported",
+			lb: &lbv1.LoadBalancer{
+				Spec: lbv1.LoadBalancerSpec{
+					Listeners: []lbv1.Listener{
+						{Name: "a", BackendPort: 80, Protocol: corev1.ProtocolTCP},
+					},
+					HealthCheck: &lbv1.HealthCheck{Port: 80, SuccessThreshold: 1, FailureThreshold: 1, PeriodSeconds: 1, TimeoutSeconds: 1,
+						Protocol: "ftp"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "tcp health check can't set http options",
+			lb: &lbv1.LoadBalancer{
+				Spec: lbv1.LoadBalancerSpec{
+					Listeners: []lbv1.Listener{
+						{Name: "a", BackendPort: 80, Protocol: corev1.ProtocolTCP},
+					},
+					HealthCheck: &lbv1.HealthCheck{Port: 80, SuccessThreshold: 1, FailureThreshold: 1, PeriodSeconds: 1, TimeoutSeconds: 1,
+						Protocol: lbv1.TCPHealthCheck, Path: "/healthz"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "http health check can't skip verifying the certificate",
+			lb: &lbv1.LoadBalancer{
+				Spec: lbv1.LoadBalancerSpec{
+					Listeners: []lbv1.Listener{
+						{Name: "a", BackendPort: 80, Protocol: corev1.ProtocolTCP},
+					},
+					HealthCheck: &lbv1.HealthCheck{Port: 80, SuccessThreshold: 1, FailureThreshold: 1, PeriodSeconds: 1, TimeoutSeconds: 1,
+						Protocol: lbv1.HTTPHealthCheck, InsecureSkipVerify: true},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "http health check path doesn't start with /",
+			lb: &lbv1.LoadBalancer{
+				Spec: lbv1.LoadBalancerSpec{
+					Listeners: []lbv1.Listener{
+						{Name: "a", BackendPort: 80, Protocol: corev1.ProtocolTCP},
+					},
+					HealthCheck: &lbv1.HealthCheck{Port: 80, SuccessThreshold: 1, FailureThreshold: 1, PeriodSeconds: 1, TimeoutSeconds: 1,
+						Protocol: lbv1.HTTPHealthCheck, Path: "healthz"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "http health check status is out of range",
+			lb: &lbv1.LoadBalancer{
+				Spec: lbv1.LoadBalancerSpec{
+					Listeners: []lbv1.Listener{
+						{Name: "a", BackendPort: 80, Protocol: corev1.ProtocolTCP},
+					},
+					HealthCheck: &lbv1.HealthCheck{Port: 80, SuccessThreshold: 1, FailureThreshold: 1, PeriodSeconds: 1, TimeoutSeconds: 1,
+						Protocol: lbv1.HTTPHealthCheck, ExpectedStatuses: []string{"200-600"}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "http health check status range is reversed",
+			lb: &lbv1.LoadBalancer{
+				Spec: lbv1.LoadBalancerSpec{
+					Listeners: []lbv1.Listener{
+						{Name: "a", BackendPort: 80, Protocol: corev1.ProtocolTCP},
+					},
+					HealthCheck: &lbv1.HealthCheck{Port: 80, SuccessThreshold: 1, FailureThreshold: 1, PeriodSeconds: 1, TimeoutSeconds: 1,
+						Protocol: lbv1.HTTPHealthCheck, ExpectedStatuses: []string{"299-200"}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "http health check body match is not a regular expression",
+			lb: &lbv1.LoadBalancer{
+				Spec: lbv1.LoadBalancerSpec{
+					Listeners: []lbv1.Listener{
+						{Name: "a", BackendPort: 80, Protocol: corev1.ProtocolTCP},
+					},
+					HealthCheck: &lbv1.HealthCheck{Port: 80, SuccessThreshold: 1, FailureThreshold: 1, PeriodSeconds: 1, TimeoutSeconds: 1,
+						Protocol: lbv1.HTTPHealthCheck, BodyMatch: "(ok"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "grpc health check right case",
+			lb: &lbv1.LoadBalancer{
+				Spec: lbv1.LoadBalancerSpec{
+					Listeners: []lbv1.Listener{
+						{Name: "a", BackendPort: 80, Protocol: corev1.ProtocolTCP},
+					},
+					HealthCheck: &lbv1.HealthCheck{Port: 80, SuccessThreshold: 1, FailureThreshold: 1, PeriodSeconds: 1, TimeoutSeconds: 1,
+						Protocol: lbv1.GRPCHealthCheck, Service: "helloworld.Greeter", Host: "example.com", TLS: true, InsecureSkipVerify: true},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "grpc health check without tls can't skip verifying the certificate",
+			lb: &lbv1.LoadBalancer{
+				Spec: lbv1.LoadBalancerSpec{
+					Listeners: []lbv1.Listener{
+						{Name: "a", BackendPort: 80, Protocol: corev1.ProtocolTCP},
+					},
+					HealthCheck: &lbv1.HealthCheck{Port: 80, SuccessThreshold: 1, FailureThreshold: 1, PeriodSeconds: 1, TimeoutSeconds: 1,
+						Protocol: lbv1.GRPCHealthCheck, InsecureSkipVerify: true},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "grpc health check can't set http options",
+			lb: &lbv1.LoadBalancer{
+				Spec: lbv1.LoadBalancerSpec{
+					Listeners: []lbv1.Listener{
+						{Name: "a", BackendPort: 80, Protocol: corev1.ProtocolTCP},
+					},
+					HealthCheck: &lbv1.HealthCheck{Port: 80, SuccessThreshold: 1, FailureThreshold: 1, PeriodSeconds: 1, TimeoutSeconds: 1,
+						Protocol: lbv1.GRPCHealthCheck, Path: "/healthz"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "http health check can't set grpc options",
+			lb: &lbv1.LoadBalancer{
+				Spec: lbv1.LoadBalancerSpec{
+					Listeners: []lbv1.Listener{
+						{Name: "a", BackendPort: 80, Protocol: corev1.ProtocolTCP},
+					},
+					HealthCheck: &lbv1.HealthCheck{Port: 80, SuccessThreshold: 1, FailureThreshold: 1, PeriodSeconds: 1, TimeoutSeconds: 1,
+						Protocol: lbv1.HTTPHealthCheck, Service: "helloworld.Greeter"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "udp health check right case",
+			lb: &lbv1.LoadBalancer{
+				Spec: lbv1.LoadBalancerSpec{
+					Listeners: []lbv1.Listener{
+						{Name: "a", BackendPort: 53, Protocol: corev1.ProtocolUDP},
+					},
+					HealthCheck: &lbv1.HealthCheck{Port: 53, SuccessThreshold: 1, FailureThreshold: 1, PeriodSeconds: 1, TimeoutSeconds: 1,
+						Protocol: lbv1.UDPHealthCheck, Payload: "ping", ResponseMatch: "^pong"},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "udp health check with dns preset",
+			lb: &lbv1.LoadBalancer{
+				Spec: lbv1.LoadBalancerSpec{
+					Listeners: []lbv1.Listener{
+						{Name: "a", BackendPort: 53, Protocol: corev1.ProtocolUDP},
+					},
+					HealthCheck: &lbv1.HealthCheck{Port: 53, SuccessThreshold: 1, FailureThreshold: 1, PeriodSeconds: 1, TimeoutSeconds: 1,
+						Protocol: lbv1.UDPHealthCheck, UDPPreset: lbv1.DNSPreset, DNSQuery: "example.com"},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "udp health check on a TCP backend port",
+			lb: &lbv1.LoadBalancer{
+				Spec: lbv1.LoadBalancerSpec{
+					Listeners: []lbv1.Listener{
+						{Name: "a", BackendPort: 53, Protocol: corev1.ProtocolTCP},
+					},
+					HealthCheck: &lbv1.HealthCheck{Port: 53, SuccessThreshold: 1, FailureThreshold: 1, PeriodSeconds: 1, TimeoutSeconds: 1,
+						Protocol: lbv1.UDPHealthCheck},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "http health check on a UDP backend port",
+			lb: &lbv1.LoadBalancer{
+				Spec: lbv1.LoadBalancerSpec{
+					Listeners: []lbv1.Listener{
+						{Name: "a", BackendPort: 53, Protocol: corev1.ProtocolUDP},
+					},
+					HealthCheck: &lbv1.HealthCheck{Port: 53, SuccessThreshold: 1, FailureThreshold: 1, PeriodSeconds: 1, TimeoutSeconds: 1,
+						Protocol: lbv1.HTTPHealthCheck},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "udp health check response match is not a regular expression",
+			lb: &lbv1.LoadBalancer{
+				Spec: lbv1.LoadBalancerSpec{
+					Listeners: []lbv1.Listener{
+						{Name: "a", BackendPort: 53, Protocol: corev1.ProtocolUDP},
+					},
+					HealthCheck: &lbv1.HealthCheck{Port: 53, SuccessThreshold: 1, FailureThreshold: 1, PeriodSeconds: 1, TimeoutSeconds: 1,
+						Protocol: lbv1.UDPHealthCheck, ResponseMatch: "(pong"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "udp health check dns preset can't set payload",
+			lb: &lbv1.LoadBalancer{
+				Spec: lbv1.LoadBalancerSpec{
+					Listeners: []lbv1.Listener{
+						{Name: "a", BackendPort: 53, Protocol: corev1.ProtocolUDP},
+					},
+					HealthCheck: &lbv1.HealthCheck{Port: 53, SuccessThreshold: 1, FailureThreshold: 1, PeriodSeconds: 1, TimeoutSeconds: 1,
+						Protocol: lbv1.UDPHealthCheck, UDPPreset: lbv1.DNSPreset, Payload: "ping"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "udp health check dns query without dns preset",
+			lb: &lbv1.LoadBalancer{
+				Spec: lbv1.LoadBalancerSpec{
+					Listeners: []lbv1.Listener{
+						{Name: "a", BackendPort: 53, Protocol: corev1.ProtocolUDP},
+					},
+					HealthCheck: &lbv1.HealthCheck{Port: 53, SuccessThreshold: 1, FailureThreshold: 1, PeriodSeconds: 1, TimeoutSeconds: 1,
+						Protocol: lbv1.UDPHealthCheck, DNSQuery: "example.com"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "udp health check dns query is invalid",
+			lb: &lbv1.LoadBalancer{
+				Spec: lbv1.LoadBalancerSpec{
+					Listeners: []lbv1.Listener{
+						{Name: "a", BackendPort: 53, Protocol: corev1.ProtocolUDP},
+					},
+					HealthCheck: &lbv1.HealthCheck{Port: 53, SuccessThreshold: 1, FailureThreshold: 1, PeriodSeconds: 1, TimeoutSeconds: 1,
+						Protocol: lbv1.UDPHealthCheck, UDPPreset: lbv1.DNSPreset, DNSQuery: "example..com"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "udp health check preset is not supported",
+			lb: &lbv1.LoadBalancer{
+				Spec: lbv1.LoadBalancerSpec{
+					Listeners: []lbv1.Listener{
+						{Name: "a", BackendPort: 53, Protocol: corev1.ProtocolUDP},
+					},
+					HealthCheck: &lbv1.HealthCheck{Port: 53, SuccessThreshold: 1, FailureThreshold: 1, PeriodSeconds: 1, TimeoutSeconds: 1,
+						Protocol: lbv1.UDPHealthCheck, UDPPreset: "ntp"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "tcp health check can't set udp options",
+			lb: &lbv1.LoadBalancer{
+				Spec: lbv1.LoadBalancerSpec{
+					Listeners: []lbv1.Listener{
+						{Name: "a", BackendPort: 53, Protocol: corev1.ProtocolTCP},
+					},
+					HealthCheck: &lbv1.HealthCheck{Port: 53, SuccessThreshold: 1, FailureThreshold: 1, PeriodSeconds: 1, TimeoutSeconds: 1,
+						Payload: "ping"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "listener health checks with perport policy",
+			lb: &lbv1.LoadBalancer{
+				Spec: lbv1.LoadBalancerSpec{
+					Listeners: []lbv1.Listener{
+						{Name: "http", BackendPort: 80, Protocol: corev1.ProtocolTCP, HealthCheck: &lbv1.HealthCheck{Protocol: lbv1.HTTPHealthCheck, Path: "/healthz"}},
+						{Name: "dns", BackendPort: 53, Protocol: corev1.ProtocolUDP, HealthCheck: &lbv1.HealthCheck{Protocol: lbv1.UDPHealthCheck, UDPPreset: lbv1.DNSPreset}},
+						{Name: "ssh", BackendPort: 22, Protocol: corev1.ProtocolTCP},
+					},
+					HealthCheckPolicy: lbv1.PerPortHealth,
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "lb health check is used by the listener without its own health check in perport policy",
+			lb: &lbv1.LoadBalancer{
+				Spec: lbv1.LoadBalancerSpec{
+					Listeners: []lbv1.Listener{
+						{Name: "http", BackendPort: 80, Protocol: corev1.ProtocolTCP, HealthCheck: &lbv1.HealthCheck{Protocol: lbv1.HTTPHealthCheck}},
+						{Name: "ssh", BackendPort: 22, Protocol: corev1.ProtocolTCP},
+					},
+					HealthCheck:       &lbv1.HealthCheck{Port: 22, SuccessThreshold: 1, FailureThreshold: 1, PeriodSeconds: 1, TimeoutSeconds: 1},
+					HealthCheckPolicy: lbv1.PerPortHealth,
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "lb health check is not used by any listener in perport policy",
+			lb: &lbv1.LoadBalancer{
+				Spec: lbv1.LoadBalancerSpec{
+					Listeners: []lbv1.Listener{
+						{Name: "http", BackendPort: 80, Protocol: corev1.ProtocolTCP, HealthCheck: &lbv1.HealthCheck{Protocol: lbv1.HTTPHealthCheck}},
+					},
+					HealthCheck:       &lbv1.HealthCheck{Port: 80, SuccessThreshold: 1, FailureThreshold: 1, PeriodSeconds: 1, TimeoutSeconds: 1},
+					HealthCheckPolicy: lbv1.PerPortHealth,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "listener health check probes another port",
+			lb: &lbv1.LoadBalancer{
+				Spec: lbv1.LoadBalancerSpec{
+					Listeners: []lbv1.Listener{
+						{Name: "dns", BackendPort: 53, Protocol: corev1.ProtocolUDP, HealthCheck: &lbv1.HealthCheck{Port: 8080, Protocol: lbv1.HTTPHealthCheck}},
+					},
+					HealthCheckPolicy: lbv1.AnyHealthy,
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "health check policy is not supported",
+			lb: &lbv1.LoadBalancer{
+				Spec: lbv1.LoadBalancerSpec{
+					Listeners: []lbv1.Listener{
+						{Name: "http", BackendPort: 80, Protocol: corev1.ProtocolTCP, HealthCheck: &lbv1.HealthCheck{Protocol: lbv1.HTTPHealthCheck, Path: "/healthz"}},
+					},
+					HealthCheckPolicy: "some",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "listener with a health check has no name",
+			lb: &lbv1.LoadBalancer{
+				Spec: lbv1.LoadBalancerSpec{
+					Listeners: []lbv1.Listener{
+						{BackendPort: 80, Protocol: corev1.ProtocolTCP, HealthCheck: &lbv1.HealthCheck{}},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "listener health check protocol does not match the backend port",
+			lb: &lbv1.LoadBalancer{
+				Spec: lbv1.LoadBalancerSpec{
+					Listeners: []lbv1.Listener{
+						{Name: "dns", BackendPort: 53, Protocol: corev1.ProtocolUDP, HealthCheck: &lbv1.HealthCheck{Protocol: lbv1.TCPHealthCheck}},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "listener health check port is out of range",
+			lb: &lbv1.LoadBalancer{
+				Spec: lbv1.LoadBalancerSpec{
+					Listeners: []lbv1.Listener{
+						{Name: "http", BackendPort: 80, Protocol: corev1.ProtocolTCP, HealthCheck: &lbv1.HealthCheck{Port: 65536}},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "listener health check option is invalid",
+			lb: &lbv1.LoadBalancer{
+				Spec: lbv1.LoadBalancerSpec{
+					Listeners: []lbv1.Listener{
+						{Name: "http", BackendPort: 80, Protocol: corev1.ProtocolTCP, HealthCheck: &lbv1.HealthCheck{Protocol: lbv1.HTTPHealthCheck, Path: "healthz"}},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "vmi health check without port",
+			lb: &lbv1.LoadBalancer{
+				Spec: lbv1.LoadBalancerSpec{
+					Listeners: []lbv1.Listener{
+						{Name: "a", BackendPort: 80, Protocol: corev1.ProtocolTCP},
+					},
+					HealthCheck: &lbv1.HealthCheck{Mode: lbv1.VMIMode, VMIConditions: []lbv1.VMICondition{lbv1.VMIReady, lbv1.VMIAgentConnected}},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "probe and vmi health check",
+			lb: &lbv1.LoadBalancer{
+				Spec: lbv1.LoadBalancerSpec{
+					Listeners: []lbv1.Listener{
+						{Name: "a", BackendPort: 80, Protocol: corev1.ProtocolTCP},
+					},
+					HealthCheck: &lbv1.HealthCheck{Mode: lbv1.ProbeAndVMIMode, Port: 80, SuccessThreshold: 1, FailureThreshold: 1, PeriodSeconds: 1, TimeoutSeconds: 1},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "listener vmi health check",
+			lb: &lbv1.LoadBalancer{
+				Spec: lbv1.LoadBalancerSpec{
+					Listeners: []lbv1.Listener{
+						{Name: "a", BackendPort: 80, Protocol: corev1.ProtocolTCP, HealthCheck: &lbv1.HealthCheck{Mode: lbv1.VMIMode, VMIConditions: []lbv1.VMICondition{lbv1.VMIAgentConnected}}},
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "probe and vmi health check without port",
+			lb: &lbv1.LoadBalancer{
+				Spec: lbv1.LoadBalancerSpec{
+					Listeners: []lbv1.Listener{
+						{Name: "a", BackendPort: 80, Protocol: corev1.ProtocolTCP},
+					},
+					HealthCheck: &lbv1.HealthCheck{Mode: lbv1.ProbeAndVMIMode},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "vmi health check with a port",
+			lb: &lbv1.LoadBalancer{
+				Spec: lbv1.LoadBalancerSpec{
+					Listeners: []lbv1.Listener{
+						{Name: "a", BackendPort: 80, Protocol: corev1.ProtocolTCP},
+					},
+					HealthCheck: &lbv1.HealthCheck{Mode: lbv1.VMIMode, Port: 80},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "vmi health check with probe options",
+			lb: &lbv1.LoadBalancer{
+				Spec: lbv1.LoadBalancerSpec{
+					Listeners: []lbv1.Listener{
+						{Name: "a", BackendPort: 80, Protocol: corev1.ProtocolTCP},
+					},
+					HealthCheck: &lbv1.HealthCheck{Mode: lbv1.VMIMode, Path: "/healthz"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "vmi conditions in the probe mode",
+			lb: &lbv1.LoadBalancer{
+				Spec: lbv1.LoadBalancerSpec{
+					Listeners: []lbv1.Listener{
+						{Name: "a", BackendPort: 80, Protocol: corev1.ProtocolTCP},
+					},
+					HealthCheck: &lbv1.HealthCheck{Port: 80, SuccessThreshold: 1, FailureThreshold: 1, PeriodSeconds: 1, TimeoutSeconds: 1, VMIConditions: []lbv1.VMICondition{lbv1.VMIReady}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "vmi condition is not supported",
+			lb: &lbv1.LoadBalancer{
+				Spec: lbv1.LoadBalancerSpec{
+					Listeners: []lbv1.Listener{
+						{Name: "a", BackendPort: 80, Protocol: corev1.ProtocolTCP},
+					},
+					HealthCheck: &lbv1.HealthCheck{Mode: lbv1.VMIMode, VMIConditions: []lbv1.VMICondition{"paused"}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "health check mode is not supported",
+			lb: &lbv1.LoadBalancer{
+				Spec: lbv1.LoadBalancerSpec{
+					Listeners: []lbv1.Listener{
+						{Name: "a", BackendPort: 80, Protocol: corev1.ProtocolTCP, HealthCheck: &lbv1.HealthCheck{Mode: "passive"}},
+					},
+				},
+			},
+			wantErr: true,
+		},
 		{
 			name: "Cluster type LB may set invalid health check, but it is skipped",
 			lb: &lbv1.LoadBalancer{
@@ -384,6 +899,64 @@ func TestCheckListeners(t *testing.T) {
 		},
 	}
 
+	testsIPFamilyPolicy := []struct {
+		name    string
+		oldLb   *lbv1.LoadBalancer
+		newLb   *lbv1.LoadBalancer
+		wantErr bool
+	}{
+		{
+			name: "IPFamilyPolicy changes from empty to ipv4",
+			oldLb: &lbv1.LoadBalancer{
+				Spec: lbv1.LoadBalancerSpec{
+					IPFamilyPolicy: "",
+				},
+			},
+			newLb: &lbv1.LoadBalancer{
+				Spec: lbv1.LoadBalancerSpec{
+					IPFamilyPolicy: lbv1.IPv4,
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "IPFamilyPolicy changes from ipv4 to dualstack",
+			oldLb: &lbv1.LoadBalancer{
+				Spec: lbv1.LoadBalancerSpec{
+					IPFamilyPolicy: lbv1.IPv4,
+				},
+			},
+			newLb: &lbv1.LoadBalancer{
+				Spec: lbv1.LoadBalancerSpec{
+					IPFamilyPolicy: lbv1.DualStack,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name:  "IPFamilyPolicy ipv6 with DHCP",
+			oldLb: nil,
+			newLb: &lbv1.LoadBalancer{
+				Spec: lbv1.LoadBalancerSpec{
+					IPAM:           lbv1.DHCP,
+					IPFamilyPolicy: lbv1.IPv6,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name:  "IPFamilyPolicy dualstack with pool",
+			oldLb: nil,
+			newLb: &lbv1.LoadBalancer{
+				Spec: lbv1.LoadBalancerSpec{
+					IPAM:           lbv1.Pool,
+					IPFamilyPolicy: lbv1.DualStack,
+				},
+			},
+			wantErr: false,
+		},
+	}
+
 	testsWorkloadType := []struct {
 		name    string
 		oldLb   *lbv1.LoadBalancer
@@ -545,6 +1118,16 @@ func TestCheckListeners(t *testing.T) {
 			t.Errorf("%q. checkWorkloadType() error = %v, wantErr %v", tt.name, err, tt.wantErr)
 		}
 	}
+
+	for _, tt := range testsIPFamilyPolicy {
+		err := checkIPFamilyPolicy(tt.newLb)
+		if err == nil && tt.oldLb != nil {
+			err = checkIPFamilyPolicyChange(tt.oldLb, tt.newLb)
+		}
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q. checkIPFamilyPolicy() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
 }
 
 func Test_BlockNewLBWhenGuestClusterIsOnRemove(t *testing.T) {
@@ -761,8 +1344,10 @@ func Test_BlockNewLBWhenGuestClusterIsOnRemove(t *testing.T) {
 		objs := []runtime.Object{tt.vm, tt.lb}
 		clientset := fake.NewSimpleClientset(objs...)
 		v := &validator{
-			vmCache:  fakeclients.VirtualMachineCache(clientset.KubevirtV1().VirtualMachines),
-			vmiCache: fakeclients.VirtualMachineInstanceCache(clientset.KubevirtV1().VirtualMachineInstances),
+			vmCache:         fakeclients.VirtualMachineCache(clientset.KubevirtV1().VirtualMachines),
+			vmiCache:        fakeclients.VirtualMachineInstanceCache(clientset.KubevirtV1().VirtualMachineInstances),
+			ipPoolCache:     fakeclients.IPPoolCache(clientset.LoadbalancerV1beta1().IPPools),
+			allocationCache: fakeclients.IPAllocationCache(clientset.LoadbalancerV1beta1().IPAllocations),
 		}
 		err := v.Create(nil, tt.lb)
 		if (err != nil) != tt.wantErr {
@@ -773,3 +1358,304 @@ func Test_BlockNewLBWhenGuestClusterIsOnRemove(t *testing.T) {
 		}
 	}
 }
+
+func TestCheckRequestedIPs(t *testing.T) {
+	pool := &lbv1.IPPool{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "pool1",
+		},
+		Spec: lbv1.IPPoolSpec{
+			Ranges: []lbv1.Range{
+				{Subnet: "192.168.100.0/24"},
+				{Subnet: "fd00:100::/120"},
+			},
+			Selector: lbv1.Selector{Scope: []lbv1.Tuple{{Namespace: "default"}}},
+		},
+	}
+	allocation := store.NewAllocation(pool, "192.168.100.10", "default/lb1")
+
+	tests := []struct {
+		name     string
+		lb       *lbv1.LoadBalancer
+		wantErr  bool
+		errorKey string
+	}{
+		{
+			name: "requested IP is allocated to the lb itself",
+			lb: &lbv1.LoadBalancer{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "lb1"},
+				Spec: lbv1.LoadBalancerSpec{
+					IPPool:       "pool1",
+					RequestedIPs: []string{"192.168.100.10"},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "requested IP is allocated to others",
+			lb: &lbv1.LoadBalancer{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "lb2"},
+				Spec: lbv1.LoadBalancerSpec{
+					IPPool:       "pool1",
+					RequestedIPs: []string{"192.168.100.10"},
+				},
+			},
+			wantErr:  true,
+			errorKey: "has been allocated to default/lb1",
+		},
+		{
+			name: "requested IP is out of the pool",
+			lb: &lbv1.LoadBalancer{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "lb2"},
+				Spec: lbv1.LoadBalancerSpec{
+					IPPool:       "pool1",
+					RequestedIPs: []string{"192.168.200.10"},
+				},
+			},
+			wantErr:  true,
+			errorKey: "is not available in pool pool1",
+		},
+		{
+			name: "requested IP is the gateway",
+			lb: &lbv1.LoadBalancer{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "lb2"},
+				Spec: lbv1.LoadBalancerSpec{
+					IPPool:       "pool1",
+					RequestedIPs: []string{"192.168.100.1"},
+				},
+			},
+			wantErr:  true,
+			errorKey: "gateway",
+		},
+		{
+			name: "requested IP is not in any pool",
+			lb: &lbv1.LoadBalancer{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "lb2"},
+				Spec: lbv1.LoadBalancerSpec{
+					RequestedIPs: []string{"192.168.200.10"},
+				},
+			},
+			wantErr:  true,
+			errorKey: "is not in any pool",
+		},
+		{
+			name: "requested IPv6 is not in the IP family of the lb",
+			lb: &lbv1.LoadBalancer{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "lb2"},
+				Spec: lbv1.LoadBalancerSpec{
+					RequestedIPs: []string{"fd00:100::10"},
+				},
+			},
+			wantErr:  true,
+			errorKey: "is not in the IP families",
+		},
+		{
+			name: "requested dual-stack IPs",
+			lb: &lbv1.LoadBalancer{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "lb2"},
+				Spec: lbv1.LoadBalancerSpec{
+					IPFamilyPolicy: lbv1.DualStack,
+					RequestedIPs:   []string{"192.168.100.20", "fd00:100::20"},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "requested IP is in the pool scoped to other namespaces",
+			lb: &lbv1.LoadBalancer{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "other", Name: "lb2"},
+				Spec: lbv1.LoadBalancerSpec{
+					RequestedIPs: []string{"192.168.100.20"},
+				},
+			},
+			wantErr:  true,
+			errorKey: "out of scope",
+		},
+		{
+			name: "requested IPs are in the same IP family",
+			lb: &lbv1.LoadBalancer{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "lb2"},
+				Spec: lbv1.LoadBalancerSpec{
+					IPFamilyPolicy: lbv1.DualStack,
+					RequestedIPs:   []string{"192.168.100.20", "192.168.100.21"},
+				},
+			},
+			wantErr:  true,
+			errorKey: "in the same IP family",
+		},
+		{
+			name: "requested IP with DHCP",
+			lb: &lbv1.LoadBalancer{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "lb2"},
+				Spec: lbv1.LoadBalancerSpec{
+					IPAM:         lbv1.DHCP,
+					RequestedIPs: []string{"192.168.100.20"},
+				},
+			},
+			wantErr:  true,
+			errorKey: "IPAM dhcp",
+		},
+	}
+
+	clientset := fake.NewSimpleClientset(pool, allocation)
+	v := &validator{
+		ipPoolCache:     fakeclients.IPPoolCache(clientset.LoadbalancerV1beta1().IPPools),
+		allocationCache: fakeclients.IPAllocationCache(clientset.LoadbalancerV1beta1().IPAllocations),
+	}
+	for _, tt := range tests {
+		err := v.checkRequestedIPs(tt.lb)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q. checkRequestedIPs() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+		if tt.wantErr && tt.errorKey != "" && !strings.Contains(err.Error(), tt.errorKey) {
+			t.Errorf("%q, the return error %v does not include the keyword '%s'", tt.name, err, tt.errorKey)
+		}
+	}
+}
+
+func TestCheckQuota(t *testing.T) {
+	pool := &lbv1.IPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "pool1"},
+		Spec: lbv1.IPPoolSpec{
+			Ranges:   []lbv1.Range{{Subnet: "192.168.100.0/24"}},
+			Selector: lbv1.Selector{Scope: []lbv1.Tuple{{Namespace: "default"}}},
+			Quotas:   []lbv1.Quota{{Tuple: lbv1.Tuple{Namespace: "default"}, Max: 1}},
+		},
+		Status: lbv1.IPPoolStatus{Available: 252},
+	}
+	lb1 := &lbv1.LoadBalancer{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "lb1"}}
+	allocation := store.NewAllocation(pool, "192.168.100.10", "default/lb1")
+
+	tests := []struct {
+		name     string
+		lb       *lbv1.LoadBalancer
+		wantErr  bool
+		errorKey string
+	}{
+		{
+			name: "the tenant has no quota",
+			lb: &lbv1.LoadBalancer{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "other", Name: "lb2"},
+				Spec:       lbv1.LoadBalancerSpec{IPPool: pool.Name},
+			},
+		},
+		{
+			name: "the quota is used up",
+			lb: &lbv1.LoadBalancer{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "lb2"},
+				Spec:       lbv1.LoadBalancerSpec{IPPool: pool.Name},
+			},
+			wantErr:  true,
+			errorKey: "quota is exceeded",
+		},
+		{
+			name: "the pool is selected by the requested IP",
+			lb: &lbv1.LoadBalancer{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "lb2"},
+				Spec:       lbv1.LoadBalancerSpec{RequestedIPs: []string{"192.168.100.20"}},
+			},
+			wantErr:  true,
+			errorKey: "quota is exceeded",
+		},
+		{
+			name: "DHCP",
+			lb: &lbv1.LoadBalancer{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "lb2"},
+				Spec:       lbv1.LoadBalancerSpec{IPAM: lbv1.DHCP},
+			},
+		},
+	}
+
+	clientset := fake.NewSimpleClientset(pool, allocation, lb1)
+	v := &validator{
+		ipPoolCache:     fakeclients.IPPoolCache(clientset.LoadbalancerV1beta1().IPPools),
+		allocationCache: fakeclients.IPAllocationCache(clientset.LoadbalancerV1beta1().IPAllocations),
+		lbCache:         fakeclients.LoadBalancerCache(clientset.LoadbalancerV1beta1().LoadBalancers),
+	}
+	for _, tt := range tests {
+		err := v.checkQuota(tt.lb)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q. checkQuota() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+		if tt.wantErr && tt.errorKey != "" && !strings.Contains(err.Error(), tt.errorKey) {
+			t.Errorf("%q, the return error %v does not include the keyword '%s'", tt.name, err, tt.errorKey)
+		}
+	}
+	// the quota is checked when an update moves the lb to the pool
+	oldLb := &lbv1.LoadBalancer{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "lb2"},
+		Spec: lbv1.LoadBalancerSpec{
+			Listeners: []lbv1.Listener{{Name: "a", Port: 80, BackendPort: 80, Protocol: corev1.ProtocolTCP}},
+		},
+	}
+	newLb := oldLb.DeepCopy()
+	newLb.Spec.IPPool = pool.Name
+	if err := v.Update(nil, oldLb, newLb); err == nil || !strings.Contains(err.Error(), "quota is exceeded") {
+		t.Errorf("Update() to the pool with the quota used up returns %v, want the quota error", err)
+	}
+	if err := v.Update(nil, oldLb, oldLb.DeepCopy()); err != nil {
+		t.Errorf("Update() without changing the pool returns %v", err)
+	}
+}
+
+func TestCheckIPClaim(t *testing.T) {
+	claim4 := &lbv1.IPClaim{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "claim4"}}
+	claim6 := &lbv1.IPClaim{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "claim6"},
+		Spec:       lbv1.IPClaimSpec{IPFamily: corev1.IPv6Protocol},
+	}
+	tests := []struct {
+		name     string
+		lb       *lbv1.LoadBalancer
+		wantErr  bool
+		errorKey string
+	}{
+		{
+			name: "the IPv4 claim covers the default IPFamilyPolicy",
+			lb: &lbv1.LoadBalancer{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "lb1"},
+				Spec:       lbv1.LoadBalancerSpec{IPClaim: claim4.Name},
+			},
+		},
+		{
+			name: "the IPv6 claim covers IPFamilyPolicy IPv6",
+			lb: &lbv1.LoadBalancer{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "lb1"},
+				Spec:       lbv1.LoadBalancerSpec{IPClaim: claim6.Name, IPFamilyPolicy: lbv1.IPv6},
+			},
+		},
+		{
+			name: "the IPv6 claim doesn't cover the default IPFamilyPolicy",
+			lb: &lbv1.LoadBalancer{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "lb1"},
+				Spec:       lbv1.LoadBalancerSpec{IPClaim: claim6.Name},
+			},
+			wantErr:  true,
+			errorKey: "doesn't cover",
+		},
+		{
+			name: "the IPv4 claim doesn't cover IPFamilyPolicy DualStack",
+			lb: &lbv1.LoadBalancer{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "lb1"},
+				Spec:       lbv1.LoadBalancerSpec{IPClaim: claim4.Name, IPFamilyPolicy: lbv1.DualStack},
+			},
+			wantErr:  true,
+			errorKey: "doesn't cover",
+		},
+	}
+
+	clientset := fake.NewSimpleClientset(claim4, claim6)
+	v := &validator{
+		claimCache: fakeclients.IPClaimCache(clientset.LoadbalancerV1beta1().IPClaims),
+		lbCache:    fakeclients.LoadBalancerCache(clientset.LoadbalancerV1beta1().LoadBalancers),
+	}
+	for _, tt := range tests {
+		err := v.checkIPClaim(tt.lb)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q. checkIPClaim() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+		if tt.wantErr && tt.errorKey != "" && !strings.Contains(err.Error(), tt.errorKey) {
+			t.Errorf("%q, the return error %v does not include the keyword '%s'", tt.name, err, tt.errorKey)
+		}
+	}
+}